@@ -4,4 +4,11 @@ import "time"
 
 const (
 	SessionDuration = 3 * time.Hour
+	// BaseCurrency is the currency totals and costs are kept in
+	BaseCurrency = "PYG"
+	// RoleAdmin can close and reopen periods and open, close and cancel
+	// inventory counts
+	RoleAdmin = "ADMIN"
+	// AttachmentMaxSize is the largest file that can be attached, in bytes
+	AttachmentMaxSize = 10 << 20
 )
@@ -0,0 +1,61 @@
+package constants
+
+import "github.com/shopspring/decimal"
+
+// Number of decimal places kept for each kind of value, they match the
+// NUMERIC columns on the database
+const (
+	// QuantityScale applies to stock quantities, in the unit of the product
+	QuantityScale int32 = 3
+	// BomQuantityScale applies to the quantity of a component per unit of
+	// the finished product
+	BomQuantityScale int32 = 6
+	// PriceScale applies to unit prices and unit costs
+	PriceScale int32 = 4
+	// AmountScale applies to money totals: line totals, valuations and costs
+	AmountScale int32 = 2
+	// PercentageScale applies to reported percentages
+	PercentageScale int32 = 2
+	// ExchangeRateScale applies to the rates between a currency and the base
+	// currency
+	ExchangeRateScale int32 = 6
+)
+
+// All rounding is half away from zero, 2.345 rounds to 2.35 and -2.345 to
+// -2.35
+
+func RoundQuantity(value decimal.Decimal) decimal.Decimal {
+	return value.Round(QuantityScale)
+}
+
+func RoundPrice(value decimal.Decimal) decimal.Decimal {
+	return value.Round(PriceScale)
+}
+
+func RoundAmount(value decimal.Decimal) decimal.Decimal {
+	return value.Round(AmountScale)
+}
+
+// LineTotal is the money total of quantity units at price each
+func LineTotal(quantity decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	return RoundAmount(quantity.Mul(price))
+}
+
+// UnitPrice spreads a money total over a quantity, zero when there is no
+// quantity to spread it over
+func UnitPrice(total decimal.Decimal, quantity decimal.Decimal) decimal.Decimal {
+	if quantity.IsZero() {
+		return decimal.Zero
+	}
+
+	return total.DivRound(quantity, PriceScale)
+}
+
+// Percentage returns part over whole as a percentage, zero when whole is zero
+func Percentage(part decimal.Decimal, whole decimal.Decimal) decimal.Decimal {
+	if whole.IsZero() {
+		return decimal.Zero
+	}
+
+	return part.Mul(decimal.NewFromInt(100)).DivRound(whole, PercentageScale)
+}
@@ -1,6 +1,10 @@
 package constants
 
-import "fmt"
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+)
 
 type UniqueConstraintError struct {
 	Message string
@@ -43,3 +47,58 @@ func NewInvalidOperationError(additionalInfo string) *InvalidOperationError {
 		fmt.Sprintf("required field: %v", additionalInfo),
 	}
 }
+
+type InsufficientStockItem struct {
+	ProductID     string          `json:"productId"`
+	ProductName   string          `json:"productName"`
+	WarehouseName string          `json:"warehouseName"`
+	Batch         string          `json:"batch"`
+	Requested     decimal.Decimal `json:"requested"`
+	Available     decimal.Decimal `json:"available"`
+}
+
+type InsufficientStockError struct {
+	Message string
+	Items   []*InsufficientStockItem
+}
+
+func (u InsufficientStockError) Error() string {
+	return u.Message
+}
+
+func NewInsufficientStockError(items []*InsufficientStockItem) *InsufficientStockError {
+	shortItems := make([]string, 0, len(items))
+	for _, item := range items {
+		name := item.ProductName
+		if item.WarehouseName != "" {
+			name = fmt.Sprintf("%v in %v", name, item.WarehouseName)
+		}
+		if item.Batch != "" {
+			name = fmt.Sprintf("%v (batch %v)", name, item.Batch)
+		}
+		shortItems = append(shortItems, fmt.Sprintf("%v requested %v available %v", name, item.Requested, item.Available))
+	}
+
+	return &InsufficientStockError{
+		Message: fmt.Sprintf("insufficient stock: %v", strings.Join(shortItems, ", ")),
+		Items:   items,
+	}
+}
+
+// PeriodClosedError is returned for changes dated inside a closed period,
+// Period is the month in yyyy-mm
+type PeriodClosedError struct {
+	Message string
+	Period  string
+}
+
+func (u PeriodClosedError) Error() string {
+	return u.Message
+}
+
+func NewPeriodClosedError(period string) *PeriodClosedError {
+	return &PeriodClosedError{
+		Message: fmt.Sprintf("period %v is closed", period),
+		Period:  period,
+	}
+}
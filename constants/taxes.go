@@ -0,0 +1,27 @@
+package constants
+
+import "github.com/shopspring/decimal"
+
+// IVA rates of a product, prices are recorded with the tax included as they
+// are printed on paraguayan invoices
+const (
+	TaxRateIVA10  = "IVA_10"
+	TaxRateIVA5   = "IVA_5"
+	TaxRateExenta = "EXENTA"
+)
+
+// TaxRates lists the rates in the order they are reported
+var TaxRates = []string{TaxRateIVA10, TaxRateIVA5, TaxRateExenta}
+
+// IncludedTax returns the IVA contained in a total that already includes it,
+// a tenth of the base is 1/11 of the total and a twentieth is 1/21
+func IncludedTax(total decimal.Decimal, taxRate string) decimal.Decimal {
+	switch taxRate {
+	case TaxRateIVA10:
+		return total.DivRound(decimal.NewFromInt(11), AmountScale)
+	case TaxRateIVA5:
+		return total.DivRound(decimal.NewFromInt(21), AmountScale)
+	default:
+		return decimal.Zero
+	}
+}
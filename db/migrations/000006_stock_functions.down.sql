@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "stock_movement_date_created_at";
+
+DROP FUNCTION IF EXISTS movement_sign(MOVEMENT_TYPE);
+
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+
+-- sign applied to stock_movement_items.quantity when computing stock on hand,
+-- ADJUST quantities are already signed by the user
+CREATE OR REPLACE FUNCTION movement_sign(movement_type MOVEMENT_TYPE) RETURNS INT AS
+$$
+SELECT CASE
+           WHEN movement_type::TEXT IN ('SALE', 'PRODUCTION_OUT') THEN -1
+           ELSE 1
+           END
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE INDEX IF NOT EXISTS "stock_movement_date_created_at" ON "stock_movements" ("date", "created_at");
+
+COMMIT;
@@ -0,0 +1,13 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "stock_movement_item_lot";
+
+ALTER TABLE "stock_movement_items"
+    DROP CONSTRAINT IF EXISTS "fk_lot",
+    DROP COLUMN IF EXISTS "lot_id";
+
+DROP TABLE IF EXISTS "lots";
+
+DROP TYPE IF EXISTS LOT_STATUS;
+
+COMMIT;
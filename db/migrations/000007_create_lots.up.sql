@@ -0,0 +1,52 @@
+BEGIN;
+
+DROP TYPE IF EXISTS LOT_STATUS;
+CREATE TYPE LOT_STATUS AS ENUM ('ACTIVE', 'QUARANTINE', 'INACTIVE');
+
+CREATE TABLE IF NOT EXISTS "lots"
+(
+    "id"               UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "status"           LOT_STATUS       NOT NULL DEFAULT 'ACTIVE',
+    "product_id"       UUID             NOT NULL,
+    "code"             VARCHAR(30)      NOT NULL,
+    "manufacture_date" DATE,
+    "expiry_date"      DATE,
+    "created_at"       TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at"       TIMESTAMP        NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_product"
+        FOREIGN KEY ("product_id")
+            REFERENCES "products" ("id"),
+
+    CONSTRAINT "lots_product_code_unique"
+        UNIQUE ("product_id", "code")
+);
+
+CREATE INDEX "lots_status" ON "lots" ("status");
+CREATE INDEX "lots_product" ON "lots" ("product_id");
+CREATE INDEX "lots_expiry_date" ON "lots" ("expiry_date");
+
+ALTER TABLE "stock_movement_items"
+    ADD COLUMN "lot_id" UUID,
+    ADD CONSTRAINT "fk_lot"
+        FOREIGN KEY ("lot_id")
+            REFERENCES "lots" ("id");
+
+CREATE INDEX "stock_movement_item_lot" ON "stock_movement_items" ("lot_id");
+
+-- existing batches of batch controlled products become lots
+INSERT INTO "lots" ("product_id", "code")
+SELECT DISTINCT smi.product_id, smi.batch
+FROM "stock_movement_items" smi
+         JOIN "products" p ON p.id = smi.product_id
+WHERE p.batch_control
+  AND coalesce(smi.batch, '') <> ''
+ON CONFLICT DO NOTHING;
+
+UPDATE "stock_movement_items" smi
+SET lot_id = l.id
+FROM "lots" l
+WHERE l.product_id = smi.product_id
+  AND l.code = smi.batch;
+
+COMMIT;
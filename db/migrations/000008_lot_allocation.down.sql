@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE "products"
+    DROP COLUMN IF EXISTS "lot_allocation";
+
+DROP TYPE IF EXISTS LOT_ALLOCATION;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP TYPE IF EXISTS LOT_ALLOCATION;
+CREATE TYPE LOT_ALLOCATION AS ENUM ('FEFO', 'FIFO');
+
+ALTER TABLE "products"
+    ADD COLUMN "lot_allocation" LOT_ALLOCATION NOT NULL DEFAULT 'FEFO';
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE "products"
+    DROP COLUMN IF EXISTS "allow_negative_stock";
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE "products"
+    ADD COLUMN "allow_negative_stock" BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
@@ -0,0 +1,19 @@
+BEGIN;
+
+DELETE FROM "stock_movement_items"
+WHERE stock_movement_id IN (SELECT id FROM "stock_movements" WHERE type = 'TRANSFER');
+
+DELETE FROM "stock_movements"
+WHERE type = 'TRANSFER';
+
+DROP INDEX IF EXISTS "stock_movement_item_warehouse";
+
+ALTER TABLE "stock_movement_items"
+    DROP CONSTRAINT IF EXISTS "fk_warehouse",
+    DROP COLUMN IF EXISTS "warehouse_id";
+
+DROP TABLE IF EXISTS "warehouses";
+
+-- postgres cannot drop a value from an enum, TRANSFER stays in MOVEMENT_TYPE
+
+COMMIT;
@@ -0,0 +1,34 @@
+ALTER TYPE MOVEMENT_TYPE ADD VALUE IF NOT EXISTS 'TRANSFER';
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS "warehouses"
+(
+    "id"         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "status"     STATUS           NOT NULL DEFAULT 'ACTIVE',
+    "name"       TEXT             NOT NULL UNIQUE,
+    "created_at" TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at" TIMESTAMP        NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "warehouses_status" ON "warehouses" ("status");
+
+-- everything registered so far lived in a single implicit warehouse
+INSERT INTO "warehouses" ("name")
+VALUES ('PRINCIPAL');
+
+ALTER TABLE "stock_movement_items"
+    ADD COLUMN "warehouse_id" UUID,
+    ADD CONSTRAINT "fk_warehouse"
+        FOREIGN KEY ("warehouse_id")
+            REFERENCES "warehouses" ("id");
+
+UPDATE "stock_movement_items"
+SET warehouse_id = (SELECT id FROM "warehouses" WHERE name = 'PRINCIPAL');
+
+ALTER TABLE "stock_movement_items"
+    ALTER COLUMN "warehouse_id" SET NOT NULL;
+
+CREATE INDEX "stock_movement_item_warehouse" ON "stock_movement_items" ("warehouse_id");
+
+COMMIT;
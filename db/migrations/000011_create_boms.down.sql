@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE IF EXISTS "bom_items";
+
+DROP TABLE IF EXISTS "boms";
+
+COMMIT;
@@ -0,0 +1,51 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS "boms"
+(
+    "id"         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "status"     STATUS           NOT NULL DEFAULT 'ACTIVE',
+    "product_id" UUID             NOT NULL,
+    "version"    INT              NOT NULL,
+    "notes"      TEXT             NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at" TIMESTAMP        NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_product"
+        FOREIGN KEY ("product_id")
+            REFERENCES "products" ("id"),
+
+    CONSTRAINT "boms_product_version_unique"
+        UNIQUE ("product_id", "version")
+);
+
+CREATE INDEX "boms_status" ON "boms" ("status");
+CREATE INDEX "boms_product" ON "boms" ("product_id");
+
+CREATE TABLE IF NOT EXISTS "bom_items"
+(
+    "id"                   UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "bom_id"               UUID             NOT NULL,
+    "component_product_id" UUID             NOT NULL,
+    -- quantity of the component per output unit, in thousandths like stock_movement_items
+    "quantity"             INT              NOT NULL CHECK ( quantity > 0 ),
+    "scrap_percentage"     NUMERIC(5, 2)    NOT NULL DEFAULT 0 CHECK ( scrap_percentage >= 0 AND scrap_percentage < 100 ),
+    "created_at"           TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at"           TIMESTAMP        NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_bom"
+        FOREIGN KEY ("bom_id")
+            REFERENCES "boms" ("id")
+            ON DELETE CASCADE,
+
+    CONSTRAINT "fk_component_product"
+        FOREIGN KEY ("component_product_id")
+            REFERENCES "products" ("id"),
+
+    CONSTRAINT "bom_items_component_unique"
+        UNIQUE ("bom_id", "component_product_id")
+);
+
+CREATE INDEX "bom_items_bom" ON "bom_items" ("bom_id");
+CREATE INDEX "bom_items_component_product" ON "bom_items" ("component_product_id");
+
+COMMIT;
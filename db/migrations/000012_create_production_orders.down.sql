@@ -0,0 +1,13 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "stock_movement_production_order";
+
+ALTER TABLE "stock_movements"
+    DROP CONSTRAINT IF EXISTS "fk_production_order",
+    DROP COLUMN IF EXISTS "production_order_id";
+
+DROP TABLE IF EXISTS "production_orders";
+
+DROP TYPE IF EXISTS PRODUCTION_ORDER_STATUS;
+
+COMMIT;
@@ -0,0 +1,64 @@
+BEGIN;
+
+DROP TYPE IF EXISTS PRODUCTION_ORDER_STATUS;
+CREATE TYPE PRODUCTION_ORDER_STATUS AS ENUM ('PLANNED', 'IN_PROGRESS', 'COMPLETED', 'CANCELLED');
+
+CREATE TABLE IF NOT EXISTS "production_orders"
+(
+    "id"                      UUID PRIMARY KEY        NOT NULL DEFAULT uuid_generate_v4(),
+    "status"                  PRODUCTION_ORDER_STATUS NOT NULL DEFAULT 'PLANNED',
+    "product_id"              UUID                    NOT NULL,
+    "bom_id"                  UUID                    NOT NULL,
+    -- quantities in thousandths like stock_movement_items
+    "planned_quantity"        INT                     NOT NULL CHECK ( planned_quantity > 0 ),
+    "produced_quantity"       INT,
+    "date"                    DATE                    NOT NULL,
+    "completed_date"          DATE,
+    "components_warehouse_id" UUID                    NOT NULL,
+    "output_warehouse_id"     UUID                    NOT NULL,
+    "batch"                   VARCHAR(30)             NOT NULL DEFAULT '',
+    "notes"                   TEXT                    NOT NULL DEFAULT '',
+    "created_by_user_id"      UUID                    NOT NULL,
+    "cancelled_by_user_id"    UUID,
+    "created_at"              TIMESTAMP               NOT NULL DEFAULT NOW(),
+    "updated_at"              TIMESTAMP               NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_product"
+        FOREIGN KEY ("product_id")
+            REFERENCES "products" ("id"),
+
+    CONSTRAINT "fk_bom"
+        FOREIGN KEY ("bom_id")
+            REFERENCES "boms" ("id"),
+
+    CONSTRAINT "fk_components_warehouse"
+        FOREIGN KEY ("components_warehouse_id")
+            REFERENCES "warehouses" ("id"),
+
+    CONSTRAINT "fk_output_warehouse"
+        FOREIGN KEY ("output_warehouse_id")
+            REFERENCES "warehouses" ("id"),
+
+    CONSTRAINT "fk_created_by_user"
+        FOREIGN KEY ("created_by_user_id")
+            REFERENCES "users" ("id"),
+
+    CONSTRAINT "fk_cancelled_by_user"
+        FOREIGN KEY ("cancelled_by_user_id")
+            REFERENCES "users" ("id")
+);
+
+CREATE INDEX "production_orders_status" ON "production_orders" ("status");
+CREATE INDEX "production_orders_product" ON "production_orders" ("product_id");
+CREATE INDEX "production_orders_bom" ON "production_orders" ("bom_id");
+CREATE INDEX "production_orders_date" ON "production_orders" ("date");
+
+ALTER TABLE "stock_movements"
+    ADD COLUMN "production_order_id" UUID,
+    ADD CONSTRAINT "fk_production_order"
+        FOREIGN KEY ("production_order_id")
+            REFERENCES "production_orders" ("id");
+
+CREATE INDEX "stock_movement_production_order" ON "stock_movements" ("production_order_id");
+
+COMMIT;
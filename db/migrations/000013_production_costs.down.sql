@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE "production_orders"
+    DROP COLUMN IF EXISTS "components_cost",
+    DROP COLUMN IF EXISTS "labour_cost",
+    DROP COLUMN IF EXISTS "overhead_cost";
+
+COMMIT;
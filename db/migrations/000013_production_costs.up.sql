@@ -0,0 +1,9 @@
+BEGIN;
+
+-- totals of the order, set when it is completed
+ALTER TABLE "production_orders"
+    ADD COLUMN "components_cost" INT,
+    ADD COLUMN "labour_cost"     INT NOT NULL DEFAULT 0 CHECK ( labour_cost >= 0 ),
+    ADD COLUMN "overhead_cost"   INT NOT NULL DEFAULT 0 CHECK ( overhead_cost >= 0 );
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+ALTER TABLE "production_orders"
+    ALTER COLUMN "planned_quantity" TYPE INT USING round(planned_quantity * 1000),
+    ALTER COLUMN "produced_quantity" TYPE INT USING round(produced_quantity * 1000),
+    ALTER COLUMN "components_cost" TYPE INT USING round(components_cost),
+    ALTER COLUMN "labour_cost" TYPE INT USING round(labour_cost),
+    ALTER COLUMN "overhead_cost" TYPE INT USING round(overhead_cost);
+
+ALTER TABLE "bom_items"
+    ALTER COLUMN "quantity" TYPE INT USING round(quantity * 1000);
+
+ALTER TABLE "stock_movement_items"
+    ALTER COLUMN "quantity" TYPE INT USING round(quantity * 1000),
+    ALTER COLUMN "price" TYPE INT USING round(price);
+
+COMMIT;
@@ -0,0 +1,19 @@
+BEGIN;
+
+-- quantities were stored in thousandths of a unit, prices per unit
+ALTER TABLE "stock_movement_items"
+    ALTER COLUMN "quantity" TYPE NUMERIC(15, 3) USING quantity / 1000.0,
+    ALTER COLUMN "price" TYPE NUMERIC(19, 4) USING price;
+
+-- quantity of the component per unit of the finished product
+ALTER TABLE "bom_items"
+    ALTER COLUMN "quantity" TYPE NUMERIC(18, 6) USING quantity / 1000.0;
+
+ALTER TABLE "production_orders"
+    ALTER COLUMN "planned_quantity" TYPE NUMERIC(15, 3) USING planned_quantity / 1000.0,
+    ALTER COLUMN "produced_quantity" TYPE NUMERIC(15, 3) USING produced_quantity / 1000.0,
+    ALTER COLUMN "components_cost" TYPE NUMERIC(19, 2) USING components_cost,
+    ALTER COLUMN "labour_cost" TYPE NUMERIC(19, 2) USING labour_cost,
+    ALTER COLUMN "overhead_cost" TYPE NUMERIC(19, 2) USING overhead_cost;
+
+COMMIT;
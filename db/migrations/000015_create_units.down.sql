@@ -0,0 +1,31 @@
+BEGIN;
+
+ALTER TABLE "stock_movement_items"
+    DROP CONSTRAINT IF EXISTS "fk_unit",
+    DROP COLUMN IF EXISTS "unit",
+    DROP COLUMN IF EXISTS "unit_factor";
+
+ALTER TABLE "products"
+    ADD COLUMN "conversion_factor" NUMERIC NOT NULL DEFAULT 1;
+
+UPDATE "products" p
+SET conversion_factor = pu.factor
+FROM "product_units" pu
+WHERE pu.product_id = p.id
+  AND pu.unit = 'CJ';
+
+DROP TABLE IF EXISTS "product_units";
+
+CREATE TYPE UNIT AS ENUM ('KG', 'L', 'UNITS', 'OTHER');
+
+ALTER TABLE "products"
+    DROP CONSTRAINT IF EXISTS "fk_unit",
+    ALTER COLUMN "unit" DROP DEFAULT,
+    ALTER COLUMN "unit" TYPE UNIT USING (CASE WHEN unit = 'UN' THEN 'UNITS'
+                                              WHEN unit IN ('KG', 'L') THEN unit
+                                              ELSE 'OTHER' END)::UNIT,
+    ALTER COLUMN "unit" SET DEFAULT 'UNITS';
+
+DROP TABLE IF EXISTS "units";
+
+COMMIT;
@@ -0,0 +1,83 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS "units"
+(
+    "code"       VARCHAR(10) PRIMARY KEY NOT NULL,
+    "status"     STATUS                  NOT NULL DEFAULT 'ACTIVE',
+    "name"       TEXT                    NOT NULL,
+    "created_at" TIMESTAMP               NOT NULL DEFAULT NOW(),
+    "updated_at" TIMESTAMP               NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO "units" ("code", "name")
+VALUES ('UN', 'UNIDAD'),
+       ('KG', 'KILOGRAMO'),
+       ('L', 'LITRO'),
+       ('CJ', 'CAJA'),
+       ('PALLET', 'PALLET'),
+       ('OTHER', 'OTRO');
+
+-- the UNIT enum spelled units as UNITS while the api accepted UN
+ALTER TABLE "products"
+    ALTER COLUMN "unit" DROP DEFAULT,
+    ALTER COLUMN "unit" TYPE VARCHAR(10) USING (CASE unit WHEN 'UNITS' THEN 'UN' ELSE unit::TEXT END),
+    ALTER COLUMN "unit" SET DEFAULT 'UN',
+    ADD CONSTRAINT "fk_unit"
+        FOREIGN KEY ("unit")
+            REFERENCES "units" ("code");
+
+DROP TYPE IF EXISTS UNIT;
+
+-- packaging levels of a product, factor is how many base units one of them
+-- holds, a pallet of 60 boxes of 12 has a factor of 720
+CREATE TABLE IF NOT EXISTS "product_units"
+(
+    "id"         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "status"     STATUS           NOT NULL DEFAULT 'ACTIVE',
+    "product_id" UUID             NOT NULL,
+    "unit"       VARCHAR(10)      NOT NULL,
+    "factor"     NUMERIC(18, 6)   NOT NULL CHECK ("factor" > 0),
+    "barcode"    TEXT UNIQUE,
+    "created_at" TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at" TIMESTAMP        NOT NULL DEFAULT NOW(),
+    CONSTRAINT "fk_product"
+        FOREIGN KEY ("product_id")
+            REFERENCES "products" ("id"),
+    CONSTRAINT "fk_unit"
+        FOREIGN KEY ("unit")
+            REFERENCES "units" ("code"),
+    UNIQUE ("product_id", "unit")
+);
+
+CREATE INDEX "product_units_product" ON "product_units" ("product_id");
+
+-- the single conversion factor was the size of the box the product is
+-- bought in
+INSERT INTO "product_units" ("product_id", "unit", "factor")
+SELECT id, 'CJ', conversion_factor
+FROM "products"
+WHERE conversion_factor > 0
+  AND conversion_factor <> 1
+  AND unit <> 'CJ';
+
+ALTER TABLE "products"
+    DROP COLUMN IF EXISTS "conversion_factor";
+
+-- unit an item was entered in, quantity and price are always stored in the
+-- base unit of the product
+ALTER TABLE "stock_movement_items"
+    ADD COLUMN "unit"        VARCHAR(10),
+    ADD COLUMN "unit_factor" NUMERIC(18, 6) NOT NULL DEFAULT 1 CHECK ("unit_factor" > 0),
+    ADD CONSTRAINT "fk_unit"
+        FOREIGN KEY ("unit")
+            REFERENCES "units" ("code");
+
+UPDATE "stock_movement_items" smi
+SET unit = p.unit
+FROM "products" p
+WHERE p.id = smi.product_id;
+
+ALTER TABLE "stock_movement_items"
+    ALTER COLUMN "unit" SET NOT NULL;
+
+COMMIT;
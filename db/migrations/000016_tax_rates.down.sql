@@ -0,0 +1,11 @@
+BEGIN;
+
+ALTER TABLE "stock_movement_items"
+    DROP COLUMN IF EXISTS "tax_rate";
+
+ALTER TABLE "products"
+    DROP COLUMN IF EXISTS "tax_rate";
+
+DROP TYPE IF EXISTS TAX_RATE;
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN;
+
+DROP TYPE IF EXISTS TAX_RATE;
+CREATE TYPE TAX_RATE AS ENUM ('IVA_10', 'IVA_5', 'EXENTA');
+
+ALTER TABLE "products"
+    ADD COLUMN "tax_rate" TAX_RATE NOT NULL DEFAULT 'IVA_10';
+
+-- rate of the product when the item was posted, later changes to the product
+-- do not alter declared movements
+ALTER TABLE "stock_movement_items"
+    ADD COLUMN "tax_rate" TAX_RATE NOT NULL DEFAULT 'IVA_10';
+
+COMMIT;
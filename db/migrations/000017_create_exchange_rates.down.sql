@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE "stock_movements"
+    DROP COLUMN IF EXISTS "currency",
+    DROP COLUMN IF EXISTS "exchange_rate";
+
+DROP TABLE IF EXISTS "exchange_rates";
+
+COMMIT;
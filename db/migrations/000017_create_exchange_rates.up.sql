@@ -0,0 +1,21 @@
+BEGIN;
+
+-- rate is how many PYG, the base currency, one unit of currency is worth
+CREATE TABLE IF NOT EXISTS "exchange_rates"
+(
+    "id"         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "currency"   VARCHAR(3)       NOT NULL,
+    "date"       DATE             NOT NULL,
+    "rate"       NUMERIC(19, 6)   NOT NULL CHECK ("rate" > 0),
+    "created_at" TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at" TIMESTAMP        NOT NULL DEFAULT NOW(),
+    UNIQUE ("currency", "date")
+);
+
+-- item prices are in the currency of the movement, exchange_rate is the rate
+-- that was used to convert them to PYG
+ALTER TABLE "stock_movements"
+    ADD COLUMN "currency"      VARCHAR(3)     NOT NULL DEFAULT 'PYG',
+    ADD COLUMN "exchange_rate" NUMERIC(19, 6) NOT NULL DEFAULT 1 CHECK ("exchange_rate" > 0);
+
+COMMIT;
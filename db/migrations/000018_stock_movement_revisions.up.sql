@@ -0,0 +1,22 @@
+BEGIN;
+
+-- every edit of a movement, changes holds the header and items before and
+-- after the edit
+CREATE TABLE IF NOT EXISTS "stock_movement_revisions"
+(
+    "id"                UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "stock_movement_id" UUID             NOT NULL,
+    "revision"          INT              NOT NULL,
+    "user_id"           UUID             NOT NULL,
+    "changes"           JSONB            NOT NULL,
+    "created_at"        TIMESTAMP        NOT NULL DEFAULT NOW(),
+    CONSTRAINT "fk_stock_movement"
+        FOREIGN KEY ("stock_movement_id")
+            REFERENCES "stock_movements" ("id"),
+    CONSTRAINT "fk_user"
+        FOREIGN KEY ("user_id")
+            REFERENCES "users" ("id"),
+    UNIQUE ("stock_movement_id", "revision")
+);
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE "stock_movements"
+    DROP CONSTRAINT IF EXISTS "fk_reversal_of",
+    DROP COLUMN IF EXISTS "cancel_reason",
+    DROP COLUMN IF EXISTS "cancelled_at",
+    DROP COLUMN IF EXISTS "reversal_of_id";
+
+COMMIT;
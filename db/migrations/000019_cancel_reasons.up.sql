@@ -0,0 +1,18 @@
+BEGIN;
+
+-- a cancelled movement either turns INACTIVE or stays ACTIVE and is undone by
+-- a reversal movement dated when the cancellation happened
+ALTER TABLE "stock_movements"
+    ADD COLUMN "cancel_reason"  TEXT,
+    ADD COLUMN "cancelled_at"   TIMESTAMP,
+    ADD COLUMN "reversal_of_id" UUID UNIQUE,
+    ADD CONSTRAINT "fk_reversal_of"
+        FOREIGN KEY ("reversal_of_id")
+            REFERENCES "stock_movements" ("id");
+
+UPDATE "stock_movements"
+SET cancelled_at = updated_at
+WHERE status = 'INACTIVE'
+  AND cancelled_by_user_id IS NOT NULL;
+
+COMMIT;
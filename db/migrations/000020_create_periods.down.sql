@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE IF EXISTS "period_audits";
+DROP TABLE IF EXISTS "periods";
+DROP TYPE IF EXISTS PERIOD_STATUS;
+
+COMMIT;
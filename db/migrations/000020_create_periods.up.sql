@@ -0,0 +1,39 @@
+BEGIN;
+
+DROP TYPE IF EXISTS PERIOD_STATUS;
+CREATE TYPE PERIOD_STATUS AS ENUM ('OPEN', 'CLOSED');
+
+-- a month is open until it is closed, only closed and reopened months have a
+-- row. period is the first day of the month
+CREATE TABLE IF NOT EXISTS "periods"
+(
+    "period"             DATE PRIMARY KEY NOT NULL CHECK (date_trunc('month', "period") = "period"),
+    "status"             PERIOD_STATUS    NOT NULL DEFAULT 'CLOSED',
+    "updated_by_user_id" UUID             NOT NULL,
+    "created_at"         TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at"         TIMESTAMP        NOT NULL DEFAULT NOW(),
+    CONSTRAINT "fk_updated_by_user"
+        FOREIGN KEY ("updated_by_user_id")
+            REFERENCES "users" ("id")
+);
+
+-- every close and reopen of a period
+CREATE TABLE IF NOT EXISTS "period_audits"
+(
+    "id"         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "period"     DATE             NOT NULL,
+    "action"     PERIOD_STATUS    NOT NULL,
+    "user_id"    UUID             NOT NULL,
+    "reason"     TEXT             NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP        NOT NULL DEFAULT NOW(),
+    CONSTRAINT "fk_period"
+        FOREIGN KEY ("period")
+            REFERENCES "periods" ("period"),
+    CONSTRAINT "fk_user"
+        FOREIGN KEY ("user_id")
+            REFERENCES "users" ("id")
+);
+
+CREATE INDEX IF NOT EXISTS "idx_period_audits_period" ON "period_audits" ("period");
+
+COMMIT;
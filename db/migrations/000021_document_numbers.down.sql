@@ -0,0 +1,13 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "uq_stock_movements_invoice_number";
+
+ALTER TABLE "stock_movements"
+    DROP CONSTRAINT IF EXISTS "uq_document_number",
+    DROP COLUMN IF EXISTS "document_number",
+    DROP COLUMN IF EXISTS "invoice_number";
+
+DROP TABLE IF EXISTS "document_sequences";
+DROP FUNCTION IF EXISTS document_prefix(MOVEMENT_TYPE);
+
+COMMIT;
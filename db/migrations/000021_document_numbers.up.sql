@@ -0,0 +1,59 @@
+BEGIN;
+
+-- prefix of the document number of each movement type
+CREATE OR REPLACE FUNCTION document_prefix(movement_type MOVEMENT_TYPE) RETURNS VARCHAR AS
+$$
+SELECT CASE movement_type
+           WHEN 'PURCHASE' THEN 'PUR'
+           WHEN 'SALE' THEN 'SAL'
+           WHEN 'ADJUST' THEN 'ADJ'
+           WHEN 'TRANSFER' THEN 'TRF'
+           WHEN 'PRODUCTION_OUT' THEN 'PRO'
+           WHEN 'PRODUCTION_IN' THEN 'PRI'
+           END;
+$$ LANGUAGE sql IMMUTABLE;
+
+-- last number given to each type in a year, the row is locked until the
+-- movement that took the number commits so numbers have no gaps
+CREATE TABLE IF NOT EXISTS "document_sequences"
+(
+    "type"        MOVEMENT_TYPE NOT NULL,
+    "year"        INT           NOT NULL,
+    "last_number" INT           NOT NULL DEFAULT 0,
+    PRIMARY KEY ("type", "year")
+);
+
+-- invoice_number is the number of the supplier invoice of a purchase
+ALTER TABLE "stock_movements"
+    ADD COLUMN "document_number" VARCHAR(30),
+    ADD COLUMN "invoice_number"  VARCHAR(30);
+
+WITH numbered AS (
+    SELECT
+        id,
+        type,
+        EXTRACT(YEAR FROM date)::INT AS year,
+        row_number() OVER (PARTITION BY type, EXTRACT(YEAR FROM date) ORDER BY created_at, id) AS number
+    FROM "stock_movements"
+)
+UPDATE "stock_movements" sm
+SET document_number = document_prefix(n.type) || '-' || n.year || '-' || lpad(n.number::TEXT, 6, '0')
+FROM numbered n
+WHERE n.id = sm.id;
+
+INSERT INTO "document_sequences" (type, year, last_number)
+SELECT type, EXTRACT(YEAR FROM date)::INT, count(*)
+FROM "stock_movements"
+GROUP BY type, EXTRACT(YEAR FROM date);
+
+ALTER TABLE "stock_movements"
+    ALTER COLUMN "document_number" SET NOT NULL,
+    ADD CONSTRAINT "uq_document_number" UNIQUE ("document_number");
+
+-- an invoice can only be entered once per supplier, unless the purchase
+-- that has it was cancelled
+CREATE UNIQUE INDEX IF NOT EXISTS "uq_stock_movements_invoice_number"
+    ON "stock_movements" ("entity_id", "invoice_number")
+    WHERE invoice_number IS NOT NULL AND status = 'ACTIVE' AND cancelled_at IS NULL;
+
+COMMIT;
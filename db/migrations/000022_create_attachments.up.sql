@@ -0,0 +1,26 @@
+BEGIN;
+
+-- files attached to a movement, the content lives in the file storage under
+-- storage_key
+CREATE TABLE IF NOT EXISTS "attachments"
+(
+    "id"                  UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "stock_movement_id"   UUID             NOT NULL,
+    "file_name"           VARCHAR(255)     NOT NULL,
+    "mime_type"           VARCHAR(255)     NOT NULL,
+    "size"                BIGINT           NOT NULL CHECK ("size" >= 0),
+    "sha256"              CHAR(64)         NOT NULL,
+    "storage_key"         VARCHAR(255)     NOT NULL UNIQUE,
+    "uploaded_by_user_id" UUID             NOT NULL,
+    "created_at"          TIMESTAMP        NOT NULL DEFAULT NOW(),
+    CONSTRAINT "fk_stock_movement"
+        FOREIGN KEY ("stock_movement_id")
+            REFERENCES "stock_movements" ("id"),
+    CONSTRAINT "fk_uploaded_by_user"
+        FOREIGN KEY ("uploaded_by_user_id")
+            REFERENCES "users" ("id")
+);
+
+CREATE INDEX IF NOT EXISTS "idx_attachments_stock_movement_id" ON "attachments" ("stock_movement_id");
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+DROP VIEW IF EXISTS "purchase_order_line_receipts";
+
+ALTER TABLE "stock_movement_items"
+    DROP CONSTRAINT IF EXISTS "fk_purchase_order_line",
+    DROP COLUMN IF EXISTS "purchase_order_line_id";
+
+ALTER TABLE "stock_movements"
+    DROP CONSTRAINT IF EXISTS "fk_purchase_order",
+    DROP COLUMN IF EXISTS "purchase_order_id";
+
+DROP TABLE IF EXISTS "purchase_order_lines";
+DROP TABLE IF EXISTS "purchase_orders";
+DROP TYPE IF EXISTS PURCHASE_ORDER_STATUS;
+
+COMMIT;
@@ -0,0 +1,91 @@
+BEGIN;
+
+DROP TYPE IF EXISTS PURCHASE_ORDER_STATUS;
+CREATE TYPE PURCHASE_ORDER_STATUS AS ENUM ('DRAFT', 'SENT', 'PARTIALLY_RECEIVED', 'RECEIVED', 'CLOSED');
+
+-- prices of the lines are in currency, the currency of its receipts
+CREATE TABLE IF NOT EXISTS "purchase_orders"
+(
+    "id"                 UUID PRIMARY KEY      NOT NULL DEFAULT uuid_generate_v4(),
+    "status"             PURCHASE_ORDER_STATUS NOT NULL DEFAULT 'DRAFT',
+    "entity_id"          UUID                  NOT NULL,
+    "date"               DATE                  NOT NULL,
+    "expected_date"      DATE,
+    "currency"           VARCHAR(3)            NOT NULL DEFAULT 'PYG',
+    "notes"              TEXT                  NOT NULL DEFAULT '',
+    "created_by_user_id" UUID                  NOT NULL,
+    "closed_by_user_id"  UUID,
+    "created_at"         TIMESTAMP             NOT NULL DEFAULT NOW(),
+    "updated_at"         TIMESTAMP             NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_entity"
+        FOREIGN KEY ("entity_id")
+            REFERENCES "entities" ("id"),
+
+    CONSTRAINT "fk_created_by_user"
+        FOREIGN KEY ("created_by_user_id")
+            REFERENCES "users" ("id"),
+
+    CONSTRAINT "fk_closed_by_user"
+        FOREIGN KEY ("closed_by_user_id")
+            REFERENCES "users" ("id")
+);
+
+CREATE INDEX "purchase_orders_status" ON "purchase_orders" ("status");
+CREATE INDEX "purchase_orders_entity" ON "purchase_orders" ("entity_id");
+
+-- quantity is in the base unit of the product and price per base unit
+CREATE TABLE IF NOT EXISTS "purchase_order_lines"
+(
+    "id"                UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "purchase_order_id" UUID             NOT NULL,
+    "product_id"        UUID             NOT NULL,
+    "quantity"          NUMERIC(15, 3)   NOT NULL CHECK ( quantity > 0 ),
+    "price"             NUMERIC(19, 4)   NOT NULL CHECK ( price >= 0 ),
+    "created_at"        TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at"        TIMESTAMP        NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_purchase_order"
+        FOREIGN KEY ("purchase_order_id")
+            REFERENCES "purchase_orders" ("id")
+            ON DELETE CASCADE,
+
+    CONSTRAINT "fk_product"
+        FOREIGN KEY ("product_id")
+            REFERENCES "products" ("id"),
+
+    CONSTRAINT "purchase_order_lines_product_unique"
+        UNIQUE ("purchase_order_id", "product_id")
+);
+
+CREATE INDEX "purchase_order_lines_product" ON "purchase_order_lines" ("product_id");
+
+-- receipts are PURCHASE movements of the order, each item on one of its lines
+ALTER TABLE "stock_movements"
+    ADD COLUMN "purchase_order_id" UUID,
+    ADD CONSTRAINT "fk_purchase_order"
+        FOREIGN KEY ("purchase_order_id")
+            REFERENCES "purchase_orders" ("id");
+
+ALTER TABLE "stock_movement_items"
+    ADD COLUMN "purchase_order_line_id" UUID,
+    ADD CONSTRAINT "fk_purchase_order_line"
+        FOREIGN KEY ("purchase_order_line_id")
+            REFERENCES "purchase_order_lines" ("id");
+
+CREATE INDEX "stock_movement_items_purchase_order_line" ON "stock_movement_items" ("purchase_order_line_id");
+
+-- quantity received on each line by receipts that were neither cancelled nor
+-- reversed
+CREATE OR REPLACE VIEW "purchase_order_line_receipts" AS
+SELECT
+    pol.id                                                       AS purchase_order_line_id,
+    coalesce(sum(smi.quantity) FILTER (WHERE sm.id IS NOT NULL), 0) AS received_quantity
+FROM "purchase_order_lines" pol
+LEFT JOIN "stock_movement_items" smi ON smi.purchase_order_line_id = pol.id
+LEFT JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+    AND sm.status = 'ACTIVE'
+    AND sm.cancelled_at IS NULL
+GROUP BY pol.id;
+
+COMMIT;
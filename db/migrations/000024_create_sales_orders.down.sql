@@ -0,0 +1,17 @@
+BEGIN;
+
+DROP VIEW IF EXISTS "sales_order_line_deliveries";
+
+ALTER TABLE "stock_movement_items"
+    DROP CONSTRAINT IF EXISTS "fk_sales_order_line",
+    DROP COLUMN IF EXISTS "sales_order_line_id";
+
+ALTER TABLE "stock_movements"
+    DROP CONSTRAINT IF EXISTS "fk_sales_order",
+    DROP COLUMN IF EXISTS "sales_order_id";
+
+DROP TABLE IF EXISTS "sales_order_lines";
+DROP TABLE IF EXISTS "sales_orders";
+DROP TYPE IF EXISTS SALES_ORDER_STATUS;
+
+COMMIT;
@@ -0,0 +1,113 @@
+BEGIN;
+
+DROP TYPE IF EXISTS SALES_ORDER_STATUS;
+CREATE TYPE SALES_ORDER_STATUS AS ENUM ('QUOTE', 'CONFIRMED', 'PARTIALLY_DELIVERED', 'DELIVERED', 'CANCELLED');
+
+-- prices of the lines are in currency, the currency of its deliveries. The
+-- lines are reserved on warehouse_id when the order is confirmed
+CREATE TABLE IF NOT EXISTS "sales_orders"
+(
+    "id"                    UUID PRIMARY KEY   NOT NULL DEFAULT uuid_generate_v4(),
+    "status"                SALES_ORDER_STATUS NOT NULL DEFAULT 'QUOTE',
+    "entity_id"             UUID               NOT NULL,
+    "warehouse_id"          UUID               NOT NULL,
+    "date"                  DATE               NOT NULL,
+    "valid_until"           DATE,
+    "currency"              VARCHAR(3)         NOT NULL DEFAULT 'PYG',
+    "notes"                 TEXT               NOT NULL DEFAULT '',
+    "created_by_user_id"    UUID               NOT NULL,
+    "confirmed_at"          TIMESTAMP,
+    "cancelled_by_user_id"  UUID,
+    "cancelled_at"          TIMESTAMP,
+    "created_at"            TIMESTAMP          NOT NULL DEFAULT NOW(),
+    "updated_at"            TIMESTAMP          NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_entity"
+        FOREIGN KEY ("entity_id")
+            REFERENCES "entities" ("id"),
+
+    CONSTRAINT "fk_warehouse"
+        FOREIGN KEY ("warehouse_id")
+            REFERENCES "warehouses" ("id"),
+
+    CONSTRAINT "fk_created_by_user"
+        FOREIGN KEY ("created_by_user_id")
+            REFERENCES "users" ("id"),
+
+    CONSTRAINT "fk_cancelled_by_user"
+        FOREIGN KEY ("cancelled_by_user_id")
+            REFERENCES "users" ("id")
+);
+
+CREATE INDEX "sales_orders_status" ON "sales_orders" ("status");
+CREATE INDEX "sales_orders_entity" ON "sales_orders" ("entity_id");
+
+-- quantity is in the base unit of the product and price per base unit,
+-- reserved_quantity is the part of quantity that was in stock on confirm
+CREATE TABLE IF NOT EXISTS "sales_order_lines"
+(
+    "id"                UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "sales_order_id"    UUID             NOT NULL,
+    "product_id"        UUID             NOT NULL,
+    "quantity"          NUMERIC(15, 3)   NOT NULL CHECK ( quantity > 0 ),
+    "price"             NUMERIC(19, 4)   NOT NULL CHECK ( price >= 0 ),
+    "reserved_quantity" NUMERIC(15, 3)   NOT NULL DEFAULT 0 CHECK ( reserved_quantity >= 0 AND reserved_quantity <= quantity ),
+    "created_at"        TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at"        TIMESTAMP        NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_sales_order"
+        FOREIGN KEY ("sales_order_id")
+            REFERENCES "sales_orders" ("id")
+            ON DELETE CASCADE,
+
+    CONSTRAINT "fk_product"
+        FOREIGN KEY ("product_id")
+            REFERENCES "products" ("id"),
+
+    CONSTRAINT "sales_order_lines_product_unique"
+        UNIQUE ("sales_order_id", "product_id")
+);
+
+CREATE INDEX "sales_order_lines_product" ON "sales_order_lines" ("product_id");
+
+-- deliveries are SALE movements of the order, each item on one of its lines
+ALTER TABLE "stock_movements"
+    ADD COLUMN "sales_order_id" UUID,
+    ADD CONSTRAINT "fk_sales_order"
+        FOREIGN KEY ("sales_order_id")
+            REFERENCES "sales_orders" ("id");
+
+ALTER TABLE "stock_movement_items"
+    ADD COLUMN "sales_order_line_id" UUID,
+    ADD CONSTRAINT "fk_sales_order_line"
+        FOREIGN KEY ("sales_order_line_id")
+            REFERENCES "sales_order_lines" ("id");
+
+CREATE INDEX "stock_movement_items_sales_order_line" ON "stock_movement_items" ("sales_order_line_id");
+
+-- quantity delivered on each line by deliveries that were neither cancelled
+-- nor reversed, and what is still held for the line: the reserved part not
+-- delivered yet, only while the order is open
+CREATE OR REPLACE VIEW "sales_order_line_deliveries" AS
+SELECT
+    sol.id                                                          AS sales_order_line_id,
+    d.delivered_quantity,
+    CASE
+        WHEN so.status IN ('CONFIRMED', 'PARTIALLY_DELIVERED')
+            THEN greatest(sol.reserved_quantity - d.delivered_quantity, 0)
+        ELSE 0
+        END                                                         AS reserved_open_quantity
+FROM "sales_order_lines" sol
+JOIN "sales_orders" so ON so.id = sol.sales_order_id
+JOIN LATERAL (
+    SELECT
+        coalesce(sum(smi.quantity), 0) AS delivered_quantity
+    FROM "stock_movement_items" smi
+    JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+    WHERE
+        smi.sales_order_line_id = sol.id
+        AND sm.status = 'ACTIVE'
+        AND sm.cancelled_at IS NULL
+) d ON TRUE;
+
+COMMIT;
@@ -0,0 +1,30 @@
+BEGIN;
+
+CREATE OR REPLACE VIEW "sales_order_line_deliveries" AS
+SELECT
+    sol.id                                                          AS sales_order_line_id,
+    d.delivered_quantity,
+    CASE
+        WHEN so.status IN ('CONFIRMED', 'PARTIALLY_DELIVERED')
+            THEN greatest(sol.reserved_quantity - d.delivered_quantity, 0)
+        ELSE 0
+        END                                                         AS reserved_open_quantity
+FROM "sales_order_lines" sol
+JOIN "sales_orders" so ON so.id = sol.sales_order_id
+JOIN LATERAL (
+    SELECT
+        coalesce(sum(smi.quantity), 0) AS delivered_quantity
+    FROM "stock_movement_items" smi
+    JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+    WHERE
+        smi.sales_order_line_id = sol.id
+        AND sm.status = 'ACTIVE'
+        AND sm.cancelled_at IS NULL
+) d ON TRUE;
+
+DROP VIEW IF EXISTS "reservation_holds";
+DROP TABLE IF EXISTS "reservations";
+DROP TYPE IF EXISTS RESERVATION_SOURCE;
+DROP TYPE IF EXISTS RESERVATION_STATUS;
+
+COMMIT;
@@ -0,0 +1,141 @@
+BEGIN;
+
+DROP TYPE IF EXISTS RESERVATION_STATUS;
+CREATE TYPE RESERVATION_STATUS AS ENUM ('ACTIVE', 'RELEASED', 'EXPIRED');
+
+DROP TYPE IF EXISTS RESERVATION_SOURCE;
+CREATE TYPE RESERVATION_SOURCE AS ENUM ('SALES_ORDER', 'MANUAL');
+
+-- stock held for a document, quantity is in the base unit of the product.
+-- Without a warehouse the product is held on any of them. source_id is the
+-- document holding the stock, reference describes a manual one.
+CREATE TABLE IF NOT EXISTS "reservations"
+(
+    "id"                  UUID PRIMARY KEY   NOT NULL DEFAULT uuid_generate_v4(),
+    "status"              RESERVATION_STATUS NOT NULL DEFAULT 'ACTIVE',
+    "product_id"          UUID               NOT NULL,
+    "warehouse_id"        UUID,
+    "lot_id"              UUID,
+    "quantity"            NUMERIC(15, 3)     NOT NULL CHECK ( quantity > 0 ),
+    "source_type"         RESERVATION_SOURCE NOT NULL,
+    "source_id"           UUID,
+    "sales_order_line_id" UUID,
+    "reference"           VARCHAR(100)       NOT NULL DEFAULT '',
+    "expires_at"          TIMESTAMP,
+    "created_by_user_id"  UUID               NOT NULL,
+    "released_by_user_id" UUID,
+    "released_at"         TIMESTAMP,
+    "created_at"          TIMESTAMP          NOT NULL DEFAULT NOW(),
+    "updated_at"          TIMESTAMP          NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_product"
+        FOREIGN KEY ("product_id")
+            REFERENCES "products" ("id"),
+
+    CONSTRAINT "fk_warehouse"
+        FOREIGN KEY ("warehouse_id")
+            REFERENCES "warehouses" ("id"),
+
+    CONSTRAINT "fk_lot"
+        FOREIGN KEY ("lot_id")
+            REFERENCES "lots" ("id"),
+
+    CONSTRAINT "fk_sales_order_line"
+        FOREIGN KEY ("sales_order_line_id")
+            REFERENCES "sales_order_lines" ("id"),
+
+    CONSTRAINT "fk_created_by_user"
+        FOREIGN KEY ("created_by_user_id")
+            REFERENCES "users" ("id"),
+
+    CONSTRAINT "fk_released_by_user"
+        FOREIGN KEY ("released_by_user_id")
+            REFERENCES "users" ("id")
+);
+
+CREATE INDEX "reservations_product" ON "reservations" ("product_id") WHERE status = 'ACTIVE';
+CREATE INDEX "reservations_source" ON "reservations" ("source_id");
+CREATE INDEX "reservations_expires_at" ON "reservations" ("expires_at") WHERE status = 'ACTIVE';
+
+-- quantity still held by each active, not expired reservation. Deliveries
+-- of a sales order line take their quantity out of its reservation.
+CREATE OR REPLACE VIEW "reservation_holds" AS
+SELECT
+    r.id,
+    r.product_id,
+    r.warehouse_id,
+    r.lot_id,
+    r.source_type,
+    r.source_id,
+    r.sales_order_line_id,
+    CASE
+        WHEN r.sales_order_line_id IS NULL THEN r.quantity
+        ELSE greatest(r.quantity - d.delivered_quantity, 0)
+        END AS held_quantity
+FROM "reservations" r
+LEFT JOIN LATERAL (
+    SELECT
+        coalesce(sum(smi.quantity), 0) AS delivered_quantity
+    FROM "stock_movement_items" smi
+    JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+    WHERE
+        smi.sales_order_line_id = r.sales_order_line_id
+        AND sm.status = 'ACTIVE'
+        AND sm.cancelled_at IS NULL
+) d ON TRUE
+WHERE
+    r.status = 'ACTIVE'
+    AND (r.expires_at IS NULL OR r.expires_at > now());
+
+-- open sales orders keep what they reserved on confirm as reservations
+INSERT INTO "reservations" (
+    product_id,
+    warehouse_id,
+    quantity,
+    source_type,
+    source_id,
+    sales_order_line_id,
+    created_by_user_id,
+    created_at
+)
+SELECT
+    sol.product_id,
+    so.warehouse_id,
+    sol.reserved_quantity,
+    'SALES_ORDER',
+    so.id,
+    sol.id,
+    so.created_by_user_id,
+    coalesce(so.confirmed_at, so.created_at)
+FROM "sales_order_lines" sol
+JOIN "sales_orders" so ON so.id = sol.sales_order_id
+WHERE
+    so.status IN ('CONFIRMED', 'PARTIALLY_DELIVERED', 'DELIVERED')
+    AND sol.reserved_quantity > 0;
+
+-- what is still held for a line now comes from its reservations
+CREATE OR REPLACE VIEW "sales_order_line_deliveries" AS
+SELECT
+    sol.id                                  AS sales_order_line_id,
+    d.delivered_quantity,
+    coalesce(h.held_quantity, 0)::NUMERIC   AS reserved_open_quantity
+FROM "sales_order_lines" sol
+JOIN LATERAL (
+    SELECT
+        coalesce(sum(smi.quantity), 0) AS delivered_quantity
+    FROM "stock_movement_items" smi
+    JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+    WHERE
+        smi.sales_order_line_id = sol.id
+        AND sm.status = 'ACTIVE'
+        AND sm.cancelled_at IS NULL
+) d ON TRUE
+LEFT JOIN LATERAL (
+    SELECT
+        sum(rh.held_quantity) AS held_quantity
+    FROM "reservation_holds" rh
+    WHERE
+        rh.sales_order_line_id = sol.id
+) h ON TRUE;
+
+COMMIT;
@@ -0,0 +1,45 @@
+BEGIN;
+
+DROP VIEW IF EXISTS "stock_movement_item_returns";
+
+DELETE FROM "stock_movement_items"
+WHERE stock_movement_id IN (SELECT id FROM "stock_movements" WHERE type::TEXT IN ('SALE_RETURN', 'PURCHASE_RETURN'));
+
+DELETE FROM "stock_movements"
+WHERE type::TEXT IN ('SALE_RETURN', 'PURCHASE_RETURN');
+
+DELETE FROM "document_sequences"
+WHERE type::TEXT IN ('SALE_RETURN', 'PURCHASE_RETURN');
+
+ALTER TABLE "stock_movement_items"
+    DROP CONSTRAINT IF EXISTS "fk_return_of_item",
+    DROP COLUMN IF EXISTS "return_of_item_id";
+
+ALTER TABLE "stock_movements"
+    DROP CONSTRAINT IF EXISTS "fk_return_of",
+    DROP COLUMN IF EXISTS "return_of_id";
+
+CREATE OR REPLACE FUNCTION movement_sign(movement_type MOVEMENT_TYPE) RETURNS INT AS
+$$
+SELECT CASE
+           WHEN movement_type::TEXT IN ('SALE', 'PRODUCTION_OUT') THEN -1
+           ELSE 1
+           END
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION document_prefix(movement_type MOVEMENT_TYPE) RETURNS VARCHAR AS
+$$
+SELECT CASE movement_type
+           WHEN 'PURCHASE' THEN 'PUR'
+           WHEN 'SALE' THEN 'SAL'
+           WHEN 'ADJUST' THEN 'ADJ'
+           WHEN 'TRANSFER' THEN 'TRF'
+           WHEN 'PRODUCTION_OUT' THEN 'PRO'
+           WHEN 'PRODUCTION_IN' THEN 'PRI'
+           END;
+$$ LANGUAGE sql IMMUTABLE;
+
+-- postgres cannot drop a value from an enum, SALE_RETURN and PURCHASE_RETURN
+-- stay in MOVEMENT_TYPE
+
+COMMIT;
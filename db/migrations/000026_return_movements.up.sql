@@ -0,0 +1,62 @@
+ALTER TYPE MOVEMENT_TYPE ADD VALUE IF NOT EXISTS 'SALE_RETURN';
+ALTER TYPE MOVEMENT_TYPE ADD VALUE IF NOT EXISTS 'PURCHASE_RETURN';
+
+BEGIN;
+
+-- the new values cannot be used as enum literals in the transaction that
+-- added them, both functions compare the type as text
+CREATE OR REPLACE FUNCTION movement_sign(movement_type MOVEMENT_TYPE) RETURNS INT AS
+$$
+SELECT CASE
+           WHEN movement_type::TEXT IN ('SALE', 'PRODUCTION_OUT', 'PURCHASE_RETURN') THEN -1
+           ELSE 1
+           END
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION document_prefix(movement_type MOVEMENT_TYPE) RETURNS VARCHAR AS
+$$
+SELECT CASE movement_type::TEXT
+           WHEN 'PURCHASE' THEN 'PUR'
+           WHEN 'SALE' THEN 'SAL'
+           WHEN 'ADJUST' THEN 'ADJ'
+           WHEN 'TRANSFER' THEN 'TRF'
+           WHEN 'PRODUCTION_OUT' THEN 'PRO'
+           WHEN 'PRODUCTION_IN' THEN 'PRI'
+           WHEN 'SALE_RETURN' THEN 'SRT'
+           WHEN 'PURCHASE_RETURN' THEN 'PRT'
+           END;
+$$ LANGUAGE sql IMMUTABLE;
+
+-- a return points to the SALE or PURCHASE it gives back and each of its
+-- items to the item returned
+ALTER TABLE "stock_movements"
+    ADD COLUMN "return_of_id" UUID,
+    ADD CONSTRAINT "fk_return_of"
+        FOREIGN KEY ("return_of_id")
+            REFERENCES "stock_movements" ("id");
+
+ALTER TABLE "stock_movement_items"
+    ADD COLUMN "return_of_item_id" UUID,
+    ADD CONSTRAINT "fk_return_of_item"
+        FOREIGN KEY ("return_of_item_id")
+            REFERENCES "stock_movement_items" ("id");
+
+CREATE INDEX "stock_movements_return_of" ON "stock_movements" ("return_of_id");
+CREATE INDEX "stock_movement_items_return_of_item" ON "stock_movement_items" ("return_of_item_id");
+
+-- quantity given back of each returned item by returns that were neither
+-- cancelled nor reversed
+CREATE OR REPLACE VIEW "stock_movement_item_returns" AS
+SELECT
+    smi.return_of_item_id AS stock_movement_item_id,
+    sum(smi.quantity)     AS returned_quantity
+FROM "stock_movement_items" smi
+JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+WHERE
+    smi.return_of_item_id IS NOT NULL
+    AND sm.status = 'ACTIVE'
+    AND sm.cancelled_at IS NULL
+GROUP BY
+    smi.return_of_item_id;
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+ALTER TABLE "stock_movements"
+    DROP CONSTRAINT IF EXISTS "fk_inventory_count",
+    DROP COLUMN IF EXISTS "inventory_count_id";
+
+DROP TABLE IF EXISTS "inventory_count_entries";
+DROP TABLE IF EXISTS "inventory_count_lines";
+DROP TABLE IF EXISTS "inventory_counts";
+DROP TYPE IF EXISTS INVENTORY_COUNT_STATUS;
+
+COMMIT;
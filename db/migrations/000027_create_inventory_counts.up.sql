@@ -0,0 +1,109 @@
+BEGIN;
+
+DROP TYPE IF EXISTS INVENTORY_COUNT_STATUS;
+CREATE TYPE INVENTORY_COUNT_STATUS AS ENUM ('OPEN', 'CLOSED', 'CANCELLED');
+
+-- a physical count of warehouse_id, of every product or only product_ids.
+-- Closing it posts the variances as an ADJUST pointing back to the count
+CREATE TABLE IF NOT EXISTS "inventory_counts"
+(
+    "id"                   UUID PRIMARY KEY       NOT NULL DEFAULT uuid_generate_v4(),
+    "status"               INVENTORY_COUNT_STATUS NOT NULL DEFAULT 'OPEN',
+    "warehouse_id"         UUID                   NOT NULL,
+    "product_ids"          UUID[],
+    "date"                 DATE                   NOT NULL,
+    "notes"                TEXT                   NOT NULL DEFAULT '',
+    "created_by_user_id"   UUID                   NOT NULL,
+    "closed_by_user_id"    UUID,
+    "closed_at"            TIMESTAMP,
+    "cancelled_by_user_id" UUID,
+    "cancelled_at"         TIMESTAMP,
+    "created_at"           TIMESTAMP              NOT NULL DEFAULT NOW(),
+    "updated_at"           TIMESTAMP              NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_warehouse"
+        FOREIGN KEY ("warehouse_id")
+            REFERENCES "warehouses" ("id"),
+
+    CONSTRAINT "fk_created_by_user"
+        FOREIGN KEY ("created_by_user_id")
+            REFERENCES "users" ("id"),
+
+    CONSTRAINT "fk_closed_by_user"
+        FOREIGN KEY ("closed_by_user_id")
+            REFERENCES "users" ("id"),
+
+    CONSTRAINT "fk_cancelled_by_user"
+        FOREIGN KEY ("cancelled_by_user_id")
+            REFERENCES "users" ("id")
+);
+
+CREATE INDEX "inventory_counts_status" ON "inventory_counts" ("status");
+
+-- counts of a warehouse cannot overlap, each would adjust the same stock
+CREATE UNIQUE INDEX "uq_inventory_counts_open_warehouse"
+    ON "inventory_counts" ("warehouse_id")
+    WHERE status = 'OPEN';
+
+-- expected_quantity is the stock on hand of the product, or lot, frozen
+-- when the count was opened, in the base unit of the product. Lines found
+-- during the count are added with nothing expected.
+CREATE TABLE IF NOT EXISTS "inventory_count_lines"
+(
+    "id"                 UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "inventory_count_id" UUID             NOT NULL,
+    "product_id"         UUID             NOT NULL,
+    "lot_id"             UUID,
+    "expected_quantity"  NUMERIC(15, 3)   NOT NULL DEFAULT 0,
+    "created_at"         TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at"         TIMESTAMP        NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_inventory_count"
+        FOREIGN KEY ("inventory_count_id")
+            REFERENCES "inventory_counts" ("id")
+            ON DELETE CASCADE,
+
+    CONSTRAINT "fk_product"
+        FOREIGN KEY ("product_id")
+            REFERENCES "products" ("id"),
+
+    CONSTRAINT "fk_lot"
+        FOREIGN KEY ("lot_id")
+            REFERENCES "lots" ("id")
+);
+
+CREATE UNIQUE INDEX "uq_inventory_count_lines_product_lot"
+    ON "inventory_count_lines" ("inventory_count_id", "product_id", coalesce("lot_id", '00000000-0000-0000-0000-000000000000'::UUID));
+
+-- the quantity counted of a line on each device, a device submitting again
+-- replaces its previous count and the devices add up
+CREATE TABLE IF NOT EXISTS "inventory_count_entries"
+(
+    "id"                      UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    "inventory_count_line_id" UUID             NOT NULL,
+    "device"                  VARCHAR(50)      NOT NULL DEFAULT '',
+    "quantity"                NUMERIC(15, 3)   NOT NULL CHECK ( quantity >= 0 ),
+    "counted_by_user_id"      UUID             NOT NULL,
+    "created_at"              TIMESTAMP        NOT NULL DEFAULT NOW(),
+    "updated_at"              TIMESTAMP        NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_inventory_count_line"
+        FOREIGN KEY ("inventory_count_line_id")
+            REFERENCES "inventory_count_lines" ("id")
+            ON DELETE CASCADE,
+
+    CONSTRAINT "fk_counted_by_user"
+        FOREIGN KEY ("counted_by_user_id")
+            REFERENCES "users" ("id"),
+
+    CONSTRAINT "inventory_count_entries_line_device_unique"
+        UNIQUE ("inventory_count_line_id", "device")
+);
+
+ALTER TABLE "stock_movements"
+    ADD COLUMN "inventory_count_id" UUID,
+    ADD CONSTRAINT "fk_inventory_count"
+        FOREIGN KEY ("inventory_count_id")
+            REFERENCES "inventory_counts" ("id");
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN;
+
+DROP TABLE IF EXISTS "low_stock_alerts";
+DROP TYPE IF EXISTS LOW_STOCK_ALERT_STATUS;
+DROP VIEW IF EXISTS "product_stock_levels";
+
+ALTER TABLE "products"
+    DROP CONSTRAINT IF EXISTS "products_min_stock_reorder_point",
+    DROP CONSTRAINT IF EXISTS "fk_preferred_supplier",
+    DROP COLUMN IF EXISTS "preferred_supplier_id",
+    DROP COLUMN IF EXISTS "reorder_point",
+    DROP COLUMN IF EXISTS "min_stock";
+
+COMMIT;
@@ -0,0 +1,86 @@
+BEGIN;
+
+-- quantities are in the base unit of the product. Products without a
+-- reorder_point are left out of the low stock alerts
+ALTER TABLE "products"
+    ADD COLUMN "min_stock"             NUMERIC(15, 3) CHECK ( min_stock >= 0 ),
+    ADD COLUMN "reorder_point"         NUMERIC(15, 3) CHECK ( reorder_point >= 0 ),
+    ADD COLUMN "preferred_supplier_id" UUID,
+    ADD CONSTRAINT "fk_preferred_supplier"
+        FOREIGN KEY ("preferred_supplier_id")
+            REFERENCES "entities" ("id"),
+    ADD CONSTRAINT "products_min_stock_reorder_point"
+        CHECK ( min_stock <= reorder_point );
+
+-- stock of each product on every warehouse, available is what is left after
+-- the reservations and on_order what open purchase orders still have to
+-- receive
+CREATE OR REPLACE VIEW "product_stock_levels" AS
+SELECT
+    p.id                        AS product_id,
+    s.on_hand,
+    h.reserved,
+    s.on_hand - h.reserved      AS available,
+    o.on_order
+FROM "products" p
+JOIN LATERAL (
+    SELECT
+        coalesce(sum(smi.quantity * movement_sign(sm.type)), 0) AS on_hand
+    FROM "stock_movement_items" smi
+    JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+    WHERE
+        smi.product_id = p.id
+        AND sm.status = 'ACTIVE'
+) s ON TRUE
+JOIN LATERAL (
+    SELECT
+        coalesce(sum(rh.held_quantity), 0) AS reserved
+    FROM "reservation_holds" rh
+    WHERE
+        rh.product_id = p.id
+) h ON TRUE
+JOIN LATERAL (
+    SELECT
+        coalesce(sum(greatest(pol.quantity - polr.received_quantity, 0)), 0) AS on_order
+    FROM "purchase_order_lines" pol
+    JOIN "purchase_orders" po ON po.id = pol.purchase_order_id
+    JOIN "purchase_order_line_receipts" polr ON polr.purchase_order_line_id = pol.id
+    WHERE
+        pol.product_id = p.id
+        AND po.status IN ('SENT', 'PARTIALLY_RECEIVED')
+) o ON TRUE;
+
+DROP TYPE IF EXISTS LOW_STOCK_ALERT_STATUS;
+CREATE TYPE LOW_STOCK_ALERT_STATUS AS ENUM ('OPEN', 'ACKNOWLEDGED', 'RESOLVED');
+
+-- a product falling below its reorder point opens an alert, it is resolved
+-- once the available stock is back at the reorder point. available_quantity
+-- and reorder_point are the values when it was opened.
+CREATE TABLE IF NOT EXISTS "low_stock_alerts"
+(
+    "id"                      UUID PRIMARY KEY       NOT NULL DEFAULT uuid_generate_v4(),
+    "status"                  LOW_STOCK_ALERT_STATUS NOT NULL DEFAULT 'OPEN',
+    "product_id"              UUID                   NOT NULL,
+    "available_quantity"      NUMERIC(15, 3)         NOT NULL,
+    "reorder_point"           NUMERIC(15, 3)         NOT NULL,
+    "opened_at"               TIMESTAMP              NOT NULL DEFAULT NOW(),
+    "acknowledged_by_user_id" UUID,
+    "acknowledged_at"         TIMESTAMP,
+    "resolved_at"             TIMESTAMP,
+    "created_at"              TIMESTAMP              NOT NULL DEFAULT NOW(),
+    "updated_at"              TIMESTAMP              NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT "fk_product"
+        FOREIGN KEY ("product_id")
+            REFERENCES "products" ("id"),
+
+    CONSTRAINT "fk_acknowledged_by_user"
+        FOREIGN KEY ("acknowledged_by_user_id")
+            REFERENCES "users" ("id")
+);
+
+-- a product has at most one alert that is not resolved
+CREATE UNIQUE INDEX "low_stock_alerts_product_unresolved" ON "low_stock_alerts" ("product_id") WHERE status <> 'RESOLVED';
+CREATE INDEX "low_stock_alerts_status" ON "low_stock_alerts" ("status");
+
+COMMIT;
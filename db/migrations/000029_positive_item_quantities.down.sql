@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS "stock_movement_items_quantity" ON "stock_movement_items";
+
+DROP FUNCTION IF EXISTS check_item_quantity();
+
+COMMIT;
@@ -0,0 +1,32 @@
+BEGIN;
+
+-- the type of the movement gives the direction of its items, their quantity
+-- is positive. ADJUST and TRANSFER items are signed and a reversal negates
+-- the items of the movement it reverses. A CHECK cannot see the movement of
+-- the item, a trigger enforces it instead.
+CREATE OR REPLACE FUNCTION check_item_quantity() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF NEW.quantity = 0 OR (NEW.quantity < 0 AND NOT EXISTS (
+        SELECT 1
+        FROM "stock_movements" sm
+        WHERE
+            sm.id = NEW.stock_movement_id
+            AND (sm.type::TEXT IN ('ADJUST', 'TRANSFER') OR sm.reversal_of_id IS NOT NULL)
+    )) THEN
+        RAISE EXCEPTION 'stock movement item quantity must be positive'
+            USING ERRCODE = 'check_violation';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS "stock_movement_items_quantity" ON "stock_movement_items";
+CREATE TRIGGER "stock_movement_items_quantity"
+    BEFORE INSERT OR UPDATE OF "quantity"
+    ON "stock_movement_items"
+    FOR EACH ROW
+EXECUTE FUNCTION check_item_quantity();
+
+COMMIT;
@@ -1,7 +1,7 @@
 INSERT INTO
     public.users (id, status, email, name, password, roles)
 VALUES
-    ('45b3d5f3-1abd-4386-b0a1-dd06b89eb96c', 'ACTIVE', 'admin@hk.com', 'admin', '123456', '{}');
+    ('45b3d5f3-1abd-4386-b0a1-dd06b89eb96c', 'ACTIVE', 'admin@hk.com', 'admin', '123456', '{ADMIN}');
 INSERT INTO
     public.users (id, status, email, name, password, roles)
 VALUES
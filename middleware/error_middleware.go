@@ -49,6 +49,32 @@ func FiberCustomErrorHandler(c *fiber.Ctx, err error) error {
 		})
 	}
 
+	var invalidOperationError *constants.InvalidOperationError
+	if errors.As(err, &invalidOperationError) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(map[string]string{
+			"code":    "invalid_operation",
+			"message": invalidOperationError.Error(),
+		})
+	}
+
+	var insufficientStockError *constants.InsufficientStockError
+	if errors.As(err, &insufficientStockError) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(map[string]any{
+			"code":    "insufficient_stock",
+			"message": insufficientStockError.Error(),
+			"items":   insufficientStockError.Items,
+		})
+	}
+
+	var periodClosedError *constants.PeriodClosedError
+	if errors.As(err, &periodClosedError) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(map[string]string{
+			"code":    "period_closed",
+			"message": periodClosedError.Error(),
+			"period":  periodClosedError.Period,
+		})
+	}
+
 	var validationErrors validator.ValidationErrors
 	if errors.As(err, &validationErrors) {
 		errorMessages := make([]map[string]string, 0)
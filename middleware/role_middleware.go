@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireRoles only lets through users with at least one of roles, it must
+// run after AuthMiddleware
+func RequireRoles(roles ...string) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		userRoles, _ := c.Locals("roles").([]string)
+		for _, userRole := range userRoles {
+			for _, role := range roles {
+				if userRole == role {
+					return c.Next()
+				}
+			}
+		}
+
+		return fiber.NewError(fiber.StatusForbidden, "forbidden")
+	}
+}
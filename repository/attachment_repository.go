@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// Attachment is the metadata of a file attached to a stock movement, its
+// content is kept in the file storage under StorageKey
+type Attachment struct {
+	ID                 *pgxuuid.UUID
+	StockMovementID    *pgxuuid.UUID
+	FileName           string
+	MimeType           string
+	Size               int64
+	Sha256             string
+	StorageKey         string
+	UploadedByUserID   *pgxuuid.UUID
+	UploadedByUserName string
+	CreatedAt          time.Time
+}
+
+const attachmentQuery = `
+	SELECT
+		a.id,
+		a.stock_movement_id,
+		a.file_name,
+		a.mime_type,
+		a.size,
+		a.sha256,
+		a.storage_key,
+		a.uploaded_by_user_id,
+		u.name,
+		a.created_at
+	FROM "attachments" a
+	JOIN "users" u ON u.id = a.uploaded_by_user_id
+`
+
+func scanAttachment(row pgx.Row) (*Attachment, error) {
+	var attachment Attachment
+	err := row.Scan(
+		&attachment.ID,
+		&attachment.StockMovementID,
+		&attachment.FileName,
+		&attachment.MimeType,
+		&attachment.Size,
+		&attachment.Sha256,
+		&attachment.StorageKey,
+		&attachment.UploadedByUserID,
+		&attachment.UploadedByUserName,
+		&attachment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attachment, nil
+}
+
+func (r *PgRepository) FetchAttachments(ctx context.Context, stockMovementID *pgxuuid.UUID) ([]*Attachment, error) {
+	rows, err := r.db.Query(ctx, attachmentQuery+`
+		WHERE
+			a.stock_movement_id = $1
+		ORDER BY
+			a.created_at
+	`, stockMovementID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]*Attachment, 0)
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+func (r *PgRepository) GetAttachment(ctx context.Context, stockMovementID *pgxuuid.UUID, id *pgxuuid.UUID) (*Attachment, error) {
+	return scanAttachment(r.db.QueryRow(ctx, attachmentQuery+`
+		WHERE
+			a.stock_movement_id = $1
+			AND a.id = $2
+	`, stockMovementID, id))
+}
+
+type CreateAttachmentParams struct {
+	StockMovementID  *pgxuuid.UUID
+	FileName         string
+	MimeType         string
+	Size             int64
+	Sha256           string
+	StorageKey       string
+	UploadedByUserID *pgxuuid.UUID
+}
+
+func (r *PgRepository) CreateAttachment(ctx context.Context, params *CreateAttachmentParams) (*Attachment, error) {
+	var id pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO "attachments" (
+			stock_movement_id,
+			file_name,
+			mime_type,
+			size,
+			sha256,
+			storage_key,
+			uploaded_by_user_id
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		) RETURNING id
+	`, params.StockMovementID, params.FileName, params.MimeType, params.Size, params.Sha256, params.StorageKey, params.UploadedByUserID).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetAttachment(ctx, params.StockMovementID, &id)
+}
+
+func (r *PgRepository) DeleteAttachment(ctx context.Context, id *pgxuuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM "attachments"
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,491 @@
+package repository
+
+import (
+	"context"
+	"github.com/hoffax/prodrest/constants"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+type Bom struct {
+	ID          *pgxuuid.UUID
+	Status      string
+	ProductID   *pgxuuid.UUID
+	ProductName string
+	Version     int
+	Notes       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	Items []*BomItem
+}
+
+type BomItem struct {
+	ID                   *pgxuuid.UUID
+	BomID                *pgxuuid.UUID
+	ComponentProductID   *pgxuuid.UUID
+	ComponentProductName string
+	Quantity             decimal.Decimal
+	ScrapPercentage      decimal.Decimal
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// fetchBomItems loads the items of the given boms into them
+func (r *PgRepository) fetchBomItems(ctx context.Context, boms []*Bom) error {
+	bomIDs := make([]pgxuuid.UUID, len(boms))
+	bomMap := make(map[pgxuuid.UUID]*Bom, len(boms))
+	for i, bom := range boms {
+		bomIDs[i] = *bom.ID
+		bomMap[*bom.ID] = bom
+		bom.Items = make([]*BomItem, 0)
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			bi.id,
+			bi.bom_id,
+			bi.component_product_id,
+			p.name,
+			bi.quantity,
+			bi.scrap_percentage,
+			bi.created_at,
+			bi.updated_at
+		FROM "bom_items" bi
+		JOIN "products" p ON p.id = bi.component_product_id
+		WHERE
+			bi.bom_id = ANY($1::uuid[])
+		ORDER BY
+			p.name
+	`, bomIDs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := BomItem{}
+		err := rows.Scan(
+			&item.ID,
+			&item.BomID,
+			&item.ComponentProductID,
+			&item.ComponentProductName,
+			&item.Quantity,
+			&item.ScrapPercentage,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if bom, ok := bomMap[*item.BomID]; ok {
+			bom.Items = append(bom.Items, &item)
+		}
+	}
+
+	return rows.Err()
+}
+
+// FetchProductBoms returns every version of the recipe of a product, newest
+// first
+func (r *PgRepository) FetchProductBoms(ctx context.Context, productID *pgxuuid.UUID) ([]*Bom, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			b.id,
+			b.status,
+			b.product_id,
+			p.name,
+			b.version,
+			b.notes,
+			b.created_at,
+			b.updated_at
+		FROM "boms" b
+		JOIN "products" p ON p.id = b.product_id
+		WHERE
+			b.product_id = $1
+		ORDER BY
+			b.version DESC
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boms := make([]*Bom, 0)
+	for rows.Next() {
+		bom := Bom{}
+		err := rows.Scan(
+			&bom.ID,
+			&bom.Status,
+			&bom.ProductID,
+			&bom.ProductName,
+			&bom.Version,
+			&bom.Notes,
+			&bom.CreatedAt,
+			&bom.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		boms = append(boms, &bom)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = r.fetchBomItems(ctx, boms)
+	if err != nil {
+		return nil, err
+	}
+
+	return boms, nil
+}
+
+func (r *PgRepository) getBom(ctx context.Context, where string, args ...any) (*Bom, error) {
+	bom := Bom{}
+	err := r.db.QueryRow(ctx, `
+		SELECT
+			b.id,
+			b.status,
+			b.product_id,
+			p.name,
+			b.version,
+			b.notes,
+			b.created_at,
+			b.updated_at
+		FROM "boms" b
+		JOIN "products" p ON p.id = b.product_id
+		WHERE `+where+`
+		ORDER BY
+			b.version DESC
+		LIMIT 1
+	`, args...).Scan(
+		&bom.ID,
+		&bom.Status,
+		&bom.ProductID,
+		&bom.ProductName,
+		&bom.Version,
+		&bom.Notes,
+		&bom.CreatedAt,
+		&bom.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.fetchBomItems(ctx, []*Bom{&bom})
+	if err != nil {
+		return nil, err
+	}
+
+	return &bom, nil
+}
+
+func (r *PgRepository) GetBomByID(ctx context.Context, id *pgxuuid.UUID) (*Bom, error) {
+	return r.getBom(ctx, `b.id = $1`, id)
+}
+
+func (r *PgRepository) GetBomByVersion(ctx context.Context, productID *pgxuuid.UUID, version int) (*Bom, error) {
+	return r.getBom(ctx, `b.product_id = $1 AND b.version = $2`, productID, version)
+}
+
+// GetActiveBom returns the latest ACTIVE version of the recipe of a product
+func (r *PgRepository) GetActiveBom(ctx context.Context, productID *pgxuuid.UUID) (*Bom, error) {
+	return r.getBom(ctx, `b.product_id = $1 AND b.status = 'ACTIVE'`, productID)
+}
+
+// BomEdge links a product to a component of one of its ACTIVE recipes
+type BomEdge struct {
+	ProductID            *pgxuuid.UUID
+	ProductName          string
+	ComponentProductID   *pgxuuid.UUID
+	ComponentProductName string
+}
+
+func fetchBomEdges(ctx context.Context, tx pgx.Tx) ([]*BomEdge, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT DISTINCT
+			b.product_id,
+			p.name,
+			bi.component_product_id,
+			cp.name
+		FROM "boms" b
+		JOIN "bom_items" bi ON bi.bom_id = b.id
+		JOIN "products" p ON p.id = b.product_id
+		JOIN "products" cp ON cp.id = bi.component_product_id
+		WHERE
+			b.status = 'ACTIVE'
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edges := make([]*BomEdge, 0)
+	for rows.Next() {
+		edge := BomEdge{}
+		err := rows.Scan(
+			&edge.ProductID,
+			&edge.ProductName,
+			&edge.ComponentProductID,
+			&edge.ComponentProductName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, &edge)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return edges, nil
+}
+
+// bomComponents groups the edges by the product whose recipe they belong to
+func bomComponents(edges []*BomEdge) map[pgxuuid.UUID][]*BomEdge {
+	components := make(map[pgxuuid.UUID][]*BomEdge)
+	for _, edge := range edges {
+		components[*edge.ProductID] = append(components[*edge.ProductID], edge)
+	}
+
+	return components
+}
+
+// bomCycle searches depth first down the recipes of componentID and returns
+// the edges leading back to productID, nil when componentID does not use
+// productID
+func bomCycle(productID pgxuuid.UUID, componentID pgxuuid.UUID, components map[pgxuuid.UUID][]*BomEdge) []*BomEdge {
+	var path []*BomEdge
+	visited := make(map[pgxuuid.UUID]bool)
+	var reaches func(id pgxuuid.UUID) bool
+	reaches = func(id pgxuuid.UUID) bool {
+		if id == productID {
+			return true
+		}
+		if visited[id] {
+			return false
+		}
+		visited[id] = true
+
+		for _, edge := range components[id] {
+			path = append(path, edge)
+			if reaches(*edge.ComponentProductID) {
+				return true
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+
+	if !reaches(componentID) {
+		return nil
+	}
+
+	return path
+}
+
+// lockBoms serialises the writes of recipes until tx ends, so two saves
+// cannot each miss the cycle the other one closes
+func lockBoms(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('boms'))`)
+	return err
+}
+
+// checkBomCycles fails when a component of a recipe for productID uses
+// productID anywhere down its own recipes, tx has to hold lockBoms
+func checkBomCycles(ctx context.Context, tx pgx.Tx, productID *pgxuuid.UUID, items []*CreateBomItem) error {
+	edges, err := fetchBomEdges(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	components := bomComponents(edges)
+	for _, item := range items {
+		cycle := bomCycle(*productID, *item.ComponentProductID, components)
+		if len(cycle) == 0 {
+			continue
+		}
+
+		// the last edge leads back to the product and the first one starts
+		// at the component
+		names := []string{cycle[len(cycle)-1].ComponentProductName, cycle[0].ProductName}
+		for _, edge := range cycle {
+			names = append(names, edge.ComponentProductName)
+		}
+		return constants.NewInvalidOperationError("cyclic recipe: " + strings.Join(names, " -> "))
+	}
+
+	return nil
+}
+
+type CreateBomItem struct {
+	ComponentProductID *pgxuuid.UUID
+	Quantity           decimal.Decimal
+	ScrapPercentage    decimal.Decimal
+}
+
+type CreateBomParams struct {
+	ProductID *pgxuuid.UUID
+	Notes     string
+	Items     []*CreateBomItem
+}
+
+func insertBomItems(ctx context.Context, tx pgx.Tx, bomID *pgxuuid.UUID, items []*CreateBomItem) error {
+	for _, item := range items {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO "bom_items" (
+				bom_id,
+				component_product_id,
+				quantity,
+				scrap_percentage
+			) VALUES (
+				$1, $2, $3, $4
+			)
+		`, bomID, item.ComponentProductID, item.Quantity, item.ScrapPercentage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateBom stores a new version of the recipe of a product, numbered after
+// the last existing one. A component using the product down its own recipes
+// fails with an InvalidOperationError.
+func (r *PgRepository) CreateBom(ctx context.Context, params *CreateBomParams) (*Bom, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockBoms(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.lockProducts(ctx, tx, []pgxuuid.UUID{*params.ProductID})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkBomCycles(ctx, tx, params.ProductID, params.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var bomID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO "boms" (
+			product_id,
+			version,
+			notes
+		) VALUES (
+			$1,
+			(SELECT coalesce(max(version), 0) + 1 FROM "boms" WHERE product_id = $1),
+			$2
+		) RETURNING id
+	`, params.ProductID, params.Notes).Scan(
+		&bomID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertBomItems(ctx, tx, &bomID, params.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetBomByID(ctx, &bomID)
+}
+
+type UpdateBomParams struct {
+	ID     *pgxuuid.UUID
+	Status string
+	Notes  string
+	Items  []*CreateBomItem
+}
+
+// UpdateBom changes the status and notes of a recipe version and replaces
+// its items, an ACTIVE version is checked for cycles like on CreateBom
+func (r *PgRepository) UpdateBom(ctx context.Context, params *UpdateBomParams) (*Bom, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockBoms(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var productID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		UPDATE "boms" SET
+			status = $2,
+			notes = $3,
+			updated_at = now()
+		WHERE
+			id = $1
+		RETURNING product_id
+	`, params.ID, params.Status, params.Notes).Scan(
+		&productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Status == "ACTIVE" {
+		err = checkBomCycles(ctx, tx, &productID, params.Items)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM "bom_items" WHERE bom_id = $1`, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertBomItems(ctx, tx, params.ID, params.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetBomByID(ctx, params.ID)
+}
+
+func (r *PgRepository) DeactivateBom(ctx context.Context, id *pgxuuid.UUID) (*Bom, error) {
+	_, err := r.db.Exec(ctx, `
+		UPDATE "boms" SET
+			status = 'INACTIVE',
+			updated_at = now()
+		WHERE
+			id = $1
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetBomByID(ctx, id)
+}
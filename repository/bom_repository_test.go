@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"github.com/gofrs/uuid/v5"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"testing"
+)
+
+func TestBomCycle(t *testing.T) {
+	ids := make(map[string]*pgxuuid.UUID)
+	id := func(name string) *pgxuuid.UUID {
+		if ids[name] == nil {
+			productID := pgxuuid.UUID(uuid.Must(uuid.NewV4()))
+			ids[name] = &productID
+		}
+		return ids[name]
+	}
+	edge := func(product, component string) *BomEdge {
+		return &BomEdge{
+			ProductID:            id(product),
+			ProductName:          product,
+			ComponentProductID:   id(component),
+			ComponentProductName: component,
+		}
+	}
+
+	// cake uses dough and icing, dough uses flour and icing uses sugar twice
+	// over through syrup
+	components := bomComponents([]*BomEdge{
+		edge("cake", "dough"),
+		edge("cake", "icing"),
+		edge("dough", "flour"),
+		edge("icing", "sugar"),
+		edge("icing", "syrup"),
+		edge("syrup", "sugar"),
+	})
+
+	t.Run("no cycle", func(t *testing.T) {
+		if cycle := bomCycle(*id("flour"), *id("icing"), components); cycle != nil {
+			t.Fatalf("expected no cycle, got %d edges", len(cycle))
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		// a recipe of sugar using cake closes cake -> icing -> syrup -> sugar
+		cycle := bomCycle(*id("sugar"), *id("cake"), components)
+		if len(cycle) == 0 {
+			t.Fatalf("expected a cycle")
+		}
+
+		last := cycle[len(cycle)-1]
+		if cycle[0].ProductName != "cake" || *last.ComponentProductID != *id("sugar") {
+			t.Fatalf("expected the path from cake back to sugar, got %v -> %v", cycle[0].ProductName, last.ComponentProductName)
+		}
+	})
+}
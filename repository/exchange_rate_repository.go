@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"context"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// ExchangeRate is how many units of the base currency one unit of Currency
+// is worth from Date on
+type ExchangeRate struct {
+	ID        *pgxuuid.UUID
+	Currency  string
+	Date      time.Time
+	Rate      decimal.Decimal
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const exchangeRateQuery = `
+	SELECT
+		id,
+		currency,
+		date,
+		rate,
+		created_at,
+		updated_at
+	FROM "exchange_rates"
+`
+
+func scanExchangeRate(row pgx.Row) (*ExchangeRate, error) {
+	var exchangeRate ExchangeRate
+	err := row.Scan(
+		&exchangeRate.ID,
+		&exchangeRate.Currency,
+		&exchangeRate.Date,
+		&exchangeRate.Rate,
+		&exchangeRate.CreatedAt,
+		&exchangeRate.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &exchangeRate, nil
+}
+
+type FetchExchangeRatesParams struct {
+	Currency string
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+	Offset   int
+}
+
+type FetchExchangeRatesResult struct {
+	TotalCount int
+	Items      []*ExchangeRate
+}
+
+func (r *PgRepository) FetchExchangeRates(ctx context.Context, params *FetchExchangeRatesParams) (*FetchExchangeRatesResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			COUNT(*) OVER() AS full_count,
+			er.*
+		FROM (`+exchangeRateQuery+`) er
+		WHERE
+			($1 = '' OR er.currency = $1)
+			AND ($2::DATE IS NULL OR er.date >= $2)
+			AND ($3::DATE IS NULL OR er.date <= $3)
+		ORDER BY
+			er.date DESC,
+			er.currency
+		LIMIT $4
+		OFFSET $5
+	`, params.Currency, params.From, params.To, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchExchangeRatesResult{
+		Items: make([]*ExchangeRate, 0),
+	}
+	for rows.Next() {
+		var exchangeRate ExchangeRate
+		err := rows.Scan(
+			&result.TotalCount,
+			&exchangeRate.ID,
+			&exchangeRate.Currency,
+			&exchangeRate.Date,
+			&exchangeRate.Rate,
+			&exchangeRate.CreatedAt,
+			&exchangeRate.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, &exchangeRate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *PgRepository) GetExchangeRateByID(ctx context.Context, id *pgxuuid.UUID) (*ExchangeRate, error) {
+	return scanExchangeRate(r.db.QueryRow(ctx, exchangeRateQuery+`
+		WHERE id = $1
+	`, id))
+}
+
+func (r *PgRepository) GetExchangeRateByDate(ctx context.Context, currency string, date time.Time) (*ExchangeRate, error) {
+	return scanExchangeRate(r.db.QueryRow(ctx, exchangeRateQuery+`
+		WHERE
+			currency = $1
+			AND date = $2
+	`, currency, date))
+}
+
+// GetEffectiveExchangeRate returns the latest rate of currency published on
+// or before date
+func (r *PgRepository) GetEffectiveExchangeRate(ctx context.Context, currency string, date time.Time) (*ExchangeRate, error) {
+	return scanExchangeRate(r.db.QueryRow(ctx, exchangeRateQuery+`
+		WHERE
+			currency = $1
+			AND date <= $2
+		ORDER BY
+			date DESC
+		LIMIT 1
+	`, currency, date))
+}
+
+type CreateExchangeRateParams struct {
+	Currency string
+	Date     time.Time
+	Rate     decimal.Decimal
+}
+
+func (r *PgRepository) CreateExchangeRate(ctx context.Context, params *CreateExchangeRateParams) (*ExchangeRate, error) {
+	var id pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO "exchange_rates" (
+			currency,
+			date,
+			rate
+		) VALUES (
+			$1, $2, $3
+		) RETURNING id
+	`, params.Currency, params.Date, params.Rate).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetExchangeRateByID(ctx, &id)
+}
+
+type UpdateExchangeRateParams struct {
+	ID   *pgxuuid.UUID
+	Date time.Time
+	Rate decimal.Decimal
+}
+
+// UpdateExchangeRate corrects a published rate, movements already posted keep
+// the rate they were converted with
+func (r *PgRepository) UpdateExchangeRate(ctx context.Context, params *UpdateExchangeRateParams) (*ExchangeRate, error) {
+	_, err := r.db.Exec(ctx, `
+		UPDATE "exchange_rates" SET
+			date = $2,
+			rate = $3,
+			updated_at = now()
+		WHERE
+			id = $1
+	`, params.ID, params.Date, params.Rate)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetExchangeRateByID(ctx, params.ID)
+}
+
+func (r *PgRepository) DeleteExchangeRate(ctx context.Context, id *pgxuuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM "exchange_rates"
+		WHERE id = $1
+	`, id)
+
+	return err
+}
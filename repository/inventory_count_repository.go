@@ -0,0 +1,584 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/hoffax/prodrest/constants"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// InventoryCount counts WarehouseID, every product or only ProductIDs.
+// StockMovementID is the ADJUST posted on close, nil when nothing differed.
+type InventoryCount struct {
+	ID              *pgxuuid.UUID
+	Status          string
+	WarehouseID     *pgxuuid.UUID
+	WarehouseName   string
+	ProductIDs      []pgxuuid.UUID
+	Date            time.Time
+	Notes           string
+	StockMovementID *pgxuuid.UUID
+	ClosedAt        *time.Time
+	CancelledAt     *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+
+	CreatedByUserID     *pgxuuid.UUID
+	CreatedByUserName   string
+	ClosedByUserID      *pgxuuid.UUID
+	ClosedByUserName    string
+	CancelledByUserID   *pgxuuid.UUID
+	CancelledByUserName string
+
+	Lines []*InventoryCountLine
+}
+
+// InventoryCountLine holds quantities in the base unit of the product.
+// CountedQuantity adds up the counts of every device, nil until one counted
+// the line.
+type InventoryCountLine struct {
+	ID               *pgxuuid.UUID
+	InventoryCountID *pgxuuid.UUID
+	ProductID        *pgxuuid.UUID
+	ProductName      string
+	LotID            *pgxuuid.UUID
+	Batch            string
+	ExpectedQuantity decimal.Decimal
+	CountedQuantity  *decimal.Decimal
+	Devices          int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// inventoryCountQuery selects every column scanned by scanInventoryCount,
+// callers append their own WHERE and ORDER BY
+const inventoryCountQuery = `
+	SELECT
+		ic.id,
+		ic.status,
+		ic.warehouse_id,
+		w.name,
+		ic.product_ids,
+		ic.date,
+		ic.notes,
+		(SELECT sm.id FROM "stock_movements" sm WHERE sm.inventory_count_id = ic.id),
+		ic.closed_at,
+		ic.cancelled_at,
+		ic.created_at,
+		ic.updated_at,
+		cu.id,
+		cu.name,
+		cu2.id,
+		coalesce(cu2.name, ''),
+		cu3.id,
+		coalesce(cu3.name, '')
+	FROM "inventory_counts" ic
+	JOIN "warehouses" w ON w.id = ic.warehouse_id
+	LEFT JOIN "users" cu ON cu.id = ic.created_by_user_id
+	LEFT JOIN "users" cu2 ON cu2.id = ic.closed_by_user_id
+	LEFT JOIN "users" cu3 ON cu3.id = ic.cancelled_by_user_id
+`
+
+func scanInventoryCount(row pgx.Row, extra ...any) (*InventoryCount, error) {
+	ic := InventoryCount{}
+	dest := append(extra,
+		&ic.ID,
+		&ic.Status,
+		&ic.WarehouseID,
+		&ic.WarehouseName,
+		&ic.ProductIDs,
+		&ic.Date,
+		&ic.Notes,
+		&ic.StockMovementID,
+		&ic.ClosedAt,
+		&ic.CancelledAt,
+		&ic.CreatedAt,
+		&ic.UpdatedAt,
+		&ic.CreatedByUserID,
+		&ic.CreatedByUserName,
+		&ic.ClosedByUserID,
+		&ic.ClosedByUserName,
+		&ic.CancelledByUserID,
+		&ic.CancelledByUserName,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ic, nil
+}
+
+// fetchInventoryCountLines returns the lines of a count, tx may be nil
+func (r *PgRepository) fetchInventoryCountLines(ctx context.Context, tx pgx.Tx, countID *pgxuuid.UUID) ([]*InventoryCountLine, error) {
+	query := `
+		SELECT
+			icl.id,
+			icl.inventory_count_id,
+			icl.product_id,
+			p.name,
+			icl.lot_id,
+			coalesce(l.code, ''),
+			icl.expected_quantity,
+			e.counted_quantity,
+			e.devices,
+			icl.created_at,
+			icl.updated_at
+		FROM "inventory_count_lines" icl
+		JOIN "products" p ON p.id = icl.product_id
+		LEFT JOIN "lots" l ON l.id = icl.lot_id
+		JOIN LATERAL (
+			SELECT
+				sum(ice.quantity) AS counted_quantity,
+				count(*) AS devices
+			FROM "inventory_count_entries" ice
+			WHERE
+				ice.inventory_count_line_id = icl.id
+		) e ON TRUE
+		WHERE
+			icl.inventory_count_id = $1
+		ORDER BY
+			p.name,
+			l.code NULLS FIRST
+	`
+
+	var rows pgx.Rows
+	var err error
+	if tx != nil {
+		rows, err = tx.Query(ctx, query, countID)
+	} else {
+		rows, err = r.db.Query(ctx, query, countID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make([]*InventoryCountLine, 0)
+	for rows.Next() {
+		line := InventoryCountLine{}
+		err := rows.Scan(
+			&line.ID,
+			&line.InventoryCountID,
+			&line.ProductID,
+			&line.ProductName,
+			&line.LotID,
+			&line.Batch,
+			&line.ExpectedQuantity,
+			&line.CountedQuantity,
+			&line.Devices,
+			&line.CreatedAt,
+			&line.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, &line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+type FetchInventoryCountsParams struct {
+	StatusOptions []string
+	WarehouseID   *pgxuuid.UUID
+	Limit         int
+	Offset        int
+}
+
+type FetchInventoryCountsResult struct {
+	TotalCount int
+	Items      []*InventoryCount
+}
+
+// FetchInventoryCounts lists counts without their lines
+func (r *PgRepository) FetchInventoryCounts(ctx context.Context, params *FetchInventoryCountsParams) (*FetchInventoryCountsResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			COUNT(*) OVER() AS full_count,
+			q.*
+		FROM (`+inventoryCountQuery+`
+			WHERE
+				(cardinality($1::inventory_count_status[]) = 0 OR ic.status = ANY($1::inventory_count_status[]))
+				AND ($2::UUID IS NULL OR ic.warehouse_id = $2)
+		) q
+		ORDER BY
+			q.date DESC,
+			q.created_at DESC
+		LIMIT $3
+		OFFSET $4
+	`, params.StatusOptions, params.WarehouseID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchInventoryCountsResult{
+		Items: make([]*InventoryCount, 0),
+	}
+	for rows.Next() {
+		ic, err := scanInventoryCount(rows, &result.TotalCount)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, ic)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetInventoryCountByID returns a count with its lines
+func (r *PgRepository) GetInventoryCountByID(ctx context.Context, id *pgxuuid.UUID) (*InventoryCount, error) {
+	ic, err := scanInventoryCount(r.db.QueryRow(ctx, inventoryCountQuery+`
+		WHERE
+			ic.id = $1
+	`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	ic.Lines, err = r.fetchInventoryCountLines(ctx, nil, ic.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ic, nil
+}
+
+type CreateInventoryCountParams struct {
+	WarehouseID *pgxuuid.UUID
+	// ProductIDs limits the count to those products, every product when nil
+	ProductIDs []pgxuuid.UUID
+	Date       time.Time
+	Notes      string
+	CreatedBy  *pgxuuid.UUID
+}
+
+// CreateInventoryCount opens a count and freezes the stock on hand of the
+// warehouse as its expected quantities, a line per product and lot with
+// stock. Products of the subset without stock get a line with nothing
+// expected, unless they are batch controlled and the lot is not known yet.
+func (r *PgRepository) CreateInventoryCount(ctx context.Context, params *CreateInventoryCountParams) (*InventoryCount, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var countID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO "inventory_counts" (
+			warehouse_id,
+			product_ids,
+			date,
+			notes,
+			created_by_user_id
+		) VALUES (
+			$1, $2, $3, $4, $5
+		)
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`,
+		params.WarehouseID,
+		params.ProductIDs,
+		params.Date,
+		params.Notes,
+		params.CreatedBy,
+	).Scan(
+		&countID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("warehouseId: the warehouse already has an open count")
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO "inventory_count_lines" (
+			inventory_count_id,
+			product_id,
+			lot_id,
+			expected_quantity
+		)
+		SELECT
+			$1,
+			smi.product_id,
+			smi.lot_id,
+			sum(smi.quantity * movement_sign(sm.type))
+		FROM "stock_movement_items" smi
+		JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+		WHERE
+			sm.status = 'ACTIVE'
+			AND smi.warehouse_id = $2
+			AND ($3::uuid[] IS NULL OR smi.product_id = ANY($3::uuid[]))
+		GROUP BY
+			smi.product_id,
+			smi.lot_id
+		HAVING
+			sum(smi.quantity * movement_sign(sm.type)) <> 0
+	`, &countID, params.WarehouseID, params.ProductIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.ProductIDs != nil {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO "inventory_count_lines" (
+				inventory_count_id,
+				product_id
+			)
+			SELECT
+				$1,
+				p.id
+			FROM "products" p
+			WHERE
+				p.id = ANY($2::uuid[])
+				AND NOT p.batch_control
+			ON CONFLICT DO NOTHING
+		`, &countID, params.ProductIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetInventoryCountByID(ctx, &countID)
+}
+
+// InventoryCountEntry is the quantity a device counted of a product, or of
+// one of its lots
+type InventoryCountEntry struct {
+	ProductID *pgxuuid.UUID
+	LotID     *pgxuuid.UUID
+	Quantity  decimal.Decimal
+}
+
+type SubmitInventoryCountParams struct {
+	ID      *pgxuuid.UUID
+	Device  string
+	UserID  *pgxuuid.UUID
+	Entries []*InventoryCountEntry
+}
+
+// SubmitInventoryCount records what a device counted. A device counting a
+// line again replaces its previous quantity, lines not frozen on open are
+// added with nothing expected. The count is only share locked so devices
+// can submit at the same time, but not while it is being closed.
+func (r *PgRepository) SubmitInventoryCount(ctx context.Context, params *SubmitInventoryCountParams) (*InventoryCount, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var countID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		SELECT id
+		FROM "inventory_counts"
+		WHERE
+			id = $1
+			AND status = 'OPEN'
+		FOR SHARE
+	`, params.ID).Scan(
+		&countID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("inventory count is not open")
+		}
+		return nil, err
+	}
+
+	for _, entry := range params.Entries {
+		var lineID pgxuuid.UUID
+		err = tx.QueryRow(ctx, `
+			INSERT INTO "inventory_count_lines" (
+				inventory_count_id,
+				product_id,
+				lot_id
+			) VALUES (
+				$1, $2, $3
+			)
+			ON CONFLICT (inventory_count_id, product_id, coalesce(lot_id, '00000000-0000-0000-0000-000000000000'::UUID)) DO UPDATE SET
+				updated_at = now()
+			RETURNING id
+		`, &countID, entry.ProductID, entry.LotID).Scan(
+			&lineID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO "inventory_count_entries" (
+				inventory_count_line_id,
+				device,
+				quantity,
+				counted_by_user_id
+			) VALUES (
+				$1, $2, $3, $4
+			)
+			ON CONFLICT (inventory_count_line_id, device) DO UPDATE SET
+				quantity = excluded.quantity,
+				counted_by_user_id = excluded.counted_by_user_id,
+				updated_at = now()
+		`, &lineID, params.Device, entry.Quantity, params.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetInventoryCountByID(ctx, &countID)
+}
+
+type CloseInventoryCountParams struct {
+	ID     *pgxuuid.UUID
+	UserID *pgxuuid.UUID
+	Date   time.Time
+	// ZeroUncounted counts the lines nobody counted as zero, otherwise
+	// they are left as they are
+	ZeroUncounted bool
+	// Prices values the variance of each product, per base unit
+	Prices map[pgxuuid.UUID]decimal.Decimal
+}
+
+// CloseInventoryCount closes an OPEN count and posts on Date a single ADJUST
+// with the difference between the counted and the expected quantity of every
+// line that differs
+func (r *PgRepository) CloseInventoryCount(ctx context.Context, params *CloseInventoryCountParams) (*InventoryCount, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var countID, warehouseID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		UPDATE "inventory_counts" SET
+			status = 'CLOSED',
+			closed_by_user_id = $2,
+			closed_at = now(),
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'OPEN'
+		RETURNING
+			id,
+			warehouse_id
+	`, params.ID, params.UserID).Scan(
+		&countID,
+		&warehouseID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("inventory count is not open")
+		}
+		return nil, err
+	}
+
+	err = checkOpenPeriods(ctx, tx, params.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := r.fetchInventoryCountLines(ctx, tx, &countID)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustment := CreateStockMovementParams{
+		Type:             "ADJUST",
+		Date:             params.Date,
+		CreatedBy:        params.UserID,
+		InventoryCountID: &countID,
+	}
+	for _, line := range lines {
+		counted := decimal.Zero
+		if line.CountedQuantity != nil {
+			counted = *line.CountedQuantity
+		} else if !params.ZeroUncounted {
+			continue
+		}
+
+		variance := counted.Sub(line.ExpectedQuantity)
+		if variance.IsZero() {
+			continue
+		}
+
+		adjustment.Items = append(adjustment.Items, &CreateStockItem{
+			ProductID:   line.ProductID,
+			Quantity:    variance,
+			Price:       params.Prices[*line.ProductID],
+			Batch:       line.Batch,
+			LotID:       line.LotID,
+			WarehouseID: &warehouseID,
+		})
+	}
+
+	if len(adjustment.Items) > 0 {
+		_, err = r.createStockMovement(ctx, tx, &adjustment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetInventoryCountByID(ctx, &countID)
+}
+
+type CancelInventoryCountParams struct {
+	ID     *pgxuuid.UUID
+	UserID *pgxuuid.UUID
+}
+
+// CancelInventoryCount drops an OPEN count without touching the stock
+func (r *PgRepository) CancelInventoryCount(ctx context.Context, params *CancelInventoryCountParams) (*InventoryCount, error) {
+	var countID pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		UPDATE "inventory_counts" SET
+			status = 'CANCELLED',
+			cancelled_by_user_id = $2,
+			cancelled_at = now(),
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'OPEN'
+		RETURNING id
+	`, params.ID, params.UserID).Scan(
+		&countID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("inventory count is not open")
+		}
+		return nil, err
+	}
+
+	return r.GetInventoryCountByID(ctx, &countID)
+}
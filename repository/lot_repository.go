@@ -0,0 +1,343 @@
+package repository
+
+import (
+	"context"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type Lot struct {
+	ID              *pgxuuid.UUID
+	Status          string
+	ProductID       *pgxuuid.UUID
+	ProductName     string
+	Code            string
+	ManufactureDate *time.Time
+	ExpiryDate      *time.Time
+	ReceivedDate    *time.Time
+	Quantity        decimal.Decimal
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+type FetchProductLotsParams struct {
+	ProductID     *pgxuuid.UUID
+	StatusOptions []string
+}
+
+// FetchProductLots returns the lots of a product with their remaining
+// quantity, the ones expiring first on top
+func (r *PgRepository) FetchProductLots(ctx context.Context, params *FetchProductLotsParams) ([]*Lot, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			l.id,
+			l.status,
+			l.product_id,
+			p.name,
+			l.code,
+			l.manufacture_date,
+			l.expiry_date,
+			min(sm.date) FILTER (WHERE sm.status = 'ACTIVE' AND smi.quantity * movement_sign(sm.type) > 0),
+			coalesce(sum(smi.quantity * movement_sign(sm.type)) FILTER (WHERE sm.status = 'ACTIVE'), 0),
+			l.created_at,
+			l.updated_at
+		FROM "lots" l
+		JOIN "products" p ON p.id = l.product_id
+		LEFT JOIN "stock_movement_items" smi ON smi.lot_id = l.id
+		LEFT JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+		WHERE
+			l.product_id = $1
+			AND l.status = ANY($2::lot_status[])
+		GROUP BY
+			l.id,
+			p.name
+		ORDER BY
+			l.expiry_date NULLS LAST,
+			l.created_at
+	`, params.ProductID, params.StatusOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lots := make([]*Lot, 0)
+	for rows.Next() {
+		lot := Lot{}
+		err := rows.Scan(
+			&lot.ID,
+			&lot.Status,
+			&lot.ProductID,
+			&lot.ProductName,
+			&lot.Code,
+			&lot.ManufactureDate,
+			&lot.ExpiryDate,
+			&lot.ReceivedDate,
+			&lot.Quantity,
+			&lot.CreatedAt,
+			&lot.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, &lot)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+func (r *PgRepository) GetLotByID(ctx context.Context, id *pgxuuid.UUID) (*Lot, error) {
+	lot := Lot{}
+	err := r.db.QueryRow(ctx, `
+		SELECT
+			l.id,
+			l.status,
+			l.product_id,
+			p.name,
+			l.code,
+			l.manufacture_date,
+			l.expiry_date,
+			min(sm.date) FILTER (WHERE sm.status = 'ACTIVE' AND smi.quantity * movement_sign(sm.type) > 0),
+			coalesce(sum(smi.quantity * movement_sign(sm.type)) FILTER (WHERE sm.status = 'ACTIVE'), 0),
+			l.created_at,
+			l.updated_at
+		FROM "lots" l
+		JOIN "products" p ON p.id = l.product_id
+		LEFT JOIN "stock_movement_items" smi ON smi.lot_id = l.id
+		LEFT JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+		WHERE
+			l.id = $1
+		GROUP BY
+			l.id,
+			p.name
+	`, id).Scan(
+		&lot.ID,
+		&lot.Status,
+		&lot.ProductID,
+		&lot.ProductName,
+		&lot.Code,
+		&lot.ManufactureDate,
+		&lot.ExpiryDate,
+		&lot.ReceivedDate,
+		&lot.Quantity,
+		&lot.CreatedAt,
+		&lot.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lot, nil
+}
+
+func (r *PgRepository) GetLotByProductAndCode(ctx context.Context, productID *pgxuuid.UUID, code string) (*Lot, error) {
+	lot := Lot{}
+	err := r.db.QueryRow(ctx, `
+		SELECT
+			l.id,
+			l.status,
+			l.product_id,
+			p.name,
+			l.code,
+			l.manufacture_date,
+			l.expiry_date,
+			min(sm.date) FILTER (WHERE sm.status = 'ACTIVE' AND smi.quantity * movement_sign(sm.type) > 0),
+			coalesce(sum(smi.quantity * movement_sign(sm.type)) FILTER (WHERE sm.status = 'ACTIVE'), 0),
+			l.created_at,
+			l.updated_at
+		FROM "lots" l
+		JOIN "products" p ON p.id = l.product_id
+		LEFT JOIN "stock_movement_items" smi ON smi.lot_id = l.id
+		LEFT JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+		WHERE
+			l.product_id = $1
+			AND l.code = $2
+		GROUP BY
+			l.id,
+			p.name
+	`, productID, code).Scan(
+		&lot.ID,
+		&lot.Status,
+		&lot.ProductID,
+		&lot.ProductName,
+		&lot.Code,
+		&lot.ManufactureDate,
+		&lot.ExpiryDate,
+		&lot.ReceivedDate,
+		&lot.Quantity,
+		&lot.CreatedAt,
+		&lot.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lot, nil
+}
+
+type FetchAvailableLotsParams struct {
+	ProductID   *pgxuuid.UUID
+	WarehouseID *pgxuuid.UUID
+	Date        time.Time
+	Allocation  string
+	// SalesOrderID is the order being delivered, its own reservations do not
+	// hold back its lots
+	SalesOrderID *pgxuuid.UUID
+	// StockMovementID is a movement being edited, its items are left out of
+	// the remaining quantity
+	StockMovementID *pgxuuid.UUID
+}
+
+// FetchAvailableLots returns the active, not expired lots of a product with
+// remaining quantity in a warehouse, in the order they should be consumed:
+// first expired first out for FEFO or by receipt date for FIFO. Quantity is
+// what is left after the reservations held on the lot.
+func (r *PgRepository) FetchAvailableLots(ctx context.Context, params *FetchAvailableLotsParams) ([]*Lot, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT *
+		FROM (
+			SELECT
+				l.id,
+				l.status,
+				l.product_id,
+				p.name,
+				l.code,
+				l.manufacture_date,
+				l.expiry_date,
+				min(sm.date) FILTER (WHERE sm.status = 'ACTIVE' AND sm.id IS DISTINCT FROM $6::UUID AND smi.quantity * movement_sign(sm.type) > 0) AS received_date,
+				coalesce(sum(smi.quantity * movement_sign(sm.type)) FILTER (WHERE sm.status = 'ACTIVE' AND sm.id IS DISTINCT FROM $6::UUID), 0) - (
+					SELECT
+						coalesce(sum(rh.held_quantity), 0)
+					FROM "reservation_holds" rh
+					WHERE
+						rh.lot_id = l.id
+						AND rh.warehouse_id = $4
+						AND (
+							rh.source_id IS NULL
+							OR rh.source_id IS DISTINCT FROM $5::UUID
+						)
+				) AS quantity,
+				l.created_at,
+				l.updated_at
+			FROM "lots" l
+			JOIN "products" p ON p.id = l.product_id
+			LEFT JOIN "stock_movement_items" smi ON smi.lot_id = l.id AND smi.warehouse_id = $4
+			LEFT JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+			WHERE
+				l.product_id = $1
+				AND l.status = 'ACTIVE'
+				AND (l.expiry_date IS NULL OR l.expiry_date >= $2)
+			GROUP BY
+				l.id,
+				p.name
+		) available
+		WHERE
+			available.quantity > 0
+		ORDER BY
+			CASE WHEN $3::TEXT = 'FEFO' THEN available.expiry_date END NULLS LAST,
+			available.received_date NULLS LAST,
+			available.created_at
+	`, params.ProductID, params.Date, params.Allocation, params.WarehouseID, params.SalesOrderID, params.StockMovementID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lots := make([]*Lot, 0)
+	for rows.Next() {
+		lot := Lot{}
+		err := rows.Scan(
+			&lot.ID,
+			&lot.Status,
+			&lot.ProductID,
+			&lot.ProductName,
+			&lot.Code,
+			&lot.ManufactureDate,
+			&lot.ExpiryDate,
+			&lot.ReceivedDate,
+			&lot.Quantity,
+			&lot.CreatedAt,
+			&lot.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, &lot)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+type CreateLotParams struct {
+	ProductID       *pgxuuid.UUID
+	Code            string
+	ManufactureDate *time.Time
+	ExpiryDate      *time.Time
+}
+
+func (r *PgRepository) CreateLot(ctx context.Context, params *CreateLotParams) (*Lot, error) {
+	var lotID pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO "lots" (
+			product_id,
+			code,
+			manufacture_date,
+			expiry_date
+		) VALUES (
+			$1, $2, $3, $4
+		) RETURNING id
+	`,
+		params.ProductID,
+		params.Code,
+		params.ManufactureDate,
+		params.ExpiryDate,
+	).Scan(
+		&lotID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetLotByID(ctx, &lotID)
+}
+
+type UpdateLotParams struct {
+	ID              *pgxuuid.UUID
+	Status          string
+	ManufactureDate *time.Time
+	ExpiryDate      *time.Time
+}
+
+func (r *PgRepository) UpdateLot(ctx context.Context, params *UpdateLotParams) (*Lot, error) {
+	var lotID pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		UPDATE "lots" SET
+			status = $2,
+			manufacture_date = $3,
+			expiry_date = $4,
+			updated_at = now()
+		WHERE
+			id = $1
+		RETURNING id
+	`,
+		params.ID,
+		params.Status,
+		params.ManufactureDate,
+		params.ExpiryDate,
+	).Scan(
+		&lotID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetLotByID(ctx, &lotID)
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestFetchAvailableLotsEditedMovement(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+	productID := newTestProduct(t)
+
+	lot, err := testRepo.CreateLot(ctx, &CreateLotParams{
+		ProductID: productID,
+		Code:      testName("lot"),
+	})
+	if err != nil {
+		t.Fatalf("create lot: %v", err)
+	}
+
+	postLot := func(movementType string) *StockMovement {
+		stockMovement, err := testRepo.CreateStockMovement(ctx, &CreateStockMovementParams{
+			Type:      movementType,
+			Date:      time.Now(),
+			CreatedBy: userID,
+			Items: []*CreateStockItem{
+				{
+					ProductID:   productID,
+					Quantity:    decimal.NewFromInt(5),
+					Price:       decimal.NewFromInt(1000),
+					Batch:       lot.Code,
+					LotID:       lot.ID,
+					WarehouseID: warehouseID,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("create %v: %v", movementType, err)
+		}
+		return stockMovement
+	}
+
+	postLot("PURCHASE")
+	sale := postLot("SALE")
+
+	fetchLots := func(stockMovementID *pgxuuid.UUID) []*Lot {
+		lots, err := testRepo.FetchAvailableLots(ctx, &FetchAvailableLotsParams{
+			ProductID:       productID,
+			WarehouseID:     warehouseID,
+			Date:            time.Now(),
+			Allocation:      "FEFO",
+			StockMovementID: stockMovementID,
+		})
+		if err != nil {
+			t.Fatalf("fetch available lots: %v", err)
+		}
+		return lots
+	}
+
+	if lots := fetchLots(nil); len(lots) != 0 {
+		t.Fatalf("expected no lot left, got %d", len(lots))
+	}
+
+	// the sale being edited gives its lot back
+	lots := fetchLots(sale.ID)
+	if len(lots) != 1 || !lots[0].Quantity.Equal(decimal.NewFromInt(5)) {
+		t.Fatalf("expected the lot with 5 left, got %v", lots)
+	}
+}
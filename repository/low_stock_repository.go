@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/hoffax/prodrest/constants"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// LowStockProduct is an active product whose available stock, on hand less
+// reservations, is below its reorder point. OnOrder is what open purchase
+// orders still have to receive, AlertID the alert that is not resolved yet,
+// nil until the evaluator opens one.
+type LowStockProduct struct {
+	ProductID             *pgxuuid.UUID
+	ProductName           string
+	Barcode               string
+	Unit                  string
+	MinStock              *decimal.Decimal
+	ReorderPoint          decimal.Decimal
+	PreferredSupplierID   *pgxuuid.UUID
+	PreferredSupplierName string
+	OnHand                decimal.Decimal
+	Reserved              decimal.Decimal
+	Available             decimal.Decimal
+	OnOrder               decimal.Decimal
+	AlertID               *pgxuuid.UUID
+	AlertStatus           string
+	AlertOpenedAt         *time.Time
+}
+
+type FetchLowStockProductsParams struct {
+	PreferredSupplierID *pgxuuid.UUID
+	Limit               int
+	Offset              int
+}
+
+type FetchLowStockProductsResult struct {
+	TotalCount int
+	Items      []*LowStockProduct
+}
+
+// FetchLowStockProducts lists the products below their reorder point
+// grouped by preferred supplier, the ones without one at the end
+func (r *PgRepository) FetchLowStockProducts(ctx context.Context, params *FetchLowStockProductsParams) (*FetchLowStockProductsResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			COUNT(*) OVER() AS full_count,
+			p.id,
+			p.name,
+			p.barcode,
+			p.unit,
+			p.min_stock,
+			p.reorder_point,
+			p.preferred_supplier_id,
+			coalesce(e.name, ''),
+			psl.on_hand,
+			psl.reserved,
+			psl.available,
+			psl.on_order,
+			a.id,
+			coalesce(a.status::TEXT, ''),
+			a.opened_at
+		FROM "products" p
+		JOIN "product_stock_levels" psl ON psl.product_id = p.id
+		LEFT JOIN "entities" e ON e.id = p.preferred_supplier_id
+		LEFT JOIN "low_stock_alerts" a ON a.product_id = p.id
+			AND a.status <> 'RESOLVED'
+		WHERE
+			p.status = 'ACTIVE'
+			AND p.reorder_point IS NOT NULL
+			AND psl.available < p.reorder_point
+			AND ($1::UUID IS NULL OR p.preferred_supplier_id = $1)
+		ORDER BY
+			e.name NULLS LAST,
+			p.name
+		LIMIT $2
+		OFFSET $3
+	`, params.PreferredSupplierID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchLowStockProductsResult{
+		Items: make([]*LowStockProduct, 0),
+	}
+	for rows.Next() {
+		item := LowStockProduct{}
+		err := rows.Scan(
+			&result.TotalCount,
+			&item.ProductID,
+			&item.ProductName,
+			&item.Barcode,
+			&item.Unit,
+			&item.MinStock,
+			&item.ReorderPoint,
+			&item.PreferredSupplierID,
+			&item.PreferredSupplierName,
+			&item.OnHand,
+			&item.Reserved,
+			&item.Available,
+			&item.OnOrder,
+			&item.AlertID,
+			&item.AlertStatus,
+			&item.AlertOpenedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// LowStockAlert records a product falling below its reorder point.
+// AvailableQuantity and ReorderPoint are the values when it was opened.
+type LowStockAlert struct {
+	ID                *pgxuuid.UUID
+	Status            string
+	ProductID         *pgxuuid.UUID
+	ProductName       string
+	AvailableQuantity decimal.Decimal
+	ReorderPoint      decimal.Decimal
+	OpenedAt          time.Time
+	ResolvedAt        *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+
+	AcknowledgedByUserID   *pgxuuid.UUID
+	AcknowledgedByUserName string
+	AcknowledgedAt         *time.Time
+}
+
+// lowStockAlertQuery selects every column scanned by scanLowStockAlert,
+// callers append their own WHERE and ORDER BY
+const lowStockAlertQuery = `
+	SELECT
+		a.id,
+		a.status,
+		a.product_id,
+		p.name,
+		a.available_quantity,
+		a.reorder_point,
+		a.opened_at,
+		a.resolved_at,
+		a.created_at,
+		a.updated_at,
+		au.id,
+		coalesce(au.name, ''),
+		a.acknowledged_at
+	FROM "low_stock_alerts" a
+	JOIN "products" p ON p.id = a.product_id
+	LEFT JOIN "users" au ON au.id = a.acknowledged_by_user_id
+`
+
+func scanLowStockAlert(row pgx.Row, extra ...any) (*LowStockAlert, error) {
+	alert := LowStockAlert{}
+	dest := append(extra,
+		&alert.ID,
+		&alert.Status,
+		&alert.ProductID,
+		&alert.ProductName,
+		&alert.AvailableQuantity,
+		&alert.ReorderPoint,
+		&alert.OpenedAt,
+		&alert.ResolvedAt,
+		&alert.CreatedAt,
+		&alert.UpdatedAt,
+		&alert.AcknowledgedByUserID,
+		&alert.AcknowledgedByUserName,
+		&alert.AcknowledgedAt,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &alert, nil
+}
+
+type FetchLowStockAlertsParams struct {
+	StatusOptions []string
+	ProductID     *pgxuuid.UUID
+	Limit         int
+	Offset        int
+}
+
+type FetchLowStockAlertsResult struct {
+	TotalCount int
+	Items      []*LowStockAlert
+}
+
+func (r *PgRepository) FetchLowStockAlerts(ctx context.Context, params *FetchLowStockAlertsParams) (*FetchLowStockAlertsResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			COUNT(*) OVER() AS full_count,
+			q.*
+		FROM (`+lowStockAlertQuery+`
+			WHERE
+				(cardinality($1::low_stock_alert_status[]) = 0 OR a.status = ANY($1::low_stock_alert_status[]))
+				AND ($2::UUID IS NULL OR a.product_id = $2)
+		) q
+		ORDER BY
+			q.opened_at DESC
+		LIMIT $3
+		OFFSET $4
+	`, params.StatusOptions, params.ProductID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchLowStockAlertsResult{
+		Items: make([]*LowStockAlert, 0),
+	}
+	for rows.Next() {
+		alert, err := scanLowStockAlert(rows, &result.TotalCount)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, alert)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *PgRepository) GetLowStockAlertByID(ctx context.Context, id *pgxuuid.UUID) (*LowStockAlert, error) {
+	return scanLowStockAlert(r.db.QueryRow(ctx, lowStockAlertQuery+`
+		WHERE
+			a.id = $1
+	`, id))
+}
+
+type AcknowledgeLowStockAlertParams struct {
+	ID     *pgxuuid.UUID
+	UserID *pgxuuid.UUID
+}
+
+// AcknowledgeLowStockAlert marks an OPEN alert as seen, it stays on until the
+// stock is back at the reorder point
+func (r *PgRepository) AcknowledgeLowStockAlert(ctx context.Context, params *AcknowledgeLowStockAlertParams) (*LowStockAlert, error) {
+	var id pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		UPDATE "low_stock_alerts" SET
+			status = 'ACKNOWLEDGED',
+			acknowledged_by_user_id = $2,
+			acknowledged_at = now(),
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'OPEN'
+		RETURNING id
+	`, params.ID, params.UserID).Scan(
+		&id,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("low stock alert is not open")
+		}
+		return nil, err
+	}
+
+	return r.GetLowStockAlertByID(ctx, &id)
+}
+
+// EvaluateLowStockAlerts opens an alert for each product that fell below its
+// reorder point and resolves the ones of products that are back at it, or
+// no longer tracked. It returns how many alerts were opened and resolved.
+func (r *PgRepository) EvaluateLowStockAlerts(ctx context.Context) (int64, int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	resolved, err := tx.Exec(ctx, `
+		UPDATE "low_stock_alerts" a SET
+			status = 'RESOLVED',
+			resolved_at = now(),
+			updated_at = now()
+		FROM "products" p
+		JOIN "product_stock_levels" psl ON psl.product_id = p.id
+		WHERE
+			p.id = a.product_id
+			AND a.status <> 'RESOLVED'
+			AND (
+				p.status <> 'ACTIVE'
+				OR p.reorder_point IS NULL
+				OR psl.available >= p.reorder_point
+			)
+	`)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	opened, err := tx.Exec(ctx, `
+		INSERT INTO "low_stock_alerts" (
+			product_id,
+			available_quantity,
+			reorder_point
+		)
+		SELECT
+			p.id,
+			psl.available,
+			p.reorder_point
+		FROM "products" p
+		JOIN "product_stock_levels" psl ON psl.product_id = p.id
+		WHERE
+			p.status = 'ACTIVE'
+			AND p.reorder_point IS NOT NULL
+			AND psl.available < p.reorder_point
+		ON CONFLICT (product_id) WHERE status <> 'RESOLVED' DO NOTHING
+	`)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return opened.RowsAffected(), resolved.RowsAffected(), nil
+}
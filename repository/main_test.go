@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/db"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	pgxdecimal "github.com/jackc/pgx-shopspring-decimal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+var testRepo *PgRepository
+
+// TestMain migrates the database at DB_URL up before the tests and drops it
+// afterwards, without DB_URL only the tests not calling requireDB run
+func TestMain(m *testing.M) {
+	if os.Getenv("DB_URL") == "" {
+		os.Exit(m.Run())
+	}
+
+	migrator, err := db.NewTestDBMigrator()
+	if err != nil {
+		log.Fatalf("test db migrator: %v", err)
+	}
+
+	err = migrator.Prepare()
+	if err != nil {
+		log.Fatalf("test db prepare: %v", err)
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(os.Getenv("DB_URL"))
+	if err != nil {
+		log.Fatalf("test db url: %v", err)
+	}
+	poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		pgxdecimal.Register(conn.TypeMap())
+		pgxuuid.Register(conn.TypeMap())
+		return nil
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		log.Fatalf("test db connect: %v", err)
+	}
+	testRepo = NewPgRepository(pool)
+
+	code := m.Run()
+
+	pool.Close()
+	err = migrator.Cleanup()
+	if err != nil {
+		log.Printf("test db cleanup: %v", err)
+	}
+
+	os.Exit(code)
+}
+
+// requireDB skips a test that needs the database when DB_URL is not set
+func requireDB(t *testing.T) {
+	t.Helper()
+
+	if testRepo == nil {
+		t.Skip("DB_URL not set")
+	}
+}
+
+// testName makes names unique across tests sharing the database
+func testName(prefix string) string {
+	return prefix + " " + uuid.Must(uuid.NewV4()).String()[:8]
+}
+
+func newTestUser(t *testing.T) *pgxuuid.UUID {
+	t.Helper()
+
+	user, err := testRepo.CreateUser(context.Background(), &NewUserParams{
+		Email:    testName("user") + "@test.local",
+		Name:     testName("user"),
+		Password: "-",
+		Roles:    []string{},
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	return user.ID
+}
+
+func newTestWarehouse(t *testing.T) *pgxuuid.UUID {
+	t.Helper()
+
+	warehouse, err := testRepo.CreateWarehouse(context.Background(), &CreateWarehouseParams{
+		Name: testName("warehouse"),
+	})
+	if err != nil {
+		t.Fatalf("create warehouse: %v", err)
+	}
+
+	return warehouse.ID
+}
+
+func newTestEntity(t *testing.T) *pgxuuid.UUID {
+	t.Helper()
+
+	entity, err := testRepo.CreateEntity(context.Background(), &CreateEntityParams{
+		Name: testName("entity"),
+		RUC:  testName("ruc"),
+	})
+	if err != nil {
+		t.Fatalf("create entity: %v", err)
+	}
+
+	return entity.ID
+}
+
+func newTestProduct(t *testing.T) *pgxuuid.UUID {
+	t.Helper()
+
+	product, err := testRepo.CreateProduct(context.Background(), &CreateProductParams{
+		Name:          testName("product"),
+		Barcode:       testName("barcode"),
+		Unit:          "UN",
+		LotAllocation: "FEFO",
+		TaxRate:       constants.TaxRateIVA10,
+	})
+	if err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+
+	return product.ID
+}
+
+// postStock creates a movement of a single item, quantity is in the base
+// unit and signed only for TRANSFER and ADJUST
+func postStock(movementType string, userID, warehouseID, productID *pgxuuid.UUID, quantity int64) (*StockMovement, error) {
+	return testRepo.CreateStockMovement(context.Background(), &CreateStockMovementParams{
+		Type:      movementType,
+		Date:      time.Now(),
+		CreatedBy: userID,
+		Items: []*CreateStockItem{
+			{
+				ProductID:   productID,
+				Quantity:    decimal.NewFromInt(quantity),
+				Price:       decimal.NewFromInt(1000),
+				WarehouseID: warehouseID,
+			},
+		},
+	})
+}
+
+func mustPostStock(t *testing.T, movementType string, userID, warehouseID, productID *pgxuuid.UUID, quantity int64) *StockMovement {
+	t.Helper()
+
+	stockMovement, err := postStock(movementType, userID, warehouseID, productID, quantity)
+	if err != nil {
+		t.Fatalf("create %v: %v", movementType, err)
+	}
+
+	return stockMovement
+}
+
+func assertInsufficientStock(t *testing.T, err error) {
+	t.Helper()
+
+	var insufficientStock *constants.InsufficientStockError
+	if !errors.As(err, &insufficientStock) {
+		t.Fatalf("expected an insufficient stock error, got %v", err)
+	}
+}
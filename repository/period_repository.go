@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hoffax/prodrest/constants"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// Period is a month that was closed at some point, Period is its first day
+type Period struct {
+	Period            time.Time
+	Status            string
+	UpdatedByUserID   *pgxuuid.UUID
+	UpdatedByUserName string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+type PeriodAudit struct {
+	ID       *pgxuuid.UUID
+	Period   time.Time
+	Action   string
+	UserID   *pgxuuid.UUID
+	UserName string
+	Reason   string
+	// CreatedAt is when the period was closed or reopened
+	CreatedAt time.Time
+}
+
+const periodQuery = `
+	SELECT
+		p.period,
+		p.status,
+		p.updated_by_user_id,
+		u.name,
+		p.created_at,
+		p.updated_at
+	FROM "periods" p
+	JOIN "users" u ON u.id = p.updated_by_user_id
+`
+
+func scanPeriod(row pgx.Row) (*Period, error) {
+	var period Period
+	err := row.Scan(
+		&period.Period,
+		&period.Status,
+		&period.UpdatedByUserID,
+		&period.UpdatedByUserName,
+		&period.CreatedAt,
+		&period.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &period, nil
+}
+
+func (r *PgRepository) FetchPeriods(ctx context.Context, statusOptions []string) ([]*Period, error) {
+	rows, err := r.db.Query(ctx, periodQuery+`
+		WHERE
+			cardinality($1::PERIOD_STATUS[]) = 0 OR p.status = ANY($1::PERIOD_STATUS[])
+		ORDER BY
+			p.period DESC
+	`, statusOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	periods := make([]*Period, 0)
+	for rows.Next() {
+		period, err := scanPeriod(rows)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, period)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return periods, nil
+}
+
+func (r *PgRepository) GetPeriod(ctx context.Context, period time.Time) (*Period, error) {
+	return scanPeriod(r.db.QueryRow(ctx, periodQuery+`
+		WHERE
+			p.period = $1
+	`, period))
+}
+
+// periodLockQuery takes an advisory lock per month of the dates in $1, in
+// month order. The months have to be locked apart from their periods rows, a
+// month that was never closed has no row to lock yet.
+const periodLockQuery = `
+	SELECT %s(hashtext('periods'), m.month)
+	FROM (
+		SELECT DISTINCT (date_part('year', d) * 12 + date_part('month', d))::INT AS month
+		FROM unnest($1::DATE[]) d
+		ORDER BY month
+	) m
+`
+
+// checkOpenPeriods returns a PeriodClosedError when the month of any of
+// dates is closed. The months stay locked until tx ends, SetPeriodStatus
+// waits for the movements being posted in them.
+func checkOpenPeriods(ctx context.Context, tx pgx.Tx, dates ...time.Time) error {
+	_, err := tx.Exec(ctx, fmt.Sprintf(periodLockQuery, "pg_advisory_xact_lock_shared"), dates)
+	if err != nil {
+		return err
+	}
+
+	var period time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT period
+		FROM "periods"
+		WHERE
+			status = 'CLOSED'
+			AND period IN (
+				SELECT date_trunc('month', d)::DATE FROM unnest($1::DATE[]) d
+			)
+		ORDER BY
+			period
+		LIMIT 1
+		FOR SHARE
+	`, dates).Scan(&period)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	return constants.NewPeriodClosedError(period.Format("2006-01"))
+}
+
+func (r *PgRepository) FetchPeriodAudits(ctx context.Context, period time.Time) ([]*PeriodAudit, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			pa.id,
+			pa.period,
+			pa.action,
+			pa.user_id,
+			u.name,
+			pa.reason,
+			pa.created_at
+		FROM "period_audits" pa
+		JOIN "users" u ON u.id = pa.user_id
+		WHERE
+			pa.period = $1
+		ORDER BY
+			pa.created_at DESC
+	`, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audits := make([]*PeriodAudit, 0)
+	for rows.Next() {
+		audit := PeriodAudit{}
+		err := rows.Scan(
+			&audit.ID,
+			&audit.Period,
+			&audit.Action,
+			&audit.UserID,
+			&audit.UserName,
+			&audit.Reason,
+			&audit.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		audits = append(audits, &audit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return audits, nil
+}
+
+type SetPeriodStatusParams struct {
+	Period time.Time
+	Status string
+	UserID *pgxuuid.UUID
+	Reason string
+}
+
+// SetPeriodStatus closes or reopens a period and audits the change. Closing
+// an already closed period or reopening one that is not closed returns an
+// InvalidOperationError.
+func (r *PgRepository) SetPeriodStatus(ctx context.Context, params *SetPeriodStatusParams) (*Period, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// waits for the movements being posted in the month, see checkOpenPeriods
+	_, err = tx.Exec(ctx, fmt.Sprintf(periodLockQuery, "pg_advisory_xact_lock"), []time.Time{params.Period})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		SELECT period
+		FROM "periods"
+		WHERE
+			period = $1
+		FOR UPDATE
+	`, params.Period)
+	if err != nil {
+		return nil, err
+	}
+
+	var period time.Time
+	if params.Status == "CLOSED" {
+		err = tx.QueryRow(ctx, `
+			INSERT INTO "periods" (
+				period,
+				status,
+				updated_by_user_id
+			) VALUES (
+				$1, 'CLOSED', $2
+			)
+			ON CONFLICT (period) DO UPDATE SET
+				status = 'CLOSED',
+				updated_by_user_id = $2,
+				updated_at = now()
+			WHERE
+				"periods".status = 'OPEN'
+			RETURNING period
+		`, params.Period, params.UserID).Scan(&period)
+	} else {
+		err = tx.QueryRow(ctx, `
+			UPDATE "periods" SET
+				status = 'OPEN',
+				updated_by_user_id = $2,
+				updated_at = now()
+			WHERE
+				period = $1
+				AND status = 'CLOSED'
+			RETURNING period
+		`, params.Period, params.UserID).Scan(&period)
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			if params.Status == "CLOSED" {
+				return nil, constants.NewInvalidOperationError("period is already closed")
+			}
+			return nil, constants.NewInvalidOperationError("period is not closed")
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO "period_audits" (
+			period,
+			action,
+			user_id,
+			reason
+		) VALUES (
+			$1, $2, $3, $4
+		)
+	`, period, params.Status, params.UserID, params.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetPeriod(ctx, period)
+}
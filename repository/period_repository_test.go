@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestSetPeriodStatus(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+
+	// far enough back not to close the month the other tests post in
+	period := time.Date(2002, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+	setStatus := func(status string) (*Period, error) {
+		return testRepo.SetPeriodStatus(ctx, &SetPeriodStatusParams{
+			Period: period,
+			Status: status,
+			UserID: userID,
+			Reason: "test",
+		})
+	}
+
+	assertInvalidOperation := func(t *testing.T, err error) {
+		t.Helper()
+
+		var invalidOperation *constants.InvalidOperationError
+		if !errors.As(err, &invalidOperation) {
+			t.Fatalf("expected an invalid operation error, got %v", err)
+		}
+	}
+
+	_, err := setStatus("OPEN")
+	assertInvalidOperation(t, err)
+
+	p, err := setStatus("CLOSED")
+	if err != nil {
+		t.Fatalf("close period: %v", err)
+	}
+	if p.Status != "CLOSED" {
+		t.Fatalf("expected CLOSED, got %v", p.Status)
+	}
+
+	_, err = setStatus("CLOSED")
+	assertInvalidOperation(t, err)
+
+	p, err = setStatus("OPEN")
+	if err != nil {
+		t.Fatalf("reopen period: %v", err)
+	}
+	if p.Status != "OPEN" {
+		t.Fatalf("expected OPEN, got %v", p.Status)
+	}
+
+	audits, err := testRepo.FetchPeriodAudits(ctx, period)
+	if err != nil {
+		t.Fatalf("fetch period audits: %v", err)
+	}
+	if len(audits) != 2 {
+		t.Fatalf("expected the close and the reopen audited, got %v audits", len(audits))
+	}
+}
+
+func TestCheckOpenPeriods(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+	productID := newTestProduct(t)
+
+	// far enough back not to close the month the other tests post in
+	period := time.Date(2001, time.March, 1, 0, 0, 0, 0, time.UTC)
+	params := &CreateStockMovementParams{
+		Type:      "PURCHASE",
+		Date:      period.AddDate(0, 0, 14),
+		CreatedBy: userID,
+		Items: []*CreateStockItem{
+			{
+				ProductID:   productID,
+				Quantity:    decimal.NewFromInt(10),
+				Price:       decimal.NewFromInt(1000),
+				WarehouseID: warehouseID,
+			},
+		},
+	}
+
+	stockMovement, err := testRepo.CreateStockMovement(ctx, params)
+	if err != nil {
+		t.Fatalf("create purchase: %v", err)
+	}
+
+	_, err = testRepo.SetPeriodStatus(ctx, &SetPeriodStatusParams{
+		Period: period,
+		Status: "CLOSED",
+		UserID: userID,
+		Reason: "test",
+	})
+	if err != nil {
+		t.Fatalf("close period: %v", err)
+	}
+
+	assertPeriodClosed := func(t *testing.T, err error) {
+		t.Helper()
+
+		var periodClosed *constants.PeriodClosedError
+		if !errors.As(err, &periodClosed) {
+			t.Fatalf("expected a period closed error, got %v", err)
+		}
+		if periodClosed.Period != "2001-03" {
+			t.Fatalf("expected period 2001-03, got %v", periodClosed.Period)
+		}
+	}
+
+	t.Run("create", func(t *testing.T) {
+		_, err := testRepo.CreateStockMovement(ctx, params)
+		assertPeriodClosed(t, err)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		_, err := testRepo.DeleteStockMovement(ctx, &DeleteStockMovementParams{
+			ID:     stockMovement.ID,
+			UserID: userID,
+		})
+		assertPeriodClosed(t, err)
+	})
+
+	t.Run("reopened", func(t *testing.T) {
+		_, err := testRepo.SetPeriodStatus(ctx, &SetPeriodStatusParams{
+			Period: period,
+			Status: "OPEN",
+			UserID: userID,
+			Reason: "test",
+		})
+		if err != nil {
+			t.Fatalf("reopen period: %v", err)
+		}
+
+		_, err = testRepo.CreateStockMovement(ctx, params)
+		if err != nil {
+			t.Fatalf("create purchase: %v", err)
+		}
+	})
+}
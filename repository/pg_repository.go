@@ -1,13 +1,15 @@
 package repository
 
-import "github.com/jackc/pgx/v5"
+import "github.com/jackc/pgx/v5/pgxpool"
 
+// PgRepository runs every query on a connection of the pool, a transaction
+// keeps its connection until it ends
 type PgRepository struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewPgRepository(conn *pgx.Conn) *PgRepository {
+func NewPgRepository(pool *pgxpool.Pool) *PgRepository {
 	return &PgRepository{
-		db: conn,
+		db: pool,
 	}
 }
@@ -3,19 +3,27 @@ package repository
 import (
 	"context"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
 	"time"
 )
 
 type Product struct {
-	ID               *pgxuuid.UUID
-	Status           string
-	Name             string
-	Barcode          string
-	Unit             string
-	BatchControl     bool
-	ConversionFactor int
-	CreatedAt        time.Time
-	UpdatedAt        time.Time
+	ID                 *pgxuuid.UUID
+	Status             string
+	Name               string
+	Barcode            string
+	Unit               string
+	BatchControl       bool
+	LotAllocation      string
+	AllowNegativeStock bool
+	TaxRate            string
+	// MinStock and ReorderPoint are in the base unit, nil when the product
+	// is not replenished from the low stock alerts
+	MinStock            *decimal.Decimal
+	ReorderPoint        *decimal.Decimal
+	PreferredSupplierID *pgxuuid.UUID
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
 }
 
 type FetchProductsParams struct {
@@ -40,7 +48,12 @@ func (r *PgRepository) FetchProducts(ctx context.Context, params *FetchProductsP
 			barcode,
 			unit,
 			batch_control,
-			conversion_factor,
+			lot_allocation,
+			allow_negative_stock,
+			tax_rate,
+			min_stock,
+			reorder_point,
+			preferred_supplier_id,
 			created_at,
 			updated_at
 		FROM "products"
@@ -72,7 +85,12 @@ func (r *PgRepository) FetchProducts(ctx context.Context, params *FetchProductsP
 			&product.Barcode,
 			&product.Unit,
 			&product.BatchControl,
-			&product.ConversionFactor,
+			&product.LotAllocation,
+			&product.AllowNegativeStock,
+			&product.TaxRate,
+			&product.MinStock,
+			&product.ReorderPoint,
+			&product.PreferredSupplierID,
 			&product.CreatedAt,
 			&product.UpdatedAt,
 		)
@@ -97,7 +115,12 @@ func (r *PgRepository) GetProductByID(ctx context.Context, id *pgxuuid.UUID) (*P
 			barcode,
 			unit,
 			batch_control,
-			conversion_factor,
+			lot_allocation,
+			allow_negative_stock,
+			tax_rate,
+			min_stock,
+			reorder_point,
+			preferred_supplier_id,
 			created_at,
 			updated_at
 		FROM "products"
@@ -110,7 +133,12 @@ func (r *PgRepository) GetProductByID(ctx context.Context, id *pgxuuid.UUID) (*P
 		&product.Barcode,
 		&product.Unit,
 		&product.BatchControl,
-		&product.ConversionFactor,
+		&product.LotAllocation,
+		&product.AllowNegativeStock,
+		&product.TaxRate,
+		&product.MinStock,
+		&product.ReorderPoint,
+		&product.PreferredSupplierID,
 		&product.CreatedAt,
 		&product.UpdatedAt,
 	)
@@ -131,7 +159,12 @@ func (r *PgRepository) GetProductByBarcode(ctx context.Context, barcode string)
 			barcode,
 			unit,
 			batch_control,
-			conversion_factor,
+			lot_allocation,
+			allow_negative_stock,
+			tax_rate,
+			min_stock,
+			reorder_point,
+			preferred_supplier_id,
 			created_at,
 			updated_at
 		FROM "products"
@@ -144,7 +177,12 @@ func (r *PgRepository) GetProductByBarcode(ctx context.Context, barcode string)
 		&product.Barcode,
 		&product.Unit,
 		&product.BatchControl,
-		&product.ConversionFactor,
+		&product.LotAllocation,
+		&product.AllowNegativeStock,
+		&product.TaxRate,
+		&product.MinStock,
+		&product.ReorderPoint,
+		&product.PreferredSupplierID,
 		&product.CreatedAt,
 		&product.UpdatedAt,
 	)
@@ -156,11 +194,16 @@ func (r *PgRepository) GetProductByBarcode(ctx context.Context, barcode string)
 }
 
 type CreateProductParams struct {
-	Name             string
-	Barcode          string
-	Unit             string
-	BatchControl     bool
-	ConversionFactor int
+	Name                string
+	Barcode             string
+	Unit                string
+	BatchControl        bool
+	LotAllocation       string
+	AllowNegativeStock  bool
+	TaxRate             string
+	MinStock            *decimal.Decimal
+	ReorderPoint        *decimal.Decimal
+	PreferredSupplierID *pgxuuid.UUID
 }
 
 func (r *PgRepository) CreateProduct(ctx context.Context, params *CreateProductParams) (*Product, error) {
@@ -171,9 +214,14 @@ func (r *PgRepository) CreateProduct(ctx context.Context, params *CreateProductP
 			barcode,
 			unit,
 			batch_control,
-			conversion_factor
+			lot_allocation,
+			allow_negative_stock,
+			tax_rate,
+			min_stock,
+			reorder_point,
+			preferred_supplier_id
 		) VALUES (
-			$1, $2, $3, $4, $5
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
 		) RETURNING
 			id,
 			status,
@@ -181,7 +229,12 @@ func (r *PgRepository) CreateProduct(ctx context.Context, params *CreateProductP
 			barcode,
 			unit,
 			batch_control,
-			conversion_factor,
+			lot_allocation,
+			allow_negative_stock,
+			tax_rate,
+			min_stock,
+			reorder_point,
+			preferred_supplier_id,
 			created_at,
 			updated_at
 	`,
@@ -189,7 +242,12 @@ func (r *PgRepository) CreateProduct(ctx context.Context, params *CreateProductP
 		params.Barcode,
 		params.Unit,
 		params.BatchControl,
-		params.ConversionFactor,
+		params.LotAllocation,
+		params.AllowNegativeStock,
+		params.TaxRate,
+		params.MinStock,
+		params.ReorderPoint,
+		params.PreferredSupplierID,
 	).Scan(
 		&product.ID,
 		&product.Status,
@@ -197,7 +255,12 @@ func (r *PgRepository) CreateProduct(ctx context.Context, params *CreateProductP
 		&product.Barcode,
 		&product.Unit,
 		&product.BatchControl,
-		&product.ConversionFactor,
+		&product.LotAllocation,
+		&product.AllowNegativeStock,
+		&product.TaxRate,
+		&product.MinStock,
+		&product.ReorderPoint,
+		&product.PreferredSupplierID,
 		&product.CreatedAt,
 		&product.UpdatedAt,
 	)
@@ -209,13 +272,18 @@ func (r *PgRepository) CreateProduct(ctx context.Context, params *CreateProductP
 }
 
 type UpdateProductParams struct {
-	ID               *pgxuuid.UUID
-	Status           string
-	Name             string
-	Barcode          string
-	Unit             string
-	BatchControl     bool
-	ConversionFactor int
+	ID                  *pgxuuid.UUID
+	Status              string
+	Name                string
+	Barcode             string
+	Unit                string
+	BatchControl        bool
+	LotAllocation       string
+	AllowNegativeStock  bool
+	TaxRate             string
+	MinStock            *decimal.Decimal
+	ReorderPoint        *decimal.Decimal
+	PreferredSupplierID *pgxuuid.UUID
 }
 
 func (r *PgRepository) UpdateProduct(ctx context.Context, params *UpdateProductParams) (*Product, error) {
@@ -227,7 +295,13 @@ func (r *PgRepository) UpdateProduct(ctx context.Context, params *UpdateProductP
 			barcode = $4,
 			unit = $5,
 			batch_control = $6,
-			conversion_factor = $7
+			lot_allocation = $7,
+			allow_negative_stock = $8,
+			tax_rate = $9,
+			min_stock = $10,
+			reorder_point = $11,
+			preferred_supplier_id = $12,
+			updated_at = now()
 		WHERE
 			id = $1
 		RETURNING
@@ -237,7 +311,12 @@ func (r *PgRepository) UpdateProduct(ctx context.Context, params *UpdateProductP
 			barcode,
 			unit,
 			batch_control,
-			conversion_factor,
+			lot_allocation,
+			allow_negative_stock,
+			tax_rate,
+			min_stock,
+			reorder_point,
+			preferred_supplier_id,
 			created_at,
 			updated_at
 	`,
@@ -247,7 +326,12 @@ func (r *PgRepository) UpdateProduct(ctx context.Context, params *UpdateProductP
 		params.Barcode,
 		params.Unit,
 		params.BatchControl,
-		params.ConversionFactor,
+		params.LotAllocation,
+		params.AllowNegativeStock,
+		params.TaxRate,
+		params.MinStock,
+		params.ReorderPoint,
+		params.PreferredSupplierID,
 	).Scan(
 		&product.ID,
 		&product.Status,
@@ -255,7 +339,12 @@ func (r *PgRepository) UpdateProduct(ctx context.Context, params *UpdateProductP
 		&product.Barcode,
 		&product.Unit,
 		&product.BatchControl,
-		&product.ConversionFactor,
+		&product.LotAllocation,
+		&product.AllowNegativeStock,
+		&product.TaxRate,
+		&product.MinStock,
+		&product.ReorderPoint,
+		&product.PreferredSupplierID,
 		&product.CreatedAt,
 		&product.UpdatedAt,
 	)
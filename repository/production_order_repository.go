@@ -0,0 +1,669 @@
+package repository
+
+import (
+	"context"
+	"github.com/hoffax/prodrest/constants"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type ProductionOrder struct {
+	ID               *pgxuuid.UUID
+	Status           string
+	ProductID        *pgxuuid.UUID
+	ProductName      string
+	BomID            *pgxuuid.UUID
+	BomVersion       int
+	PlannedQuantity  decimal.Decimal
+	ProducedQuantity *decimal.Decimal
+	Date             time.Time
+	CompletedDate    *time.Time
+	Batch            string
+	Notes            string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+
+	ComponentsCost *decimal.Decimal
+	LabourCost     decimal.Decimal
+	OverheadCost   decimal.Decimal
+
+	ComponentsWarehouseID   *pgxuuid.UUID
+	ComponentsWarehouseName string
+	OutputWarehouseID       *pgxuuid.UUID
+	OutputWarehouseName     string
+
+	// movements posted when the order was completed
+	ConsumptionMovementID *pgxuuid.UUID
+	OutputMovementID      *pgxuuid.UUID
+
+	CreatedByUserID     *pgxuuid.UUID
+	CreatedByUserName   string
+	CancelledByUserID   *pgxuuid.UUID
+	CancelledByUserName string
+}
+
+// productionOrderQuery selects every column scanned by scanProductionOrder,
+// callers append their own WHERE and ORDER BY
+const productionOrderQuery = `
+	SELECT
+		po.id,
+		po.status,
+		po.product_id,
+		p.name,
+		po.bom_id,
+		b.version,
+		po.planned_quantity,
+		po.produced_quantity,
+		po.date,
+		po.completed_date,
+		po.batch,
+		po.notes,
+		po.created_at,
+		po.updated_at,
+		po.components_cost,
+		po.labour_cost,
+		po.overhead_cost,
+		po.components_warehouse_id,
+		cw.name,
+		po.output_warehouse_id,
+		ow.name,
+		(SELECT sm.id FROM "stock_movements" sm WHERE sm.production_order_id = po.id AND sm.type = 'PRODUCTION_OUT' ORDER BY sm.created_at DESC LIMIT 1),
+		(SELECT sm.id FROM "stock_movements" sm WHERE sm.production_order_id = po.id AND sm.type = 'PRODUCTION_IN' ORDER BY sm.created_at DESC LIMIT 1),
+		cu.id,
+		cu.name,
+		cu2.id,
+		coalesce(cu2.name, '')
+	FROM "production_orders" po
+	JOIN "products" p ON p.id = po.product_id
+	JOIN "boms" b ON b.id = po.bom_id
+	JOIN "warehouses" cw ON cw.id = po.components_warehouse_id
+	JOIN "warehouses" ow ON ow.id = po.output_warehouse_id
+	LEFT JOIN "users" cu ON cu.id = po.created_by_user_id
+	LEFT JOIN "users" cu2 ON cu2.id = po.cancelled_by_user_id
+`
+
+func scanProductionOrder(row pgx.Row, extra ...any) (*ProductionOrder, error) {
+	po := ProductionOrder{}
+	dest := append(extra,
+		&po.ID,
+		&po.Status,
+		&po.ProductID,
+		&po.ProductName,
+		&po.BomID,
+		&po.BomVersion,
+		&po.PlannedQuantity,
+		&po.ProducedQuantity,
+		&po.Date,
+		&po.CompletedDate,
+		&po.Batch,
+		&po.Notes,
+		&po.CreatedAt,
+		&po.UpdatedAt,
+		&po.ComponentsCost,
+		&po.LabourCost,
+		&po.OverheadCost,
+		&po.ComponentsWarehouseID,
+		&po.ComponentsWarehouseName,
+		&po.OutputWarehouseID,
+		&po.OutputWarehouseName,
+		&po.ConsumptionMovementID,
+		&po.OutputMovementID,
+		&po.CreatedByUserID,
+		&po.CreatedByUserName,
+		&po.CancelledByUserID,
+		&po.CancelledByUserName,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &po, nil
+}
+
+type FetchProductionOrdersParams struct {
+	StatusOptions []string
+	ProductID     *pgxuuid.UUID
+	Limit         int
+	Offset        int
+}
+
+type FetchProductionOrdersResult struct {
+	TotalCount int
+	Items      []*ProductionOrder
+}
+
+func (r *PgRepository) FetchProductionOrders(ctx context.Context, params *FetchProductionOrdersParams) (*FetchProductionOrdersResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			COUNT(*) OVER() AS full_count,
+			q.*
+		FROM (`+productionOrderQuery+`
+			WHERE
+				(cardinality($1::production_order_status[]) = 0 OR po.status = ANY($1::production_order_status[]))
+				AND ($2::UUID IS NULL OR po.product_id = $2)
+		) q
+		ORDER BY
+			q.date DESC,
+			q.created_at DESC
+		LIMIT $3
+		OFFSET $4
+	`, params.StatusOptions, params.ProductID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchProductionOrdersResult{
+		Items: make([]*ProductionOrder, 0),
+	}
+	for rows.Next() {
+		po, err := scanProductionOrder(rows, &result.TotalCount)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, po)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *PgRepository) GetProductionOrderByID(ctx context.Context, id *pgxuuid.UUID) (*ProductionOrder, error) {
+	return scanProductionOrder(r.db.QueryRow(ctx, productionOrderQuery+`
+		WHERE
+			po.id = $1
+	`, id))
+}
+
+// CountBomProductionOrders returns how many orders, not cancelled, were
+// planned with the given recipe version
+func (r *PgRepository) CountBomProductionOrders(ctx context.Context, bomID *pgxuuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `
+		SELECT count(*)
+		FROM "production_orders"
+		WHERE
+			bom_id = $1
+			AND status <> 'CANCELLED'
+	`, bomID).Scan(
+		&count,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+type CreateProductionOrderParams struct {
+	ProductID             *pgxuuid.UUID
+	BomID                 *pgxuuid.UUID
+	PlannedQuantity       decimal.Decimal
+	Date                  time.Time
+	ComponentsWarehouseID *pgxuuid.UUID
+	OutputWarehouseID     *pgxuuid.UUID
+	Batch                 string
+	Notes                 string
+	CreatedBy             *pgxuuid.UUID
+}
+
+func (r *PgRepository) CreateProductionOrder(ctx context.Context, params *CreateProductionOrderParams) (*ProductionOrder, error) {
+	var poID pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO "production_orders" (
+			product_id,
+			bom_id,
+			planned_quantity,
+			date,
+			components_warehouse_id,
+			output_warehouse_id,
+			batch,
+			notes,
+			created_by_user_id
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9
+		) RETURNING id
+	`,
+		params.ProductID,
+		params.BomID,
+		params.PlannedQuantity,
+		params.Date,
+		params.ComponentsWarehouseID,
+		params.OutputWarehouseID,
+		params.Batch,
+		params.Notes,
+		params.CreatedBy,
+	).Scan(
+		&poID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetProductionOrderByID(ctx, &poID)
+}
+
+type UpdateProductionOrderParams struct {
+	ID                    *pgxuuid.UUID
+	BomID                 *pgxuuid.UUID
+	PlannedQuantity       decimal.Decimal
+	Date                  time.Time
+	ComponentsWarehouseID *pgxuuid.UUID
+	OutputWarehouseID     *pgxuuid.UUID
+	Batch                 string
+	Notes                 string
+}
+
+// UpdateProductionOrder changes an order that was not started yet
+func (r *PgRepository) UpdateProductionOrder(ctx context.Context, params *UpdateProductionOrderParams) (*ProductionOrder, error) {
+	var poID pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		UPDATE "production_orders" SET
+			bom_id = $2,
+			planned_quantity = $3,
+			date = $4,
+			components_warehouse_id = $5,
+			output_warehouse_id = $6,
+			batch = $7,
+			notes = $8,
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'PLANNED'
+		RETURNING id
+	`,
+		params.ID,
+		params.BomID,
+		params.PlannedQuantity,
+		params.Date,
+		params.ComponentsWarehouseID,
+		params.OutputWarehouseID,
+		params.Batch,
+		params.Notes,
+	).Scan(
+		&poID,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, constants.NewInvalidOperationError("production order is not planned")
+		}
+		return nil, err
+	}
+
+	return r.GetProductionOrderByID(ctx, &poID)
+}
+
+func (r *PgRepository) StartProductionOrder(ctx context.Context, id *pgxuuid.UUID) (*ProductionOrder, error) {
+	var poID pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		UPDATE "production_orders" SET
+			status = 'IN_PROGRESS',
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'PLANNED'
+		RETURNING id
+	`, id).Scan(
+		&poID,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, constants.NewInvalidOperationError("production order is not planned")
+		}
+		return nil, err
+	}
+
+	return r.GetProductionOrderByID(ctx, &poID)
+}
+
+type CompleteProductionOrderParams struct {
+	ID               *pgxuuid.UUID
+	ProducedQuantity decimal.Decimal
+	Date             time.Time
+	Batch            string
+	ComponentsCost   decimal.Decimal
+	LabourCost       decimal.Decimal
+	OverheadCost     decimal.Decimal
+	// Consumption is the PRODUCTION_OUT movement of the components and
+	// Output the PRODUCTION_IN movement of the finished product
+	Consumption *CreateStockMovementParams
+	Output      *CreateStockMovementParams
+}
+
+// CompleteProductionOrder posts the consumption and output movements of an
+// order in progress and marks it COMPLETED, all in a single transaction
+func (r *PgRepository) CompleteProductionOrder(ctx context.Context, params *CompleteProductionOrderParams) (*ProductionOrder, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var poID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		UPDATE "production_orders" SET
+			status = 'COMPLETED',
+			produced_quantity = $2,
+			completed_date = $3,
+			batch = $4,
+			components_cost = $5,
+			labour_cost = $6,
+			overhead_cost = $7,
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'IN_PROGRESS'
+		RETURNING id
+	`,
+		params.ID,
+		params.ProducedQuantity,
+		params.Date,
+		params.Batch,
+		params.ComponentsCost,
+		params.LabourCost,
+		params.OverheadCost,
+	).Scan(
+		&poID,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, constants.NewInvalidOperationError("production order is not in progress")
+		}
+		return nil, err
+	}
+
+	params.Consumption.ProductionOrderID = &poID
+	_, err = r.createStockMovement(ctx, tx, params.Consumption)
+	if err != nil {
+		return nil, err
+	}
+
+	params.Output.ProductionOrderID = &poID
+	_, err = r.createStockMovement(ctx, tx, params.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetProductionOrderByID(ctx, &poID)
+}
+
+type CancelProductionOrderParams struct {
+	ID     *pgxuuid.UUID
+	UserID *pgxuuid.UUID
+}
+
+// CancelProductionOrder cancels the order together with the movements it
+// posted, if any. It fails with an InsufficientStockError when the output
+// was already used.
+func (r *PgRepository) CancelProductionOrder(ctx context.Context, params *CancelProductionOrderParams) (*ProductionOrder, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var poID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		UPDATE "production_orders" SET
+			status = 'CANCELLED',
+			cancelled_by_user_id = $2,
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status <> 'CANCELLED'
+		RETURNING id
+	`, params.ID, params.UserID).Scan(
+		&poID,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, constants.NewInvalidOperationError("production order is already cancelled")
+		}
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		UPDATE "stock_movements" SET
+			status = 'INACTIVE',
+			cancelled_by_user_id = $2,
+			cancel_reason = 'production order cancelled',
+			cancelled_at = now(),
+			updated_at = now()
+		WHERE
+			production_order_id = $1
+			AND status = 'ACTIVE'
+		RETURNING id, date
+	`, &poID, params.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	smIDs := make([]pgxuuid.UUID, 0)
+	dates := make([]time.Time, 0)
+	for rows.Next() {
+		var smID pgxuuid.UUID
+		var date time.Time
+		err := rows.Scan(&smID, &date)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		smIDs = append(smIDs, smID)
+		dates = append(dates, date)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = checkOpenPeriods(ctx, tx, dates...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the output may have been sold already, it cannot be taken back then
+	if len(smIDs) > 0 {
+		err = r.checkStockRemoval(ctx, tx, smIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetProductionOrderByID(ctx, &poID)
+}
+
+type FetchProductionVariancesParams struct {
+	From      *time.Time
+	To        *time.Time
+	ProductID *pgxuuid.UUID
+}
+
+// ProductionVarianceOrder is a completed order with the output actually
+// received by its active PRODUCTION_IN movement
+type ProductionVarianceOrder struct {
+	ID               *pgxuuid.UUID
+	ProductID        *pgxuuid.UUID
+	ProductName      string
+	BomVersion       int
+	PlannedQuantity  decimal.Decimal
+	CompletedDate    time.Time
+	ProducedQuantity decimal.Decimal
+	OutputValue      decimal.Decimal
+}
+
+// ProductionVarianceComponent compares what the recipe of an order expects
+// to consume of a component, scrap included, with what its active
+// PRODUCTION_OUT movement consumed. Components out of the recipe have no
+// expected quantity and components never consumed no actual quantity.
+type ProductionVarianceComponent struct {
+	ProductionOrderID *pgxuuid.UUID
+	ProductID         *pgxuuid.UUID
+	ProductName       string
+	ExpectedQuantity  decimal.Decimal
+	ActualQuantity    decimal.Decimal
+	ActualValue       decimal.Decimal
+}
+
+type FetchProductionVariancesResult struct {
+	Orders     []*ProductionVarianceOrder
+	Components []*ProductionVarianceComponent
+}
+
+// FetchProductionVariances returns the orders completed between From and To
+// (both inclusive and optional) with their expected and actual consumption
+func (r *PgRepository) FetchProductionVariances(ctx context.Context, params *FetchProductionVariancesParams) (*FetchProductionVariancesResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			po.id,
+			po.product_id,
+			p.name,
+			b.version,
+			po.planned_quantity,
+			po.completed_date,
+			coalesce(output.quantity, 0),
+			coalesce(output.value, 0)
+		FROM "production_orders" po
+		JOIN "products" p ON p.id = po.product_id
+		JOIN "boms" b ON b.id = po.bom_id
+		LEFT JOIN LATERAL (
+			SELECT
+				sum(smi.quantity) AS quantity,
+				sum(round(smi.quantity * smi.price, 2)) AS value
+			FROM "stock_movements" sm
+			JOIN "stock_movement_items" smi ON smi.stock_movement_id = sm.id
+			WHERE
+				sm.production_order_id = po.id
+				AND sm.type = 'PRODUCTION_IN'
+				AND sm.status = 'ACTIVE'
+		) output ON TRUE
+		WHERE
+			po.status = 'COMPLETED'
+			AND ($1::DATE IS NULL OR po.completed_date >= $1)
+			AND ($2::DATE IS NULL OR po.completed_date <= $2)
+			AND ($3::UUID IS NULL OR po.product_id = $3)
+		ORDER BY
+			po.completed_date,
+			po.created_at
+	`, params.From, params.To, params.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchProductionVariancesResult{
+		Orders:     make([]*ProductionVarianceOrder, 0),
+		Components: make([]*ProductionVarianceComponent, 0),
+	}
+	orderIDs := make([]pgxuuid.UUID, 0)
+	for rows.Next() {
+		order := ProductionVarianceOrder{}
+		err := rows.Scan(
+			&order.ID,
+			&order.ProductID,
+			&order.ProductName,
+			&order.BomVersion,
+			&order.PlannedQuantity,
+			&order.CompletedDate,
+			&order.ProducedQuantity,
+			&order.OutputValue,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result.Orders = append(result.Orders, &order)
+		orderIDs = append(orderIDs, *order.ID)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(orderIDs) == 0 {
+		return &result, nil
+	}
+
+	rows, err = r.db.Query(ctx, `
+		WITH "expected" AS (
+			SELECT
+				po.id AS production_order_id,
+				bi.component_product_id AS product_id,
+				round(bi.quantity * po.planned_quantity * (1 + bi.scrap_percentage / 100), 3) AS quantity
+			FROM "production_orders" po
+			JOIN "bom_items" bi ON bi.bom_id = po.bom_id
+			WHERE
+				po.id = ANY($1::uuid[])
+		), "actual" AS (
+			SELECT
+				sm.production_order_id,
+				smi.product_id,
+				sum(smi.quantity) AS quantity,
+				sum(round(smi.quantity * smi.price, 2)) AS value
+			FROM "stock_movements" sm
+			JOIN "stock_movement_items" smi ON smi.stock_movement_id = sm.id
+			WHERE
+				sm.production_order_id = ANY($1::uuid[])
+				AND sm.type = 'PRODUCTION_OUT'
+				AND sm.status = 'ACTIVE'
+			GROUP BY
+				sm.production_order_id,
+				smi.product_id
+		)
+		SELECT
+			coalesce(e.production_order_id, a.production_order_id),
+			p.id,
+			p.name,
+			coalesce(e.quantity, 0),
+			coalesce(a.quantity, 0),
+			coalesce(a.value, 0)
+		FROM "expected" e
+		FULL OUTER JOIN "actual" a ON a.production_order_id = e.production_order_id AND a.product_id = e.product_id
+		JOIN "products" p ON p.id = coalesce(e.product_id, a.product_id)
+		ORDER BY
+			p.name
+	`, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		component := ProductionVarianceComponent{}
+		err := rows.Scan(
+			&component.ProductionOrderID,
+			&component.ProductID,
+			&component.ProductName,
+			&component.ExpectedQuantity,
+			&component.ActualQuantity,
+			&component.ActualValue,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result.Components = append(result.Components, &component)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
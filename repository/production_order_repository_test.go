@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+// newStartedProductionOrder starts an order of 5 units of productID made of 1
+// unit of componentID, both on warehouseID
+func newStartedProductionOrder(t *testing.T, userID, warehouseID, componentID, productID *pgxuuid.UUID) *ProductionOrder {
+	t.Helper()
+	ctx := context.Background()
+
+	bom, err := testRepo.CreateBom(ctx, &CreateBomParams{
+		ProductID: productID,
+		Items: []*CreateBomItem{
+			{
+				ComponentProductID: componentID,
+				Quantity:           decimal.NewFromInt(1),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("create bom: %v", err)
+	}
+
+	po, err := testRepo.CreateProductionOrder(ctx, &CreateProductionOrderParams{
+		ProductID:             productID,
+		BomID:                 bom.ID,
+		PlannedQuantity:       decimal.NewFromInt(5),
+		Date:                  time.Now(),
+		ComponentsWarehouseID: warehouseID,
+		OutputWarehouseID:     warehouseID,
+		CreatedBy:             userID,
+	})
+	if err != nil {
+		t.Fatalf("create production order: %v", err)
+	}
+
+	po, err = testRepo.StartProductionOrder(ctx, po.ID)
+	if err != nil {
+		t.Fatalf("start production order: %v", err)
+	}
+
+	return po
+}
+
+// completeParams consumes 2 units of componentID to output the 5 units of
+// the order
+func completeParams(po *ProductionOrder, userID, warehouseID, componentID *pgxuuid.UUID) *CompleteProductionOrderParams {
+	return &CompleteProductionOrderParams{
+		ID:               po.ID,
+		ProducedQuantity: decimal.NewFromInt(5),
+		Date:             time.Now(),
+		Consumption: &CreateStockMovementParams{
+			Type:      "PRODUCTION_OUT",
+			Date:      time.Now(),
+			CreatedBy: userID,
+			Items: []*CreateStockItem{
+				{
+					ProductID:   componentID,
+					Quantity:    decimal.NewFromInt(2),
+					Price:       decimal.NewFromInt(1000),
+					WarehouseID: warehouseID,
+				},
+			},
+		},
+		Output: &CreateStockMovementParams{
+			Type:      "PRODUCTION_IN",
+			Date:      time.Now(),
+			CreatedBy: userID,
+			Items: []*CreateStockItem{
+				{
+					ProductID:   po.ProductID,
+					Quantity:    decimal.NewFromInt(5),
+					Price:       decimal.NewFromInt(400),
+					WarehouseID: warehouseID,
+				},
+			},
+		},
+	}
+}
+
+// newCompletedProductionOrder completes an order turning 2 units of a
+// component into 5 units of a new product, both on warehouseID
+func newCompletedProductionOrder(t *testing.T, userID, warehouseID *pgxuuid.UUID) (*ProductionOrder, *pgxuuid.UUID) {
+	t.Helper()
+
+	componentID := newTestProduct(t)
+	productID := newTestProduct(t)
+	mustPostStock(t, "PURCHASE", userID, warehouseID, componentID, 10)
+
+	po := newStartedProductionOrder(t, userID, warehouseID, componentID, productID)
+	po, err := testRepo.CompleteProductionOrder(context.Background(), completeParams(po, userID, warehouseID, componentID))
+	if err != nil {
+		t.Fatalf("complete production order: %v", err)
+	}
+
+	return po, productID
+}
+
+func TestCompleteProductionOrder(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+
+	t.Run("components on hand", func(t *testing.T) {
+		po, productID := newCompletedProductionOrder(t, userID, warehouseID)
+		if po.Status != "COMPLETED" {
+			t.Fatalf("expected COMPLETED, got %v", po.Status)
+		}
+		if po.ConsumptionMovementID == nil || po.OutputMovementID == nil {
+			t.Fatalf("expected both movements to be posted")
+		}
+
+		// the output is on hand
+		mustPostStock(t, "SALE", userID, warehouseID, productID, 5)
+	})
+
+	t.Run("components missing", func(t *testing.T) {
+		componentID := newTestProduct(t)
+		productID := newTestProduct(t)
+		po := newStartedProductionOrder(t, userID, warehouseID, componentID, productID)
+
+		_, err := testRepo.CompleteProductionOrder(ctx, completeParams(po, userID, warehouseID, componentID))
+		assertInsufficientStock(t, err)
+
+		po, err = testRepo.GetProductionOrderByID(ctx, po.ID)
+		if err != nil {
+			t.Fatalf("get production order: %v", err)
+		}
+		if po.Status != "IN_PROGRESS" {
+			t.Fatalf("expected IN_PROGRESS, got %v", po.Status)
+		}
+
+		// nothing of the output was posted
+		_, err = postStock("SALE", userID, warehouseID, productID, 1)
+		assertInsufficientStock(t, err)
+	})
+}
+
+func TestCancelProductionOrder(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+
+	t.Run("output on hand", func(t *testing.T) {
+		po, _ := newCompletedProductionOrder(t, userID, warehouseID)
+
+		po, err := testRepo.CancelProductionOrder(ctx, &CancelProductionOrderParams{
+			ID:     po.ID,
+			UserID: userID,
+		})
+		if err != nil {
+			t.Fatalf("cancel production order: %v", err)
+		}
+		if po.Status != "CANCELLED" {
+			t.Fatalf("expected CANCELLED, got %v", po.Status)
+		}
+	})
+
+	t.Run("output sold", func(t *testing.T) {
+		po, productID := newCompletedProductionOrder(t, userID, warehouseID)
+		mustPostStock(t, "SALE", userID, warehouseID, productID, 3)
+
+		_, err := testRepo.CancelProductionOrder(ctx, &CancelProductionOrderParams{
+			ID:     po.ID,
+			UserID: userID,
+		})
+		assertInsufficientStock(t, err)
+
+		po, err = testRepo.GetProductionOrderByID(ctx, po.ID)
+		if err != nil {
+			t.Fatalf("get production order: %v", err)
+		}
+		if po.Status != "COMPLETED" {
+			t.Fatalf("expected COMPLETED, got %v", po.Status)
+		}
+	})
+}
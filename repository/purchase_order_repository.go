@@ -0,0 +1,617 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hoffax/prodrest/constants"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+type PurchaseOrder struct {
+	ID           *pgxuuid.UUID
+	Status       string
+	EntityID     *pgxuuid.UUID
+	EntityName   string
+	Date         time.Time
+	ExpectedDate *time.Time
+	Currency     string
+	Notes        string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	CreatedByUserID   *pgxuuid.UUID
+	CreatedByUserName string
+	ClosedByUserID    *pgxuuid.UUID
+	ClosedByUserName  string
+
+	Lines []*PurchaseOrderLine
+}
+
+// PurchaseOrderLine holds quantities in the base unit of the product,
+// ReceivedQuantity adds up the active receipts of the line
+type PurchaseOrderLine struct {
+	ID               *pgxuuid.UUID
+	PurchaseOrderID  *pgxuuid.UUID
+	ProductID        *pgxuuid.UUID
+	ProductName      string
+	Quantity         decimal.Decimal
+	Price            decimal.Decimal
+	ReceivedQuantity decimal.Decimal
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// purchaseOrderQuery selects every column scanned by scanPurchaseOrder,
+// callers append their own WHERE and ORDER BY
+const purchaseOrderQuery = `
+	SELECT
+		po.id,
+		po.status,
+		po.entity_id,
+		e.name,
+		po.date,
+		po.expected_date,
+		po.currency,
+		po.notes,
+		po.created_at,
+		po.updated_at,
+		cu.id,
+		cu.name,
+		cu2.id,
+		coalesce(cu2.name, '')
+	FROM "purchase_orders" po
+	JOIN "entities" e ON e.id = po.entity_id
+	LEFT JOIN "users" cu ON cu.id = po.created_by_user_id
+	LEFT JOIN "users" cu2 ON cu2.id = po.closed_by_user_id
+`
+
+func scanPurchaseOrder(row pgx.Row, extra ...any) (*PurchaseOrder, error) {
+	po := PurchaseOrder{}
+	dest := append(extra,
+		&po.ID,
+		&po.Status,
+		&po.EntityID,
+		&po.EntityName,
+		&po.Date,
+		&po.ExpectedDate,
+		&po.Currency,
+		&po.Notes,
+		&po.CreatedAt,
+		&po.UpdatedAt,
+		&po.CreatedByUserID,
+		&po.CreatedByUserName,
+		&po.ClosedByUserID,
+		&po.ClosedByUserName,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &po, nil
+}
+
+// fetchPurchaseOrderLines loads the lines of the given orders into them
+func (r *PgRepository) fetchPurchaseOrderLines(ctx context.Context, orders []*PurchaseOrder) error {
+	orderIDs := make([]pgxuuid.UUID, len(orders))
+	orderMap := make(map[pgxuuid.UUID]*PurchaseOrder, len(orders))
+	for i, po := range orders {
+		orderIDs[i] = *po.ID
+		orderMap[*po.ID] = po
+		po.Lines = make([]*PurchaseOrderLine, 0)
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			pol.id,
+			pol.purchase_order_id,
+			pol.product_id,
+			p.name,
+			pol.quantity,
+			pol.price,
+			polr.received_quantity,
+			pol.created_at,
+			pol.updated_at
+		FROM "purchase_order_lines" pol
+		JOIN "products" p ON p.id = pol.product_id
+		JOIN "purchase_order_line_receipts" polr ON polr.purchase_order_line_id = pol.id
+		WHERE
+			pol.purchase_order_id = ANY($1::uuid[])
+		ORDER BY
+			p.name
+	`, orderIDs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		line := PurchaseOrderLine{}
+		err := rows.Scan(
+			&line.ID,
+			&line.PurchaseOrderID,
+			&line.ProductID,
+			&line.ProductName,
+			&line.Quantity,
+			&line.Price,
+			&line.ReceivedQuantity,
+			&line.CreatedAt,
+			&line.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if po, ok := orderMap[*line.PurchaseOrderID]; ok {
+			po.Lines = append(po.Lines, &line)
+		}
+	}
+
+	return rows.Err()
+}
+
+type FetchPurchaseOrdersParams struct {
+	StatusOptions []string
+	EntityID      *pgxuuid.UUID
+	ProductID     *pgxuuid.UUID
+	Limit         int
+	Offset        int
+}
+
+type FetchPurchaseOrdersResult struct {
+	TotalCount int
+	Items      []*PurchaseOrder
+}
+
+func (r *PgRepository) FetchPurchaseOrders(ctx context.Context, params *FetchPurchaseOrdersParams) (*FetchPurchaseOrdersResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			COUNT(*) OVER() AS full_count,
+			q.*
+		FROM (`+purchaseOrderQuery+`
+			WHERE
+				(cardinality($1::purchase_order_status[]) = 0 OR po.status = ANY($1::purchase_order_status[]))
+				AND ($2::UUID IS NULL OR po.entity_id = $2)
+				AND ($3::UUID IS NULL OR EXISTS (
+					SELECT 1 FROM "purchase_order_lines" pol WHERE pol.purchase_order_id = po.id AND pol.product_id = $3
+				))
+		) q
+		ORDER BY
+			q.date DESC,
+			q.created_at DESC
+		LIMIT $4
+		OFFSET $5
+	`, params.StatusOptions, params.EntityID, params.ProductID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchPurchaseOrdersResult{
+		Items: make([]*PurchaseOrder, 0),
+	}
+	for rows.Next() {
+		po, err := scanPurchaseOrder(rows, &result.TotalCount)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, po)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = r.fetchPurchaseOrderLines(ctx, result.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *PgRepository) GetPurchaseOrderByID(ctx context.Context, id *pgxuuid.UUID) (*PurchaseOrder, error) {
+	po, err := scanPurchaseOrder(r.db.QueryRow(ctx, purchaseOrderQuery+`
+		WHERE
+			po.id = $1
+	`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.fetchPurchaseOrderLines(ctx, []*PurchaseOrder{po})
+	if err != nil {
+		return nil, err
+	}
+
+	return po, nil
+}
+
+// OpenPurchaseOrderLine is a line of a SENT or PARTIALLY_RECEIVED order that
+// still has a quantity to receive
+type OpenPurchaseOrderLine struct {
+	PurchaseOrderLine
+	EntityID     *pgxuuid.UUID
+	EntityName   string
+	Date         time.Time
+	ExpectedDate *time.Time
+	Currency     string
+}
+
+type FetchOpenPurchaseOrderLinesParams struct {
+	EntityID  *pgxuuid.UUID
+	ProductID *pgxuuid.UUID
+}
+
+// FetchOpenPurchaseOrderLines returns the lines still expected from
+// suppliers, the ones expected first on top
+func (r *PgRepository) FetchOpenPurchaseOrderLines(ctx context.Context, params *FetchOpenPurchaseOrderLinesParams) ([]*OpenPurchaseOrderLine, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			pol.id,
+			pol.purchase_order_id,
+			pol.product_id,
+			p.name,
+			pol.quantity,
+			pol.price,
+			polr.received_quantity,
+			pol.created_at,
+			pol.updated_at,
+			po.entity_id,
+			e.name,
+			po.date,
+			po.expected_date,
+			po.currency
+		FROM "purchase_order_lines" pol
+		JOIN "purchase_orders" po ON po.id = pol.purchase_order_id
+		JOIN "purchase_order_line_receipts" polr ON polr.purchase_order_line_id = pol.id
+		JOIN "products" p ON p.id = pol.product_id
+		JOIN "entities" e ON e.id = po.entity_id
+		WHERE
+			po.status IN ('SENT', 'PARTIALLY_RECEIVED')
+			AND polr.received_quantity < pol.quantity
+			AND ($1::UUID IS NULL OR po.entity_id = $1)
+			AND ($2::UUID IS NULL OR pol.product_id = $2)
+		ORDER BY
+			coalesce(po.expected_date, po.date),
+			p.name
+	`, params.EntityID, params.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make([]*OpenPurchaseOrderLine, 0)
+	for rows.Next() {
+		line := OpenPurchaseOrderLine{}
+		err := rows.Scan(
+			&line.ID,
+			&line.PurchaseOrderID,
+			&line.ProductID,
+			&line.ProductName,
+			&line.Quantity,
+			&line.Price,
+			&line.ReceivedQuantity,
+			&line.CreatedAt,
+			&line.UpdatedAt,
+			&line.EntityID,
+			&line.EntityName,
+			&line.Date,
+			&line.ExpectedDate,
+			&line.Currency,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, &line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+type CreatePurchaseOrderLine struct {
+	ProductID *pgxuuid.UUID
+	Quantity  decimal.Decimal
+	Price     decimal.Decimal
+}
+
+type CreatePurchaseOrderParams struct {
+	EntityID     *pgxuuid.UUID
+	Date         time.Time
+	ExpectedDate *time.Time
+	Currency     string
+	Notes        string
+	CreatedBy    *pgxuuid.UUID
+	Lines        []*CreatePurchaseOrderLine
+}
+
+func insertPurchaseOrderLines(ctx context.Context, tx pgx.Tx, poID *pgxuuid.UUID, lines []*CreatePurchaseOrderLine) error {
+	for _, line := range lines {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO "purchase_order_lines" (
+				purchase_order_id,
+				product_id,
+				quantity,
+				price
+			) VALUES (
+				$1, $2, $3, $4
+			)
+		`, poID, line.ProductID, line.Quantity, line.Price)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *PgRepository) CreatePurchaseOrder(ctx context.Context, params *CreatePurchaseOrderParams) (*PurchaseOrder, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var poID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO "purchase_orders" (
+			entity_id,
+			date,
+			expected_date,
+			currency,
+			notes,
+			created_by_user_id
+		) VALUES (
+			$1, $2, $3, $4, $5, $6
+		) RETURNING id
+	`,
+		params.EntityID,
+		params.Date,
+		params.ExpectedDate,
+		params.Currency,
+		params.Notes,
+		params.CreatedBy,
+	).Scan(
+		&poID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertPurchaseOrderLines(ctx, tx, &poID, params.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetPurchaseOrderByID(ctx, &poID)
+}
+
+type UpdatePurchaseOrderParams struct {
+	ID           *pgxuuid.UUID
+	EntityID     *pgxuuid.UUID
+	Date         time.Time
+	ExpectedDate *time.Time
+	Currency     string
+	Notes        string
+	Lines        []*CreatePurchaseOrderLine
+}
+
+// UpdatePurchaseOrder changes a DRAFT order and replaces its lines
+func (r *PgRepository) UpdatePurchaseOrder(ctx context.Context, params *UpdatePurchaseOrderParams) (*PurchaseOrder, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var poID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		UPDATE "purchase_orders" SET
+			entity_id = $2,
+			date = $3,
+			expected_date = $4,
+			currency = $5,
+			notes = $6,
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'DRAFT'
+		RETURNING id
+	`,
+		params.ID,
+		params.EntityID,
+		params.Date,
+		params.ExpectedDate,
+		params.Currency,
+		params.Notes,
+	).Scan(
+		&poID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("purchase order is not a draft")
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM "purchase_order_lines" WHERE purchase_order_id = $1`, &poID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertPurchaseOrderLines(ctx, tx, &poID, params.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetPurchaseOrderByID(ctx, &poID)
+}
+
+func (r *PgRepository) SendPurchaseOrder(ctx context.Context, id *pgxuuid.UUID) (*PurchaseOrder, error) {
+	var poID pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		UPDATE "purchase_orders" SET
+			status = 'SENT',
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'DRAFT'
+		RETURNING id
+	`, id).Scan(
+		&poID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("purchase order is not a draft")
+		}
+		return nil, err
+	}
+
+	return r.GetPurchaseOrderByID(ctx, &poID)
+}
+
+type ClosePurchaseOrderParams struct {
+	ID     *pgxuuid.UUID
+	UserID *pgxuuid.UUID
+}
+
+// ClosePurchaseOrder stops an order from receiving anything else, whatever
+// is still outstanding is no longer expected
+func (r *PgRepository) ClosePurchaseOrder(ctx context.Context, params *ClosePurchaseOrderParams) (*PurchaseOrder, error) {
+	var poID pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		UPDATE "purchase_orders" SET
+			status = 'CLOSED',
+			closed_by_user_id = $2,
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status <> 'CLOSED'
+		RETURNING id
+	`, params.ID, params.UserID).Scan(
+		&poID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("purchase order is already closed")
+		}
+		return nil, err
+	}
+
+	return r.GetPurchaseOrderByID(ctx, &poID)
+}
+
+// lockPurchaseOrderForReceipt locks an order until tx ends so concurrent
+// receipts cannot both pass the over-receipt check
+func lockPurchaseOrderForReceipt(ctx context.Context, tx pgx.Tx, poID *pgxuuid.UUID) error {
+	var status string
+	err := tx.QueryRow(ctx, `
+		SELECT status
+		FROM "purchase_orders"
+		WHERE id = $1
+		FOR UPDATE
+	`, poID).Scan(&status)
+	if err != nil {
+		return err
+	}
+
+	if status != "SENT" && status != "PARTIALLY_RECEIVED" {
+		return constants.NewInvalidOperationError("purchase order is not open for receiving")
+	}
+
+	return nil
+}
+
+// checkPurchaseOrderReceipt fails when any line of the order was received
+// over its ordered quantity
+func checkPurchaseOrderReceipt(ctx context.Context, tx pgx.Tx, poID *pgxuuid.UUID) error {
+	rows, err := tx.Query(ctx, `
+		SELECT
+			p.name,
+			pol.quantity,
+			polr.received_quantity
+		FROM "purchase_order_lines" pol
+		JOIN "purchase_order_line_receipts" polr ON polr.purchase_order_line_id = pol.id
+		JOIN "products" p ON p.id = pol.product_id
+		WHERE
+			pol.purchase_order_id = $1
+			AND polr.received_quantity > pol.quantity
+		ORDER BY
+			p.name
+	`, poID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	overReceived := make([]string, 0)
+	for rows.Next() {
+		var name string
+		var ordered, received decimal.Decimal
+		err := rows.Scan(&name, &ordered, &received)
+		if err != nil {
+			return err
+		}
+		overReceived = append(overReceived, fmt.Sprintf("%v ordered %v received %v", name, ordered, received))
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(overReceived) > 0 {
+		return constants.NewInvalidOperationError("over-receipt: " + strings.Join(overReceived, ", "))
+	}
+
+	return nil
+}
+
+// refreshPurchaseOrderStatus moves an open order between SENT,
+// PARTIALLY_RECEIVED and RECEIVED after its receipts changed, closed and
+// draft orders are left alone
+func refreshPurchaseOrderStatus(ctx context.Context, tx pgx.Tx, poID *pgxuuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE "purchase_orders" po SET
+			status = CASE
+				WHEN NOT EXISTS (
+					SELECT 1
+					FROM "purchase_order_lines" pol
+					JOIN "purchase_order_line_receipts" polr ON polr.purchase_order_line_id = pol.id
+					WHERE pol.purchase_order_id = po.id AND polr.received_quantity < pol.quantity
+				) THEN 'RECEIVED'::PURCHASE_ORDER_STATUS
+				WHEN EXISTS (
+					SELECT 1
+					FROM "purchase_order_lines" pol
+					JOIN "purchase_order_line_receipts" polr ON polr.purchase_order_line_id = pol.id
+					WHERE pol.purchase_order_id = po.id AND polr.received_quantity > 0
+				) THEN 'PARTIALLY_RECEIVED'::PURCHASE_ORDER_STATUS
+				ELSE 'SENT'::PURCHASE_ORDER_STATUS
+			END,
+			updated_at = now()
+		WHERE
+			po.id = $1
+			AND po.status IN ('SENT', 'PARTIALLY_RECEIVED', 'RECEIVED')
+	`, poID)
+
+	return err
+}
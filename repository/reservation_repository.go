@@ -0,0 +1,398 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/hoffax/prodrest/constants"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// Reservation holds Quantity of a product, in its base unit, for the
+// document SourceID. HeldQuantity is what it still holds: nothing once it is
+// released or expired, and for a sales order line what is not delivered yet.
+type Reservation struct {
+	ID               *pgxuuid.UUID
+	Status           string
+	ProductID        *pgxuuid.UUID
+	ProductName      string
+	WarehouseID      *pgxuuid.UUID
+	WarehouseName    string
+	LotID            *pgxuuid.UUID
+	Batch            string
+	Quantity         decimal.Decimal
+	HeldQuantity     decimal.Decimal
+	SourceType       string
+	SourceID         *pgxuuid.UUID
+	SalesOrderLineID *pgxuuid.UUID
+	Reference        string
+	ExpiresAt        *time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+
+	CreatedByUserID    *pgxuuid.UUID
+	CreatedByUserName  string
+	ReleasedByUserID   *pgxuuid.UUID
+	ReleasedByUserName string
+	ReleasedAt         *time.Time
+}
+
+// reservationQuery selects every column scanned by scanReservation, callers
+// append their own WHERE and ORDER BY
+const reservationQuery = `
+	SELECT
+		r.id,
+		r.status,
+		r.product_id,
+		p.name,
+		r.warehouse_id,
+		coalesce(w.name, ''),
+		r.lot_id,
+		coalesce(l.code, ''),
+		r.quantity,
+		coalesce(rh.held_quantity, 0),
+		r.source_type,
+		r.source_id,
+		r.sales_order_line_id,
+		r.reference,
+		r.expires_at,
+		r.created_at,
+		r.updated_at,
+		cu.id,
+		cu.name,
+		cu2.id,
+		coalesce(cu2.name, ''),
+		r.released_at
+	FROM "reservations" r
+	JOIN "products" p ON p.id = r.product_id
+	LEFT JOIN "warehouses" w ON w.id = r.warehouse_id
+	LEFT JOIN "lots" l ON l.id = r.lot_id
+	LEFT JOIN "reservation_holds" rh ON rh.id = r.id
+	LEFT JOIN "users" cu ON cu.id = r.created_by_user_id
+	LEFT JOIN "users" cu2 ON cu2.id = r.released_by_user_id
+`
+
+func scanReservation(row pgx.Row, extra ...any) (*Reservation, error) {
+	reservation := Reservation{}
+	dest := append(extra,
+		&reservation.ID,
+		&reservation.Status,
+		&reservation.ProductID,
+		&reservation.ProductName,
+		&reservation.WarehouseID,
+		&reservation.WarehouseName,
+		&reservation.LotID,
+		&reservation.Batch,
+		&reservation.Quantity,
+		&reservation.HeldQuantity,
+		&reservation.SourceType,
+		&reservation.SourceID,
+		&reservation.SalesOrderLineID,
+		&reservation.Reference,
+		&reservation.ExpiresAt,
+		&reservation.CreatedAt,
+		&reservation.UpdatedAt,
+		&reservation.CreatedByUserID,
+		&reservation.CreatedByUserName,
+		&reservation.ReleasedByUserID,
+		&reservation.ReleasedByUserName,
+		&reservation.ReleasedAt,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reservation, nil
+}
+
+type FetchReservationsParams struct {
+	StatusOptions []string
+	ProductID     *pgxuuid.UUID
+	WarehouseID   *pgxuuid.UUID
+	SourceID      *pgxuuid.UUID
+	Limit         int
+	Offset        int
+}
+
+type FetchReservationsResult struct {
+	TotalCount int
+	Items      []*Reservation
+}
+
+func (r *PgRepository) FetchReservations(ctx context.Context, params *FetchReservationsParams) (*FetchReservationsResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			COUNT(*) OVER() AS full_count,
+			q.*
+		FROM (`+reservationQuery+`
+			WHERE
+				(cardinality($1::reservation_status[]) = 0 OR r.status = ANY($1::reservation_status[]))
+				AND ($2::UUID IS NULL OR r.product_id = $2)
+				AND ($3::UUID IS NULL OR r.warehouse_id = $3)
+				AND ($4::UUID IS NULL OR r.source_id = $4)
+		) q
+		ORDER BY
+			q.created_at DESC
+		LIMIT $5
+		OFFSET $6
+	`, params.StatusOptions, params.ProductID, params.WarehouseID, params.SourceID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchReservationsResult{
+		Items: make([]*Reservation, 0),
+	}
+	for rows.Next() {
+		reservation, err := scanReservation(rows, &result.TotalCount)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, reservation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *PgRepository) GetReservationByID(ctx context.Context, id *pgxuuid.UUID) (*Reservation, error) {
+	return scanReservation(r.db.QueryRow(ctx, reservationQuery+`
+		WHERE
+			r.id = $1
+	`, id))
+}
+
+// FetchReservedStock returns the quantity held by reservations of each of
+// the given products, products with nothing held are left out
+func (r *PgRepository) FetchReservedStock(ctx context.Context, productIDs []pgxuuid.UUID) (map[pgxuuid.UUID]decimal.Decimal, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			rh.product_id,
+			sum(rh.held_quantity)
+		FROM "reservation_holds" rh
+		WHERE
+			rh.product_id = ANY($1::uuid[])
+		GROUP BY
+			rh.product_id
+	`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reserved := make(map[pgxuuid.UUID]decimal.Decimal, len(productIDs))
+	for rows.Next() {
+		var productID pgxuuid.UUID
+		var quantity decimal.Decimal
+		err := rows.Scan(&productID, &quantity)
+		if err != nil {
+			return nil, err
+		}
+		reserved[productID] = quantity
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reserved, nil
+}
+
+// availableStock returns the stock on hand of a product less what other
+// documents hold of it, on warehouseID or on every warehouse when nil. Held
+// quantities of the other warehouses and of no warehouse are only counted
+// when looking at the whole product.
+func availableStock(ctx context.Context, tx pgx.Tx, productID *pgxuuid.UUID, warehouseID *pgxuuid.UUID, lotID *pgxuuid.UUID) (decimal.Decimal, error) {
+	var available decimal.Decimal
+	err := tx.QueryRow(ctx, `
+		SELECT
+			(
+				SELECT
+					coalesce(sum(smi.quantity * movement_sign(sm.type)), 0)
+				FROM "stock_movement_items" smi
+				JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+				WHERE
+					sm.status = 'ACTIVE'
+					AND smi.product_id = $1
+					AND ($2::UUID IS NULL OR smi.warehouse_id = $2)
+					AND ($3::UUID IS NULL OR smi.lot_id = $3)
+			) - (
+				SELECT
+					coalesce(sum(rh.held_quantity), 0)
+				FROM "reservation_holds" rh
+				WHERE
+					rh.product_id = $1
+					AND ($2::UUID IS NULL OR rh.warehouse_id = $2)
+					AND ($3::UUID IS NULL OR rh.lot_id = $3)
+			)
+	`, productID, warehouseID, lotID).Scan(&available)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return available, nil
+}
+
+type CreateReservationParams struct {
+	ProductID   *pgxuuid.UUID
+	WarehouseID *pgxuuid.UUID
+	LotID       *pgxuuid.UUID
+	Quantity    decimal.Decimal
+	Reference   string
+	ExpiresAt   *time.Time
+	CreatedBy   *pgxuuid.UUID
+}
+
+// CreateReservation holds stock for a MANUAL reservation. It fails with an
+// InsufficientStockError when the quantity is not available on the
+// warehouse, or lot, and on the product as a whole.
+func (r *PgRepository) CreateReservation(ctx context.Context, params *CreateReservationParams) (*Reservation, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = r.lockProducts(ctx, tx, []pgxuuid.UUID{*params.ProductID})
+	if err != nil {
+		return nil, err
+	}
+
+	available, err := availableStock(ctx, tx, params.ProductID, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if params.WarehouseID != nil {
+		warehouseAvailable, err := availableStock(ctx, tx, params.ProductID, params.WarehouseID, params.LotID)
+		if err != nil {
+			return nil, err
+		}
+		available = decimal.Min(available, warehouseAvailable)
+	}
+
+	if available.LessThan(params.Quantity) {
+		var productName string
+		err = tx.QueryRow(ctx, `SELECT name FROM "products" WHERE id = $1`, params.ProductID).Scan(&productName)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, constants.NewInsufficientStockError([]*constants.InsufficientStockItem{{
+			ProductID:   uuidText(params.ProductID),
+			ProductName: productName,
+			Requested:   params.Quantity,
+			Available:   available,
+		}})
+	}
+
+	var id pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO "reservations" (
+			product_id,
+			warehouse_id,
+			lot_id,
+			quantity,
+			source_type,
+			reference,
+			expires_at,
+			created_by_user_id
+		) VALUES (
+			$1, $2, $3, $4, 'MANUAL', $5, $6, $7
+		) RETURNING id
+	`,
+		params.ProductID,
+		params.WarehouseID,
+		params.LotID,
+		params.Quantity,
+		params.Reference,
+		params.ExpiresAt,
+		params.CreatedBy,
+	).Scan(
+		&id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetReservationByID(ctx, &id)
+}
+
+type ReleaseReservationParams struct {
+	ID     *pgxuuid.UUID
+	UserID *pgxuuid.UUID
+}
+
+// ReleaseReservation gives the stock of an ACTIVE reservation back
+func (r *PgRepository) ReleaseReservation(ctx context.Context, params *ReleaseReservationParams) (*Reservation, error) {
+	var id pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		UPDATE "reservations" SET
+			status = 'RELEASED',
+			released_by_user_id = $2,
+			released_at = now(),
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'ACTIVE'
+		RETURNING id
+	`, params.ID, params.UserID).Scan(
+		&id,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("reservation is not active")
+		}
+		return nil, err
+	}
+
+	return r.GetReservationByID(ctx, &id)
+}
+
+// ExpireReservations marks the ACTIVE reservations past their expiry as
+// EXPIRED and returns how many there were
+func (r *PgRepository) ExpireReservations(ctx context.Context) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE "reservations" SET
+			status = 'EXPIRED',
+			released_at = expires_at,
+			updated_at = now()
+		WHERE
+			status = 'ACTIVE'
+			AND expires_at <= now()
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// releaseSourceReservations releases every ACTIVE reservation held for the
+// document sourceID
+func releaseSourceReservations(ctx context.Context, tx pgx.Tx, sourceID *pgxuuid.UUID, userID *pgxuuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE "reservations" SET
+			status = 'RELEASED',
+			released_by_user_id = $2,
+			released_at = now(),
+			updated_at = now()
+		WHERE
+			source_id = $1
+			AND status = 'ACTIVE'
+	`, sourceID, userID)
+
+	return err
+}
@@ -0,0 +1,779 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hoffax/prodrest/constants"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+type SalesOrder struct {
+	ID            *pgxuuid.UUID
+	Status        string
+	EntityID      *pgxuuid.UUID
+	EntityName    string
+	WarehouseID   *pgxuuid.UUID
+	WarehouseName string
+	Date          time.Time
+	ValidUntil    *time.Time
+	Currency      string
+	Notes         string
+	ConfirmedAt   *time.Time
+	CancelledAt   *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+
+	CreatedByUserID     *pgxuuid.UUID
+	CreatedByUserName   string
+	CancelledByUserID   *pgxuuid.UUID
+	CancelledByUserName string
+
+	Lines []*SalesOrderLine
+}
+
+// SalesOrderLine holds quantities in the base unit of the product.
+// DeliveredQuantity adds up the active deliveries of the line and
+// ReservedOpenQuantity is the reserved part still to be delivered.
+type SalesOrderLine struct {
+	ID                   *pgxuuid.UUID
+	SalesOrderID         *pgxuuid.UUID
+	ProductID            *pgxuuid.UUID
+	ProductName          string
+	Quantity             decimal.Decimal
+	Price                decimal.Decimal
+	ReservedQuantity     decimal.Decimal
+	DeliveredQuantity    decimal.Decimal
+	ReservedOpenQuantity decimal.Decimal
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// salesOrderQuery selects every column scanned by scanSalesOrder, callers
+// append their own WHERE and ORDER BY
+const salesOrderQuery = `
+	SELECT
+		so.id,
+		so.status,
+		so.entity_id,
+		e.name,
+		so.warehouse_id,
+		w.name,
+		so.date,
+		so.valid_until,
+		so.currency,
+		so.notes,
+		so.confirmed_at,
+		so.cancelled_at,
+		so.created_at,
+		so.updated_at,
+		cu.id,
+		cu.name,
+		cu2.id,
+		coalesce(cu2.name, '')
+	FROM "sales_orders" so
+	JOIN "entities" e ON e.id = so.entity_id
+	JOIN "warehouses" w ON w.id = so.warehouse_id
+	LEFT JOIN "users" cu ON cu.id = so.created_by_user_id
+	LEFT JOIN "users" cu2 ON cu2.id = so.cancelled_by_user_id
+`
+
+func scanSalesOrder(row pgx.Row, extra ...any) (*SalesOrder, error) {
+	so := SalesOrder{}
+	dest := append(extra,
+		&so.ID,
+		&so.Status,
+		&so.EntityID,
+		&so.EntityName,
+		&so.WarehouseID,
+		&so.WarehouseName,
+		&so.Date,
+		&so.ValidUntil,
+		&so.Currency,
+		&so.Notes,
+		&so.ConfirmedAt,
+		&so.CancelledAt,
+		&so.CreatedAt,
+		&so.UpdatedAt,
+		&so.CreatedByUserID,
+		&so.CreatedByUserName,
+		&so.CancelledByUserID,
+		&so.CancelledByUserName,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &so, nil
+}
+
+// salesOrderLineColumns are scanned by scanSalesOrderLine
+const salesOrderLineColumns = `
+	sol.id,
+	sol.sales_order_id,
+	sol.product_id,
+	p.name,
+	sol.quantity,
+	sol.price,
+	sol.reserved_quantity,
+	sold.delivered_quantity,
+	sold.reserved_open_quantity,
+	sol.created_at,
+	sol.updated_at
+`
+
+func scanSalesOrderLine(row pgx.Row, extra ...any) (*SalesOrderLine, error) {
+	line := SalesOrderLine{}
+	dest := append([]any{
+		&line.ID,
+		&line.SalesOrderID,
+		&line.ProductID,
+		&line.ProductName,
+		&line.Quantity,
+		&line.Price,
+		&line.ReservedQuantity,
+		&line.DeliveredQuantity,
+		&line.ReservedOpenQuantity,
+		&line.CreatedAt,
+		&line.UpdatedAt,
+	}, extra...)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &line, nil
+}
+
+// fetchSalesOrderLines loads the lines of the given orders into them
+func (r *PgRepository) fetchSalesOrderLines(ctx context.Context, orders []*SalesOrder) error {
+	orderIDs := make([]pgxuuid.UUID, len(orders))
+	orderMap := make(map[pgxuuid.UUID]*SalesOrder, len(orders))
+	for i, so := range orders {
+		orderIDs[i] = *so.ID
+		orderMap[*so.ID] = so
+		so.Lines = make([]*SalesOrderLine, 0)
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT`+salesOrderLineColumns+`
+		FROM "sales_order_lines" sol
+		JOIN "products" p ON p.id = sol.product_id
+		JOIN "sales_order_line_deliveries" sold ON sold.sales_order_line_id = sol.id
+		WHERE
+			sol.sales_order_id = ANY($1::uuid[])
+		ORDER BY
+			p.name
+	`, orderIDs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		line, err := scanSalesOrderLine(rows)
+		if err != nil {
+			return err
+		}
+		if so, ok := orderMap[*line.SalesOrderID]; ok {
+			so.Lines = append(so.Lines, line)
+		}
+	}
+
+	return rows.Err()
+}
+
+type FetchSalesOrdersParams struct {
+	StatusOptions []string
+	EntityID      *pgxuuid.UUID
+	ProductID     *pgxuuid.UUID
+	Limit         int
+	Offset        int
+}
+
+type FetchSalesOrdersResult struct {
+	TotalCount int
+	Items      []*SalesOrder
+}
+
+func (r *PgRepository) FetchSalesOrders(ctx context.Context, params *FetchSalesOrdersParams) (*FetchSalesOrdersResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			COUNT(*) OVER() AS full_count,
+			q.*
+		FROM (`+salesOrderQuery+`
+			WHERE
+				(cardinality($1::sales_order_status[]) = 0 OR so.status = ANY($1::sales_order_status[]))
+				AND ($2::UUID IS NULL OR so.entity_id = $2)
+				AND ($3::UUID IS NULL OR EXISTS (
+					SELECT 1 FROM "sales_order_lines" sol WHERE sol.sales_order_id = so.id AND sol.product_id = $3
+				))
+		) q
+		ORDER BY
+			q.date DESC,
+			q.created_at DESC
+		LIMIT $4
+		OFFSET $5
+	`, params.StatusOptions, params.EntityID, params.ProductID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchSalesOrdersResult{
+		Items: make([]*SalesOrder, 0),
+	}
+	for rows.Next() {
+		so, err := scanSalesOrder(rows, &result.TotalCount)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, so)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = r.fetchSalesOrderLines(ctx, result.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *PgRepository) GetSalesOrderByID(ctx context.Context, id *pgxuuid.UUID) (*SalesOrder, error) {
+	so, err := scanSalesOrder(r.db.QueryRow(ctx, salesOrderQuery+`
+		WHERE
+			so.id = $1
+	`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.fetchSalesOrderLines(ctx, []*SalesOrder{so})
+	if err != nil {
+		return nil, err
+	}
+
+	return so, nil
+}
+
+// BackorderLine is a line of a CONFIRMED or PARTIALLY_DELIVERED order whose
+// outstanding quantity was not covered by the reservation made on confirm
+type BackorderLine struct {
+	SalesOrderLine
+	EntityID      *pgxuuid.UUID
+	EntityName    string
+	WarehouseID   *pgxuuid.UUID
+	WarehouseName string
+	Date          time.Time
+}
+
+type FetchBackordersParams struct {
+	EntityID  *pgxuuid.UUID
+	ProductID *pgxuuid.UUID
+}
+
+// FetchBackorders returns the backordered lines grouped by customer, the
+// oldest orders of each customer first
+func (r *PgRepository) FetchBackorders(ctx context.Context, params *FetchBackordersParams) ([]*BackorderLine, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT`+salesOrderLineColumns+`,
+			so.entity_id,
+			e.name,
+			so.warehouse_id,
+			w.name,
+			so.date
+		FROM "sales_order_lines" sol
+		JOIN "sales_orders" so ON so.id = sol.sales_order_id
+		JOIN "sales_order_line_deliveries" sold ON sold.sales_order_line_id = sol.id
+		JOIN "products" p ON p.id = sol.product_id
+		JOIN "entities" e ON e.id = so.entity_id
+		JOIN "warehouses" w ON w.id = so.warehouse_id
+		WHERE
+			so.status IN ('CONFIRMED', 'PARTIALLY_DELIVERED')
+			AND sol.quantity - sold.delivered_quantity - sold.reserved_open_quantity > 0
+			AND ($1::UUID IS NULL OR so.entity_id = $1)
+			AND ($2::UUID IS NULL OR sol.product_id = $2)
+		ORDER BY
+			e.name,
+			so.date,
+			p.name
+	`, params.EntityID, params.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make([]*BackorderLine, 0)
+	for rows.Next() {
+		line := BackorderLine{}
+		salesOrderLine, err := scanSalesOrderLine(rows,
+			&line.EntityID,
+			&line.EntityName,
+			&line.WarehouseID,
+			&line.WarehouseName,
+			&line.Date,
+		)
+		if err != nil {
+			return nil, err
+		}
+		line.SalesOrderLine = *salesOrderLine
+		lines = append(lines, &line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+type CreateSalesOrderLine struct {
+	ProductID *pgxuuid.UUID
+	Quantity  decimal.Decimal
+	Price     decimal.Decimal
+}
+
+type CreateSalesOrderParams struct {
+	EntityID    *pgxuuid.UUID
+	WarehouseID *pgxuuid.UUID
+	Date        time.Time
+	ValidUntil  *time.Time
+	Currency    string
+	Notes       string
+	CreatedBy   *pgxuuid.UUID
+	Lines       []*CreateSalesOrderLine
+}
+
+func insertSalesOrderLines(ctx context.Context, tx pgx.Tx, soID *pgxuuid.UUID, lines []*CreateSalesOrderLine) error {
+	for _, line := range lines {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO "sales_order_lines" (
+				sales_order_id,
+				product_id,
+				quantity,
+				price
+			) VALUES (
+				$1, $2, $3, $4
+			)
+		`, soID, line.ProductID, line.Quantity, line.Price)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *PgRepository) CreateSalesOrder(ctx context.Context, params *CreateSalesOrderParams) (*SalesOrder, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var soID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO "sales_orders" (
+			entity_id,
+			warehouse_id,
+			date,
+			valid_until,
+			currency,
+			notes,
+			created_by_user_id
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		) RETURNING id
+	`,
+		params.EntityID,
+		params.WarehouseID,
+		params.Date,
+		params.ValidUntil,
+		params.Currency,
+		params.Notes,
+		params.CreatedBy,
+	).Scan(
+		&soID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertSalesOrderLines(ctx, tx, &soID, params.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetSalesOrderByID(ctx, &soID)
+}
+
+type UpdateSalesOrderParams struct {
+	ID          *pgxuuid.UUID
+	EntityID    *pgxuuid.UUID
+	WarehouseID *pgxuuid.UUID
+	Date        time.Time
+	ValidUntil  *time.Time
+	Currency    string
+	Notes       string
+	Lines       []*CreateSalesOrderLine
+}
+
+// UpdateSalesOrder changes a QUOTE and replaces its lines
+func (r *PgRepository) UpdateSalesOrder(ctx context.Context, params *UpdateSalesOrderParams) (*SalesOrder, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var soID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		UPDATE "sales_orders" SET
+			entity_id = $2,
+			warehouse_id = $3,
+			date = $4,
+			valid_until = $5,
+			currency = $6,
+			notes = $7,
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status = 'QUOTE'
+		RETURNING id
+	`,
+		params.ID,
+		params.EntityID,
+		params.WarehouseID,
+		params.Date,
+		params.ValidUntil,
+		params.Currency,
+		params.Notes,
+	).Scan(
+		&soID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("sales order is not a quote")
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM "sales_order_lines" WHERE sales_order_id = $1`, &soID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertSalesOrderLines(ctx, tx, &soID, params.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetSalesOrderByID(ctx, &soID)
+}
+
+type ConfirmSalesOrderParams struct {
+	ID     *pgxuuid.UUID
+	UserID *pgxuuid.UUID
+}
+
+// ConfirmSalesOrder confirms a QUOTE and reserves each line on the warehouse
+// of the order, up to the stock on hand not held by other documents. What
+// cannot be reserved is left as a backorder.
+func (r *PgRepository) ConfirmSalesOrder(ctx context.Context, params *ConfirmSalesOrderParams) (*SalesOrder, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	var warehouseID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		SELECT
+			status,
+			warehouse_id
+		FROM "sales_orders"
+		WHERE id = $1
+		FOR UPDATE
+	`, params.ID).Scan(
+		&status,
+		&warehouseID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != "QUOTE" {
+		return nil, constants.NewInvalidOperationError("sales order is not a quote")
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT
+			id,
+			product_id,
+			quantity
+		FROM "sales_order_lines"
+		WHERE
+			sales_order_id = $1
+	`, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	lineIDs := make([]pgxuuid.UUID, 0)
+	productIDs := make([]pgxuuid.UUID, 0)
+	lines := make([]*CreateSalesOrderLine, 0)
+	for rows.Next() {
+		var lineID pgxuuid.UUID
+		line := CreateSalesOrderLine{}
+		err := rows.Scan(&lineID, &line.ProductID, &line.Quantity)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		lineIDs = append(lineIDs, lineID)
+		productIDs = append(productIDs, *line.ProductID)
+		lines = append(lines, &line)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// movements and other reservations of the products wait until these
+	// are made
+	err = r.lockProducts(ctx, tx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range lines {
+		// the stock may be free on the warehouse but held on the product
+		// by a reservation without warehouse
+		available, err := availableStock(ctx, tx, line.ProductID, &warehouseID, nil)
+		if err != nil {
+			return nil, err
+		}
+		productAvailable, err := availableStock(ctx, tx, line.ProductID, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		reserved := decimal.Max(decimal.Min(line.Quantity, decimal.Min(available, productAvailable)), decimal.Zero)
+		if reserved.IsZero() {
+			continue
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE "sales_order_lines" SET
+				reserved_quantity = $2,
+				updated_at = now()
+			WHERE
+				id = $1
+		`, &lineIDs[i], reserved)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO "reservations" (
+				product_id,
+				warehouse_id,
+				quantity,
+				source_type,
+				source_id,
+				sales_order_line_id,
+				created_by_user_id
+			) VALUES (
+				$1, $2, $3, 'SALES_ORDER', $4, $5, $6
+			)
+		`, line.ProductID, &warehouseID, reserved, params.ID, &lineIDs[i], params.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE "sales_orders" SET
+			status = 'CONFIRMED',
+			confirmed_at = now(),
+			updated_at = now()
+		WHERE
+			id = $1
+	`, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetSalesOrderByID(ctx, params.ID)
+}
+
+type CancelSalesOrderParams struct {
+	ID     *pgxuuid.UUID
+	UserID *pgxuuid.UUID
+}
+
+// CancelSalesOrder cancels an order that is not fully delivered, its
+// reservations are released and its deliveries stay as they are
+func (r *PgRepository) CancelSalesOrder(ctx context.Context, params *CancelSalesOrderParams) (*SalesOrder, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var soID pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
+		UPDATE "sales_orders" SET
+			status = 'CANCELLED',
+			cancelled_by_user_id = $2,
+			cancelled_at = now(),
+			updated_at = now()
+		WHERE
+			id = $1
+			AND status IN ('QUOTE', 'CONFIRMED', 'PARTIALLY_DELIVERED')
+		RETURNING id
+	`, params.ID, params.UserID).Scan(
+		&soID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("sales order is already delivered or cancelled")
+		}
+		return nil, err
+	}
+
+	err = releaseSourceReservations(ctx, tx, &soID, params.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetSalesOrderByID(ctx, &soID)
+}
+
+// lockSalesOrderForDelivery locks an order until tx ends so concurrent
+// deliveries cannot both pass the over-delivery check
+func lockSalesOrderForDelivery(ctx context.Context, tx pgx.Tx, soID *pgxuuid.UUID) error {
+	var status string
+	err := tx.QueryRow(ctx, `
+		SELECT status
+		FROM "sales_orders"
+		WHERE id = $1
+		FOR UPDATE
+	`, soID).Scan(&status)
+	if err != nil {
+		return err
+	}
+
+	if status != "CONFIRMED" && status != "PARTIALLY_DELIVERED" {
+		return constants.NewInvalidOperationError("sales order is not open for delivery")
+	}
+
+	return nil
+}
+
+// checkSalesOrderDelivery fails when any line of the order was delivered
+// over its ordered quantity
+func checkSalesOrderDelivery(ctx context.Context, tx pgx.Tx, soID *pgxuuid.UUID) error {
+	rows, err := tx.Query(ctx, `
+		SELECT
+			p.name,
+			sol.quantity,
+			sold.delivered_quantity
+		FROM "sales_order_lines" sol
+		JOIN "sales_order_line_deliveries" sold ON sold.sales_order_line_id = sol.id
+		JOIN "products" p ON p.id = sol.product_id
+		WHERE
+			sol.sales_order_id = $1
+			AND sold.delivered_quantity > sol.quantity
+		ORDER BY
+			p.name
+	`, soID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	overDelivered := make([]string, 0)
+	for rows.Next() {
+		var name string
+		var ordered, delivered decimal.Decimal
+		err := rows.Scan(&name, &ordered, &delivered)
+		if err != nil {
+			return err
+		}
+		overDelivered = append(overDelivered, fmt.Sprintf("%v ordered %v delivered %v", name, ordered, delivered))
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(overDelivered) > 0 {
+		return constants.NewInvalidOperationError("over-delivery: " + strings.Join(overDelivered, ", "))
+	}
+
+	return nil
+}
+
+// refreshSalesOrderStatus moves an open order between CONFIRMED,
+// PARTIALLY_DELIVERED and DELIVERED after its deliveries changed, quotes and
+// cancelled orders are left alone
+func refreshSalesOrderStatus(ctx context.Context, tx pgx.Tx, soID *pgxuuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE "sales_orders" so SET
+			status = CASE
+				WHEN NOT EXISTS (
+					SELECT 1
+					FROM "sales_order_lines" sol
+					JOIN "sales_order_line_deliveries" sold ON sold.sales_order_line_id = sol.id
+					WHERE sol.sales_order_id = so.id AND sold.delivered_quantity < sol.quantity
+				) THEN 'DELIVERED'::SALES_ORDER_STATUS
+				WHEN EXISTS (
+					SELECT 1
+					FROM "sales_order_lines" sol
+					JOIN "sales_order_line_deliveries" sold ON sold.sales_order_line_id = sol.id
+					WHERE sol.sales_order_id = so.id AND sold.delivered_quantity > 0
+				) THEN 'PARTIALLY_DELIVERED'::SALES_ORDER_STATUS
+				ELSE 'CONFIRMED'::SALES_ORDER_STATUS
+			END,
+			updated_at = now()
+		WHERE
+			so.id = $1
+			AND so.status IN ('CONFIRMED', 'PARTIALLY_DELIVERED', 'DELIVERED')
+	`, soID)
+
+	return err
+}
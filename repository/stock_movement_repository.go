@@ -2,9 +2,13 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/hoffax/prodrest/constants"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
-	"math"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"strings"
 	"time"
 )
 
@@ -16,7 +20,17 @@ type StockMovement struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 
-	Total int
+	// DocumentNumber is numbered per type and year, InvoiceNumber is the
+	// supplier invoice of a purchase
+	DocumentNumber string
+	InvoiceNumber  string
+
+	// prices are in Currency, DocumentTotal adds them up and Total converts
+	// it to the base currency with ExchangeRate
+	Currency      string
+	ExchangeRate  decimal.Decimal
+	DocumentTotal decimal.Decimal
+	Total         decimal.Decimal
 
 	EntityID       *pgxuuid.UUID
 	EntityName     string
@@ -26,6 +40,21 @@ type StockMovement struct {
 	CreatedByUserName   string
 	CancelledByUserID   *pgxuuid.UUID
 	CancelledByUserName string
+	CancelReason        string
+	CancelledAt         *time.Time
+	// ReversalOfID is set on a reversal to the movement it undoes, and
+	// ReversedByID on that movement to its reversal
+	ReversalOfID *pgxuuid.UUID
+	ReversedByID *pgxuuid.UUID
+
+	ProductionOrderID *pgxuuid.UUID
+	PurchaseOrderID   *pgxuuid.UUID
+	SalesOrderID      *pgxuuid.UUID
+	// ReturnOfID is set on a SALE_RETURN or PURCHASE_RETURN to the movement
+	// it gives back
+	ReturnOfID *pgxuuid.UUID
+	// InventoryCountID is set on the ADJUST posted by closing the count
+	InventoryCountID *pgxuuid.UUID
 
 	Items []*StockMovementItem
 }
@@ -35,20 +64,35 @@ type StockMovementItem struct {
 	StockMovementID *pgxuuid.UUID
 	ProductID       *pgxuuid.UUID
 	ProductName     string
-	Quantity        int
-	Price           int
-	Total           int
+	Quantity        decimal.Decimal
+	Price           decimal.Decimal
+	Total           decimal.Decimal
+	Unit            string
+	UnitFactor      decimal.Decimal
+	TaxRate         string
 	Batch           string
+	LotID           *pgxuuid.UUID
+	WarehouseID     *pgxuuid.UUID
+	WarehouseName   string
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
+
+	PurchaseOrderLineID *pgxuuid.UUID
+	SalesOrderLineID    *pgxuuid.UUID
+	// ReturnOfItemID is the item a return item gives back, ReturnedQuantity
+	// what the returns of the movement gave back of this item so far
+	ReturnOfItemID   *pgxuuid.UUID
+	ReturnedQuantity decimal.Decimal
 }
 
 type FetchStockMovementsParams struct {
 	StatusOptions []string
 	TypeOptions   []string
 	StartDate     time.Time
-	Limit         int
-	Offset        int
+	// Search matches the document or invoice number
+	Search string
+	Limit  int
+	Offset int
 }
 
 type FetchStockMovementsResult struct {
@@ -72,7 +116,20 @@ func (r *PgRepository) FetchStockMovements(ctx context.Context, param *FetchStoc
 			cu.id,
 			cu.name,
 			cu2.id,
-			coalesce(cu2.name, '')
+			coalesce(cu2.name, ''),
+			sm.production_order_id,
+			sm.currency,
+			sm.exchange_rate,
+			coalesce(sm.cancel_reason, ''),
+			sm.cancelled_at,
+			sm.reversal_of_id,
+			(SELECT r.id FROM "stock_movements" r WHERE r.reversal_of_id = sm.id),
+			sm.document_number,
+			coalesce(sm.invoice_number, ''),
+			sm.purchase_order_id,
+			sm.sales_order_id,
+			sm.return_of_id,
+			sm.inventory_count_id
 		FROM "stock_movements" sm
 		LEFT JOIN "entities" e ON e.id = sm.entity_id
 		LEFT JOIN "users" cu ON cu.id = sm.created_by_user_id
@@ -81,11 +138,16 @@ func (r *PgRepository) FetchStockMovements(ctx context.Context, param *FetchStoc
 		    sm.status = ANY($1::status[])
 			AND sm.type = ANY($2::movement_type[])
 			AND sm.date >= $3
+			AND (
+			    $6 = ''
+			    OR sm.document_number ILIKE '%' || $6 || '%'
+			    OR sm.invoice_number ILIKE '%' || $6 || '%'
+			)
 		ORDER BY
 		    sm.created_at DESC
 		LIMIT $4
 		OFFSET $5
-	`, param.StatusOptions, param.TypeOptions, param.StartDate, param.Limit, param.Offset)
+	`, param.StatusOptions, param.TypeOptions, param.StartDate, param.Limit, param.Offset, param.Search)
 	if err != nil {
 		return nil, err
 	}
@@ -109,6 +171,19 @@ func (r *PgRepository) FetchStockMovements(ctx context.Context, param *FetchStoc
 			&sm.CreatedByUserName,
 			&sm.CancelledByUserID,
 			&sm.CancelledByUserName,
+			&sm.ProductionOrderID,
+			&sm.Currency,
+			&sm.ExchangeRate,
+			&sm.CancelReason,
+			&sm.CancelledAt,
+			&sm.ReversalOfID,
+			&sm.ReversedByID,
+			&sm.DocumentNumber,
+			&sm.InvoiceNumber,
+			&sm.PurchaseOrderID,
+			&sm.SalesOrderID,
+			&sm.ReturnOfID,
+			&sm.InventoryCountID,
 		)
 		if err != nil {
 			return nil, err
@@ -129,11 +204,23 @@ func (r *PgRepository) FetchStockMovements(ctx context.Context, param *FetchStoc
 			p.name,
 			smi.quantity,
 			smi.price,
-			smi.batch,
+			smi.unit,
+			smi.unit_factor,
+			smi.tax_rate,
+			coalesce(smi.batch, ''),
+			smi.lot_id,
+			smi.warehouse_id,
+			w.name,
+			smi.created_at,
 			smi.updated_at,
-			smi.updated_at
+			smi.purchase_order_line_id,
+			smi.sales_order_line_id,
+			smi.return_of_item_id,
+			coalesce(ret.returned_quantity, 0)
 		FROM "stock_movement_items" smi
 		LEFT JOIN products p on p.id = smi.product_id
+		LEFT JOIN warehouses w on w.id = smi.warehouse_id
+		LEFT JOIN "stock_movement_item_returns" ret on ret.stock_movement_item_id = smi.id
 		WHERE
 			smi.stock_movement_id = ANY($1::uuid[])`, smIDs)
 	if err != nil {
@@ -155,35 +242,77 @@ func (r *PgRepository) FetchStockMovements(ctx context.Context, param *FetchStoc
 			&smi.ProductName,
 			&smi.Quantity,
 			&smi.Price,
+			&smi.Unit,
+			&smi.UnitFactor,
+			&smi.TaxRate,
 			&smi.Batch,
+			&smi.LotID,
+			&smi.WarehouseID,
+			&smi.WarehouseName,
 			&smi.CreatedAt,
 			&smi.UpdatedAt,
+			&smi.PurchaseOrderLineID,
+			&smi.SalesOrderLineID,
+			&smi.ReturnOfItemID,
+			&smi.ReturnedQuantity,
 		)
 		if err != nil {
 			return nil, err
 		}
 		if sm, ok := smMap[*smi.StockMovementID]; ok {
 			sm.Items = append(sm.Items, &smi)
-			sm.Total += int(math.Round(float64(smi.Quantity*smi.Price) / 1000))
+			smi.Total = constants.LineTotal(smi.Quantity, smi.Price)
+			sm.DocumentTotal = sm.DocumentTotal.Add(smi.Total)
 		}
 	}
 
+	for _, sm := range result.Items {
+		sm.Total = constants.RoundAmount(sm.DocumentTotal.Mul(sm.ExchangeRate))
+	}
+
 	return &result, nil
 }
 
 type CreateStockMovementParams struct {
-	Type      string
-	Date      time.Time
-	EntityID  *pgxuuid.UUID
-	CreatedBy *pgxuuid.UUID
-	Items     []*CreateStockItem
+	Type              string
+	Date              time.Time
+	EntityID          *pgxuuid.UUID
+	CreatedBy         *pgxuuid.UUID
+	ProductionOrderID *pgxuuid.UUID
+	ReversalOfID      *pgxuuid.UUID
+	// PurchaseOrderID makes the movement a receipt of the order, each item
+	// must then be on one of its lines
+	PurchaseOrderID *pgxuuid.UUID
+	// SalesOrderID makes the movement a delivery of the order, each item
+	// must then be on one of its lines
+	SalesOrderID *pgxuuid.UUID
+	// ReturnOfID makes the movement a return of that SALE or PURCHASE, each
+	// item then points to the item it gives back
+	ReturnOfID *pgxuuid.UUID
+	// InventoryCountID makes the movement the ADJUST of a closed count
+	InventoryCountID *pgxuuid.UUID
+	InvoiceNumber    string
+	Currency         string
+	ExchangeRate     decimal.Decimal
+	Items            []*CreateStockItem
 }
 
+// CreateStockItem holds quantity and price in the base unit of the product,
+// Unit and UnitFactor only record the unit the item was entered in
 type CreateStockItem struct {
-	ProductID *pgxuuid.UUID
-	Quantity  int
-	Price     int
-	Batch     string
+	ProductID   *pgxuuid.UUID
+	Quantity    decimal.Decimal
+	Price       decimal.Decimal
+	Unit        string
+	UnitFactor  decimal.Decimal
+	TaxRate     string
+	Batch       string
+	LotID       *pgxuuid.UUID
+	WarehouseID *pgxuuid.UUID
+
+	PurchaseOrderLineID *pgxuuid.UUID
+	SalesOrderLineID    *pgxuuid.UUID
+	ReturnOfItemID      *pgxuuid.UUID
 }
 
 func (r *PgRepository) CreateStockMovement(ctx context.Context, params *CreateStockMovementParams) (*StockMovement, error) {
@@ -191,6 +320,85 @@ func (r *PgRepository) CreateStockMovement(ctx context.Context, params *CreateSt
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback(ctx)
+
+	smID, err := r.createStockMovement(ctx, tx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stockMovement, err := r.FetchStockMovementByID(ctx, smID)
+	if err != nil {
+		return nil, err
+	}
+
+	return stockMovement, nil
+}
+
+// createStockMovement inserts the movement and its items inside tx, the
+// products of the items stay locked until tx ends so concurrent movements
+// cannot both pass the stock availability check
+func (r *PgRepository) createStockMovement(ctx context.Context, tx pgx.Tx, params *CreateStockMovementParams) (*pgxuuid.UUID, error) {
+	err := checkOpenPeriods(ctx, tx, params.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.PurchaseOrderID != nil {
+		err := lockPurchaseOrderForReceipt(ctx, tx, params.PurchaseOrderID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if params.SalesOrderID != nil {
+		err := lockSalesOrderForDelivery(ctx, tx, params.SalesOrderID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if params.ReturnOfID != nil {
+		err := lockStockMovementForReturn(ctx, tx, params.ReturnOfID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	productIDs := make([]pgxuuid.UUID, len(params.Items))
+	for i, item := range params.Items {
+		productIDs[i] = *item.ProductID
+	}
+
+	err = r.lockProducts(ctx, tx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sequence row stays locked until tx ends, a rolled back movement
+	// gives its number back
+	var documentNumber string
+	err = tx.QueryRow(ctx, `
+		INSERT INTO "document_sequences" (
+			type,
+			year,
+			last_number
+		) VALUES (
+			$1, EXTRACT(YEAR FROM $2::DATE)::INT, 1
+		)
+		ON CONFLICT (type, year) DO UPDATE SET
+			last_number = "document_sequences".last_number + 1
+		RETURNING document_prefix(type) || '-' || year || '-' || lpad(last_number::TEXT, 6, '0')
+	`, params.Type, params.Date).Scan(
+		&documentNumber,
+	)
+	if err != nil {
+		return nil, err
+	}
 
 	var smID pgxuuid.UUID
 	err = tx.QueryRow(ctx, `
@@ -199,119 +407,727 @@ func (r *PgRepository) CreateStockMovement(ctx context.Context, params *CreateSt
 			type,
 			date,
 			entity_id,
-			created_by_user_id
+			created_by_user_id,
+			production_order_id,
+			currency,
+			exchange_rate,
+			reversal_of_id,
+			document_number,
+			invoice_number,
+			purchase_order_id,
+			sales_order_id,
+			return_of_id,
+			inventory_count_id
 		) VALUES (
 			$1,
 			$2,
 			$3,
 			$4,
-			$5
+			$5,
+			$6,
+			coalesce(nullif($7, ''), 'PYG'),
+			CASE WHEN $8::NUMERIC > 0 THEN $8::NUMERIC ELSE 1 END,
+			$9,
+			$10,
+			nullif($11, ''),
+			$12,
+			$13,
+			$14,
+			$15
 		) RETURNING id
-	`, "ACTIVE", params.Type, params.Date, params.EntityID, params.CreatedBy).Scan(
+	`, "ACTIVE", params.Type, params.Date, params.EntityID, params.CreatedBy, params.ProductionOrderID, params.Currency, params.ExchangeRate, params.ReversalOfID, documentNumber, params.InvoiceNumber, params.PurchaseOrderID, params.SalesOrderID, params.ReturnOfID, params.InventoryCountID).Scan(
 		&smID,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("smID: %v\n", smID)
+	err = insertStockMovementItems(ctx, tx, &smID, params.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.checkStockAvailability(ctx, tx, &smID)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.PurchaseOrderID != nil {
+		err = checkPurchaseOrderReceipt(ctx, tx, params.PurchaseOrderID)
+		if err != nil {
+			return nil, err
+		}
 
-	for _, item := range params.Items {
-		_, err = tx.Exec(ctx, `
+		err = refreshPurchaseOrderStatus(ctx, tx, params.PurchaseOrderID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if params.SalesOrderID != nil {
+		err = checkSalesOrderDelivery(ctx, tx, params.SalesOrderID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = refreshSalesOrderStatus(ctx, tx, params.SalesOrderID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if params.ReturnOfID != nil {
+		err = checkStockMovementReturns(ctx, tx, params.ReturnOfID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &smID, nil
+}
+
+func insertStockMovementItems(ctx context.Context, tx pgx.Tx, smID *pgxuuid.UUID, items []*CreateStockItem) error {
+	for _, item := range items {
+		_, err := tx.Exec(ctx, `
 			INSERT INTO "stock_movement_items" (
 				stock_movement_id,
 				product_id,
 				quantity,
 				price,
-				batch
+				batch,
+				lot_id,
+				warehouse_id,
+				unit,
+				unit_factor,
+				tax_rate,
+				purchase_order_line_id,
+				sales_order_line_id,
+				return_of_item_id
 			) VALUES (
 				$1,
 				$2,
 				$3,
 				$4,
-				$5
+				$5,
+				$6,
+				$7,
+				coalesce(nullif($8, ''), (SELECT unit FROM "products" WHERE id = $2)),
+				CASE WHEN $9::NUMERIC > 0 THEN $9::NUMERIC ELSE 1 END,
+				coalesce(nullif($10, '')::TAX_RATE, (SELECT tax_rate FROM "products" WHERE id = $2)),
+				$11,
+				$12,
+				$13
 			) RETURNING id
-		`, smID, item.ProductID, item.Quantity, item.Price, item.Batch)
+		`, smID, item.ProductID, item.Quantity, item.Price, item.Batch, item.LotID, item.WarehouseID, item.Unit, item.UnitFactor, item.TaxRate, item.PurchaseOrderLineID, item.SalesOrderLineID, item.ReturnOfItemID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateStockMovementParams changes the header of a movement and, when
+// Items is not nil, replaces its items. UpdatedAt must match the stored
+// value so an edit based on an outdated read is rejected.
+type UpdateStockMovementParams struct {
+	ID        *pgxuuid.UUID
+	Date      time.Time
+	EntityID  *pgxuuid.UUID
+	UserID    *pgxuuid.UUID
+	UpdatedAt time.Time
+	Items     []*CreateStockItem
+	Changes   []byte
+}
+
+// UpdateStockMovement edits an ACTIVE movement and stores the revision in a
+// single transaction, replaced items are checked against the stock like a
+// new movement would be
+func (r *PgRepository) UpdateStockMovement(ctx context.Context, params *UpdateStockMovementParams) (*StockMovement, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var movementType string
+	var date time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT type, date
+		FROM "stock_movements"
+		WHERE
+			id = $1
+			AND status = 'ACTIVE'
+			AND cancelled_at IS NULL
+			AND updated_at = $2
+		FOR UPDATE
+	`, params.ID, params.UpdatedAt).Scan(
+		&movementType,
+		&date,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("stock movement is inactive or was changed by someone else")
+		}
+		return nil, err
+	}
+
+	err = checkOpenPeriods(ctx, tx, date, params.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	returned, err := hasReturns(ctx, tx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+	if returned {
+		return nil, constants.NewInvalidOperationError("stock movement has returns and cannot be edited")
+	}
+
+	if params.Items != nil {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				product_id,
+				warehouse_id,
+				lot_id,
+				quantity
+			FROM "stock_movement_items"
+			WHERE
+				stock_movement_id = $1
+		`, params.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		previous := make([]*StockMovementItem, 0)
+		for rows.Next() {
+			item := StockMovementItem{}
+			err := rows.Scan(
+				&item.ProductID,
+				&item.WarehouseID,
+				&item.LotID,
+				&item.Quantity,
+			)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			previous = append(previous, &item)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+
+		productIDs := make([]pgxuuid.UUID, 0, len(previous)+len(params.Items))
+		for _, item := range previous {
+			productIDs = append(productIDs, *item.ProductID)
+		}
+		for _, item := range params.Items {
+			productIDs = append(productIDs, *item.ProductID)
+		}
+
+		err = r.lockProducts(ctx, tx, productIDs)
 		if err != nil {
 			return nil, err
 		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM "stock_movement_items" WHERE stock_movement_id = $1`, params.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = insertStockMovementItems(ctx, tx, params.ID, params.Items)
+		if err != nil {
+			return nil, err
+		}
+
+		err = r.checkStockChange(ctx, tx, params.ID, movementType, previous)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE "stock_movements" SET
+			date = $2,
+			entity_id = $3,
+			updated_at = now()
+		WHERE
+			id = $1
+	`, params.ID, params.Date, params.EntityID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO "stock_movement_revisions" (
+			stock_movement_id,
+			revision,
+			user_id,
+			changes
+		) VALUES (
+			$1,
+			(SELECT coalesce(max(revision), 0) + 1 FROM "stock_movement_revisions" WHERE stock_movement_id = $1),
+			$2,
+			$3
+		)
+	`, params.ID, params.UserID, params.Changes)
+	if err != nil {
+		return nil, err
 	}
 
 	err = tx.Commit(ctx)
 	if err != nil {
 		return nil, err
 	}
-	stockMovement, err := r.FetchStockMovementByID(ctx, &smID)
+
+	return r.FetchStockMovementByID(ctx, params.ID)
+}
+
+type StockMovementRevision struct {
+	ID              *pgxuuid.UUID
+	StockMovementID *pgxuuid.UUID
+	Revision        int
+	UserID          *pgxuuid.UUID
+	UserName        string
+	Changes         []byte
+	CreatedAt       time.Time
+}
+
+// FetchStockMovementRevisions returns the edits of a movement, latest first
+func (r *PgRepository) FetchStockMovementRevisions(ctx context.Context, stockMovementID *pgxuuid.UUID) ([]*StockMovementRevision, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			smr.id,
+			smr.stock_movement_id,
+			smr.revision,
+			smr.user_id,
+			u.name,
+			smr.changes,
+			smr.created_at
+		FROM "stock_movement_revisions" smr
+		JOIN "users" u ON u.id = smr.user_id
+		WHERE
+			smr.stock_movement_id = $1
+		ORDER BY
+			smr.revision DESC
+	`, stockMovementID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return stockMovement, nil
+	revisions := make([]*StockMovementRevision, 0)
+	for rows.Next() {
+		revision := StockMovementRevision{}
+		err := rows.Scan(
+			&revision.ID,
+			&revision.StockMovementID,
+			&revision.Revision,
+			&revision.UserID,
+			&revision.UserName,
+			&revision.Changes,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
 }
 
-type UpdateStockMovementParams struct {
-	ID       *pgxuuid.UUID
-	Date     time.Time
-	EntityID *pgxuuid.UUID
+// lockStockMovementForReturn locks the returned movement until tx ends so
+// concurrent returns cannot both pass the over-return check, nor the
+// movement be cancelled while it is given back
+func lockStockMovementForReturn(ctx context.Context, tx pgx.Tx, smID *pgxuuid.UUID) error {
+	var status string
+	var cancelledAt *time.Time
+	err := tx.QueryRow(ctx, `
+		SELECT status, cancelled_at
+		FROM "stock_movements"
+		WHERE id = $1
+		FOR UPDATE
+	`, smID).Scan(&status, &cancelledAt)
+	if err != nil {
+		return err
+	}
+
+	if status != "ACTIVE" || cancelledAt != nil {
+		return constants.NewInvalidOperationError("returnOfId: stock movement is cancelled")
+	}
+
+	return nil
+}
+
+// checkStockMovementReturns fails when any item of the movement was given
+// back over its quantity
+func checkStockMovementReturns(ctx context.Context, tx pgx.Tx, smID *pgxuuid.UUID) error {
+	rows, err := tx.Query(ctx, `
+		SELECT
+			p.name,
+			smi.quantity,
+			ret.returned_quantity
+		FROM "stock_movement_items" smi
+		JOIN "stock_movement_item_returns" ret ON ret.stock_movement_item_id = smi.id
+		JOIN "products" p ON p.id = smi.product_id
+		WHERE
+			smi.stock_movement_id = $1
+			AND ret.returned_quantity > smi.quantity
+		ORDER BY
+			p.name
+	`, smID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	overReturned := make([]string, 0)
+	for rows.Next() {
+		var name string
+		var quantity, returned decimal.Decimal
+		err := rows.Scan(&name, &quantity, &returned)
+		if err != nil {
+			return err
+		}
+		overReturned = append(overReturned, fmt.Sprintf("%v moved %v returned %v", name, quantity, returned))
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(overReturned) > 0 {
+		return constants.NewInvalidOperationError("over-return: " + strings.Join(overReturned, ", "))
+	}
+
+	return nil
+}
+
+// hasReturns reports whether any item of the movement was given back by a
+// return that is still active, the movement has to be locked in tx
+func hasReturns(ctx context.Context, tx pgx.Tx, smID *pgxuuid.UUID) (bool, error) {
+	var returned bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM "stock_movement_items" smi
+			JOIN "stock_movement_item_returns" ret ON ret.stock_movement_item_id = smi.id
+			WHERE
+				smi.stock_movement_id = $1
+		)
+	`, smID).Scan(&returned)
+	if err != nil {
+		return false, err
+	}
+
+	return returned, nil
 }
 
-func (r *PgRepository) UpdateStockMovement(ctx context.Context, param *UpdateStockMovementParams) (*StockMovement, error) {
+// checkStockRemoval checks taking the items of the given movements, already
+// INACTIVE in tx, out of the stock like checkStockChange does for an edit.
+// Cancelling a receipt whose goods were used since fails with an
+// InsufficientStockError.
+func (r *PgRepository) checkStockRemoval(ctx context.Context, tx pgx.Tx, stockMovementIDs []pgxuuid.UUID) error {
+	rows, err := tx.Query(ctx, `
+		SELECT
+			smi.product_id,
+			smi.warehouse_id,
+			smi.lot_id,
+			smi.quantity * movement_sign(sm.type)
+		FROM "stock_movement_items" smi
+		JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+		WHERE
+			sm.id = ANY($1::uuid[])
+	`, stockMovementIDs)
+	if err != nil {
+		return err
+	}
+
+	removed := make([]*StockMovementItem, 0)
+	productIDs := make([]pgxuuid.UUID, 0)
+	for rows.Next() {
+		item := StockMovementItem{}
+		err := rows.Scan(
+			&item.ProductID,
+			&item.WarehouseID,
+			&item.LotID,
+			&item.Quantity,
+		)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		removed = append(removed, &item)
+		productIDs = append(productIDs, *item.ProductID)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	err = r.lockProducts(ctx, tx, productIDs)
+	if err != nil {
+		return err
+	}
+
+	// quantities are already signed, without a movement type they are taken
+	// out as they are
+	return r.checkStockChange(ctx, tx, nil, "", removed)
+}
+
+type DeleteStockMovementParams struct {
+	ID     *pgxuuid.UUID
+	UserID *pgxuuid.UUID
+	Reason string
+}
+
+// DeleteStockMovement cancels an ACTIVE movement by turning it INACTIVE, as
+// if it never happened. Like a reversal it fails with an
+// InsufficientStockError when the stock it brought in was already used.
+func (r *PgRepository) DeleteStockMovement(ctx context.Context, params *DeleteStockMovementParams) (*StockMovement, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	var smID pgxuuid.UUID
-	err := r.db.QueryRow(ctx, `
+	var date time.Time
+	var purchaseOrderID, salesOrderID *pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
 		UPDATE "stock_movements" SET
-			date = $2,
-			entity_id = $3
+			status = 'INACTIVE',
+			cancelled_by_user_id = $2,
+			cancel_reason = $3,
+			cancelled_at = now(),
+			updated_at = now()
 		WHERE
 			id = $1
-	`, param.ID, param.Date, param.EntityID).Scan(
+			AND status = 'ACTIVE'
+			AND cancelled_at IS NULL
+		RETURNING
+			id,
+			date,
+			purchase_order_id,
+			sales_order_id
+	`, params.ID, params.UserID, params.Reason).Scan(
 		&smID,
+		&date,
+		&purchaseOrderID,
+		&salesOrderID,
 	)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("stock movement is already cancelled")
+		}
 		return nil, err
 	}
 
-	stockMovement, err := r.FetchStockMovementByID(ctx, &smID)
+	// a closed period is only left untouched by a reversal
+	err = checkOpenPeriods(ctx, tx, date)
 	if err != nil {
 		return nil, err
 	}
 
-	return stockMovement, nil
+	returned, err := hasReturns(ctx, tx, &smID)
+	if err != nil {
+		return nil, err
+	}
+	if returned {
+		return nil, constants.NewInvalidOperationError("stock movement has returns, cancel them first")
+	}
+
+	err = r.checkStockRemoval(ctx, tx, []pgxuuid.UUID{smID})
+	if err != nil {
+		return nil, err
+	}
+
+	if purchaseOrderID != nil {
+		err = refreshPurchaseOrderStatus(ctx, tx, purchaseOrderID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if salesOrderID != nil {
+		err = refreshSalesOrderStatus(ctx, tx, salesOrderID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.FetchStockMovementByID(ctx, &smID)
 }
 
-type DeleteStockMovementParams struct {
+type ReverseStockMovementParams struct {
 	ID     *pgxuuid.UUID
 	UserID *pgxuuid.UUID
+	Reason string
+	Date   time.Time
 }
 
-func (r *PgRepository) DeleteStockMovement(ctx context.Context, param *DeleteStockMovementParams) (*StockMovement, error) {
-	var sm StockMovement
-	err := r.db.QueryRow(ctx, `
+// ReverseStockMovement cancels an ACTIVE movement by posting on Date a
+// movement of the same type with every item negated. The original stays
+// ACTIVE so the stock before Date is not rewritten.
+func (r *PgRepository) ReverseStockMovement(ctx context.Context, params *ReverseStockMovementParams) (*StockMovement, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	reversal := CreateStockMovementParams{
+		ReversalOfID: params.ID,
+		CreatedBy:    params.UserID,
+		Date:         params.Date,
+	}
+	// the reversal is not a receipt or delivery itself, it only takes the
+	// original out of the received or delivered quantities of its order
+	var purchaseOrderID, salesOrderID *pgxuuid.UUID
+	err = tx.QueryRow(ctx, `
 		UPDATE "stock_movements" SET
-			status = 'INACTIVE',
 			cancelled_by_user_id = $2,
+			cancel_reason = $3,
+			cancelled_at = now(),
 			updated_at = now()
 		WHERE
 			id = $1
+			AND status = 'ACTIVE'
+			AND cancelled_at IS NULL
 		RETURNING
-			id,
-			status,
 			type,
-			date,
-			created_at,
-			updated_at
-	`, param.ID, param.UserID).Scan(
-		&sm.ID,
-		&sm.Status,
-		&sm.Type,
-		&sm.Date,
-		&sm.CreatedAt,
-		&sm.UpdatedAt,
+			entity_id,
+			currency,
+			exchange_rate,
+			purchase_order_id,
+			sales_order_id
+	`, params.ID, params.UserID, params.Reason).Scan(
+		&reversal.Type,
+		&reversal.EntityID,
+		&reversal.Currency,
+		&reversal.ExchangeRate,
+		&purchaseOrderID,
+		&salesOrderID,
 	)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("stock movement is already cancelled")
+		}
 		return nil, err
 	}
 
-	return &sm, nil
+	returned, err := hasReturns(ctx, tx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+	if returned {
+		return nil, constants.NewInvalidOperationError("stock movement has returns, cancel them first")
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT
+			product_id,
+			-quantity,
+			price,
+			coalesce(batch, ''),
+			lot_id,
+			warehouse_id,
+			unit,
+			unit_factor,
+			tax_rate
+		FROM "stock_movement_items"
+		WHERE
+			stock_movement_id = $1
+	`, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		item := CreateStockItem{}
+		err := rows.Scan(
+			&item.ProductID,
+			&item.Quantity,
+			&item.Price,
+			&item.Batch,
+			&item.LotID,
+			&item.WarehouseID,
+			&item.Unit,
+			&item.UnitFactor,
+			&item.TaxRate,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reversal.Items = append(reversal.Items, &item)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = r.createStockMovement(ctx, tx, &reversal)
+	if err != nil {
+		return nil, err
+	}
+
+	if purchaseOrderID != nil {
+		err = refreshPurchaseOrderStatus(ctx, tx, purchaseOrderID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if salesOrderID != nil {
+		err = refreshSalesOrderStatus(ctx, tx, salesOrderID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.FetchStockMovementByID(ctx, params.ID)
+}
+
+// GetStockMovementIDByInvoiceNumber returns the id of the movement of
+// entityID that holds invoiceNumber, cancelled movements are skipped
+func (r *PgRepository) GetStockMovementIDByInvoiceNumber(ctx context.Context, entityID *pgxuuid.UUID, invoiceNumber string) (*pgxuuid.UUID, error) {
+	var smID pgxuuid.UUID
+	err := r.db.QueryRow(ctx, `
+		SELECT id
+		FROM "stock_movements"
+		WHERE
+			entity_id = $1
+			AND invoice_number = $2
+			AND status = 'ACTIVE'
+			AND cancelled_at IS NULL
+	`, entityID, invoiceNumber).Scan(&smID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &smID, nil
 }
 
 func (r *PgRepository) FetchStockMovementByID(ctx context.Context, id *pgxuuid.UUID) (*StockMovement, error) {
@@ -330,7 +1146,20 @@ func (r *PgRepository) FetchStockMovementByID(ctx context.Context, id *pgxuuid.U
 			cu.id,
 			cu.name,
 			cu2.id,
-			coalesce(cu2.name, '')
+			coalesce(cu2.name, ''),
+			sm.production_order_id,
+			sm.currency,
+			sm.exchange_rate,
+			coalesce(sm.cancel_reason, ''),
+			sm.cancelled_at,
+			sm.reversal_of_id,
+			(SELECT r.id FROM "stock_movements" r WHERE r.reversal_of_id = sm.id),
+			sm.document_number,
+			coalesce(sm.invoice_number, ''),
+			sm.purchase_order_id,
+			sm.sales_order_id,
+			sm.return_of_id,
+			sm.inventory_count_id
 		FROM "stock_movements" sm
 		LEFT JOIN "entities" e ON e.id = sm.entity_id
 		LEFT JOIN "users" cu ON cu.id = sm.created_by_user_id
@@ -351,6 +1180,19 @@ func (r *PgRepository) FetchStockMovementByID(ctx context.Context, id *pgxuuid.U
 		&sm.CreatedByUserName,
 		&sm.CancelledByUserID,
 		&sm.CancelledByUserName,
+		&sm.ProductionOrderID,
+		&sm.Currency,
+		&sm.ExchangeRate,
+		&sm.CancelReason,
+		&sm.CancelledAt,
+		&sm.ReversalOfID,
+		&sm.ReversedByID,
+		&sm.DocumentNumber,
+		&sm.InvoiceNumber,
+		&sm.PurchaseOrderID,
+		&sm.SalesOrderID,
+		&sm.ReturnOfID,
+		&sm.InventoryCountID,
 	)
 	if err != nil {
 		return nil, err
@@ -364,11 +1206,23 @@ func (r *PgRepository) FetchStockMovementByID(ctx context.Context, id *pgxuuid.U
 		    	smi.stock_movement_id,
 		    	smi.quantity,
 		    	smi.price,
+		    	smi.unit,
+		    	smi.unit_factor,
+		    	smi.tax_rate,
 		    	coalesce(batch, ''),
+		    	smi.lot_id,
+		    	smi.warehouse_id,
+		    	w.name,
 		    	smi.created_at,
-		    	smi.updated_at
+		    	smi.updated_at,
+		    	smi.purchase_order_line_id,
+		    	smi.sales_order_line_id,
+		    	smi.return_of_item_id,
+		    	coalesce(ret.returned_quantity, 0)
 		FROM "stock_movement_items" smi
 		LEFT JOIN "products" p on smi.product_id = p.id
+		LEFT JOIN "warehouses" w on smi.warehouse_id = w.id
+		LEFT JOIN "stock_movement_item_returns" ret on ret.stock_movement_item_id = smi.id
 		WHERE smi.stock_movement_id = $1
     `, id)
 	if err != nil {
@@ -376,7 +1230,7 @@ func (r *PgRepository) FetchStockMovementByID(ctx context.Context, id *pgxuuid.U
 	}
 	defer rows.Close()
 
-	var total int
+	total := decimal.Zero
 
 	sm.Items = make([]*StockMovementItem, 0)
 	for rows.Next() {
@@ -388,21 +1242,33 @@ func (r *PgRepository) FetchStockMovementByID(ctx context.Context, id *pgxuuid.U
 			&item.StockMovementID,
 			&item.Quantity,
 			&item.Price,
+			&item.Unit,
+			&item.UnitFactor,
+			&item.TaxRate,
 			&item.Batch,
+			&item.LotID,
+			&item.WarehouseID,
+			&item.WarehouseName,
 			&item.CreatedAt,
 			&item.UpdatedAt,
+			&item.PurchaseOrderLineID,
+			&item.SalesOrderLineID,
+			&item.ReturnOfItemID,
+			&item.ReturnedQuantity,
 		)
 		if err != nil {
 			fmt.Printf("Error while scanning stock_movement_item")
 			return nil, err
 		}
 
-		total = total + int(math.Round(float64(item.Quantity*item.Price)/1000))
+		item.Total = constants.LineTotal(item.Quantity, item.Price)
+		total = total.Add(item.Total)
 
 		sm.Items = append(sm.Items, &item)
 	}
 
-	sm.Total = total
+	sm.DocumentTotal = total
+	sm.Total = constants.RoundAmount(total.Mul(sm.ExchangeRate))
 
 	if err = rows.Err(); err != nil {
 		return nil, err
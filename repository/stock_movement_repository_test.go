@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestCancelStockMovement(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+
+	assertAlreadyCancelled := func(t *testing.T, err error) {
+		t.Helper()
+
+		var invalidOperation *constants.InvalidOperationError
+		if !errors.As(err, &invalidOperation) {
+			t.Fatalf("expected an invalid operation error, got %v", err)
+		}
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		productID := newTestProduct(t)
+		purchase := mustPostStock(t, "PURCHASE", userID, warehouseID, productID, 10)
+
+		params := &DeleteStockMovementParams{
+			ID:     purchase.ID,
+			UserID: userID,
+			Reason: "wrong supplier",
+		}
+		purchase, err := testRepo.DeleteStockMovement(ctx, params)
+		if err != nil {
+			t.Fatalf("delete purchase: %v", err)
+		}
+		if purchase.Status != "INACTIVE" || purchase.CancelReason != "wrong supplier" {
+			t.Fatalf("expected an INACTIVE purchase with its reason, got %v %q", purchase.Status, purchase.CancelReason)
+		}
+
+		_, err = postStock("SALE", userID, warehouseID, productID, 1)
+		assertInsufficientStock(t, err)
+
+		_, err = testRepo.DeleteStockMovement(ctx, params)
+		assertAlreadyCancelled(t, err)
+	})
+
+	t.Run("reverse", func(t *testing.T) {
+		productID := newTestProduct(t)
+		purchase := mustPostStock(t, "PURCHASE", userID, warehouseID, productID, 10)
+
+		params := &ReverseStockMovementParams{
+			ID:     purchase.ID,
+			UserID: userID,
+			Reason: "wrong supplier",
+			Date:   time.Now(),
+		}
+		purchase, err := testRepo.ReverseStockMovement(ctx, params)
+		if err != nil {
+			t.Fatalf("reverse purchase: %v", err)
+		}
+		if purchase.Status != "ACTIVE" || purchase.ReversedByID == nil {
+			t.Fatalf("expected an ACTIVE purchase with its reversal, got %v %v", purchase.Status, purchase.ReversedByID)
+		}
+
+		reversal, err := testRepo.FetchStockMovementByID(ctx, purchase.ReversedByID)
+		if err != nil {
+			t.Fatalf("fetch reversal: %v", err)
+		}
+		if reversal.Type != "PURCHASE" || !reversal.Items[0].Quantity.Equal(purchase.Items[0].Quantity.Neg()) {
+			t.Fatalf("expected the reversal to negate the purchase items")
+		}
+
+		_, err = postStock("SALE", userID, warehouseID, productID, 1)
+		assertInsufficientStock(t, err)
+
+		_, err = testRepo.ReverseStockMovement(ctx, params)
+		assertAlreadyCancelled(t, err)
+	})
+}
+
+func TestDeleteStockMovementConsumed(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+	productID := newTestProduct(t)
+
+	purchase := mustPostStock(t, "PURCHASE", userID, warehouseID, productID, 10)
+	sale := mustPostStock(t, "SALE", userID, warehouseID, productID, 8)
+
+	_, err := testRepo.DeleteStockMovement(ctx, &DeleteStockMovementParams{
+		ID:     purchase.ID,
+		UserID: userID,
+		Reason: "test",
+	})
+	assertInsufficientStock(t, err)
+
+	purchase, err = testRepo.FetchStockMovementByID(ctx, purchase.ID)
+	if err != nil {
+		t.Fatalf("fetch purchase: %v", err)
+	}
+	if purchase.Status != "ACTIVE" {
+		t.Fatalf("expected the purchase to stay ACTIVE, got %v", purchase.Status)
+	}
+
+	// once the sale is cancelled the purchase is free to go
+	_, err = testRepo.DeleteStockMovement(ctx, &DeleteStockMovementParams{
+		ID:     sale.ID,
+		UserID: userID,
+		Reason: "test",
+	})
+	if err != nil {
+		t.Fatalf("cancel sale: %v", err)
+	}
+
+	_, err = testRepo.DeleteStockMovement(ctx, &DeleteStockMovementParams{
+		ID:     purchase.ID,
+		UserID: userID,
+		Reason: "test",
+	})
+	if err != nil {
+		t.Fatalf("cancel purchase: %v", err)
+	}
+}
+
+func TestCreateStockMovementReturn(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+	productID := newTestProduct(t)
+
+	mustPostStock(t, "PURCHASE", userID, warehouseID, productID, 10)
+	sale := mustPostStock(t, "SALE", userID, warehouseID, productID, 6)
+
+	postReturn := func(quantity int64) error {
+		_, err := testRepo.CreateStockMovement(ctx, &CreateStockMovementParams{
+			Type:       "SALE_RETURN",
+			Date:       time.Now(),
+			CreatedBy:  userID,
+			ReturnOfID: sale.ID,
+			Items: []*CreateStockItem{
+				{
+					ProductID:      productID,
+					Quantity:       decimal.NewFromInt(quantity),
+					Price:          sale.Items[0].Price,
+					WarehouseID:    warehouseID,
+					ReturnOfItemID: sale.Items[0].ID,
+				},
+			},
+		})
+		return err
+	}
+
+	assertInvalidOperation := func(t *testing.T, err error) {
+		t.Helper()
+
+		var invalidOperation *constants.InvalidOperationError
+		if !errors.As(err, &invalidOperation) {
+			t.Fatalf("expected an invalid operation error, got %v", err)
+		}
+	}
+
+	err := postReturn(4)
+	if err != nil {
+		t.Fatalf("create return: %v", err)
+	}
+
+	t.Run("over-return", func(t *testing.T) {
+		assertInvalidOperation(t, postReturn(3))
+	})
+
+	t.Run("cancel returned", func(t *testing.T) {
+		_, err := testRepo.DeleteStockMovement(ctx, &DeleteStockMovementParams{
+			ID:     sale.ID,
+			UserID: userID,
+			Reason: "test",
+		})
+		assertInvalidOperation(t, err)
+	})
+}
@@ -0,0 +1,293 @@
+package repository
+
+import (
+	"context"
+	"github.com/hoffax/prodrest/constants"
+	gofrsuuid "github.com/gofrs/uuid/v5"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// StockLedgerLine is a single active stock_movement_item with its quantity
+// already signed by the movement type (see movement_sign on the database)
+// and its price converted to the base currency
+type StockLedgerLine struct {
+	ID              *pgxuuid.UUID
+	ProductID       *pgxuuid.UUID
+	StockMovementID *pgxuuid.UUID
+	Type            string
+	Date            time.Time
+	EntityID        *pgxuuid.UUID
+	EntityName      string
+	EntityDocument  string
+	WarehouseID     *pgxuuid.UUID
+	WarehouseName   string
+	Batch           string
+	Quantity        decimal.Decimal
+	Price           decimal.Decimal
+	CreatedAt       time.Time
+}
+
+type FetchStockLedgerParams struct {
+	ProductIDs []pgxuuid.UUID
+}
+
+// FetchStockLedger returns every active movement line for the given products
+// in chronological order
+func (r *PgRepository) FetchStockLedger(ctx context.Context, params *FetchStockLedgerParams) ([]*StockLedgerLine, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			smi.id,
+			smi.product_id,
+			sm.id,
+			sm.type,
+			sm.date,
+			e.id,
+			coalesce(e.name, ''),
+			coalesce(e.ruc, e.ci, ''),
+			w.id,
+			w.name,
+			coalesce(smi.batch, ''),
+			smi.quantity * movement_sign(sm.type),
+			round(smi.price * sm.exchange_rate, 4),
+			smi.created_at
+		FROM "stock_movement_items" smi
+		JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+		LEFT JOIN "entities" e ON e.id = sm.entity_id
+		JOIN "warehouses" w ON w.id = smi.warehouse_id
+		WHERE
+			sm.status = 'ACTIVE'
+			AND smi.product_id = ANY($1::uuid[])
+		ORDER BY
+			sm.date,
+			sm.created_at,
+			smi.created_at
+	`, params.ProductIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make([]*StockLedgerLine, 0)
+	for rows.Next() {
+		line := StockLedgerLine{}
+		err := rows.Scan(
+			&line.ID,
+			&line.ProductID,
+			&line.StockMovementID,
+			&line.Type,
+			&line.Date,
+			&line.EntityID,
+			&line.EntityName,
+			&line.EntityDocument,
+			&line.WarehouseID,
+			&line.WarehouseName,
+			&line.Batch,
+			&line.Quantity,
+			&line.Price,
+			&line.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, &line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// lockProducts takes a row lock on the given products until tx ends, always
+// in the same order to avoid deadlocks between concurrent movements
+func (r *PgRepository) lockProducts(ctx context.Context, tx pgx.Tx, productIDs []pgxuuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		SELECT id
+		FROM "products"
+		WHERE
+			id = ANY($1::uuid[])
+		ORDER BY id
+		FOR UPDATE
+	`, productIDs)
+
+	return err
+}
+
+// checkStockAvailability fails with an InsufficientStockError when the items
+// of the movement leave a product, or one of its lots, below what other
+// documents hold of it in any warehouse, or of the whole product when it is
+// held without a warehouse. Products allowed to go negative are only checked
+// per lot.
+func (r *PgRepository) checkStockAvailability(ctx context.Context, tx pgx.Tx, stockMovementID *pgxuuid.UUID) error {
+	return r.checkStockChange(ctx, tx, stockMovementID, "", nil)
+}
+
+// checkStockChange is checkStockAvailability for a movement whose items
+// replaced previous ones, only the difference between both sets of items of
+// movementType is checked so an edit does not fail on stock it already took
+func (r *PgRepository) checkStockChange(ctx context.Context, tx pgx.Tx, stockMovementID *pgxuuid.UUID, movementType string, previous []*StockMovementItem) error {
+	productIDs := make([]string, len(previous))
+	warehouseIDs := make([]string, len(previous))
+	lotIDs := make([]string, len(previous))
+	quantities := make([]decimal.Decimal, len(previous))
+	for i, item := range previous {
+		productIDs[i] = uuidText(item.ProductID)
+		warehouseIDs[i] = uuidText(item.WarehouseID)
+		lotIDs[i] = uuidText(item.LotID)
+		quantities[i] = item.Quantity
+	}
+
+	rows, err := tx.Query(ctx, `
+		WITH "lines" AS (
+			SELECT
+				smi.product_id,
+				smi.warehouse_id,
+				smi.lot_id,
+				smi.quantity * movement_sign(sm.type) AS quantity
+			FROM "stock_movement_items" smi
+			JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+			WHERE
+				sm.id = $1
+			UNION ALL
+			SELECT
+				previous.product_id::UUID,
+				previous.warehouse_id::UUID,
+				nullif(previous.lot_id, '')::UUID,
+				-previous.quantity * movement_sign(nullif($2, '')::MOVEMENT_TYPE)
+			FROM unnest($3::TEXT[], $4::TEXT[], $5::TEXT[], $6::NUMERIC[])
+				AS previous(product_id, warehouse_id, lot_id, quantity)
+		), "moved" AS (
+			SELECT
+				product_id,
+				warehouse_id,
+				NULL::UUID AS lot_id,
+				sum(quantity) AS quantity
+			FROM "lines"
+			GROUP BY
+				product_id,
+				warehouse_id
+			UNION ALL
+			SELECT
+				product_id,
+				warehouse_id,
+				lot_id,
+				sum(quantity) AS quantity
+			FROM "lines"
+			WHERE
+				lot_id IS NOT NULL
+			GROUP BY
+				product_id,
+				warehouse_id,
+				lot_id
+			UNION ALL
+			SELECT
+				product_id,
+				NULL::UUID AS warehouse_id,
+				NULL::UUID AS lot_id,
+				sum(quantity) AS quantity
+			FROM "lines"
+			WHERE
+				EXISTS (
+					SELECT 1 FROM "reservation_holds" rh WHERE rh.product_id = "lines".product_id AND rh.warehouse_id IS NULL
+				)
+			GROUP BY
+				product_id
+		), "own" AS (
+			-- a delivery does not compete with the reservations of its order,
+			-- every other movement does, also with the manual ones
+			SELECT sales_order_id FROM "stock_movements" WHERE id = $1
+		)
+		SELECT
+			p.id::TEXT,
+			p.name,
+			coalesce(w.name, ''),
+			coalesce(l.code, ''),
+			-m.quantity,
+			balance.quantity - held.quantity - m.quantity
+		FROM "moved" m
+		JOIN "products" p ON p.id = m.product_id
+		LEFT JOIN "warehouses" w ON w.id = m.warehouse_id
+		LEFT JOIN "lots" l ON l.id = m.lot_id
+		JOIN LATERAL (
+			SELECT
+				coalesce(sum(smi.quantity * movement_sign(sm.type)), 0) AS quantity
+			FROM "stock_movement_items" smi
+			JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+			WHERE
+				sm.status = 'ACTIVE'
+				AND smi.product_id = m.product_id
+				AND (m.warehouse_id IS NULL OR smi.warehouse_id = m.warehouse_id)
+				AND (l.id IS NULL OR smi.lot_id = l.id)
+		) balance ON TRUE
+		JOIN LATERAL (
+			SELECT
+				coalesce(sum(rh.held_quantity), 0) AS quantity
+			FROM "reservation_holds" rh
+			WHERE
+				rh.product_id = m.product_id
+				AND (m.warehouse_id IS NULL OR rh.warehouse_id = m.warehouse_id)
+				AND (l.id IS NULL OR rh.lot_id = l.id)
+				AND (
+					rh.source_id IS NULL
+					OR rh.source_id IS DISTINCT FROM (SELECT sales_order_id FROM "own")
+				)
+		) held ON TRUE
+		WHERE
+			m.quantity < 0
+			AND balance.quantity - held.quantity < 0
+			AND (l.id IS NOT NULL OR NOT p.allow_negative_stock)
+		ORDER BY
+			p.name,
+			w.name NULLS LAST,
+			l.code NULLS FIRST
+	`, stockMovementID, movementType, productIDs, warehouseIDs, lotIDs, quantities)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	items := make([]*constants.InsufficientStockItem, 0)
+	for rows.Next() {
+		item := constants.InsufficientStockItem{}
+		err := rows.Scan(
+			&item.ProductID,
+			&item.ProductName,
+			&item.WarehouseName,
+			&item.Batch,
+			&item.Requested,
+			&item.Available,
+		)
+		if err != nil {
+			return err
+		}
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(items) > 0 {
+		return constants.NewInsufficientStockError(items)
+	}
+
+	return nil
+}
+
+// uuidText formats an optional id for a text array, nil becomes ''
+func uuidText(id *pgxuuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+
+	value, err := id.UUIDValue()
+	if err != nil || !value.Valid {
+		return ""
+	}
+
+	return gofrsuuid.UUID(value.Bytes).String()
+}
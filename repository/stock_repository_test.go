@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestCheckStockAvailability(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+	productID := newTestProduct(t)
+
+	mustPostStock(t, "PURCHASE", userID, warehouseID, productID, 10)
+
+	_, err := postStock("SALE", userID, warehouseID, productID, 15)
+	assertInsufficientStock(t, err)
+
+	_, err = postStock("ADJUST", userID, warehouseID, productID, -15)
+	assertInsufficientStock(t, err)
+
+	mustPostStock(t, "SALE", userID, warehouseID, productID, 10)
+
+	// a product allowed to go negative is still checked per lot
+	product, err := testRepo.CreateProduct(ctx, &CreateProductParams{
+		Name:               testName("product"),
+		Barcode:            testName("barcode"),
+		Unit:               "UN",
+		BatchControl:       true,
+		LotAllocation:      "FEFO",
+		TaxRate:            constants.TaxRateIVA10,
+		AllowNegativeStock: true,
+	})
+	if err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+	lot, err := testRepo.CreateLot(ctx, &CreateLotParams{
+		ProductID: product.ID,
+		Code:      testName("lot"),
+	})
+	if err != nil {
+		t.Fatalf("create lot: %v", err)
+	}
+
+	postLot := func(movementType string, quantity int64) (*StockMovement, error) {
+		return testRepo.CreateStockMovement(ctx, &CreateStockMovementParams{
+			Type:      movementType,
+			Date:      time.Now(),
+			CreatedBy: userID,
+			Items: []*CreateStockItem{
+				{
+					ProductID:   product.ID,
+					Quantity:    decimal.NewFromInt(quantity),
+					Price:       decimal.NewFromInt(1000),
+					LotID:       lot.ID,
+					WarehouseID: warehouseID,
+				},
+			},
+		})
+	}
+
+	_, err = postLot("PURCHASE", 5)
+	if err != nil {
+		t.Fatalf("create purchase: %v", err)
+	}
+
+	_, err = postLot("SALE", 8)
+	assertInsufficientStock(t, err)
+
+	_, err = postStock("SALE", userID, warehouseID, product.ID, 8)
+	if err != nil {
+		t.Fatalf("sale without lot of a product allowed to go negative: %v", err)
+	}
+}
+
+func TestCheckStockChangeSalesOrderReservation(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+	entityID := newTestEntity(t)
+	productID := newTestProduct(t)
+	mustPostStock(t, "PURCHASE", userID, warehouseID, productID, 10)
+
+	so, err := testRepo.CreateSalesOrder(ctx, &CreateSalesOrderParams{
+		EntityID:    entityID,
+		WarehouseID: warehouseID,
+		Date:        time.Now(),
+		CreatedBy:   userID,
+		Lines: []*CreateSalesOrderLine{
+			{
+				ProductID: productID,
+				Quantity:  decimal.NewFromInt(6),
+				Price:     decimal.NewFromInt(1500),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("create sales order: %v", err)
+	}
+
+	so, err = testRepo.ConfirmSalesOrder(ctx, &ConfirmSalesOrderParams{
+		ID:     so.ID,
+		UserID: userID,
+	})
+	if err != nil {
+		t.Fatalf("confirm sales order: %v", err)
+	}
+
+	t.Run("other sale", func(t *testing.T) {
+		_, err := postStock("SALE", userID, warehouseID, productID, 5)
+		assertInsufficientStock(t, err)
+	})
+
+	t.Run("delivery", func(t *testing.T) {
+		_, err := testRepo.CreateStockMovement(ctx, &CreateStockMovementParams{
+			Type:         "SALE",
+			Date:         time.Now(),
+			EntityID:     entityID,
+			CreatedBy:    userID,
+			SalesOrderID: so.ID,
+			Items: []*CreateStockItem{
+				{
+					ProductID:        productID,
+					Quantity:         decimal.NewFromInt(6),
+					Price:            decimal.NewFromInt(1500),
+					WarehouseID:      warehouseID,
+					SalesOrderLineID: so.Lines[0].ID,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("deliver sales order: %v", err)
+		}
+	})
+}
+
+func TestCheckStockChangeManualReservation(t *testing.T) {
+	requireDB(t)
+
+	ctx := context.Background()
+	userID := newTestUser(t)
+	warehouseID := newTestWarehouse(t)
+
+	t.Run("on the warehouse", func(t *testing.T) {
+		productID := newTestProduct(t)
+		mustPostStock(t, "PURCHASE", userID, warehouseID, productID, 10)
+
+		_, err := testRepo.CreateReservation(ctx, &CreateReservationParams{
+			ProductID:   productID,
+			WarehouseID: warehouseID,
+			Quantity:    decimal.NewFromInt(4),
+			CreatedBy:   userID,
+		})
+		if err != nil {
+			t.Fatalf("create reservation: %v", err)
+		}
+
+		_, err = postStock("SALE", userID, warehouseID, productID, 7)
+		assertInsufficientStock(t, err)
+
+		mustPostStock(t, "SALE", userID, warehouseID, productID, 6)
+	})
+
+	t.Run("on the product", func(t *testing.T) {
+		productID := newTestProduct(t)
+		mustPostStock(t, "PURCHASE", userID, warehouseID, productID, 10)
+
+		_, err := testRepo.CreateReservation(ctx, &CreateReservationParams{
+			ProductID: productID,
+			Quantity:  decimal.NewFromInt(10),
+			CreatedBy: userID,
+		})
+		if err != nil {
+			t.Fatalf("create reservation: %v", err)
+		}
+
+		_, err = postStock("SALE", userID, warehouseID, productID, 10)
+		assertInsufficientStock(t, err)
+	})
+}
@@ -0,0 +1,333 @@
+package repository
+
+import (
+	"context"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type Unit struct {
+	Code      string
+	Status    string
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (r *PgRepository) FetchUnits(ctx context.Context, statusOptions []string) ([]*Unit, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			code,
+			status,
+			name,
+			created_at,
+			updated_at
+		FROM "units"
+		WHERE
+		    cardinality($1::status[]) = 0 OR status = ANY($1::status[])
+		ORDER BY
+		    code
+	`, statusOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	units := make([]*Unit, 0)
+	for rows.Next() {
+		var unit Unit
+		err := rows.Scan(
+			&unit.Code,
+			&unit.Status,
+			&unit.Name,
+			&unit.CreatedAt,
+			&unit.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, &unit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return units, nil
+}
+
+func (r *PgRepository) GetUnitByCode(ctx context.Context, code string) (*Unit, error) {
+	var unit Unit
+	err := r.db.QueryRow(ctx, `
+		SELECT
+			code,
+			status,
+			name,
+			created_at,
+			updated_at
+		FROM "units"
+		WHERE code = $1
+	`, code).Scan(
+		&unit.Code,
+		&unit.Status,
+		&unit.Name,
+		&unit.CreatedAt,
+		&unit.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unit, nil
+}
+
+type CreateUnitParams struct {
+	Code string
+	Name string
+}
+
+func (r *PgRepository) CreateUnit(ctx context.Context, params *CreateUnitParams) (*Unit, error) {
+	var unit Unit
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO "units" (
+			code,
+			name
+		) VALUES (
+			$1, $2
+		) RETURNING
+			code,
+			status,
+			name,
+			created_at,
+			updated_at
+	`, params.Code, params.Name).Scan(
+		&unit.Code,
+		&unit.Status,
+		&unit.Name,
+		&unit.CreatedAt,
+		&unit.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unit, nil
+}
+
+type UpdateUnitParams struct {
+	Code   string
+	Status string
+	Name   string
+}
+
+func (r *PgRepository) UpdateUnit(ctx context.Context, params *UpdateUnitParams) (*Unit, error) {
+	var unit Unit
+	err := r.db.QueryRow(ctx, `
+		UPDATE "units" SET
+			status = $2,
+			name = $3,
+			updated_at = now()
+		WHERE code = $1
+		RETURNING
+			code,
+			status,
+			name,
+			created_at,
+			updated_at
+	`, params.Code, params.Status, params.Name).Scan(
+		&unit.Code,
+		&unit.Status,
+		&unit.Name,
+		&unit.CreatedAt,
+		&unit.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unit, nil
+}
+
+// CountUnitProducts returns how many products use code as their base unit
+// or as one of their packaging units
+func (r *PgRepository) CountUnitProducts(ctx context.Context, code string) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `
+		SELECT count(*)
+		FROM (
+			SELECT id FROM "products" WHERE unit = $1 AND status = 'ACTIVE'
+			UNION
+			SELECT product_id FROM "product_units" WHERE unit = $1 AND status = 'ACTIVE'
+		) p
+	`, code).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ProductBaseUnitInUse reports whether any quantity of the product was
+// recorded in its base unit, by a movement, a packaging unit or a
+// reservation, whatever their status
+func (r *PgRepository) ProductBaseUnitInUse(ctx context.Context, productID *pgxuuid.UUID) (bool, error) {
+	var inUse bool
+	err := r.db.QueryRow(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM "stock_movement_items" WHERE product_id = $1)
+			OR EXISTS (SELECT 1 FROM "product_units" WHERE product_id = $1)
+			OR EXISTS (SELECT 1 FROM "reservations" WHERE product_id = $1)
+	`, productID).Scan(&inUse)
+	if err != nil {
+		return false, err
+	}
+
+	return inUse, nil
+}
+
+// ProductUnit is a packaging level of a product, Factor is how many base
+// units of the product one of it holds
+type ProductUnit struct {
+	ID        *pgxuuid.UUID
+	Status    string
+	ProductID *pgxuuid.UUID
+	Unit      string
+	UnitName  string
+	Factor    decimal.Decimal
+	Barcode   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const productUnitQuery = `
+	SELECT
+		pu.id,
+		pu.status,
+		pu.product_id,
+		pu.unit,
+		u.name,
+		pu.factor,
+		coalesce(pu.barcode, ''),
+		pu.created_at,
+		pu.updated_at
+	FROM "product_units" pu
+	JOIN "units" u ON u.code = pu.unit
+`
+
+func scanProductUnit(row pgx.Row) (*ProductUnit, error) {
+	var productUnit ProductUnit
+	err := row.Scan(
+		&productUnit.ID,
+		&productUnit.Status,
+		&productUnit.ProductID,
+		&productUnit.Unit,
+		&productUnit.UnitName,
+		&productUnit.Factor,
+		&productUnit.Barcode,
+		&productUnit.CreatedAt,
+		&productUnit.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &productUnit, nil
+}
+
+// FetchProductUnits returns the packaging units of the given products,
+// smallest first
+func (r *PgRepository) FetchProductUnits(ctx context.Context, productIDs []pgxuuid.UUID) ([]*ProductUnit, error) {
+	rows, err := r.db.Query(ctx, productUnitQuery+`
+		WHERE
+			pu.product_id = ANY($1::uuid[])
+		ORDER BY
+			pu.factor
+	`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	productUnits := make([]*ProductUnit, 0)
+	for rows.Next() {
+		productUnit, err := scanProductUnit(rows)
+		if err != nil {
+			return nil, err
+		}
+		productUnits = append(productUnits, productUnit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return productUnits, nil
+}
+
+func (r *PgRepository) GetProductUnit(ctx context.Context, productID *pgxuuid.UUID, unit string) (*ProductUnit, error) {
+	return scanProductUnit(r.db.QueryRow(ctx, productUnitQuery+`
+		WHERE
+			pu.product_id = $1
+			AND pu.unit = $2
+	`, productID, unit))
+}
+
+func (r *PgRepository) GetProductUnitByBarcode(ctx context.Context, barcode string) (*ProductUnit, error) {
+	return scanProductUnit(r.db.QueryRow(ctx, productUnitQuery+`
+		WHERE
+			pu.barcode = $1
+	`, barcode))
+}
+
+type CreateProductUnitParams struct {
+	ProductID *pgxuuid.UUID
+	Unit      string
+	Factor    decimal.Decimal
+	Barcode   string
+}
+
+func (r *PgRepository) CreateProductUnit(ctx context.Context, params *CreateProductUnitParams) (*ProductUnit, error) {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO "product_units" (
+			product_id,
+			unit,
+			factor,
+			barcode
+		) VALUES (
+			$1, $2, $3, nullif($4, '')
+		)
+	`, params.ProductID, params.Unit, params.Factor, params.Barcode)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetProductUnit(ctx, params.ProductID, params.Unit)
+}
+
+type UpdateProductUnitParams struct {
+	ProductID *pgxuuid.UUID
+	Unit      string
+	Status    string
+	Factor    decimal.Decimal
+	Barcode   string
+}
+
+func (r *PgRepository) UpdateProductUnit(ctx context.Context, params *UpdateProductUnitParams) (*ProductUnit, error) {
+	_, err := r.db.Exec(ctx, `
+		UPDATE "product_units" SET
+			status = $3,
+			factor = $4,
+			barcode = nullif($5, ''),
+			updated_at = now()
+		WHERE
+			product_id = $1
+			AND unit = $2
+	`, params.ProductID, params.Unit, params.Status, params.Factor, params.Barcode)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetProductUnit(ctx, params.ProductID, params.Unit)
+}
@@ -0,0 +1,279 @@
+package repository
+
+import (
+	"context"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type Warehouse struct {
+	ID        *pgxuuid.UUID
+	Status    string
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type FetchWarehousesParams struct {
+	StatusOptions []string
+	Search        string
+	Limit         int
+	Offset        int
+}
+
+type FetchWarehousesResult struct {
+	TotalCount int
+	Items      []*Warehouse
+}
+
+func (r *PgRepository) FetchWarehouses(ctx context.Context, param *FetchWarehousesParams) (*FetchWarehousesResult, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+		    COUNT(*) OVER() AS full_count,
+			id,
+			status,
+			name,
+			created_at,
+			updated_at
+		FROM "warehouses"
+		WHERE
+		    (cardinality($1::status[]) = 0 OR status = ANY($1::status[]))
+			AND name ILIKE '%' || $2 || '%'
+		ORDER BY
+		    name
+		LIMIT $3
+		OFFSET $4
+	`, param.StatusOptions, param.Search, param.Limit, param.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := FetchWarehousesResult{
+		Items: make([]*Warehouse, 0),
+	}
+	for rows.Next() {
+		var item Warehouse
+		err := rows.Scan(
+			&result.TotalCount,
+			&item.ID,
+			&item.Status,
+			&item.Name,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Items = append(result.Items, &item)
+	}
+
+	return &result, nil
+}
+
+func (r *PgRepository) GetWarehouseByID(ctx context.Context, id *pgxuuid.UUID) (*Warehouse, error) {
+	var item Warehouse
+	err := r.db.QueryRow(ctx, `
+		SELECT
+			id,
+			status,
+			name,
+			created_at,
+			updated_at
+		FROM "warehouses"
+		WHERE id = $1
+	`, id).Scan(
+		&item.ID,
+		&item.Status,
+		&item.Name,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (r *PgRepository) GetWarehouseByName(ctx context.Context, name string) (*Warehouse, error) {
+	var item Warehouse
+	err := r.db.QueryRow(ctx, `
+		SELECT
+			id,
+			status,
+			name,
+			created_at,
+			updated_at
+		FROM "warehouses"
+		WHERE name = $1
+	`, name).Scan(
+		&item.ID,
+		&item.Status,
+		&item.Name,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+type CreateWarehouseParams struct {
+	Name string
+}
+
+func (r *PgRepository) CreateWarehouse(ctx context.Context, param *CreateWarehouseParams) (*Warehouse, error) {
+	var item Warehouse
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO "warehouses" (
+			name
+		) VALUES (
+			$1
+		) RETURNING
+			id,
+			status,
+			name,
+			created_at,
+			updated_at
+	`, param.Name).Scan(
+		&item.ID,
+		&item.Status,
+		&item.Name,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+type UpdateWarehouseParams struct {
+	ID     *pgxuuid.UUID
+	Status string
+	Name   string
+}
+
+func (r *PgRepository) UpdateWarehouse(ctx context.Context, param *UpdateWarehouseParams) (*Warehouse, error) {
+	var item Warehouse
+	err := r.db.QueryRow(ctx, `
+		UPDATE "warehouses" SET
+			status = $2,
+			name = $3,
+			updated_at = now()
+		WHERE id = $1
+		RETURNING
+			id,
+			status,
+			name,
+			created_at,
+			updated_at
+	`, param.ID, param.Status, param.Name).Scan(
+		&item.ID,
+		&item.Status,
+		&item.Name,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+type WarehouseStock struct {
+	WarehouseID   *pgxuuid.UUID
+	WarehouseName string
+	ProductID     *pgxuuid.UUID
+	ProductName   string
+	LotID         *pgxuuid.UUID
+	Batch         string
+	Quantity      decimal.Decimal
+	Reserved      decimal.Decimal
+}
+
+type FetchWarehouseStockParams struct {
+	WarehouseID *pgxuuid.UUID
+	ProductID   *pgxuuid.UUID
+}
+
+// FetchWarehouseStock returns the quantity on hand per warehouse, product and
+// lot with what reservations hold of it. Both filters are optional, rows with
+// nothing left are skipped.
+func (r *PgRepository) FetchWarehouseStock(ctx context.Context, params *FetchWarehouseStockParams) ([]*WarehouseStock, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			w.id,
+			w.name,
+			p.id,
+			p.name,
+			l.id,
+			coalesce(l.code, ''),
+			sum(smi.quantity * movement_sign(sm.type)),
+			(
+				SELECT
+					coalesce(sum(rh.held_quantity), 0)
+				FROM "reservation_holds" rh
+				WHERE
+					rh.product_id = p.id
+					AND rh.warehouse_id = w.id
+					AND rh.lot_id IS NOT DISTINCT FROM l.id
+			)
+		FROM "stock_movement_items" smi
+		JOIN "stock_movements" sm ON sm.id = smi.stock_movement_id
+		JOIN "warehouses" w ON w.id = smi.warehouse_id
+		JOIN "products" p ON p.id = smi.product_id
+		LEFT JOIN "lots" l ON l.id = smi.lot_id
+		WHERE
+			sm.status = 'ACTIVE'
+			AND ($1::uuid IS NULL OR smi.warehouse_id = $1)
+			AND ($2::uuid IS NULL OR smi.product_id = $2)
+		GROUP BY
+			w.id,
+			p.id,
+			l.id
+		HAVING
+			sum(smi.quantity * movement_sign(sm.type)) <> 0
+		ORDER BY
+			w.name,
+			p.name,
+			l.expiry_date NULLS LAST,
+			l.code
+	`, params.WarehouseID, params.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*WarehouseStock, 0)
+	for rows.Next() {
+		item := WarehouseStock{}
+		err := rows.Scan(
+			&item.WarehouseID,
+			&item.WarehouseName,
+			&item.ProductID,
+			&item.ProductName,
+			&item.LotID,
+			&item.Batch,
+			&item.Quantity,
+			&item.Reserved,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	"strings"
+)
+
+func (h *Handlers) RegisterAlertRoutes() {
+	g := h.app.Group("/alerts")
+
+	g.Get("/low_stock", h.getLowStockProducts)
+	g.Get("/low_stock/history", h.getLowStockAlerts)
+	g.Post("/low_stock/:id/acknowledge", h.acknowledgeLowStockAlert)
+}
+
+type GetLowStockProductsQuery struct {
+	SupplierID *uuid.UUID `query:"supplierId"`
+	Limit      int        `query:"limit"`
+	Offset     int        `query:"offset"`
+}
+
+func (h *Handlers) getLowStockProducts(c *fiber.Ctx) error {
+	params := new(GetLowStockProductsQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 10
+	}
+
+	response, err := h.sm.FetchLowStockProducts(c.Context(), &services.FetchLowStockProductsParams{
+		PreferredSupplierID: toPgxUUID(params.SupplierID),
+		Limit:               params.Limit,
+		Offset:              params.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+type GetLowStockAlertsQuery struct {
+	StatusOptions []string   `query:"status"`
+	ProductID     *uuid.UUID `query:"productId"`
+	Limit         int        `query:"limit"`
+	Offset        int        `query:"offset"`
+}
+
+func (h *Handlers) getLowStockAlerts(c *fiber.Ctx) error {
+	params := new(GetLowStockAlertsQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 10
+	}
+
+	statusOptions := make([]string, 0)
+	for _, status := range params.StatusOptions {
+		statusOptions = append(statusOptions, strings.ToUpper(status))
+	}
+
+	response, err := h.sm.FetchLowStockAlerts(c.Context(), &services.FetchLowStockAlertsParams{
+		StatusOptions: statusOptions,
+		ProductID:     toPgxUUID(params.ProductID),
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *Handlers) acknowledgeLowStockAlert(c *fiber.Ctx) error {
+	alertId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	alert, err := h.sm.AcknowledgeLowStockAlert(c.Context(), &services.AcknowledgeLowStockAlertParams{
+		ID:     alertId,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(alert)
+}
@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"mime"
+)
+
+func getAttachmentIdParam(c *fiber.Ctx) (*pgxuuid.UUID, error) {
+	param := struct {
+		AttachmentID uuid.UUID `params:"attachmentId"`
+	}{}
+
+	err := c.ParamsParser(&param)
+	if err != nil {
+		return nil, constants.InvalidParams("invalid attachmentId on url query string")
+	}
+
+	id := pgxuuid.UUID(param.AttachmentID.Bytes())
+	return &id, nil
+}
+
+func (h *Handlers) getStockMovementAttachments(c *fiber.Ctx) error {
+	stockMovementId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	attachments, err := h.sm.FetchAttachments(c.Context(), stockMovementId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(attachments)
+}
+
+// uploadStockMovementAttachment expects a multipart form with the file on
+// the "file" field
+func (h *Handlers) uploadStockMovementAttachment(c *fiber.Ctx) error {
+	stockMovementId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return constants.NewRequiredFieldError("file")
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	attachment, err := h.sm.UploadAttachment(c.Context(), &services.UploadAttachmentParams{
+		StockMovementID: stockMovementId,
+		UserID:          userID,
+		FileName:        fileHeader.Filename,
+		Size:            fileHeader.Size,
+		Content:         file,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(attachment)
+}
+
+func (h *Handlers) downloadStockMovementAttachment(c *fiber.Ctx) error {
+	stockMovementId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	attachmentId, err := getAttachmentIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	attachment, content, err := h.sm.OpenAttachment(c.Context(), stockMovementId, attachmentId)
+	if err != nil {
+		return err
+	}
+
+	// the content is always downloaded, never rendered by the browser
+	c.Set(fiber.HeaderContentType, attachment.MimeType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+
+	return c.Status(fiber.StatusOK).SendStream(content, int(attachment.Size))
+}
+
+func (h *Handlers) deleteStockMovementAttachment(c *fiber.Ctx) error {
+	stockMovementId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	attachmentId, err := getAttachmentIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	attachment, err := h.sm.DeleteAttachment(c.Context(), stockMovementId, attachmentId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(attachment)
+}
@@ -0,0 +1,168 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+)
+
+func (h *Handlers) RegisterBomRoutes() {
+	g := h.app.Group("/products/:id/bom")
+
+	g.Get("/", h.getProductBoms)
+	g.Get("/:version", h.getBomByVersion)
+	g.Post("/", h.createBom)
+	g.Put("/:version", h.updateBom)
+	g.Delete("/:version", h.deleteBom)
+}
+
+func (h *Handlers) getVersionParam(c *fiber.Ctx) (int, error) {
+	version, err := c.ParamsInt("version")
+	if err != nil || version <= 0 {
+		return 0, constants.InvalidParams("invalid version on url")
+	}
+
+	return version, nil
+}
+
+type BomItemBody struct {
+	ComponentProductID uuid.UUID       `json:"componentProductId"`
+	Quantity           decimal.Decimal `json:"quantity"`
+	ScrapPercentage    decimal.Decimal `json:"scrapPercentage"`
+}
+
+func toBomItemParams(items []*BomItemBody) []*services.BomItemParams {
+	params := make([]*services.BomItemParams, 0, len(items))
+	for _, item := range items {
+		componentProductID := pgxuuid.UUID(item.ComponentProductID.Bytes())
+		params = append(params, &services.BomItemParams{
+			ComponentProductID: &componentProductID,
+			Quantity:           item.Quantity,
+			ScrapPercentage:    item.ScrapPercentage,
+		})
+	}
+
+	return params
+}
+
+func (h *Handlers) getProductBoms(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	boms, err := h.sm.FetchProductBoms(c.Context(), productId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(boms)
+}
+
+func (h *Handlers) getBomByVersion(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := h.getVersionParam(c)
+	if err != nil {
+		return err
+	}
+
+	bom, err := h.sm.FetchBomByVersion(c.Context(), productId, version)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(bom)
+}
+
+type CreateBomBody struct {
+	Notes string         `json:"notes"`
+	Items []*BomItemBody `json:"items"`
+}
+
+func (h *Handlers) createBom(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(CreateBomBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	bom, err := h.sm.CreateBom(c.Context(), &services.CreateBomParams{
+		ProductID: productId,
+		Notes:     body.Notes,
+		Items:     toBomItemParams(body.Items),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(bom)
+}
+
+type UpdateBomBody struct {
+	Status string         `json:"status"`
+	Notes  string         `json:"notes"`
+	Items  []*BomItemBody `json:"items"`
+}
+
+func (h *Handlers) updateBom(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := h.getVersionParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(UpdateBomBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	bom, err := h.sm.UpdateBom(c.Context(), &services.UpdateBomParams{
+		ProductID: productId,
+		Version:   version,
+		Status:    body.Status,
+		Notes:     body.Notes,
+		Items:     toBomItemParams(body.Items),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(bom)
+}
+
+func (h *Handlers) deleteBom(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := h.getVersionParam(c)
+	if err != nil {
+		return err
+	}
+
+	bom, err := h.sm.DeleteBom(c.Context(), &services.DeleteBomParams{
+		ProductID: productId,
+		Version:   version,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(bom)
+}
@@ -0,0 +1,151 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+func (h *Handlers) RegisterExchangeRateRoutes() {
+	g := h.app.Group("/exchange_rates")
+
+	g.Get("/", h.getAllExchangeRates)
+	g.Get("/:id", h.getExchangeRateById)
+	g.Post("/", h.createExchangeRate)
+	g.Put("/:id", h.updateExchangeRate)
+	g.Delete("/:id", h.deleteExchangeRate)
+}
+
+type GetAllExchangeRatesQuery struct {
+	Currency string `query:"currency"`
+	From     string `query:"from"`
+	To       string `query:"to"`
+	Limit    int    `query:"limit"`
+	Offset   int    `query:"offset"`
+}
+
+func (h *Handlers) getAllExchangeRates(c *fiber.Ctx) error {
+	params := new(GetAllExchangeRatesQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidBody()
+	}
+
+	if params.Limit <= 9 {
+		params.Limit = 10
+	}
+
+	from, err := parseOptionalDate(params.From, "from")
+	if err != nil {
+		return err
+	}
+
+	to, err := parseOptionalDate(params.To, "to")
+	if err != nil {
+		return err
+	}
+
+	response, err := h.sm.FetchExchangeRates(c.Context(), &services.FetchExchangeRatesParams{
+		Currency: params.Currency,
+		From:     from,
+		To:       to,
+		Limit:    params.Limit,
+		Offset:   params.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *Handlers) getExchangeRateById(c *fiber.Ctx) error {
+	exchangeRateId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	exchangeRate, err := h.sm.FetchExchangeRateByID(c.Context(), exchangeRateId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(exchangeRate)
+}
+
+type CreateExchangeRateBody struct {
+	Currency string          `json:"currency"`
+	Date     string          `json:"date"`
+	Rate     decimal.Decimal `json:"rate"`
+}
+
+func (h *Handlers) createExchangeRate(c *fiber.Ctx) error {
+	body := new(CreateExchangeRateBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	date, err := time.Parse("2006-01-02", body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	exchangeRate, err := h.sm.CreateExchangeRate(c.Context(), &services.CreateExchangeRateParams{
+		Currency: body.Currency,
+		Date:     date,
+		Rate:     body.Rate,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(exchangeRate)
+}
+
+type UpdateExchangeRateBody struct {
+	Date string          `json:"date"`
+	Rate decimal.Decimal `json:"rate"`
+}
+
+func (h *Handlers) updateExchangeRate(c *fiber.Ctx) error {
+	exchangeRateId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(UpdateExchangeRateBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	date, err := time.Parse("2006-01-02", body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	exchangeRate, err := h.sm.UpdateExchangeRate(c.Context(), &services.UpdateExchangeRateParams{
+		ID:   exchangeRateId,
+		Date: date,
+		Rate: body.Rate,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(exchangeRate)
+}
+
+func (h *Handlers) deleteExchangeRate(c *fiber.Ctx) error {
+	exchangeRateId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	exchangeRate, err := h.sm.DeleteExchangeRate(c.Context(), exchangeRateId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(exchangeRate)
+}
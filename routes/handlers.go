@@ -7,6 +7,7 @@ import (
 	"github.com/hoffax/prodrest/constants"
 	"github.com/hoffax/prodrest/services"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"time"
 )
 
 type Handlers struct {
@@ -37,3 +38,34 @@ func (h *Handlers) getIdParam(c *fiber.Ctx) (*pgxuuid.UUID, error) {
 	return &id, nil
 
 }
+
+// parseOptionalDate parses a yyyy-mm-dd date, an empty value is returned as nil
+func parseOptionalDate(value string, field string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, value)
+	if err != nil {
+		return nil, constants.InvalidParams("invalid " + field + " format")
+	}
+
+	return &date, nil
+}
+
+// getUserID returns the id of the authenticated user set by the auth middleware
+func (h *Handlers) getUserID(c *fiber.Ctx) (*pgxuuid.UUID, error) {
+	userIDStr, ok := c.Locals("userId").(string)
+	if !ok {
+		return nil, fiber.ErrUnauthorized
+	}
+
+	userID, err := uuid.FromString(userIDStr)
+	if err != nil {
+		return nil, fiber.ErrUnauthorized
+	}
+
+	pgxUserID := pgxuuid.UUID(userID.Bytes())
+	return &pgxUserID, nil
+}
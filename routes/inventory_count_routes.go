@@ -0,0 +1,237 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/middleware"
+	"github.com/hoffax/prodrest/services"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+func (h *Handlers) RegisterInventoryCountRoutes() {
+	g := h.app.Group("/inventory_counts")
+
+	g.Get("/", h.getAllInventoryCounts)
+	g.Get("/:id", h.getInventoryCountById)
+	g.Post("/", middleware.RequireRoles(constants.RoleAdmin), h.createInventoryCount)
+	g.Post("/:id/counts", h.submitInventoryCount)
+	g.Post("/:id/close", middleware.RequireRoles(constants.RoleAdmin), h.closeInventoryCount)
+	g.Post("/:id/cancel", middleware.RequireRoles(constants.RoleAdmin), h.cancelInventoryCount)
+}
+
+type GetAllInventoryCountsQuery struct {
+	StatusOptions []string   `query:"status"`
+	WarehouseID   *uuid.UUID `query:"warehouseId"`
+	Limit         int        `query:"limit"`
+	Offset        int        `query:"offset"`
+}
+
+func (h *Handlers) getAllInventoryCounts(c *fiber.Ctx) error {
+	params := new(GetAllInventoryCountsQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 10
+	}
+
+	statusOptions := make([]string, 0)
+	for _, status := range params.StatusOptions {
+		statusOptions = append(statusOptions, strings.ToUpper(status))
+	}
+
+	response, err := h.sm.FetchInventoryCounts(c.Context(), &services.FetchInventoryCountsParams{
+		StatusOptions: statusOptions,
+		WarehouseID:   toPgxUUID(params.WarehouseID),
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *Handlers) getInventoryCountById(c *fiber.Ctx) error {
+	inventoryCountId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	inventoryCount, err := h.sm.FetchInventoryCountByID(c.Context(), inventoryCountId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(inventoryCount)
+}
+
+type InventoryCountBody struct {
+	WarehouseID *uuid.UUID `json:"warehouseId"`
+	// ProductIDs limits the count to those products, every product of the
+	// warehouse is counted when missing
+	ProductIDs []*uuid.UUID `json:"productIds"`
+	Date       string       `json:"date"`
+	Notes      string       `json:"notes"`
+}
+
+func (h *Handlers) createInventoryCount(c *fiber.Ctx) error {
+	body := new(InventoryCountBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var productIDs []*pgxuuid.UUID
+	if body.ProductIDs != nil {
+		productIDs = make([]*pgxuuid.UUID, 0, len(body.ProductIDs))
+		for _, productID := range body.ProductIDs {
+			productIDs = append(productIDs, toPgxUUID(productID))
+		}
+	}
+
+	inventoryCount, err := h.sm.CreateInventoryCount(c.Context(), &services.CreateInventoryCountParams{
+		WarehouseID: toPgxUUID(body.WarehouseID),
+		ProductIDs:  productIDs,
+		Date:        date,
+		Notes:       strings.TrimSpace(body.Notes),
+		UserID:      userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(inventoryCount)
+}
+
+type InventoryCountItemBody struct {
+	ProductID *uuid.UUID      `json:"productId"`
+	Batch     string          `json:"batch"`
+	Unit      string          `json:"unit"`
+	Quantity  decimal.Decimal `json:"quantity"`
+}
+
+type SubmitInventoryCountBody struct {
+	// Device identifies the counting device, a device submitting a product
+	// again replaces what it counted before
+	Device string                    `json:"device"`
+	Items  []*InventoryCountItemBody `json:"items"`
+}
+
+func (h *Handlers) submitInventoryCount(c *fiber.Ctx) error {
+	inventoryCountId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(SubmitInventoryCountBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*services.InventoryCountItem, 0, len(body.Items))
+	for _, item := range body.Items {
+		items = append(items, &services.InventoryCountItem{
+			ProductID: toPgxUUID(item.ProductID),
+			Batch:     strings.TrimSpace(item.Batch),
+			Unit:      item.Unit,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	inventoryCount, err := h.sm.SubmitInventoryCount(c.Context(), &services.SubmitInventoryCountParams{
+		ID:     inventoryCountId,
+		Device: strings.TrimSpace(body.Device),
+		UserID: userID,
+		Items:  items,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(inventoryCount)
+}
+
+type CloseInventoryCountBody struct {
+	Date          string `json:"date"`
+	ZeroUncounted bool   `json:"zeroUncounted"`
+}
+
+func (h *Handlers) closeInventoryCount(c *fiber.Ctx) error {
+	inventoryCountId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(CloseInventoryCountBody)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(body); err != nil {
+			return constants.InvalidBody()
+		}
+	}
+
+	date, err := parseOptionalDate(body.Date, "date")
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	inventoryCount, err := h.sm.CloseInventoryCount(c.Context(), &services.CloseInventoryCountParams{
+		ID:            inventoryCountId,
+		UserID:        userID,
+		Date:          date,
+		ZeroUncounted: body.ZeroUncounted,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(inventoryCount)
+}
+
+func (h *Handlers) cancelInventoryCount(c *fiber.Ctx) error {
+	inventoryCountId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	inventoryCount, err := h.sm.CancelInventoryCount(c.Context(), &services.CancelInventoryCountParams{
+		ID:     inventoryCountId,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(inventoryCount)
+}
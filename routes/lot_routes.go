@@ -0,0 +1,137 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+)
+
+func (h *Handlers) RegisterLotRoutes() {
+	g := h.app.Group("/lots")
+
+	g.Get("/:id", h.getLotById)
+	g.Post("/", h.createLot)
+	g.Put("/:id", h.updateLot)
+
+	// lots and remaining quantities of a product
+	h.app.Get("/products/:id/lots", h.getProductLots)
+}
+
+type GetProductLotsQuery struct {
+	StatusOptions []string `query:"status"`
+}
+
+func (h *Handlers) getProductLots(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	params := new(GetProductLotsQuery)
+	if err := c.QueryParser(params); err != nil {
+		return err
+	}
+
+	lots, err := h.sm.FetchProductLots(c.Context(), &services.FetchProductLotsParams{
+		ProductID:     productId,
+		StatusOptions: params.StatusOptions,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lots)
+}
+
+func (h *Handlers) getLotById(c *fiber.Ctx) error {
+	lotId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	lot, err := h.sm.FetchLotByID(c.Context(), lotId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lot)
+}
+
+type CreateLotBody struct {
+	ProductID       uuid.UUID `json:"productId"`
+	Code            string    `json:"code"`
+	ManufactureDate string    `json:"manufactureDate"`
+	ExpiryDate      string    `json:"expiryDate"`
+}
+
+func (h *Handlers) createLot(c *fiber.Ctx) error {
+	body := new(CreateLotBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	manufactureDate, err := parseOptionalDate(body.ManufactureDate, "manufactureDate")
+	if err != nil {
+		return err
+	}
+
+	expiryDate, err := parseOptionalDate(body.ExpiryDate, "expiryDate")
+	if err != nil {
+		return err
+	}
+
+	productID := pgxuuid.UUID(body.ProductID.Bytes())
+	lot, err := h.sm.CreateLot(c.Context(), &services.CreateLotParams{
+		ProductID:       &productID,
+		Code:            body.Code,
+		ManufactureDate: manufactureDate,
+		ExpiryDate:      expiryDate,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(lot)
+}
+
+type UpdateLotBody struct {
+	Status          string `json:"status"`
+	ManufactureDate string `json:"manufactureDate"`
+	ExpiryDate      string `json:"expiryDate"`
+}
+
+func (h *Handlers) updateLot(c *fiber.Ctx) error {
+	lotId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(UpdateLotBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	manufactureDate, err := parseOptionalDate(body.ManufactureDate, "manufactureDate")
+	if err != nil {
+		return err
+	}
+
+	expiryDate, err := parseOptionalDate(body.ExpiryDate, "expiryDate")
+	if err != nil {
+		return err
+	}
+
+	lot, err := h.sm.UpdateLot(c.Context(), &services.UpdateLotParams{
+		ID:              lotId,
+		Status:          body.Status,
+		ManufactureDate: manufactureDate,
+		ExpiryDate:      expiryDate,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lot)
+}
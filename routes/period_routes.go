@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/middleware"
+	"github.com/hoffax/prodrest/services"
+	"strings"
+	"time"
+)
+
+func (h *Handlers) RegisterPeriodRoutes() {
+	g := h.app.Group("/periods")
+
+	g.Get("/", h.getAllPeriods)
+	g.Get("/:period", h.getPeriod)
+	g.Post("/:period/close", middleware.RequireRoles(constants.RoleAdmin), h.closePeriod)
+	g.Post("/:period/reopen", middleware.RequireRoles(constants.RoleAdmin), h.reopenPeriod)
+}
+
+// getPeriodParam parses the yyyy-mm period on the url into the first day of
+// the month
+func getPeriodParam(c *fiber.Ctx) (time.Time, error) {
+	period, err := time.Parse("2006-01", c.Params("period"))
+	if err != nil {
+		return time.Time{}, constants.InvalidParams("invalid period on url, expected yyyy-mm")
+	}
+
+	return period, nil
+}
+
+type GetAllPeriodsQuery struct {
+	StatusOptions []string `query:"status"`
+}
+
+func (h *Handlers) getAllPeriods(c *fiber.Ctx) error {
+	params := new(GetAllPeriodsQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	statusOptions := make([]string, 0)
+	for _, status := range params.StatusOptions {
+		statusOptions = append(statusOptions, strings.ToUpper(status))
+	}
+
+	periods, err := h.sm.FetchPeriods(c.Context(), &services.FetchPeriodsParams{
+		StatusOptions: statusOptions,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(periods)
+}
+
+func (h *Handlers) getPeriod(c *fiber.Ctx) error {
+	period, err := getPeriodParam(c)
+	if err != nil {
+		return err
+	}
+
+	periodDTO, err := h.sm.FetchPeriod(c.Context(), period)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(periodDTO)
+}
+
+type PeriodStatusBody struct {
+	Reason string `json:"reason"`
+}
+
+func (h *Handlers) closePeriod(c *fiber.Ctx) error {
+	period, err := getPeriodParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(PeriodStatusBody)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(body); err != nil {
+			return constants.InvalidBody()
+		}
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	periodDTO, err := h.sm.ClosePeriod(c.Context(), &services.ClosePeriodParams{
+		Period: period,
+		UserID: userID,
+		Reason: strings.TrimSpace(body.Reason),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(periodDTO)
+}
+
+func (h *Handlers) reopenPeriod(c *fiber.Ctx) error {
+	period, err := getPeriodParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(PeriodStatusBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	periodDTO, err := h.sm.ReopenPeriod(c.Context(), &services.ReopenPeriodParams{
+		Period: period,
+		UserID: userID,
+		Reason: strings.TrimSpace(body.Reason),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(periodDTO)
+}
@@ -2,8 +2,10 @@ package routes
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
 	"github.com/hoffax/prodrest/constants"
 	"github.com/hoffax/prodrest/services"
+	"github.com/shopspring/decimal"
 )
 
 func (h *Handlers) RegisterProductRoutes() {
@@ -12,6 +14,7 @@ func (h *Handlers) RegisterProductRoutes() {
 	g.Get("/:id", h.getProductById)
 	g.Post("/", h.createProduct)
 	g.Put("/:id", h.updateProduct)
+	g.Get("/:id/kardex", h.getProductKardex)
 
 	h.app.Get("/check_barcode/:barcode", h.checkBarcode)
 }
@@ -61,11 +64,16 @@ func (h *Handlers) getProductById(c *fiber.Ctx) error {
 }
 
 type CreateProductBody struct {
-	Name             string `json:"name"`
-	Barcode          string `json:"barcode"`
-	Unit             string `json:"unit" `
-	BatchControl     bool   `json:"batchControl"`
-	ConversionFactor int    `json:"conversionFactor" `
+	Name                string           `json:"name"`
+	Barcode             string           `json:"barcode"`
+	Unit                string           `json:"unit" `
+	BatchControl        bool             `json:"batchControl"`
+	LotAllocation       string           `json:"lotAllocation"`
+	AllowNegativeStock  bool             `json:"allowNegativeStock"`
+	TaxRate             string           `json:"taxRate"`
+	MinStock            *decimal.Decimal `json:"minStock"`
+	ReorderPoint        *decimal.Decimal `json:"reorderPoint"`
+	PreferredSupplierID *uuid.UUID       `json:"preferredSupplierId"`
 }
 
 func (h *Handlers) createProduct(c *fiber.Ctx) error {
@@ -75,11 +83,16 @@ func (h *Handlers) createProduct(c *fiber.Ctx) error {
 	}
 
 	product, err := h.sm.CreateProduct(c.Context(), &services.CreateProductParams{
-		Name:             params.Name,
-		Barcode:          params.Barcode,
-		Unit:             params.Unit,
-		BatchControl:     params.BatchControl,
-		ConversionFactor: params.ConversionFactor,
+		Name:                params.Name,
+		Barcode:             params.Barcode,
+		Unit:                params.Unit,
+		BatchControl:        params.BatchControl,
+		LotAllocation:       params.LotAllocation,
+		AllowNegativeStock:  params.AllowNegativeStock,
+		TaxRate:             params.TaxRate,
+		MinStock:            params.MinStock,
+		ReorderPoint:        params.ReorderPoint,
+		PreferredSupplierID: toPgxUUID(params.PreferredSupplierID),
 	})
 	if err != nil {
 		return err
@@ -89,12 +102,17 @@ func (h *Handlers) createProduct(c *fiber.Ctx) error {
 }
 
 type UpdateProductBody struct {
-	Status           string `json:"status"`
-	Name             string `json:"name"`
-	Barcode          string `json:"barcode"`
-	Unit             string `json:"unit" `
-	BatchControl     bool   `json:"batchControl"`
-	ConversionFactor int    `json:"conversionFactor" `
+	Status              string           `json:"status"`
+	Name                string           `json:"name"`
+	Barcode             string           `json:"barcode"`
+	Unit                string           `json:"unit" `
+	BatchControl        bool             `json:"batchControl"`
+	LotAllocation       string           `json:"lotAllocation"`
+	AllowNegativeStock  bool             `json:"allowNegativeStock"`
+	TaxRate             string           `json:"taxRate"`
+	MinStock            *decimal.Decimal `json:"minStock"`
+	ReorderPoint        *decimal.Decimal `json:"reorderPoint"`
+	PreferredSupplierID *uuid.UUID       `json:"preferredSupplierId"`
 }
 
 func (h *Handlers) updateProduct(c *fiber.Ctx) error {
@@ -109,13 +127,18 @@ func (h *Handlers) updateProduct(c *fiber.Ctx) error {
 	}
 
 	product, err := h.sm.UpdateProduct(c.Context(), &services.UpdateProductParams{
-		ID:               productId,
-		Status:           params.Status,
-		Name:             params.Name,
-		Barcode:          params.Barcode,
-		Unit:             params.Unit,
-		BatchControl:     params.BatchControl,
-		ConversionFactor: params.ConversionFactor,
+		ID:                  productId,
+		Status:              params.Status,
+		Name:                params.Name,
+		Barcode:             params.Barcode,
+		Unit:                params.Unit,
+		BatchControl:        params.BatchControl,
+		LotAllocation:       params.LotAllocation,
+		AllowNegativeStock:  params.AllowNegativeStock,
+		TaxRate:             params.TaxRate,
+		MinStock:            params.MinStock,
+		ReorderPoint:        params.ReorderPoint,
+		PreferredSupplierID: toPgxUUID(params.PreferredSupplierID),
 	})
 	if err != nil {
 		return err
@@ -134,3 +157,41 @@ func (h *Handlers) checkBarcode(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(product)
 }
+
+type GetProductKardexQuery struct {
+	From string `query:"from"`
+	To   string `query:"to"`
+}
+
+func (h *Handlers) getProductKardex(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	params := new(GetProductKardexQuery)
+	if err := c.QueryParser(params); err != nil {
+		return err
+	}
+
+	from, err := parseOptionalDate(params.From, "from")
+	if err != nil {
+		return err
+	}
+
+	to, err := parseOptionalDate(params.To, "to")
+	if err != nil {
+		return err
+	}
+
+	kardex, err := h.sm.FetchProductKardex(c.Context(), &services.FetchProductKardexParams{
+		ProductID: productId,
+		From:      from,
+		To:        to,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(kardex)
+}
@@ -0,0 +1,294 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+func (h *Handlers) RegisterProductionOrderRoutes() {
+	g := h.app.Group("/production_orders")
+
+	g.Get("/", h.getAllProductionOrders)
+	g.Get("/:id", h.getProductionOrderById)
+	g.Post("/", h.createProductionOrder)
+	g.Put("/:id", h.updateProductionOrder)
+	g.Post("/:id/start", h.startProductionOrder)
+	g.Post("/:id/complete", h.completeProductionOrder)
+	g.Post("/:id/cancel", h.cancelProductionOrder)
+
+	h.app.Get("/reports/production_variance", h.getProductionVariance)
+}
+
+type GetAllProductionOrdersQuery struct {
+	StatusOptions []string   `query:"status"`
+	ProductID     *uuid.UUID `query:"productId"`
+	Limit         int        `query:"limit"`
+	Offset        int        `query:"offset"`
+}
+
+func (h *Handlers) getAllProductionOrders(c *fiber.Ctx) error {
+	params := new(GetAllProductionOrdersQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 10
+	}
+
+	response, err := h.sm.FetchProductionOrders(c.Context(), &services.FetchProductionOrdersParams{
+		StatusOptions: params.StatusOptions,
+		ProductID:     toPgxUUID(params.ProductID),
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *Handlers) getProductionOrderById(c *fiber.Ctx) error {
+	productionOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	productionOrder, err := h.sm.FetchProductionOrderByID(c.Context(), productionOrderId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(productionOrder)
+}
+
+type CreateProductionOrderBody struct {
+	ProductID             uuid.UUID       `json:"productId"`
+	BomVersion            int             `json:"bomVersion"`
+	PlannedQuantity       decimal.Decimal `json:"plannedQuantity"`
+	Date                  string          `json:"date"`
+	ComponentsWarehouseID *uuid.UUID      `json:"componentsWarehouseId"`
+	OutputWarehouseID     *uuid.UUID      `json:"outputWarehouseId"`
+	Batch                 string          `json:"batch"`
+	Notes                 string          `json:"notes"`
+}
+
+func (h *Handlers) createProductionOrder(c *fiber.Ctx) error {
+	body := new(CreateProductionOrderBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	productID := pgxuuid.UUID(body.ProductID.Bytes())
+	productionOrder, err := h.sm.CreateProductionOrder(c.Context(), &services.CreateProductionOrderParams{
+		ProductID:             &productID,
+		BomVersion:            body.BomVersion,
+		PlannedQuantity:       body.PlannedQuantity,
+		Date:                  date,
+		ComponentsWarehouseID: toPgxUUID(body.ComponentsWarehouseID),
+		OutputWarehouseID:     toPgxUUID(body.OutputWarehouseID),
+		Batch:                 body.Batch,
+		Notes:                 body.Notes,
+		UserID:                userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(productionOrder)
+}
+
+type UpdateProductionOrderBody struct {
+	BomVersion            int             `json:"bomVersion"`
+	PlannedQuantity       decimal.Decimal `json:"plannedQuantity"`
+	Date                  string          `json:"date"`
+	ComponentsWarehouseID *uuid.UUID      `json:"componentsWarehouseId"`
+	OutputWarehouseID     *uuid.UUID      `json:"outputWarehouseId"`
+	Batch                 string          `json:"batch"`
+	Notes                 string          `json:"notes"`
+}
+
+func (h *Handlers) updateProductionOrder(c *fiber.Ctx) error {
+	productionOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(UpdateProductionOrderBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	productionOrder, err := h.sm.UpdateProductionOrder(c.Context(), &services.UpdateProductionOrderParams{
+		ID:                    productionOrderId,
+		BomVersion:            body.BomVersion,
+		PlannedQuantity:       body.PlannedQuantity,
+		Date:                  date,
+		ComponentsWarehouseID: toPgxUUID(body.ComponentsWarehouseID),
+		OutputWarehouseID:     toPgxUUID(body.OutputWarehouseID),
+		Batch:                 body.Batch,
+		Notes:                 body.Notes,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(productionOrder)
+}
+
+func (h *Handlers) startProductionOrder(c *fiber.Ctx) error {
+	productionOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	productionOrder, err := h.sm.StartProductionOrder(c.Context(), productionOrderId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(productionOrder)
+}
+
+type ProductionConsumptionBody struct {
+	ProductID uuid.UUID       `json:"productId"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	Batch     string          `json:"batch"`
+}
+
+type CompleteProductionOrderBody struct {
+	Date             string                       `json:"date"`
+	ProducedQuantity decimal.Decimal              `json:"producedQuantity"`
+	LabourCost       decimal.Decimal              `json:"labourCost"`
+	OverheadCost     decimal.Decimal              `json:"overheadCost"`
+	Batch            string                       `json:"batch"`
+	Consumption      []*ProductionConsumptionBody `json:"consumption"`
+}
+
+func (h *Handlers) completeProductionOrder(c *fiber.Ctx) error {
+	productionOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(CompleteProductionOrderBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	consumption := make([]*services.ProductionConsumptionParams, 0, len(body.Consumption))
+	for _, item := range body.Consumption {
+		productID := pgxuuid.UUID(item.ProductID.Bytes())
+		consumption = append(consumption, &services.ProductionConsumptionParams{
+			ProductID: &productID,
+			Quantity:  item.Quantity,
+			Batch:     item.Batch,
+		})
+	}
+
+	productionOrder, err := h.sm.CompleteProductionOrder(c.Context(), &services.CompleteProductionOrderParams{
+		ID:               productionOrderId,
+		Date:             date,
+		ProducedQuantity: body.ProducedQuantity,
+		LabourCost:       body.LabourCost,
+		OverheadCost:     body.OverheadCost,
+		Batch:            body.Batch,
+		Consumption:      consumption,
+		UserID:           userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(productionOrder)
+}
+
+func (h *Handlers) cancelProductionOrder(c *fiber.Ctx) error {
+	productionOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	productionOrder, err := h.sm.CancelProductionOrder(c.Context(), &services.CancelProductionOrderParams{
+		ID:     productionOrderId,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(productionOrder)
+}
+
+type GetProductionVarianceQuery struct {
+	From      string     `query:"from"`
+	To        string     `query:"to"`
+	ProductID *uuid.UUID `query:"productId"`
+}
+
+func (h *Handlers) getProductionVariance(c *fiber.Ctx) error {
+	params := new(GetProductionVarianceQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	from, err := parseOptionalDate(params.From, "from")
+	if err != nil {
+		return err
+	}
+
+	to, err := parseOptionalDate(params.To, "to")
+	if err != nil {
+		return err
+	}
+
+	report, err := h.sm.FetchProductionVariance(c.Context(), &services.FetchProductionVarianceParams{
+		From:      from,
+		To:        to,
+		ProductID: toPgxUUID(params.ProductID),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(report)
+}
@@ -0,0 +1,304 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+func (h *Handlers) RegisterPurchaseOrderRoutes() {
+	g := h.app.Group("/purchase_orders")
+
+	g.Get("/", h.getAllPurchaseOrders)
+	g.Get("/open_lines", h.getOpenPurchaseOrderLines)
+	g.Get("/:id", h.getPurchaseOrderById)
+	g.Post("/", h.createPurchaseOrder)
+	g.Put("/:id", h.updatePurchaseOrder)
+	g.Post("/:id/send", h.sendPurchaseOrder)
+	g.Post("/:id/close", h.closePurchaseOrder)
+	g.Post("/:id/receive", h.receivePurchaseOrder)
+}
+
+type GetAllPurchaseOrdersQuery struct {
+	StatusOptions []string   `query:"status"`
+	EntityID      *uuid.UUID `query:"entityId"`
+	ProductID     *uuid.UUID `query:"productId"`
+	Limit         int        `query:"limit"`
+	Offset        int        `query:"offset"`
+}
+
+func (h *Handlers) getAllPurchaseOrders(c *fiber.Ctx) error {
+	params := new(GetAllPurchaseOrdersQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 10
+	}
+
+	statusOptions := make([]string, 0)
+	for _, status := range params.StatusOptions {
+		statusOptions = append(statusOptions, strings.ToUpper(status))
+	}
+
+	response, err := h.sm.FetchPurchaseOrders(c.Context(), &services.FetchPurchaseOrdersParams{
+		StatusOptions: statusOptions,
+		EntityID:      toPgxUUID(params.EntityID),
+		ProductID:     toPgxUUID(params.ProductID),
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+type GetOpenPurchaseOrderLinesQuery struct {
+	EntityID  *uuid.UUID `query:"entityId"`
+	ProductID *uuid.UUID `query:"productId"`
+}
+
+func (h *Handlers) getOpenPurchaseOrderLines(c *fiber.Ctx) error {
+	params := new(GetOpenPurchaseOrderLinesQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	lines, err := h.sm.FetchOpenPurchaseOrderLines(c.Context(), &services.FetchOpenPurchaseOrderLinesParams{
+		EntityID:  toPgxUUID(params.EntityID),
+		ProductID: toPgxUUID(params.ProductID),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lines)
+}
+
+func (h *Handlers) getPurchaseOrderById(c *fiber.Ctx) error {
+	purchaseOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	purchaseOrder, err := h.sm.FetchPurchaseOrderByID(c.Context(), purchaseOrderId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(purchaseOrder)
+}
+
+type PurchaseOrderLineBody struct {
+	ProductID *uuid.UUID      `json:"productId"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	Price     decimal.Decimal `json:"price"`
+}
+
+type PurchaseOrderBody struct {
+	EntityID     *uuid.UUID               `json:"entityId"`
+	Date         string                   `json:"date"`
+	ExpectedDate string                   `json:"expectedDate"`
+	Currency     string                   `json:"currency"`
+	Notes        string                   `json:"notes"`
+	Lines        []*PurchaseOrderLineBody `json:"lines"`
+}
+
+func toPurchaseOrderLineParams(lines []*PurchaseOrderLineBody) []*services.PurchaseOrderLineParams {
+	params := make([]*services.PurchaseOrderLineParams, 0, len(lines))
+	for _, line := range lines {
+		params = append(params, &services.PurchaseOrderLineParams{
+			ProductID: toPgxUUID(line.ProductID),
+			Quantity:  line.Quantity,
+			Price:     line.Price,
+		})
+	}
+
+	return params
+}
+
+func (h *Handlers) createPurchaseOrder(c *fiber.Ctx) error {
+	body := new(PurchaseOrderBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	expectedDate, err := parseOptionalDate(body.ExpectedDate, "expectedDate")
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	purchaseOrder, err := h.sm.CreatePurchaseOrder(c.Context(), &services.CreatePurchaseOrderParams{
+		EntityID:     toPgxUUID(body.EntityID),
+		Date:         date,
+		ExpectedDate: expectedDate,
+		Currency:     body.Currency,
+		Notes:        strings.TrimSpace(body.Notes),
+		UserID:       userID,
+		Lines:        toPurchaseOrderLineParams(body.Lines),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(purchaseOrder)
+}
+
+func (h *Handlers) updatePurchaseOrder(c *fiber.Ctx) error {
+	purchaseOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(PurchaseOrderBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	expectedDate, err := parseOptionalDate(body.ExpectedDate, "expectedDate")
+	if err != nil {
+		return err
+	}
+
+	purchaseOrder, err := h.sm.UpdatePurchaseOrder(c.Context(), &services.UpdatePurchaseOrderParams{
+		ID:           purchaseOrderId,
+		EntityID:     toPgxUUID(body.EntityID),
+		Date:         date,
+		ExpectedDate: expectedDate,
+		Currency:     body.Currency,
+		Notes:        strings.TrimSpace(body.Notes),
+		Lines:        toPurchaseOrderLineParams(body.Lines),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(purchaseOrder)
+}
+
+func (h *Handlers) sendPurchaseOrder(c *fiber.Ctx) error {
+	purchaseOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	purchaseOrder, err := h.sm.SendPurchaseOrder(c.Context(), purchaseOrderId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(purchaseOrder)
+}
+
+func (h *Handlers) closePurchaseOrder(c *fiber.Ctx) error {
+	purchaseOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	purchaseOrder, err := h.sm.ClosePurchaseOrder(c.Context(), &services.ClosePurchaseOrderParams{
+		ID:     purchaseOrderId,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(purchaseOrder)
+}
+
+type ReceivePurchaseOrderItemBody struct {
+	LineID      *uuid.UUID       `json:"lineId"`
+	Quantity    decimal.Decimal  `json:"quantity"`
+	Price       *decimal.Decimal `json:"price"`
+	Unit        string           `json:"unit"`
+	Batch       string           `json:"batch"`
+	WarehouseID *uuid.UUID       `json:"warehouseId"`
+}
+
+type ReceivePurchaseOrderBody struct {
+	Date          string                          `json:"date"`
+	WarehouseID   *uuid.UUID                      `json:"warehouseId"`
+	InvoiceNumber string                          `json:"invoiceNumber"`
+	ExchangeRate  decimal.Decimal                 `json:"exchangeRate"`
+	Items         []*ReceivePurchaseOrderItemBody `json:"items"`
+}
+
+func (h *Handlers) receivePurchaseOrder(c *fiber.Ctx) error {
+	purchaseOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(ReceivePurchaseOrderBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*services.ReceivePurchaseOrderItem, 0, len(body.Items))
+	for _, item := range body.Items {
+		items = append(items, &services.ReceivePurchaseOrderItem{
+			LineID:      toPgxUUID(item.LineID),
+			Quantity:    item.Quantity,
+			Price:       item.Price,
+			Unit:        item.Unit,
+			Batch:       item.Batch,
+			WarehouseID: toPgxUUID(item.WarehouseID),
+		})
+	}
+
+	stockMovement, err := h.sm.ReceivePurchaseOrder(c.Context(), &services.ReceivePurchaseOrderParams{
+		ID:            purchaseOrderId,
+		Date:          date,
+		WarehouseID:   toPgxUUID(body.WarehouseID),
+		InvoiceNumber: strings.TrimSpace(body.InvoiceNumber),
+		ExchangeRate:  body.ExchangeRate,
+		UserID:        userID,
+		Items:         items,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(stockMovement)
+}
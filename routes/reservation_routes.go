@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+func (h *Handlers) RegisterReservationRoutes() {
+	g := h.app.Group("/reservations")
+
+	g.Get("/", h.getAllReservations)
+	g.Get("/:id", h.getReservationById)
+	g.Post("/", h.createReservation)
+	g.Post("/:id/release", h.releaseReservation)
+}
+
+type GetAllReservationsQuery struct {
+	StatusOptions []string   `query:"status"`
+	ProductID     *uuid.UUID `query:"productId"`
+	WarehouseID   *uuid.UUID `query:"warehouseId"`
+	SourceID      *uuid.UUID `query:"sourceId"`
+	Limit         int        `query:"limit"`
+	Offset        int        `query:"offset"`
+}
+
+func (h *Handlers) getAllReservations(c *fiber.Ctx) error {
+	params := new(GetAllReservationsQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 10
+	}
+
+	statusOptions := make([]string, 0)
+	for _, status := range params.StatusOptions {
+		statusOptions = append(statusOptions, strings.ToUpper(status))
+	}
+
+	response, err := h.sm.FetchReservations(c.Context(), &services.FetchReservationsParams{
+		StatusOptions: statusOptions,
+		ProductID:     toPgxUUID(params.ProductID),
+		WarehouseID:   toPgxUUID(params.WarehouseID),
+		SourceID:      toPgxUUID(params.SourceID),
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *Handlers) getReservationById(c *fiber.Ctx) error {
+	reservationId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	reservation, err := h.sm.FetchReservationByID(c.Context(), reservationId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(reservation)
+}
+
+type ReservationBody struct {
+	ProductID   *uuid.UUID      `json:"productId"`
+	WarehouseID *uuid.UUID      `json:"warehouseId"`
+	Batch       string          `json:"batch"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	Reference   string          `json:"reference"`
+	ExpiresAt   *time.Time      `json:"expiresAt"`
+}
+
+func (h *Handlers) createReservation(c *fiber.Ctx) error {
+	body := new(ReservationBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	reservation, err := h.sm.CreateReservation(c.Context(), &services.CreateReservationParams{
+		ProductID:   toPgxUUID(body.ProductID),
+		WarehouseID: toPgxUUID(body.WarehouseID),
+		Batch:       strings.TrimSpace(body.Batch),
+		Quantity:    body.Quantity,
+		Reference:   strings.TrimSpace(body.Reference),
+		ExpiresAt:   body.ExpiresAt,
+		UserID:      userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(reservation)
+}
+
+func (h *Handlers) releaseReservation(c *fiber.Ctx) error {
+	reservationId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	reservation, err := h.sm.ReleaseReservation(c.Context(), &services.ReleaseReservationParams{
+		ID:     reservationId,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(reservation)
+}
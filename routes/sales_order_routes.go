@@ -0,0 +1,309 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+func (h *Handlers) RegisterSalesOrderRoutes() {
+	g := h.app.Group("/sales_orders")
+
+	g.Get("/", h.getAllSalesOrders)
+	g.Get("/backorders", h.getBackorders)
+	g.Get("/:id", h.getSalesOrderById)
+	g.Post("/", h.createSalesOrder)
+	g.Put("/:id", h.updateSalesOrder)
+	g.Post("/:id/confirm", h.confirmSalesOrder)
+	g.Post("/:id/cancel", h.cancelSalesOrder)
+	g.Post("/:id/deliver", h.deliverSalesOrder)
+}
+
+type GetAllSalesOrdersQuery struct {
+	StatusOptions []string   `query:"status"`
+	EntityID      *uuid.UUID `query:"entityId"`
+	ProductID     *uuid.UUID `query:"productId"`
+	Limit         int        `query:"limit"`
+	Offset        int        `query:"offset"`
+}
+
+func (h *Handlers) getAllSalesOrders(c *fiber.Ctx) error {
+	params := new(GetAllSalesOrdersQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 10
+	}
+
+	statusOptions := make([]string, 0)
+	for _, status := range params.StatusOptions {
+		statusOptions = append(statusOptions, strings.ToUpper(status))
+	}
+
+	response, err := h.sm.FetchSalesOrders(c.Context(), &services.FetchSalesOrdersParams{
+		StatusOptions: statusOptions,
+		EntityID:      toPgxUUID(params.EntityID),
+		ProductID:     toPgxUUID(params.ProductID),
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+type GetBackordersQuery struct {
+	EntityID  *uuid.UUID `query:"entityId"`
+	ProductID *uuid.UUID `query:"productId"`
+}
+
+func (h *Handlers) getBackorders(c *fiber.Ctx) error {
+	params := new(GetBackordersQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidParams("invalid query params")
+	}
+
+	backorders, err := h.sm.FetchBackorders(c.Context(), &services.FetchBackordersParams{
+		EntityID:  toPgxUUID(params.EntityID),
+		ProductID: toPgxUUID(params.ProductID),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(backorders)
+}
+
+func (h *Handlers) getSalesOrderById(c *fiber.Ctx) error {
+	salesOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	salesOrder, err := h.sm.FetchSalesOrderByID(c.Context(), salesOrderId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(salesOrder)
+}
+
+type SalesOrderLineBody struct {
+	ProductID *uuid.UUID      `json:"productId"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	Price     decimal.Decimal `json:"price"`
+}
+
+type SalesOrderBody struct {
+	EntityID    *uuid.UUID            `json:"entityId"`
+	WarehouseID *uuid.UUID            `json:"warehouseId"`
+	Date        string                `json:"date"`
+	ValidUntil  string                `json:"validUntil"`
+	Currency    string                `json:"currency"`
+	Notes       string                `json:"notes"`
+	Lines       []*SalesOrderLineBody `json:"lines"`
+}
+
+func toSalesOrderLineParams(lines []*SalesOrderLineBody) []*services.SalesOrderLineParams {
+	params := make([]*services.SalesOrderLineParams, 0, len(lines))
+	for _, line := range lines {
+		params = append(params, &services.SalesOrderLineParams{
+			ProductID: toPgxUUID(line.ProductID),
+			Quantity:  line.Quantity,
+			Price:     line.Price,
+		})
+	}
+
+	return params
+}
+
+func (h *Handlers) createSalesOrder(c *fiber.Ctx) error {
+	body := new(SalesOrderBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	validUntil, err := parseOptionalDate(body.ValidUntil, "validUntil")
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	salesOrder, err := h.sm.CreateSalesOrder(c.Context(), &services.CreateSalesOrderParams{
+		EntityID:    toPgxUUID(body.EntityID),
+		WarehouseID: toPgxUUID(body.WarehouseID),
+		Date:        date,
+		ValidUntil:  validUntil,
+		Currency:    body.Currency,
+		Notes:       strings.TrimSpace(body.Notes),
+		UserID:      userID,
+		Lines:       toSalesOrderLineParams(body.Lines),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(salesOrder)
+}
+
+func (h *Handlers) updateSalesOrder(c *fiber.Ctx) error {
+	salesOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(SalesOrderBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	validUntil, err := parseOptionalDate(body.ValidUntil, "validUntil")
+	if err != nil {
+		return err
+	}
+
+	salesOrder, err := h.sm.UpdateSalesOrder(c.Context(), &services.UpdateSalesOrderParams{
+		ID:          salesOrderId,
+		EntityID:    toPgxUUID(body.EntityID),
+		WarehouseID: toPgxUUID(body.WarehouseID),
+		Date:        date,
+		ValidUntil:  validUntil,
+		Currency:    body.Currency,
+		Notes:       strings.TrimSpace(body.Notes),
+		Lines:       toSalesOrderLineParams(body.Lines),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(salesOrder)
+}
+
+func (h *Handlers) confirmSalesOrder(c *fiber.Ctx) error {
+	salesOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	salesOrder, err := h.sm.ConfirmSalesOrder(c.Context(), &services.ConfirmSalesOrderParams{
+		ID:     salesOrderId,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(salesOrder)
+}
+
+func (h *Handlers) cancelSalesOrder(c *fiber.Ctx) error {
+	salesOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	salesOrder, err := h.sm.CancelSalesOrder(c.Context(), &services.CancelSalesOrderParams{
+		ID:     salesOrderId,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(salesOrder)
+}
+
+type DeliverSalesOrderItemBody struct {
+	LineID   *uuid.UUID       `json:"lineId"`
+	Quantity decimal.Decimal  `json:"quantity"`
+	Price    *decimal.Decimal `json:"price"`
+	Unit     string           `json:"unit"`
+	Batch    string           `json:"batch"`
+}
+
+type DeliverSalesOrderBody struct {
+	Date         string                       `json:"date"`
+	ExchangeRate decimal.Decimal              `json:"exchangeRate"`
+	Items        []*DeliverSalesOrderItemBody `json:"items"`
+}
+
+func (h *Handlers) deliverSalesOrder(c *fiber.Ctx) error {
+	salesOrderId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(DeliverSalesOrderBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	layout := "2006-01-02"
+	date, err := time.Parse(layout, body.Date)
+	if err != nil {
+		return constants.NewRequiredFieldError("date")
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*services.DeliverSalesOrderItem, 0, len(body.Items))
+	for _, item := range body.Items {
+		items = append(items, &services.DeliverSalesOrderItem{
+			LineID:   toPgxUUID(item.LineID),
+			Quantity: item.Quantity,
+			Price:    item.Price,
+			Unit:     item.Unit,
+			Batch:    item.Batch,
+		})
+	}
+
+	stockMovement, err := h.sm.DeliverSalesOrder(c.Context(), &services.DeliverSalesOrderParams{
+		ID:           salesOrderId,
+		Date:         date,
+		ExchangeRate: body.ExchangeRate,
+		UserID:       userID,
+		Items:        items,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(stockMovement)
+}
@@ -1,12 +1,13 @@
 package routes
 
 import (
-	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofrs/uuid/v5"
 	"github.com/hoffax/prodrest/constants"
 	"github.com/hoffax/prodrest/services"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"strings"
 	"time"
 )
 
@@ -17,6 +18,11 @@ func (h *Handlers) RegisterStockMovementRoutes() {
 	g.Get("/:id", h.getStockMovementById)
 	g.Post("/", h.createStockMovement)
 	g.Put("/:id", h.updateStockMovement)
+	g.Get("/:id/revisions", h.getStockMovementRevisions)
+	g.Get("/:id/attachments", h.getStockMovementAttachments)
+	g.Post("/:id/attachments", h.uploadStockMovementAttachment)
+	g.Get("/:id/attachments/:attachmentId", h.downloadStockMovementAttachment)
+	g.Delete("/:id/attachments/:attachmentId", h.deleteStockMovementAttachment)
 	g.Delete("/:id", h.CancelStockMovementByID)
 }
 
@@ -24,6 +30,7 @@ type GetAllStockMovementsQuery struct {
 	StatusOptions []string `query:"status"`
 	TypeOptions   []string `query:"type"`
 	StartDate     string   `query:"startDate"`
+	Search        string   `query:"search"`
 	Limit         int      `query:"limit"`
 	Offset        int      `query:"offset"`
 }
@@ -48,6 +55,7 @@ func (h *Handlers) getAllStockMovements(c *fiber.Ctx) error {
 		StatusOptions: params.StatusOptions,
 		TypeOptions:   params.TypeOptions,
 		StartDate:     startDate,
+		Search:        strings.TrimSpace(params.Search),
 		Limit:         params.Limit,
 		Offset:        params.Offset,
 	})
@@ -73,17 +81,37 @@ func (h *Handlers) getStockMovementById(c *fiber.Ctx) error {
 }
 
 type CreateStockMovementBody struct {
-	Type     string         `json:"type"`
-	Date     string         `json:"date"`
-	EntityId uuid.UUID      `json:"entityId"`
-	Items    []*CreateItems `json:"items"`
+	Type                   string          `json:"type"`
+	Date                   string          `json:"date"`
+	EntityId               uuid.UUID       `json:"entityId"`
+	WarehouseID            *uuid.UUID      `json:"warehouseId"`
+	DestinationWarehouseID *uuid.UUID      `json:"destinationWarehouseId"`
+	Currency               string          `json:"currency"`
+	ExchangeRate           decimal.Decimal `json:"exchangeRate"`
+	InvoiceNumber          string          `json:"invoiceNumber"`
+	ReturnOfID             *uuid.UUID      `json:"returnOfId"`
+	Items                  []*CreateItems  `json:"items"`
 }
 
 type CreateItems struct {
-	ProductID *uuid.UUID `json:"productId"`
-	Quantity  int        `json:"quantity"`
-	Price     int        `json:"price"`
-	Batch     string     `json:"batch"`
+	ProductID   *uuid.UUID      `json:"productId"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	Price       decimal.Decimal `json:"price"`
+	Unit        string          `json:"unit"`
+	Batch       string          `json:"batch"`
+	WarehouseID *uuid.UUID      `json:"warehouseId"`
+	// ReturnOfItemID is the returned item of a SALE_RETURN or PURCHASE_RETURN
+	ReturnOfItemID *uuid.UUID `json:"returnOfItemId"`
+}
+
+// toPgxUUID converts an optional uuid from a request body, nil stays nil
+func toPgxUUID(id *uuid.UUID) *pgxuuid.UUID {
+	if id == nil {
+		return nil
+	}
+
+	pgxID := pgxuuid.UUID(id.Bytes())
+	return &pgxID
 }
 
 func (h *Handlers) createStockMovement(c *fiber.Ctx) error {
@@ -112,21 +140,31 @@ func (h *Handlers) createStockMovement(c *fiber.Ctx) error {
 	for _, item := range params.Items {
 		itemUUID := pgxuuid.UUID(item.ProductID.Bytes())
 		items = append(items, &services.CreateStockItem{
-			ProductID: &itemUUID,
-			Quantity:  item.Quantity,
-			Price:     item.Price,
-			Batch:     item.Batch,
+			ProductID:   &itemUUID,
+			Quantity:    item.Quantity,
+			Price:       item.Price,
+			Unit:        item.Unit,
+			Batch:       item.Batch,
+			WarehouseID: toPgxUUID(item.WarehouseID),
+
+			ReturnOfItemID: toPgxUUID(item.ReturnOfItemID),
 		})
 	}
 
 	pgxUserID := pgxuuid.UUID(userID.Bytes())
 	entityID := pgxuuid.UUID(params.EntityId.Bytes())
 	stockMovement, err := h.sm.CreateStockMovement(c.Context(), &services.CreateStockMovementParams{
-		Type:     params.Type,
-		Date:     date,
-		EntityID: &entityID,
-		UserID:   &pgxUserID,
-		Items:    items,
+		Type:                   params.Type,
+		Date:                   date,
+		EntityID:               &entityID,
+		WarehouseID:            toPgxUUID(params.WarehouseID),
+		DestinationWarehouseID: toPgxUUID(params.DestinationWarehouseID),
+		Currency:               strings.ToUpper(params.Currency),
+		ExchangeRate:           params.ExchangeRate,
+		InvoiceNumber:          strings.TrimSpace(params.InvoiceNumber),
+		ReturnOfID:             toPgxUUID(params.ReturnOfID),
+		UserID:                 &pgxUserID,
+		Items:                  items,
 	})
 	if err != nil {
 		return err
@@ -138,6 +176,9 @@ func (h *Handlers) createStockMovement(c *fiber.Ctx) error {
 type UpdateStockMovementBody struct {
 	Date     string    `validate:"required"`
 	EntityID uuid.UUID `validate:"required"`
+	// Items replace the current items when present
+	WarehouseID *uuid.UUID     `json:"warehouseId"`
+	Items       []*CreateItems `json:"items"`
 }
 
 func (h *Handlers) updateStockMovement(c *fiber.Ctx) error {
@@ -157,11 +198,34 @@ func (h *Handlers) updateStockMovement(c *fiber.Ctx) error {
 		return constants.NewRequiredFieldError("date")
 	}
 
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var items []*services.CreateStockItem
+	if params.Items != nil {
+		items = make([]*services.CreateStockItem, 0)
+		for _, item := range params.Items {
+			items = append(items, &services.CreateStockItem{
+				ProductID:   toPgxUUID(item.ProductID),
+				Quantity:    item.Quantity,
+				Price:       item.Price,
+				Unit:        item.Unit,
+				Batch:       item.Batch,
+				WarehouseID: toPgxUUID(item.WarehouseID),
+			})
+		}
+	}
+
 	entityID := pgxuuid.UUID(params.EntityID.Bytes())
 	stockMovement, err := h.sm.UpdateStockMovement(c.Context(), &services.UpdateStockMovementParams{
-		ID:       stockMovementId,
-		Date:     date,
-		EntityID: &entityID,
+		ID:          stockMovementId,
+		Date:        date,
+		EntityID:    &entityID,
+		WarehouseID: toPgxUUID(params.WarehouseID),
+		UserID:      userID,
+		Items:       items,
 	})
 	if err != nil {
 		return err
@@ -170,29 +234,59 @@ func (h *Handlers) updateStockMovement(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(stockMovement)
 }
 
+func (h *Handlers) getStockMovementRevisions(c *fiber.Ctx) error {
+	stockMovementId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := h.sm.FetchStockMovementRevisions(c.Context(), stockMovementId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(revisions)
+}
+
+type CancelStockMovementBody struct {
+	Reason string `json:"reason"`
+	// Mode is CANCEL by default or REVERSAL, which posts a reversal movement
+	// dated Date, today when empty
+	Mode string `json:"mode"`
+	Date string `json:"date"`
+}
+
 func (h *Handlers) CancelStockMovementByID(c *fiber.Ctx) error {
 	stockMovementId, err := h.getIdParam(c)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	userIDStr, ok := c.Locals("userId").(string)
-	if !ok {
-		fmt.Printf("userIdStr: %v\n", userIDStr)
-		return fiber.ErrUnauthorized
+	params := new(CancelStockMovementBody)
+	if err := c.BodyParser(params); err != nil {
+		return constants.InvalidBody()
 	}
 
-	userID, err := uuid.FromString(userIDStr)
+	date, err := parseOptionalDate(params.Date, "date")
 	if err != nil {
-		fmt.Printf("err: %v\n", err)
-		return fiber.ErrUnauthorized
+		return err
+	}
+
+	userID, err := h.getUserID(c)
+	if err != nil {
+		return err
 	}
 
-	pgxUserID := pgxuuid.UUID(userID.Bytes())
 	stockMovement, err := h.sm.CancelStockMovementByID(c.Context(), &services.CancelStockMovementParams{
 		ID:     stockMovementId,
-		UserID: &pgxUserID,
+		UserID: userID,
+		Reason: strings.TrimSpace(params.Reason),
+		Mode:   strings.ToUpper(params.Mode),
+		Date:   date,
 	})
+	if err != nil {
+		return err
+	}
 
 	return c.Status(fiber.StatusOK).JSON(stockMovement)
 }
@@ -0,0 +1,171 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+	"github.com/shopspring/decimal"
+)
+
+func (h *Handlers) RegisterUnitRoutes() {
+	g := h.app.Group("/units")
+
+	g.Get("/", h.getAllUnits)
+	g.Get("/:code", h.getUnitByCode)
+	g.Post("/", h.createUnit)
+	g.Put("/:code", h.updateUnit)
+
+	// packaging levels of a product
+	h.app.Get("/products/:id/units", h.getProductUnits)
+	h.app.Post("/products/:id/units", h.createProductUnit)
+	h.app.Put("/products/:id/units/:unit", h.updateProductUnit)
+}
+
+type GetAllUnitsQuery struct {
+	StatusOptions []string `query:"status"`
+}
+
+func (h *Handlers) getAllUnits(c *fiber.Ctx) error {
+	params := new(GetAllUnitsQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidBody()
+	}
+
+	units, err := h.sm.FetchUnits(c.Context(), &services.FetchUnitsParams{
+		StatusOptions: params.StatusOptions,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(units)
+}
+
+func (h *Handlers) getUnitByCode(c *fiber.Ctx) error {
+	unit, err := h.sm.FetchUnitByCode(c.Context(), c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(unit)
+}
+
+type CreateUnitBody struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+func (h *Handlers) createUnit(c *fiber.Ctx) error {
+	body := new(CreateUnitBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	unit, err := h.sm.CreateUnit(c.Context(), &services.CreateUnitParams{
+		Code: body.Code,
+		Name: body.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(unit)
+}
+
+type UpdateUnitBody struct {
+	Status string `json:"status"`
+	Name   string `json:"name"`
+}
+
+func (h *Handlers) updateUnit(c *fiber.Ctx) error {
+	body := new(UpdateUnitBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	unit, err := h.sm.UpdateUnit(c.Context(), &services.UpdateUnitParams{
+		Code:   c.Params("code"),
+		Status: body.Status,
+		Name:   body.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(unit)
+}
+
+func (h *Handlers) getProductUnits(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	productUnits, err := h.sm.FetchProductUnits(c.Context(), productId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(productUnits)
+}
+
+type CreateProductUnitBody struct {
+	Unit    string          `json:"unit"`
+	Factor  decimal.Decimal `json:"factor"`
+	Barcode string          `json:"barcode"`
+}
+
+func (h *Handlers) createProductUnit(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(CreateProductUnitBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	productUnit, err := h.sm.CreateProductUnit(c.Context(), &services.CreateProductUnitParams{
+		ProductID: productId,
+		Unit:      body.Unit,
+		Factor:    body.Factor,
+		Barcode:   body.Barcode,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(productUnit)
+}
+
+type UpdateProductUnitBody struct {
+	Status  string          `json:"status"`
+	Factor  decimal.Decimal `json:"factor"`
+	Barcode string          `json:"barcode"`
+}
+
+func (h *Handlers) updateProductUnit(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(UpdateProductUnitBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	productUnit, err := h.sm.UpdateProductUnit(c.Context(), &services.UpdateProductUnitParams{
+		ProductID: productId,
+		Unit:      c.Params("unit"),
+		Status:    body.Status,
+		Factor:    body.Factor,
+		Barcode:   body.Barcode,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(productUnit)
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/services"
+)
+
+func (h *Handlers) RegisterWarehouseRoutes() {
+	g := h.app.Group("/warehouses")
+
+	g.Get("/", h.getAllWarehouses)
+	g.Get("/:id", h.getWarehouseById)
+	g.Post("/", h.createWarehouse)
+	g.Put("/:id", h.updateWarehouse)
+	g.Get("/:id/stock", h.getWarehouseStock)
+
+	// stock of a product per location
+	h.app.Get("/products/:id/stock", h.getProductStock)
+}
+
+type GetAllWarehousesQuery struct {
+	StatusOptions []string `query:"status"`
+	Search        string   `query:"search"`
+	Limit         int      `query:"limit"`
+	Offset        int      `query:"offset"`
+}
+
+func (h *Handlers) getAllWarehouses(c *fiber.Ctx) error {
+	params := new(GetAllWarehousesQuery)
+	if err := c.QueryParser(params); err != nil {
+		return constants.InvalidBody()
+	}
+
+	if params.Limit <= 9 {
+		params.Limit = 10
+	}
+
+	response, err := h.sm.FetchWarehouses(c.Context(), &services.FetchWarehousesParams{
+		StatusOptions: params.StatusOptions,
+		Search:        params.Search,
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *Handlers) getWarehouseById(c *fiber.Ctx) error {
+	warehouseId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	warehouse, err := h.sm.GetWarehouseByID(c.Context(), warehouseId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(warehouse)
+}
+
+type CreateWarehouseBody struct {
+	Name string `json:"name"`
+}
+
+func (h *Handlers) createWarehouse(c *fiber.Ctx) error {
+	body := new(CreateWarehouseBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	warehouse, err := h.sm.CreateWarehouse(c.Context(), &services.CreateWarehouseParams{
+		Name: body.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(warehouse)
+}
+
+type UpdateWarehouseBody struct {
+	Status string `json:"status"`
+	Name   string `json:"name"`
+}
+
+func (h *Handlers) updateWarehouse(c *fiber.Ctx) error {
+	warehouseId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(UpdateWarehouseBody)
+	if err := c.BodyParser(body); err != nil {
+		return constants.InvalidBody()
+	}
+
+	warehouse, err := h.sm.UpdateWarehouse(c.Context(), &services.UpdateWarehouseParams{
+		ID:     warehouseId,
+		Status: body.Status,
+		Name:   body.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(warehouse)
+}
+
+func (h *Handlers) getWarehouseStock(c *fiber.Ctx) error {
+	warehouseId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.sm.GetWarehouseByID(c.Context(), warehouseId)
+	if err != nil {
+		return err
+	}
+
+	stock, err := h.sm.FetchWarehouseStock(c.Context(), &services.FetchWarehouseStockParams{
+		WarehouseID: warehouseId,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(stock)
+}
+
+func (h *Handlers) getProductStock(c *fiber.Ctx) error {
+	productId, err := h.getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.sm.FetchProductById(c.Context(), productId)
+	if err != nil {
+		return err
+	}
+
+	stock, err := h.sm.FetchWarehouseStock(c.Context(), &services.FetchWarehouseStockParams{
+		ProductID: productId,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(stock)
+}
@@ -9,13 +9,17 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
 	"github.com/gofiber/storage/memory"
+	"github.com/hoffax/prodrest/constants"
 	"github.com/hoffax/prodrest/middleware"
 	"github.com/hoffax/prodrest/repository"
 	"github.com/hoffax/prodrest/routes"
 	"github.com/hoffax/prodrest/services"
+	"github.com/hoffax/prodrest/storage"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	pgxdecimal "github.com/jackc/pgx-shopspring-decimal"
 	pgx "github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
 	"log"
 	"os"
 	"time"
@@ -37,35 +41,63 @@ func (t *CustomTracer) TraceQueryEnd(_ context.Context, _ *pgx.Conn, data pgx.Tr
 }
 
 func Serve() {
-	connConfig, err := pgx.ParseConfig(os.Getenv("DB_URL"))
-	//connConfig.Tracer = &CustomTracer{}
-	conn, err := pgx.ConnectConfig(context.Background(), connConfig)
+	poolConfig, err := pgxpool.ParseConfig(os.Getenv("DB_URL"))
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v\n", err)
+		log.Fatalf("Unable to parse database url: %v\n", err)
 	}
-	defer func(conn *pgx.Conn, ctx context.Context) {
-		err := conn.Close(ctx)
-		if err != nil {
-			log.Fatalf("Error closing database connection")
-		}
-	}(conn, context.Background())
-	connConfig.AfterConnect = func(ctx context.Context, pgconn *pgconn.PgConn) error {
+	//poolConfig.ConnConfig.Tracer = &CustomTracer{}
+	// NUMERIC columns are scanned into decimal.Decimal and UUID columns into
+	// uuid.UUID on every connection of the pool
+	poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		pgxdecimal.Register(conn.TypeMap())
 		pgxuuid.Register(conn.TypeMap())
 		return nil
 	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		log.Fatalf("Unable to connect to database: %v\n", err)
+	}
+	defer pool.Close()
+	err = pool.Ping(context.Background())
+	if err != nil {
+		log.Fatalf("Unable to connect to database: %v\n", err)
+	}
 
-	repo := repository.NewPgRepository(conn)
-	sm, err := services.NewServiceManager(repo)
+	// quantities and money are sent as JSON numbers, not strings
+	decimal.MarshalJSONWithoutQuotes = true
+
+	attachmentsDir := os.Getenv("ATTACHMENTS_DIR")
+	if attachmentsDir == "" {
+		attachmentsDir = "data/attachments"
+	}
+	fileStorage, err := storage.NewLocalStorage(attachmentsDir)
+	if err != nil {
+		log.Fatalf("Could not open file storage\n %v", err)
+	}
+
+	repo := repository.NewPgRepository(pool)
+	sm, err := services.NewServiceManager(repo, fileStorage)
 	if err != nil {
 		log.Fatalf("Could not open service manager\n %v", err)
 	}
 
+	// background jobs run until Serve returns, before the pool is closed
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	// expired reservations give their stock back in the background
+	go sm.RunReservationSweeper(jobsCtx, time.Minute)
+	// and products falling below their reorder point raise an alert
+	go sm.RunLowStockEvaluator(jobsCtx, 5*time.Minute)
+
 	memoryStore := memory.New(memory.Config{
 		GCInterval: 5 * time.Second,
 	})
 
 	app := fiber.New(fiber.Config{
 		ErrorHandler: middleware.FiberCustomErrorHandler,
+		// room for an attachment and the rest of its multipart form
+		BodyLimit: constants.AttachmentMaxSize + 1<<20,
 	})
 	app.Use(logger.New())
 	app.Use(cors.New())
@@ -79,9 +111,21 @@ func Serve() {
 	app.Get("/metrics", monitor.New())
 	handlers.RegisterAuthRoutes()
 	handlers.RegisterUserRoutes()
+	handlers.RegisterUnitRoutes()
 	handlers.RegisterProductRoutes()
 	handlers.RegisterEntityRoutes()
 	handlers.RegisterStockMovementRoutes()
+	handlers.RegisterExchangeRateRoutes()
+	handlers.RegisterPeriodRoutes()
+	handlers.RegisterLotRoutes()
+	handlers.RegisterWarehouseRoutes()
+	handlers.RegisterBomRoutes()
+	handlers.RegisterProductionOrderRoutes()
+	handlers.RegisterPurchaseOrderRoutes()
+	handlers.RegisterSalesOrderRoutes()
+	handlers.RegisterReservationRoutes()
+	handlers.RegisterInventoryCountRoutes()
+	handlers.RegisterAlertRoutes()
 
 	err = app.Listen(":3088")
 	if err != nil {
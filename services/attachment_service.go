@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	"github.com/hoffax/prodrest/storage"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+type AttachmentDTO struct {
+	ID                 *uuid.UUID `json:"id"`
+	StockMovementID    *uuid.UUID `json:"stockMovementId"`
+	FileName           string     `json:"fileName"`
+	MimeType           string     `json:"mimeType"`
+	Size               int64      `json:"size"`
+	Sha256             string     `json:"sha256"`
+	UploadedByUserID   *uuid.UUID `json:"uploadedByUserId"`
+	UploadedByUserName string     `json:"uploadedByUserName"`
+	CreatedAt          time.Time  `json:"createdAt"`
+}
+
+func (s *ServiceManager) toAttachmentDTO(attachment *repository.Attachment) *AttachmentDTO {
+	attachmentId, err := s.parseUUID(attachment.ID)
+	if err != nil {
+		attachmentId = nil
+	}
+
+	stockMovementId, err := s.parseUUID(attachment.StockMovementID)
+	if err != nil {
+		stockMovementId = nil
+	}
+
+	uploadedByUserId, err := s.parseUUID(attachment.UploadedByUserID)
+	if err != nil {
+		uploadedByUserId = nil
+	}
+
+	return &AttachmentDTO{
+		ID:                 attachmentId,
+		StockMovementID:    stockMovementId,
+		FileName:           attachment.FileName,
+		MimeType:           attachment.MimeType,
+		Size:               attachment.Size,
+		Sha256:             attachment.Sha256,
+		UploadedByUserID:   uploadedByUserId,
+		UploadedByUserName: attachment.UploadedByUserName,
+		CreatedAt:          attachment.CreatedAt,
+	}
+}
+
+// getAttachment returns a NotFoundError when the movement has no attachment
+// with the given id
+func (s *ServiceManager) getAttachment(ctx context.Context, stockMovementID *pgxuuid.UUID, id *pgxuuid.UUID) (*repository.Attachment, error) {
+	attachment, err := s.repo.GetAttachment(ctx, stockMovementID, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+func (s *ServiceManager) FetchAttachments(ctx context.Context, stockMovementID *pgxuuid.UUID) ([]*AttachmentDTO, error) {
+	_, err := s.repo.FetchStockMovementByID(ctx, stockMovementID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	attachments, err := s.repo.FetchAttachments(ctx, stockMovementID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachmentsDTO := make([]*AttachmentDTO, 0)
+	for _, attachment := range attachments {
+		attachmentsDTO = append(attachmentsDTO, s.toAttachmentDTO(attachment))
+	}
+
+	return attachmentsDTO, nil
+}
+
+type UploadAttachmentParams struct {
+	StockMovementID *pgxuuid.UUID `validate:"required"`
+	UserID          *pgxuuid.UUID `validate:"required"`
+	FileName        string        `validate:"required"`
+	Size            int64         `validate:"gt=0"`
+	Content         io.ReadSeeker `validate:"required"`
+}
+
+// UploadAttachment stores the file and its metadata, the mime type is sniffed
+// from the content instead of trusting the one sent by the client
+func (s *ServiceManager) UploadAttachment(ctx context.Context, params *UploadAttachmentParams) (*AttachmentDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Size > constants.AttachmentMaxSize {
+		return nil, constants.InvalidParams(fmt.Sprintf("file is larger than %v MB", constants.AttachmentMaxSize>>20))
+	}
+
+	fileName := path.Base(strings.ReplaceAll(params.FileName, "\\", "/"))
+	if fileName == "." || fileName == "/" || len(fileName) > 255 {
+		return nil, constants.InvalidParams("invalid file name")
+	}
+
+	stockMovement, err := s.repo.FetchStockMovementByID(ctx, params.StockMovementID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	mimeType, err := mimetype.DetectReader(params.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = params.Content.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fileID, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	stockMovementId, err := s.parseUUID(stockMovement.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	storageKey := fmt.Sprintf("stock_movements/%v/%v", stockMovementId, fileID)
+	hash := sha256.New()
+	err = s.storage.Save(ctx, storageKey, io.TeeReader(params.Content, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, err := s.repo.CreateAttachment(ctx, &repository.CreateAttachmentParams{
+		StockMovementID:  params.StockMovementID,
+		FileName:         fileName,
+		MimeType:         mimeType.String(),
+		Size:             params.Size,
+		Sha256:           hex.EncodeToString(hash.Sum(nil)),
+		StorageKey:       storageKey,
+		UploadedByUserID: params.UserID,
+	})
+	if err != nil {
+		// the file is useless without its metadata
+		_ = s.storage.Delete(ctx, storageKey)
+		return nil, err
+	}
+
+	return s.toAttachmentDTO(attachment), nil
+}
+
+// OpenAttachment returns the metadata of an attachment and its content, the
+// caller must close the content
+func (s *ServiceManager) OpenAttachment(ctx context.Context, stockMovementID *pgxuuid.UUID, id *pgxuuid.UUID) (*AttachmentDTO, io.ReadCloser, error) {
+	attachment, err := s.getAttachment(ctx, stockMovementID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := s.storage.Open(ctx, attachment.StorageKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, constants.NewNotFoundError()
+		}
+		return nil, nil, err
+	}
+
+	return s.toAttachmentDTO(attachment), content, nil
+}
+
+func (s *ServiceManager) DeleteAttachment(ctx context.Context, stockMovementID *pgxuuid.UUID, id *pgxuuid.UUID) (*AttachmentDTO, error) {
+	attachment, err := s.getAttachment(ctx, stockMovementID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.DeleteAttachment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.storage.Delete(ctx, attachment.StorageKey)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	return s.toAttachmentDTO(attachment), nil
+}
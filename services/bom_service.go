@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type BomDTO struct {
+	ID          *uuid.UUID    `json:"id"`
+	Status      string        `json:"status"`
+	ProductID   *uuid.UUID    `json:"productId"`
+	ProductName string        `json:"productName"`
+	Version     int           `json:"version"`
+	Notes       string        `json:"notes"`
+	Items       []*BomItemDTO `json:"items"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	UpdatedAt   time.Time     `json:"updatedAt"`
+}
+
+type BomItemDTO struct {
+	ID                   *uuid.UUID      `json:"id"`
+	ComponentProductID   *uuid.UUID      `json:"componentProductId"`
+	ComponentProductName string          `json:"componentProductName"`
+	Quantity             decimal.Decimal `json:"quantity"`
+	ScrapPercentage      decimal.Decimal `json:"scrapPercentage"`
+}
+
+func (s *ServiceManager) toBomDTO(bom *repository.Bom) *BomDTO {
+	bomId, err := s.parseUUID(bom.ID)
+	if err != nil {
+		bomId = nil
+	}
+
+	productId, err := s.parseUUID(bom.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	items := make([]*BomItemDTO, 0)
+	for _, item := range bom.Items {
+		itemId, err := s.parseUUID(item.ID)
+		if err != nil {
+			itemId = nil
+		}
+
+		componentProductId, err := s.parseUUID(item.ComponentProductID)
+		if err != nil {
+			componentProductId = nil
+		}
+
+		items = append(items, &BomItemDTO{
+			ID:                   itemId,
+			ComponentProductID:   componentProductId,
+			ComponentProductName: item.ComponentProductName,
+			Quantity:             item.Quantity,
+			ScrapPercentage:      item.ScrapPercentage,
+		})
+	}
+
+	return &BomDTO{
+		ID:          bomId,
+		Status:      bom.Status,
+		ProductID:   productId,
+		ProductName: bom.ProductName,
+		Version:     bom.Version,
+		Notes:       bom.Notes,
+		Items:       items,
+		CreatedAt:   bom.CreatedAt,
+		UpdatedAt:   bom.UpdatedAt,
+	}
+}
+
+type BomItemParams struct {
+	ComponentProductID *pgxuuid.UUID   `validate:"required"`
+	Quantity           decimal.Decimal `validate:"gt=0"`
+	ScrapPercentage    decimal.Decimal `validate:"gte=0,lt=100"`
+}
+
+// checkBomItems validates the components of a recipe for productID: they
+// must exist and appear once. The repository checks they do not use
+// productID down their own recipes.
+func (s *ServiceManager) checkBomItems(ctx context.Context, productID *pgxuuid.UUID, items []*BomItemParams) error {
+	seen := make(map[pgxuuid.UUID]bool, len(items))
+	for i, item := range items {
+		if *item.ComponentProductID == *productID {
+			return constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: a product cannot be a component of itself", i))
+		}
+		if seen[*item.ComponentProductID] {
+			return constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: component is repeated", i))
+		}
+		seen[*item.ComponentProductID] = true
+
+		_, err := s.repo.GetProductByID(ctx, item.ComponentProductID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: component product not found", i))
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func toCreateBomItems(items []*BomItemParams) []*repository.CreateBomItem {
+	bomItems := make([]*repository.CreateBomItem, 0, len(items))
+	for _, item := range items {
+		bomItems = append(bomItems, &repository.CreateBomItem{
+			ComponentProductID: item.ComponentProductID,
+			Quantity:           item.Quantity.Round(constants.BomQuantityScale),
+			ScrapPercentage:    item.ScrapPercentage.Round(constants.PercentageScale),
+		})
+	}
+
+	return bomItems
+}
+
+// sameBomItems reports whether items holds the same components, quantities
+// and scrap as the stored recipe, in any order
+func sameBomItems(current []*repository.BomItem, items []*BomItemParams) bool {
+	if len(current) != len(items) {
+		return false
+	}
+
+	stored := make(map[pgxuuid.UUID]*repository.BomItem, len(current))
+	for _, item := range current {
+		stored[*item.ComponentProductID] = item
+	}
+
+	for _, item := range items {
+		storedItem, ok := stored[*item.ComponentProductID]
+		if !ok || !storedItem.Quantity.Equal(item.Quantity) || !storedItem.ScrapPercentage.Equal(item.ScrapPercentage) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type CreateBomParams struct {
+	ProductID *pgxuuid.UUID    `validate:"required"`
+	Notes     string           `validate:"lte=500"`
+	Items     []*BomItemParams `validate:"required,min=1,dive,required"`
+}
+
+func (s *ServiceManager) CreateBom(ctx context.Context, params *CreateBomParams) (*BomDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.repo.GetProductByID(ctx, params.ProductID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	err = s.checkBomItems(ctx, params.ProductID, params.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	bom, err := s.repo.CreateBom(ctx, &repository.CreateBomParams{
+		ProductID: params.ProductID,
+		Notes:     params.Notes,
+		Items:     toCreateBomItems(params.Items),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toBomDTO(bom), nil
+}
+
+type UpdateBomParams struct {
+	ProductID *pgxuuid.UUID    `validate:"required"`
+	Version   int              `validate:"required,gt=0"`
+	Status    string           `validate:"required,custom_status"`
+	Notes     string           `validate:"lte=500"`
+	Items     []*BomItemParams `validate:"required,min=1,dive,required"`
+}
+
+func (s *ServiceManager) UpdateBom(ctx context.Context, params *UpdateBomParams) (*BomDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	bom, err := s.repo.GetBomByVersion(ctx, params.ProductID, params.Version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if !sameBomItems(bom.Items, params.Items) {
+		orders, err := s.repo.CountBomProductionOrders(ctx, bom.ID)
+		if err != nil {
+			return nil, err
+		}
+		if orders > 0 {
+			return nil, constants.NewInvalidOperationError("bom version is used by production orders, create a new version to change its items")
+		}
+	}
+
+	if params.Status == "ACTIVE" {
+		err = s.checkBomItems(ctx, params.ProductID, params.Items)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bom, err = s.repo.UpdateBom(ctx, &repository.UpdateBomParams{
+		ID:     bom.ID,
+		Status: params.Status,
+		Notes:  params.Notes,
+		Items:  toCreateBomItems(params.Items),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toBomDTO(bom), nil
+}
+
+type DeleteBomParams struct {
+	ProductID *pgxuuid.UUID `validate:"required"`
+	Version   int           `validate:"required,gt=0"`
+}
+
+// DeleteBom deactivates a recipe version, it is kept for the history
+func (s *ServiceManager) DeleteBom(ctx context.Context, params *DeleteBomParams) (*BomDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	bom, err := s.repo.GetBomByVersion(ctx, params.ProductID, params.Version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	bom, err = s.repo.DeactivateBom(ctx, bom.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toBomDTO(bom), nil
+}
+
+func (s *ServiceManager) FetchProductBoms(ctx context.Context, productID *pgxuuid.UUID) ([]*BomDTO, error) {
+	_, err := s.repo.GetProductByID(ctx, productID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	boms, err := s.repo.FetchProductBoms(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	bomsDTO := make([]*BomDTO, 0)
+	for _, bom := range boms {
+		bomsDTO = append(bomsDTO, s.toBomDTO(bom))
+	}
+
+	return bomsDTO, nil
+}
+
+func (s *ServiceManager) FetchBomByVersion(ctx context.Context, productID *pgxuuid.UUID, version int) (*BomDTO, error) {
+	bom, err := s.repo.GetBomByVersion(ctx, productID, version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return s.toBomDTO(bom), nil
+}
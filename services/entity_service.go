@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"github.com/gofrs/uuid/v5"
 	"github.com/hoffax/prodrest/constants"
 	"github.com/hoffax/prodrest/repository"
@@ -213,3 +214,23 @@ func (s *ServiceManager) FetchEntities(ctx context.Context, params *FetchEntitie
 		Items:      entitiesDTO,
 	}, nil
 }
+
+func (s *ServiceManager) getActiveEntity(ctx context.Context, id *pgxuuid.UUID, field string) (*repository.Entity, error) {
+	if id == nil {
+		return nil, constants.NewRequiredFieldError(field)
+	}
+
+	entity, err := s.repo.GetEntityById(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError(field + ": entity not found")
+		}
+		return nil, err
+	}
+
+	if entity.Status != "ACTIVE" {
+		return nil, constants.NewInvalidOperationError(field + ": entity is inactive")
+	}
+
+	return entity, nil
+}
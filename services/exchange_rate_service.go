@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+type ExchangeRateDTO struct {
+	ID        *uuid.UUID      `json:"id"`
+	Currency  string          `json:"currency"`
+	Date      time.Time       `json:"date"`
+	Rate      decimal.Decimal `json:"rate"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+func (s *ServiceManager) toExchangeRateDTO(exchangeRate *repository.ExchangeRate) *ExchangeRateDTO {
+	exchangeRateId, err := s.parseUUID(exchangeRate.ID)
+	if err != nil {
+		exchangeRateId = nil
+	}
+
+	return &ExchangeRateDTO{
+		ID:        exchangeRateId,
+		Currency:  exchangeRate.Currency,
+		Date:      exchangeRate.Date,
+		Rate:      exchangeRate.Rate,
+		CreatedAt: exchangeRate.CreatedAt,
+		UpdatedAt: exchangeRate.UpdatedAt,
+	}
+}
+
+type FetchExchangeRatesParams struct {
+	Currency string `validate:"omitempty,iso4217"`
+	From     *time.Time
+	To       *time.Time
+	Limit    int `validate:"required,gte=1,lte=100"`
+	Offset   int `validate:"gte=0"`
+}
+
+type FetchExchangeRatesResponse struct {
+	TotalCount int                `json:"totalCount"`
+	Items      []*ExchangeRateDTO `json:"items"`
+}
+
+func (s *ServiceManager) FetchExchangeRates(ctx context.Context, params *FetchExchangeRatesParams) (*FetchExchangeRatesResponse, error) {
+	params.Currency = strings.ToUpper(params.Currency)
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.FetchExchangeRates(ctx, &repository.FetchExchangeRatesParams{
+		Currency: params.Currency,
+		From:     params.From,
+		To:       params.To,
+		Limit:    params.Limit,
+		Offset:   params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*ExchangeRateDTO, 0)
+	for _, item := range result.Items {
+		items = append(items, s.toExchangeRateDTO(item))
+	}
+
+	return &FetchExchangeRatesResponse{
+		TotalCount: result.TotalCount,
+		Items:      items,
+	}, nil
+}
+
+func (s *ServiceManager) FetchExchangeRateByID(ctx context.Context, id *pgxuuid.UUID) (*ExchangeRateDTO, error) {
+	exchangeRate, err := s.repo.GetExchangeRateByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return s.toExchangeRateDTO(exchangeRate), nil
+}
+
+type CreateExchangeRateParams struct {
+	Currency string          `validate:"required,iso4217,ne=PYG"`
+	Date     time.Time       `validate:"required"`
+	Rate     decimal.Decimal `validate:"gt=0"`
+}
+
+func (s *ServiceManager) CreateExchangeRate(ctx context.Context, params *CreateExchangeRateParams) (*ExchangeRateDTO, error) {
+	params.Currency = strings.ToUpper(params.Currency)
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.repo.GetExchangeRateByDate(ctx, params.Currency, params.Date)
+	if err == nil {
+		return nil, constants.NewUniqueConstrainError("date")
+	} else {
+		if err != pgx.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	exchangeRate, err := s.repo.CreateExchangeRate(ctx, &repository.CreateExchangeRateParams{
+		Currency: params.Currency,
+		Date:     params.Date,
+		Rate:     params.Rate.Round(constants.ExchangeRateScale),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toExchangeRateDTO(exchangeRate), nil
+}
+
+type UpdateExchangeRateParams struct {
+	ID   *pgxuuid.UUID   `validate:"required"`
+	Date time.Time       `validate:"required"`
+	Rate decimal.Decimal `validate:"gt=0"`
+}
+
+func (s *ServiceManager) UpdateExchangeRate(ctx context.Context, params *UpdateExchangeRateParams) (*ExchangeRateDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	exchangeRate, err := s.repo.GetExchangeRateByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if !exchangeRate.Date.Equal(params.Date) {
+		_, err = s.repo.GetExchangeRateByDate(ctx, exchangeRate.Currency, params.Date)
+		if err == nil {
+			return nil, constants.NewUniqueConstrainError("date")
+		} else {
+			if err != pgx.ErrNoRows {
+				return nil, err
+			}
+		}
+	}
+
+	exchangeRate, err = s.repo.UpdateExchangeRate(ctx, &repository.UpdateExchangeRateParams{
+		ID:   params.ID,
+		Date: params.Date,
+		Rate: params.Rate.Round(constants.ExchangeRateScale),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toExchangeRateDTO(exchangeRate), nil
+}
+
+func (s *ServiceManager) DeleteExchangeRate(ctx context.Context, id *pgxuuid.UUID) (*ExchangeRateDTO, error) {
+	exchangeRate, err := s.repo.GetExchangeRateByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	err = s.repo.DeleteExchangeRate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toExchangeRateDTO(exchangeRate), nil
+}
+
+// resolveExchangeRate returns the rate a movement in currency is converted
+// with, the given rate when there is one or the rate effective on date
+func (s *ServiceManager) resolveExchangeRate(ctx context.Context, currency string, rate decimal.Decimal, date time.Time) (decimal.Decimal, error) {
+	if currency == constants.BaseCurrency {
+		if !rate.IsZero() && !rate.Equal(decimal.NewFromInt(1)) {
+			return decimal.Zero, constants.NewInvalidOperationError("exchangeRate: the base currency is always converted at 1")
+		}
+		return decimal.NewFromInt(1), nil
+	}
+
+	if rate.IsPositive() {
+		return rate.Round(constants.ExchangeRateScale), nil
+	}
+
+	exchangeRate, err := s.repo.GetEffectiveExchangeRate(ctx, currency, date)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return decimal.Zero, constants.NewInvalidOperationError("exchangeRate: no " + currency + " rate effective on " + date.Format("2006-01-02"))
+		}
+		return decimal.Zero, err
+	}
+
+	return exchangeRate.Rate, nil
+}
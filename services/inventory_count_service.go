@@ -0,0 +1,490 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type InventoryCountDTO struct {
+	ID            *uuid.UUID `json:"id"`
+	Status        string     `json:"status"`
+	WarehouseID   *uuid.UUID `json:"warehouseId"`
+	WarehouseName string     `json:"warehouseName"`
+	// ProductIDs is the subset of products counted, every product when nil
+	ProductIDs      []*uuid.UUID `json:"productIds"`
+	Date            time.Time    `json:"date"`
+	Notes           string       `json:"notes"`
+	StockMovementID *uuid.UUID   `json:"stockMovementId"`
+	ClosedAt        *time.Time   `json:"closedAt"`
+	CancelledAt     *time.Time   `json:"cancelledAt"`
+	CreatedAt       time.Time    `json:"createdAt"`
+	UpdatedAt       time.Time    `json:"updatedAt"`
+
+	CreatedByUserID     *uuid.UUID `json:"createdByUserId"`
+	CreatedByUserName   string     `json:"createdByUserName"`
+	ClosedByUserID      *uuid.UUID `json:"closedByUserId"`
+	ClosedByUserName    string     `json:"closedByUserName"`
+	CancelledByUserID   *uuid.UUID `json:"cancelledByUserId"`
+	CancelledByUserName string     `json:"cancelledByUserName"`
+
+	Lines []*InventoryCountLineDTO `json:"lines"`
+}
+
+// InventoryCountLineDTO holds quantities in the base unit of the product,
+// CountedQuantity and Variance stay nil until a device counts the line
+type InventoryCountLineDTO struct {
+	ID               *uuid.UUID       `json:"id"`
+	ProductID        *uuid.UUID       `json:"productId"`
+	ProductName      string           `json:"productName"`
+	LotID            *uuid.UUID       `json:"lotId"`
+	Batch            string           `json:"batch"`
+	ExpectedQuantity decimal.Decimal  `json:"expectedQuantity"`
+	CountedQuantity  *decimal.Decimal `json:"countedQuantity"`
+	Variance         *decimal.Decimal `json:"variance"`
+	Devices          int              `json:"devices"`
+}
+
+func (s *ServiceManager) toInventoryCountLineDTO(line *repository.InventoryCountLine) *InventoryCountLineDTO {
+	lineId, err := s.parseUUID(line.ID)
+	if err != nil {
+		lineId = nil
+	}
+
+	productId, err := s.parseUUID(line.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	lotId, err := s.parseUUID(line.LotID)
+	if err != nil {
+		lotId = nil
+	}
+
+	var variance *decimal.Decimal
+	if line.CountedQuantity != nil {
+		difference := line.CountedQuantity.Sub(line.ExpectedQuantity)
+		variance = &difference
+	}
+
+	return &InventoryCountLineDTO{
+		ID:               lineId,
+		ProductID:        productId,
+		ProductName:      line.ProductName,
+		LotID:            lotId,
+		Batch:            line.Batch,
+		ExpectedQuantity: line.ExpectedQuantity,
+		CountedQuantity:  line.CountedQuantity,
+		Variance:         variance,
+		Devices:          line.Devices,
+	}
+}
+
+func (s *ServiceManager) toInventoryCountDTO(ic *repository.InventoryCount) *InventoryCountDTO {
+	icId, err := s.parseUUID(ic.ID)
+	if err != nil {
+		icId = nil
+	}
+
+	warehouseId, err := s.parseUUID(ic.WarehouseID)
+	if err != nil {
+		warehouseId = nil
+	}
+
+	stockMovementId, err := s.parseUUID(ic.StockMovementID)
+	if err != nil {
+		stockMovementId = nil
+	}
+
+	createdByUserId, err := s.parseUUID(ic.CreatedByUserID)
+	if err != nil {
+		createdByUserId = nil
+	}
+
+	closedByUserId, err := s.parseUUID(ic.ClosedByUserID)
+	if err != nil {
+		closedByUserId = nil
+	}
+
+	cancelledByUserId, err := s.parseUUID(ic.CancelledByUserID)
+	if err != nil {
+		cancelledByUserId = nil
+	}
+
+	var productIds []*uuid.UUID
+	if ic.ProductIDs != nil {
+		productIds = make([]*uuid.UUID, 0, len(ic.ProductIDs))
+		for i := range ic.ProductIDs {
+			productId, err := s.parseUUID(&ic.ProductIDs[i])
+			if err != nil {
+				continue
+			}
+			productIds = append(productIds, productId)
+		}
+	}
+
+	lines := make([]*InventoryCountLineDTO, 0)
+	for _, line := range ic.Lines {
+		lines = append(lines, s.toInventoryCountLineDTO(line))
+	}
+
+	return &InventoryCountDTO{
+		ID:                  icId,
+		Status:              ic.Status,
+		WarehouseID:         warehouseId,
+		WarehouseName:       ic.WarehouseName,
+		ProductIDs:          productIds,
+		Date:                ic.Date,
+		Notes:               ic.Notes,
+		StockMovementID:     stockMovementId,
+		ClosedAt:            ic.ClosedAt,
+		CancelledAt:         ic.CancelledAt,
+		CreatedAt:           ic.CreatedAt,
+		UpdatedAt:           ic.UpdatedAt,
+		CreatedByUserID:     createdByUserId,
+		CreatedByUserName:   ic.CreatedByUserName,
+		ClosedByUserID:      closedByUserId,
+		ClosedByUserName:    ic.ClosedByUserName,
+		CancelledByUserID:   cancelledByUserId,
+		CancelledByUserName: ic.CancelledByUserName,
+		Lines:               lines,
+	}
+}
+
+type FetchInventoryCountsParams struct {
+	StatusOptions []string `validate:"dive,oneof=OPEN CLOSED CANCELLED"`
+	WarehouseID   *pgxuuid.UUID
+	Limit         int `validate:"required,gte=1,lte=100"`
+	Offset        int `validate:"gte=0"`
+}
+
+type FetchInventoryCountsResult struct {
+	TotalCount int                  `json:"totalCount"`
+	Items      []*InventoryCountDTO `json:"items"`
+}
+
+func (s *ServiceManager) FetchInventoryCounts(ctx context.Context, params *FetchInventoryCountsParams) (*FetchInventoryCountsResult, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.FetchInventoryCounts(ctx, &repository.FetchInventoryCountsParams{
+		StatusOptions: params.StatusOptions,
+		WarehouseID:   params.WarehouseID,
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*InventoryCountDTO, 0)
+	for _, ic := range result.Items {
+		items = append(items, s.toInventoryCountDTO(ic))
+	}
+
+	return &FetchInventoryCountsResult{
+		TotalCount: result.TotalCount,
+		Items:      items,
+	}, nil
+}
+
+func (s *ServiceManager) FetchInventoryCountByID(ctx context.Context, id *pgxuuid.UUID) (*InventoryCountDTO, error) {
+	ic, err := s.repo.GetInventoryCountByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return s.toInventoryCountDTO(ic), nil
+}
+
+// CreateInventoryCountParams opens a count of WarehouseID, of every product
+// or only of ProductIDs when given
+type CreateInventoryCountParams struct {
+	WarehouseID *pgxuuid.UUID   `validate:"required"`
+	ProductIDs  []*pgxuuid.UUID `validate:"omitempty,min=1,dive,required"`
+	Date        time.Time       `validate:"required"`
+	Notes       string          `validate:"lte=500"`
+	UserID      *pgxuuid.UUID   `validate:"required"`
+}
+
+func (s *ServiceManager) CreateInventoryCount(ctx context.Context, params *CreateInventoryCountParams) (*InventoryCountDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.getActiveWarehouse(ctx, params.WarehouseID, "warehouseId")
+	if err != nil {
+		return nil, err
+	}
+
+	var productIDs []pgxuuid.UUID
+	if params.ProductIDs != nil {
+		productIDs = make([]pgxuuid.UUID, 0, len(params.ProductIDs))
+		seen := make(map[pgxuuid.UUID]bool, len(params.ProductIDs))
+		for i, productID := range params.ProductIDs {
+			if seen[*productID] {
+				continue
+			}
+			seen[*productID] = true
+
+			_, err := s.repo.GetProductByID(ctx, productID)
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return nil, constants.NewInvalidOperationError(fmt.Sprintf("productIds[%d]: product not found", i))
+				}
+				return nil, err
+			}
+			productIDs = append(productIDs, *productID)
+		}
+	}
+
+	ic, err := s.repo.CreateInventoryCount(ctx, &repository.CreateInventoryCountParams{
+		WarehouseID: params.WarehouseID,
+		ProductIDs:  productIDs,
+		Date:        params.Date,
+		Notes:       params.Notes,
+		CreatedBy:   params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toInventoryCountDTO(ic), nil
+}
+
+// InventoryCountItem is counted in Unit, the base unit of the product when
+// empty. Batch is required for batch controlled products and ignored for
+// the others.
+type InventoryCountItem struct {
+	ProductID *pgxuuid.UUID   `validate:"required"`
+	Batch     string          `validate:"lte=30"`
+	Unit      string          `validate:"lte=10"`
+	Quantity  decimal.Decimal `validate:"required"`
+}
+
+// SubmitInventoryCountParams records what Device counted, items of the same
+// product and lot are added up
+type SubmitInventoryCountParams struct {
+	ID     *pgxuuid.UUID         `validate:"required"`
+	Device string                `validate:"lte=50"`
+	UserID *pgxuuid.UUID         `validate:"required"`
+	Items  []*InventoryCountItem `validate:"required,min=1,dive,required"`
+}
+
+// inventoryCountKey identifies a line of a count
+type inventoryCountKey struct {
+	ProductID pgxuuid.UUID
+	LotID     pgxuuid.UUID
+}
+
+func (s *ServiceManager) SubmitInventoryCount(ctx context.Context, params *SubmitInventoryCountParams) (*InventoryCountDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	ic, err := s.repo.GetInventoryCountByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if ic.Status != "OPEN" {
+		return nil, constants.NewInvalidOperationError("inventory count is not open")
+	}
+
+	var scope map[pgxuuid.UUID]bool
+	if ic.ProductIDs != nil {
+		scope = make(map[pgxuuid.UUID]bool, len(ic.ProductIDs))
+		for _, productID := range ic.ProductIDs {
+			scope[productID] = true
+		}
+	}
+
+	entries := make([]*repository.InventoryCountEntry, 0, len(params.Items))
+	entryMap := make(map[inventoryCountKey]*repository.InventoryCountEntry, len(params.Items))
+	for i, item := range params.Items {
+		if scope != nil && !scope[*item.ProductID] {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: product is not part of the count", i))
+		}
+
+		product, err := s.repo.GetProductByID(ctx, item.ProductID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: product not found", i))
+			}
+			return nil, err
+		}
+
+		_, factor, err := s.getItemUnitFactor(ctx, i, product, item.Unit)
+		if err != nil {
+			return nil, err
+		}
+
+		quantity := constants.RoundQuantity(item.Quantity.Mul(factor))
+		if quantity.IsNegative() {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: quantity must not be negative", i))
+		}
+
+		key := inventoryCountKey{ProductID: *product.ID}
+		var lotID *pgxuuid.UUID
+		if product.BatchControl {
+			if item.Batch == "" {
+				return nil, constants.NewRequiredFieldError(fmt.Sprintf("items[%d].batch", i))
+			}
+
+			lot, err := s.repo.GetLotByProductAndCode(ctx, item.ProductID, item.Batch)
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: lot %v not found for %v", i, item.Batch, product.Name))
+				}
+				return nil, err
+			}
+			lotID = lot.ID
+			key.LotID = *lot.ID
+		}
+
+		if entry, ok := entryMap[key]; ok {
+			entry.Quantity = entry.Quantity.Add(quantity)
+			continue
+		}
+
+		entry := &repository.InventoryCountEntry{
+			ProductID: product.ID,
+			LotID:     lotID,
+			Quantity:  quantity,
+		}
+		entryMap[key] = entry
+		entries = append(entries, entry)
+	}
+
+	ic, err = s.repo.SubmitInventoryCount(ctx, &repository.SubmitInventoryCountParams{
+		ID:      params.ID,
+		Device:  params.Device,
+		UserID:  params.UserID,
+		Entries: entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toInventoryCountDTO(ic), nil
+}
+
+// CloseInventoryCountParams closes a count and posts its variances on Date,
+// today when nil. Lines nobody counted are left untouched unless
+// ZeroUncounted is set, then they are counted as zero.
+type CloseInventoryCountParams struct {
+	ID            *pgxuuid.UUID `validate:"required"`
+	UserID        *pgxuuid.UUID `validate:"required"`
+	Date          *time.Time
+	ZeroUncounted bool
+}
+
+// CloseInventoryCount posts the difference between the counted and the
+// frozen expected quantities as a single ADJUST on the warehouse of the
+// count, valued at the average cost of each product on Date
+func (s *ServiceManager) CloseInventoryCount(ctx context.Context, params *CloseInventoryCountParams) (*InventoryCountDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	ic, err := s.repo.GetInventoryCountByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if ic.Status != "OPEN" {
+		return nil, constants.NewInvalidOperationError("inventory count is not open")
+	}
+
+	date := time.Now().UTC().Truncate(24 * time.Hour)
+	if params.Date != nil {
+		date = *params.Date
+	}
+	if date.Before(ic.Date) {
+		return nil, constants.InvalidParams("date must not be before the date of the count")
+	}
+
+	productIDs := make([]pgxuuid.UUID, 0, len(ic.Lines))
+	seen := make(map[pgxuuid.UUID]bool, len(ic.Lines))
+	for _, line := range ic.Lines {
+		if seen[*line.ProductID] {
+			continue
+		}
+		seen[*line.ProductID] = true
+		productIDs = append(productIDs, *line.ProductID)
+	}
+
+	valuations, err := s.fetchStockValuationsAt(ctx, productIDs, &date)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make(map[pgxuuid.UUID]decimal.Decimal, len(valuations))
+	for productID, valuation := range valuations {
+		prices[productID] = valuation.AverageCost
+	}
+
+	ic, err = s.repo.CloseInventoryCount(ctx, &repository.CloseInventoryCountParams{
+		ID:            params.ID,
+		UserID:        params.UserID,
+		Date:          date,
+		ZeroUncounted: params.ZeroUncounted,
+		Prices:        prices,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toInventoryCountDTO(ic), nil
+}
+
+type CancelInventoryCountParams struct {
+	ID     *pgxuuid.UUID `validate:"required"`
+	UserID *pgxuuid.UUID `validate:"required"`
+}
+
+func (s *ServiceManager) CancelInventoryCount(ctx context.Context, params *CancelInventoryCountParams) (*InventoryCountDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.repo.GetInventoryCountByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	ic, err := s.repo.CancelInventoryCount(ctx, &repository.CancelInventoryCountParams{
+		ID:     params.ID,
+		UserID: params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toInventoryCountDTO(ic), nil
+}
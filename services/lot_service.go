@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type LotDTO struct {
+	ID              *uuid.UUID      `json:"id"`
+	Status          string          `json:"status"`
+	ProductID       *uuid.UUID      `json:"productId"`
+	ProductName     string          `json:"productName"`
+	Code            string          `json:"code"`
+	ManufactureDate *time.Time      `json:"manufactureDate"`
+	ExpiryDate      *time.Time      `json:"expiryDate"`
+	ReceivedDate    *time.Time      `json:"receivedDate"`
+	Quantity        decimal.Decimal `json:"quantity"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt"`
+}
+
+func (s *ServiceManager) toLotDTO(lot *repository.Lot) *LotDTO {
+	lotId, err := s.parseUUID(lot.ID)
+	if err != nil {
+		lotId = nil
+	}
+
+	productId, err := s.parseUUID(lot.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	return &LotDTO{
+		ID:              lotId,
+		Status:          lot.Status,
+		ProductID:       productId,
+		ProductName:     lot.ProductName,
+		Code:            lot.Code,
+		ManufactureDate: lot.ManufactureDate,
+		ExpiryDate:      lot.ExpiryDate,
+		ReceivedDate:    lot.ReceivedDate,
+		Quantity:        lot.Quantity,
+		CreatedAt:       lot.CreatedAt,
+		UpdatedAt:       lot.UpdatedAt,
+	}
+}
+
+// validateLotDates reports whether the expiry date is not before the
+// manufacture date, a missing date is always valid
+func (s *ServiceManager) validateLotDates(manufactureDate *time.Time, expiryDate *time.Time) bool {
+	if manufactureDate == nil || expiryDate == nil {
+		return true
+	}
+
+	return !expiryDate.Before(*manufactureDate)
+}
+
+type CreateLotParams struct {
+	ProductID       *pgxuuid.UUID `validate:"required"`
+	Code            string        `validate:"required,lte=30"`
+	ManufactureDate *time.Time
+	ExpiryDate      *time.Time
+}
+
+func (s *ServiceManager) CreateLot(ctx context.Context, params *CreateLotParams) (*LotDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.validateLotDates(params.ManufactureDate, params.ExpiryDate) {
+		return nil, constants.NewInvalidOperationError("expiry date is before manufacture date")
+	}
+
+	product, err := s.repo.GetProductByID(ctx, params.ProductID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if !product.BatchControl {
+		return nil, constants.NewInvalidOperationError("product has no batch control")
+	}
+
+	_, err = s.repo.GetLotByProductAndCode(ctx, params.ProductID, params.Code)
+	if err == nil {
+		return nil, constants.NewUniqueConstrainError("code")
+	} else {
+		if err != pgx.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	lot, err := s.repo.CreateLot(ctx, &repository.CreateLotParams{
+		ProductID:       params.ProductID,
+		Code:            params.Code,
+		ManufactureDate: params.ManufactureDate,
+		ExpiryDate:      params.ExpiryDate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toLotDTO(lot), nil
+}
+
+type UpdateLotParams struct {
+	ID              *pgxuuid.UUID `validate:"required"`
+	Status          string        `validate:"required,custom_lot_status"`
+	ManufactureDate *time.Time
+	ExpiryDate      *time.Time
+}
+
+func (s *ServiceManager) UpdateLot(ctx context.Context, params *UpdateLotParams) (*LotDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.validateLotDates(params.ManufactureDate, params.ExpiryDate) {
+		return nil, constants.NewInvalidOperationError("expiry date is before manufacture date")
+	}
+
+	_, err = s.repo.GetLotByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	lot, err := s.repo.UpdateLot(ctx, &repository.UpdateLotParams{
+		ID:              params.ID,
+		Status:          params.Status,
+		ManufactureDate: params.ManufactureDate,
+		ExpiryDate:      params.ExpiryDate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toLotDTO(lot), nil
+}
+
+func (s *ServiceManager) FetchLotByID(ctx context.Context, id *pgxuuid.UUID) (*LotDTO, error) {
+	lot, err := s.repo.GetLotByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return s.toLotDTO(lot), nil
+}
+
+type FetchProductLotsParams struct {
+	ProductID     *pgxuuid.UUID `validate:"required"`
+	StatusOptions []string      `validate:"dive,custom_lot_status"`
+}
+
+func (s *ServiceManager) FetchProductLots(ctx context.Context, params *FetchProductLotsParams) ([]*LotDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params.StatusOptions) == 0 {
+		params.StatusOptions = []string{"ACTIVE", "QUARANTINE"}
+	}
+
+	lots, err := s.repo.FetchProductLots(ctx, &repository.FetchProductLotsParams{
+		ProductID:     params.ProductID,
+		StatusOptions: params.StatusOptions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lotsDTO := make([]*LotDTO, 0)
+	for _, lot := range lots {
+		lotsDTO = append(lotsDTO, s.toLotDTO(lot))
+	}
+
+	return lotsDTO, nil
+}
+
+// resolveItemLot checks the batch of a movement item against the lots of
+// batch controlled products and returns the lot the item should point to,
+// products without batch control keep a free text batch and no lot
+func (s *ServiceManager) resolveItemLot(ctx context.Context, index int, movementType string, date time.Time, product *repository.Product, item *CreateStockItem) (*repository.Lot, error) {
+	if !product.BatchControl {
+		return nil, nil
+	}
+
+	if item.Batch == "" {
+		return nil, constants.NewRequiredFieldError(fmt.Sprintf("items[%d].batch", index))
+	}
+
+	lot, err := s.repo.GetLotByProductAndCode(ctx, item.ProductID, item.Batch)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: lot %v not found for %v", index, item.Batch, product.Name))
+		}
+		return nil, err
+	}
+
+	if lot.Status == "INACTIVE" {
+		return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: lot %v is inactive", index, lot.Code))
+	}
+
+	// quarantined and expired lots are what usually goes back to the supplier
+	if isOutgoingItem(movementType, item.Quantity) && movementType != "PURCHASE_RETURN" {
+		if lot.Status == "QUARANTINE" {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: lot %v is in quarantine", index, lot.Code))
+		}
+		if lot.ExpiryDate != nil && lot.ExpiryDate.Before(date) {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: lot %v expired on %v", index, lot.Code, lot.ExpiryDate.Format("2006-01-02")))
+		}
+	}
+
+	return lot, nil
+}
+
+// allocateItemLots splits an outgoing item of a batch controlled product sent
+// without batch across the available lots, following the product allocation
+// (FEFO or FIFO) in the item warehouse. allocated keeps the quantity already
+// taken from each lot by previous items of the same movement. The quantity
+// reserved on a lot is left alone unless it is reserved for the sales order
+// delivered, and on an edit the items being replaced do not count.
+func (s *ServiceManager) allocateItemLots(ctx context.Context, index int, params *CreateStockMovementParams, product *repository.Product, item *CreateStockItem, allocated map[lotAllocationKey]decimal.Decimal) ([]*CreateStockItem, error) {
+	if !item.Quantity.IsPositive() {
+		return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: quantity must be positive", index))
+	}
+
+	lots, err := s.repo.FetchAvailableLots(ctx, &repository.FetchAvailableLotsParams{
+		ProductID:       item.ProductID,
+		WarehouseID:     item.WarehouseID,
+		Date:            params.Date,
+		Allocation:      product.LotAllocation,
+		SalesOrderID:    params.SalesOrderID,
+		StockMovementID: params.EditedID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := item.Quantity
+	items := make([]*CreateStockItem, 0)
+	for _, lot := range lots {
+		if !remaining.IsPositive() {
+			break
+		}
+
+		key := lotAllocationKey{LotID: *lot.ID, WarehouseID: *item.WarehouseID}
+		available := lot.Quantity.Sub(allocated[key])
+		if !available.IsPositive() {
+			continue
+		}
+
+		quantity := decimal.Min(available, remaining)
+
+		allocated[key] = allocated[key].Add(quantity)
+		remaining = remaining.Sub(quantity)
+		items = append(items, &CreateStockItem{
+			ProductID:   item.ProductID,
+			Quantity:    quantity,
+			Price:       item.Price,
+			Batch:       lot.Code,
+			WarehouseID: item.WarehouseID,
+		})
+	}
+
+	if remaining.IsPositive() {
+		productId, err := s.parseUUID(product.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, constants.NewInsufficientStockError([]*constants.InsufficientStockItem{
+			{
+				ProductID:   productId.String(),
+				ProductName: product.Name,
+				Requested:   item.Quantity,
+				Available:   item.Quantity.Sub(remaining),
+			},
+		})
+	}
+
+	return items, nil
+}
+
+// isOutgoingItem reports whether an item of the given movement type takes
+// quantity out of stock
+func isOutgoingItem(movementType string, quantity decimal.Decimal) bool {
+	switch movementType {
+	case "SALE", "PRODUCTION_OUT", "PURCHASE_RETURN":
+		return true
+	case "ADJUST":
+		return quantity.IsNegative()
+	}
+	return false
+}
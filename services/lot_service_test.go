@@ -0,0 +1,26 @@
+package services
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestIsOutgoingItem(t *testing.T) {
+	tests := []struct {
+		movementType string
+		quantity     int64
+		want         bool
+	}{
+		{"SALE", 5, true},
+		{"PRODUCTION_OUT", 5, true},
+		{"PURCHASE", 5, false},
+		{"PRODUCTION_IN", 5, false},
+		{"ADJUST", -5, true},
+		{"ADJUST", 5, false},
+	}
+	for _, tt := range tests {
+		if got := isOutgoingItem(tt.movementType, decimal.NewFromInt(tt.quantity)); got != tt.want {
+			t.Errorf("isOutgoingItem(%v, %v) = %v, want %v", tt.movementType, tt.quantity, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"log"
+	"time"
+)
+
+// LowStockProductDTO holds quantities in the base unit of the product.
+// Available is the stock on hand less reservations and OnOrder what open
+// purchase orders still have to receive, SuggestedQuantity is what is left
+// to buy after them.
+type LowStockProductDTO struct {
+	ProductID             *uuid.UUID       `json:"productId"`
+	ProductName           string           `json:"productName"`
+	Barcode               string           `json:"barcode"`
+	Unit                  string           `json:"unit"`
+	MinStock              *decimal.Decimal `json:"minStock"`
+	ReorderPoint          decimal.Decimal  `json:"reorderPoint"`
+	PreferredSupplierID   *uuid.UUID       `json:"preferredSupplierId"`
+	PreferredSupplierName string           `json:"preferredSupplierName"`
+	OnHand                decimal.Decimal  `json:"onHand"`
+	Reserved              decimal.Decimal  `json:"reserved"`
+	Available             decimal.Decimal  `json:"available"`
+	OnOrder               decimal.Decimal  `json:"onOrder"`
+	BelowMinimum          bool             `json:"belowMinimum"`
+	SuggestedQuantity     decimal.Decimal  `json:"suggestedQuantity"`
+
+	// AlertID is the alert of the product that is not resolved yet, missing
+	// until the evaluator opens it
+	AlertID       *uuid.UUID `json:"alertId"`
+	AlertStatus   string     `json:"alertStatus"`
+	AlertOpenedAt *time.Time `json:"alertOpenedAt"`
+}
+
+// suggestedOrderQuantity tops the available stock and what is on order up to
+// the reorder point plus the stock used while an order arrives, taken as the
+// gap between the reorder point and the minimum stock
+func suggestedOrderQuantity(item *repository.LowStockProduct) decimal.Decimal {
+	minStock := decimal.Zero
+	if item.MinStock != nil {
+		minStock = *item.MinStock
+	}
+
+	target := item.ReorderPoint.Add(item.ReorderPoint.Sub(minStock))
+	suggested := target.Sub(item.Available).Sub(item.OnOrder)
+	if !suggested.IsPositive() {
+		return decimal.Zero
+	}
+
+	return constants.RoundQuantity(suggested)
+}
+
+func (s *ServiceManager) toLowStockProductDTO(item *repository.LowStockProduct) *LowStockProductDTO {
+	productId, err := s.parseUUID(item.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	preferredSupplierId, err := s.parseUUID(item.PreferredSupplierID)
+	if err != nil {
+		preferredSupplierId = nil
+	}
+
+	alertId, err := s.parseUUID(item.AlertID)
+	if err != nil {
+		alertId = nil
+	}
+
+	return &LowStockProductDTO{
+		ProductID:             productId,
+		ProductName:           item.ProductName,
+		Barcode:               item.Barcode,
+		Unit:                  item.Unit,
+		MinStock:              item.MinStock,
+		ReorderPoint:          item.ReorderPoint,
+		PreferredSupplierID:   preferredSupplierId,
+		PreferredSupplierName: item.PreferredSupplierName,
+		OnHand:                item.OnHand,
+		Reserved:              item.Reserved,
+		Available:             item.Available,
+		OnOrder:               item.OnOrder,
+		BelowMinimum:          item.MinStock != nil && item.Available.LessThan(*item.MinStock),
+		SuggestedQuantity:     suggestedOrderQuantity(item),
+		AlertID:               alertId,
+		AlertStatus:           item.AlertStatus,
+		AlertOpenedAt:         item.AlertOpenedAt,
+	}
+}
+
+type FetchLowStockProductsParams struct {
+	PreferredSupplierID *pgxuuid.UUID
+	Limit               int `validate:"required,gte=1,lte=100"`
+	Offset              int `validate:"gte=0"`
+}
+
+type FetchLowStockProductsResult struct {
+	TotalCount int                   `json:"totalCount"`
+	Items      []*LowStockProductDTO `json:"items"`
+}
+
+// FetchLowStockProducts lists what purchasing has to buy, the products whose
+// available stock is below their reorder point
+func (s *ServiceManager) FetchLowStockProducts(ctx context.Context, params *FetchLowStockProductsParams) (*FetchLowStockProductsResult, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.FetchLowStockProducts(ctx, &repository.FetchLowStockProductsParams{
+		PreferredSupplierID: params.PreferredSupplierID,
+		Limit:               params.Limit,
+		Offset:              params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*LowStockProductDTO, 0, len(result.Items))
+	for _, item := range result.Items {
+		items = append(items, s.toLowStockProductDTO(item))
+	}
+
+	return &FetchLowStockProductsResult{
+		TotalCount: result.TotalCount,
+		Items:      items,
+	}, nil
+}
+
+type LowStockAlertDTO struct {
+	ID                *uuid.UUID      `json:"id"`
+	Status            string          `json:"status"`
+	ProductID         *uuid.UUID      `json:"productId"`
+	ProductName       string          `json:"productName"`
+	AvailableQuantity decimal.Decimal `json:"availableQuantity"`
+	ReorderPoint      decimal.Decimal `json:"reorderPoint"`
+	OpenedAt          time.Time       `json:"openedAt"`
+	ResolvedAt        *time.Time      `json:"resolvedAt"`
+	CreatedAt         time.Time       `json:"createdAt"`
+	UpdatedAt         time.Time       `json:"updatedAt"`
+
+	AcknowledgedByUserID   *uuid.UUID `json:"acknowledgedByUserId"`
+	AcknowledgedByUserName string     `json:"acknowledgedByUserName"`
+	AcknowledgedAt         *time.Time `json:"acknowledgedAt"`
+}
+
+func (s *ServiceManager) toLowStockAlertDTO(alert *repository.LowStockAlert) *LowStockAlertDTO {
+	alertId, err := s.parseUUID(alert.ID)
+	if err != nil {
+		alertId = nil
+	}
+
+	productId, err := s.parseUUID(alert.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	acknowledgedByUserId, err := s.parseUUID(alert.AcknowledgedByUserID)
+	if err != nil {
+		acknowledgedByUserId = nil
+	}
+
+	return &LowStockAlertDTO{
+		ID:                     alertId,
+		Status:                 alert.Status,
+		ProductID:              productId,
+		ProductName:            alert.ProductName,
+		AvailableQuantity:      alert.AvailableQuantity,
+		ReorderPoint:           alert.ReorderPoint,
+		OpenedAt:               alert.OpenedAt,
+		ResolvedAt:             alert.ResolvedAt,
+		CreatedAt:              alert.CreatedAt,
+		UpdatedAt:              alert.UpdatedAt,
+		AcknowledgedByUserID:   acknowledgedByUserId,
+		AcknowledgedByUserName: alert.AcknowledgedByUserName,
+		AcknowledgedAt:         alert.AcknowledgedAt,
+	}
+}
+
+type FetchLowStockAlertsParams struct {
+	StatusOptions []string `validate:"dive,oneof=OPEN ACKNOWLEDGED RESOLVED"`
+	ProductID     *pgxuuid.UUID
+	Limit         int `validate:"required,gte=1,lte=100"`
+	Offset        int `validate:"gte=0"`
+}
+
+type FetchLowStockAlertsResult struct {
+	TotalCount int                 `json:"totalCount"`
+	Items      []*LowStockAlertDTO `json:"items"`
+}
+
+func (s *ServiceManager) FetchLowStockAlerts(ctx context.Context, params *FetchLowStockAlertsParams) (*FetchLowStockAlertsResult, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.FetchLowStockAlerts(ctx, &repository.FetchLowStockAlertsParams{
+		StatusOptions: params.StatusOptions,
+		ProductID:     params.ProductID,
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*LowStockAlertDTO, 0, len(result.Items))
+	for _, item := range result.Items {
+		items = append(items, s.toLowStockAlertDTO(item))
+	}
+
+	return &FetchLowStockAlertsResult{
+		TotalCount: result.TotalCount,
+		Items:      items,
+	}, nil
+}
+
+type AcknowledgeLowStockAlertParams struct {
+	ID     *pgxuuid.UUID `validate:"required"`
+	UserID *pgxuuid.UUID `validate:"required"`
+}
+
+func (s *ServiceManager) AcknowledgeLowStockAlert(ctx context.Context, params *AcknowledgeLowStockAlertParams) (*LowStockAlertDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.repo.GetLowStockAlertByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	alert, err := s.repo.AcknowledgeLowStockAlert(ctx, &repository.AcknowledgeLowStockAlertParams{
+		ID:     params.ID,
+		UserID: params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toLowStockAlertDTO(alert), nil
+}
+
+// RunLowStockEvaluator opens and resolves the low stock alerts every interval
+// until ctx is done
+func (s *ServiceManager) RunLowStockEvaluator(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			opened, resolved, err := s.repo.EvaluateLowStockAlerts(ctx)
+			if err != nil {
+				log.Printf("low stock evaluator: %v\n", err)
+				continue
+			}
+			if opened > 0 || resolved > 0 {
+				log.Printf("low stock evaluator: %d alerts opened, %d resolved\n", opened, resolved)
+			}
+		}
+	}
+}
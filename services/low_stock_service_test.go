@@ -0,0 +1,34 @@
+package services
+
+import (
+	"github.com/hoffax/prodrest/repository"
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestSuggestedOrderQuantity(t *testing.T) {
+	minStock := decimal.NewFromInt(4)
+	tests := []struct {
+		name      string
+		minStock  *decimal.Decimal
+		available string
+		onOrder   string
+		want      string
+	}{
+		{"below the reorder point", &minStock, "5", "2", "9"},
+		{"without minimum stock", nil, "3", "0", "17"},
+		{"covered by what is on order", &minStock, "8", "10", "0"},
+		{"negative stock", &minStock, "-2", "0", "18"},
+	}
+	for _, tt := range tests {
+		got := suggestedOrderQuantity(&repository.LowStockProduct{
+			MinStock:     tt.minStock,
+			ReorderPoint: decimal.NewFromInt(10),
+			Available:    decimal.RequireFromString(tt.available),
+			OnOrder:      decimal.RequireFromString(tt.onOrder),
+		})
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
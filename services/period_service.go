@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+const periodLayout = "2006-01"
+
+type PeriodDTO struct {
+	Period            string     `json:"period"`
+	Status            string     `json:"status"`
+	UpdatedByUserID   *uuid.UUID `json:"updatedByUserId"`
+	UpdatedByUserName string     `json:"updatedByUserName"`
+	CreatedAt         *time.Time `json:"createdAt"`
+	UpdatedAt         *time.Time `json:"updatedAt"`
+	// Audits is only loaded for a single period, latest first
+	Audits []*PeriodAuditDTO `json:"audits,omitempty"`
+}
+
+type PeriodAuditDTO struct {
+	ID        *uuid.UUID `json:"id"`
+	Action    string     `json:"action"`
+	UserID    *uuid.UUID `json:"userId"`
+	UserName  string     `json:"userName"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func (s *ServiceManager) toPeriodDTO(period *repository.Period) *PeriodDTO {
+	updatedByUserId, err := s.parseUUID(period.UpdatedByUserID)
+	if err != nil {
+		updatedByUserId = nil
+	}
+
+	return &PeriodDTO{
+		Period:            period.Period.Format(periodLayout),
+		Status:            period.Status,
+		UpdatedByUserID:   updatedByUserId,
+		UpdatedByUserName: period.UpdatedByUserName,
+		CreatedAt:         &period.CreatedAt,
+		UpdatedAt:         &period.UpdatedAt,
+	}
+}
+
+type FetchPeriodsParams struct {
+	StatusOptions []string `validate:"dive,oneof=OPEN CLOSED"`
+}
+
+// FetchPeriods returns the periods that were ever closed, months that never
+// were are open and not listed
+func (s *ServiceManager) FetchPeriods(ctx context.Context, params *FetchPeriodsParams) ([]*PeriodDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	periods, err := s.repo.FetchPeriods(ctx, params.StatusOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	periodsDTO := make([]*PeriodDTO, 0)
+	for _, period := range periods {
+		periodsDTO = append(periodsDTO, s.toPeriodDTO(period))
+	}
+
+	return periodsDTO, nil
+}
+
+// FetchPeriod returns a period with its audits, a month that was never
+// closed is returned as OPEN
+func (s *ServiceManager) FetchPeriod(ctx context.Context, month time.Time) (*PeriodDTO, error) {
+	period, err := s.repo.GetPeriod(ctx, month)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &PeriodDTO{
+				Period: month.Format(periodLayout),
+				Status: "OPEN",
+				Audits: make([]*PeriodAuditDTO, 0),
+			}, nil
+		}
+		return nil, err
+	}
+
+	audits, err := s.repo.FetchPeriodAudits(ctx, month)
+	if err != nil {
+		return nil, err
+	}
+
+	periodDTO := s.toPeriodDTO(period)
+	periodDTO.Audits = make([]*PeriodAuditDTO, 0)
+	for _, audit := range audits {
+		auditId, err := s.parseUUID(audit.ID)
+		if err != nil {
+			auditId = nil
+		}
+
+		userId, err := s.parseUUID(audit.UserID)
+		if err != nil {
+			userId = nil
+		}
+
+		periodDTO.Audits = append(periodDTO.Audits, &PeriodAuditDTO{
+			ID:        auditId,
+			Action:    audit.Action,
+			UserID:    userId,
+			UserName:  audit.UserName,
+			Reason:    audit.Reason,
+			CreatedAt: audit.CreatedAt,
+		})
+	}
+
+	return periodDTO, nil
+}
+
+// ClosePeriodParams closes the month of Period, the reason is optional
+type ClosePeriodParams struct {
+	Period time.Time     `validate:"required"`
+	UserID *pgxuuid.UUID `validate:"required"`
+	Reason string        `validate:"lte=500"`
+}
+
+// ClosePeriod locks the movements dated inside a month, only months that
+// already started can be closed
+func (s *ServiceManager) ClosePeriod(ctx context.Context, params *ClosePeriodParams) (*PeriodDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Period.After(time.Now()) {
+		return nil, constants.NewInvalidOperationError("period has not started yet")
+	}
+
+	period, err := s.repo.SetPeriodStatus(ctx, &repository.SetPeriodStatusParams{
+		Period: params.Period,
+		Status: "CLOSED",
+		UserID: params.UserID,
+		Reason: params.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toPeriodDTO(period), nil
+}
+
+// ReopenPeriodParams reopens the month of Period, the reason is kept in the
+// audit of the period
+type ReopenPeriodParams struct {
+	Period time.Time     `validate:"required"`
+	UserID *pgxuuid.UUID `validate:"required"`
+	Reason string        `validate:"required,gte=3,lte=500"`
+}
+
+func (s *ServiceManager) ReopenPeriod(ctx context.Context, params *ReopenPeriodParams) (*PeriodDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	period, err := s.repo.SetPeriodStatus(ctx, &repository.SetPeriodStatusParams{
+		Period: params.Period,
+		Status: "OPEN",
+		UserID: params.UserID,
+		Reason: params.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toPeriodDTO(period), nil
+}
@@ -8,48 +8,92 @@ import (
 	"github.com/hoffax/prodrest/repository"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"strings"
 	"time"
 )
 
 type ProductDTO struct {
-	ID               *uuid.UUID `json:"id"`
-	Status           string     `json:"status"`
-	Name             string     `json:"name"`
-	Barcode          string     `json:"barcode"`
-	Unit             string     `json:"unit"`
-	BatchControl     bool       `json:"batchControl"`
-	ConversionFactor int        `json:"conversionFactor"`
-	Stock            int        `json:"stock"`
-	AverageCost      int        `json:"averageCost"`
-	CreatedAt        time.Time  `json:"createdAt"`
-	UpdatedAt        time.Time  `json:"updatedAt"`
+	ID                 *uuid.UUID      `json:"id"`
+	Status             string          `json:"status"`
+	Name               string          `json:"name"`
+	Barcode            string          `json:"barcode"`
+	Unit               string          `json:"unit"`
+	BatchControl       bool            `json:"batchControl"`
+	LotAllocation      string          `json:"lotAllocation"`
+	AllowNegativeStock bool            `json:"allowNegativeStock"`
+	TaxRate            string          `json:"taxRate"`
+	Stock              decimal.Decimal `json:"stock"`
+	ReservedStock      decimal.Decimal `json:"reservedStock"`
+	AvailableStock     decimal.Decimal `json:"availableStock"`
+	AverageCost        decimal.Decimal `json:"averageCost"`
+	CreatedAt          time.Time       `json:"createdAt"`
+	UpdatedAt          time.Time       `json:"updatedAt"`
+
+	// MinStock and ReorderPoint are in the base unit, a product whose
+	// available stock is below its reorder point shows up in the low stock
+	// alerts
+	MinStock            *decimal.Decimal `json:"minStock"`
+	ReorderPoint        *decimal.Decimal `json:"reorderPoint"`
+	PreferredSupplierID *uuid.UUID       `json:"preferredSupplierId"`
+
+	// Units are the packaging levels of the product, BarcodeUnit is set when
+	// the product was found by the barcode of one of them
+	Units       []*ProductUnitDTO `json:"units"`
+	BarcodeUnit string            `json:"barcodeUnit,omitempty"`
 }
 
-func (s *ServiceManager) toProductDTO(product *repository.Product) *ProductDTO {
+// toProductDTO builds the product with its stock on hand, the reserved part
+// of it and what is left available
+func (s *ServiceManager) toProductDTO(product *repository.Product, valuation *stockValuation, reserved decimal.Decimal) *ProductDTO {
 	productId, err := s.parseUUID(product.ID)
 	if err != nil {
 		productId = nil
 	}
 
+	preferredSupplierId, err := s.parseUUID(product.PreferredSupplierID)
+	if err != nil {
+		preferredSupplierId = nil
+	}
+
+	if valuation == nil {
+		valuation = &stockValuation{}
+	}
+
 	return &ProductDTO{
-		ID:               productId,
-		Status:           product.Status,
-		Name:             product.Name,
-		Barcode:          product.Barcode,
-		Unit:             product.Unit,
-		BatchControl:     product.BatchControl,
-		ConversionFactor: product.ConversionFactor,
-		CreatedAt:        product.CreatedAt,
-		UpdatedAt:        product.UpdatedAt,
+		ID:                  productId,
+		Status:              product.Status,
+		Name:                product.Name,
+		Barcode:             product.Barcode,
+		Unit:                product.Unit,
+		BatchControl:        product.BatchControl,
+		LotAllocation:       product.LotAllocation,
+		AllowNegativeStock:  product.AllowNegativeStock,
+		TaxRate:             product.TaxRate,
+		MinStock:            product.MinStock,
+		ReorderPoint:        product.ReorderPoint,
+		PreferredSupplierID: preferredSupplierId,
+		Stock:               valuation.Stock,
+		ReservedStock:       reserved,
+		AvailableStock:      valuation.Stock.Sub(reserved),
+		AverageCost:         valuation.AverageCost,
+		Units:               make([]*ProductUnitDTO, 0),
+		CreatedAt:           product.CreatedAt,
+		UpdatedAt:           product.UpdatedAt,
 	}
 }
 
 type CreateProductParams struct {
-	Barcode          string `validate:"required,gte=3"`
-	Name             string `validate:"required,gte=3,lte=80"`
-	Unit             string `validate:"required,custom_unit"`
-	ConversionFactor int    `validate:"required,gt=0"`
-	BatchControl     bool
+	Barcode             string `validate:"required,gte=3"`
+	Name                string `validate:"required,gte=3,lte=80"`
+	Unit                string `validate:"required"`
+	BatchControl        bool
+	LotAllocation       string `validate:"omitempty,custom_lot_allocation"`
+	AllowNegativeStock  bool
+	TaxRate             string           `validate:"omitempty,custom_tax_rate"`
+	MinStock            *decimal.Decimal `validate:"omitempty,gte=0"`
+	ReorderPoint        *decimal.Decimal `validate:"omitempty,gte=0"`
+	PreferredSupplierID *pgxuuid.UUID
 }
 
 func (s *ServiceManager) CreateProduct(ctx context.Context, params *CreateProductParams) (*ProductDTO, error) {
@@ -58,37 +102,62 @@ func (s *ServiceManager) CreateProduct(ctx context.Context, params *CreateProduc
 		return nil, err
 	}
 
-	_, err = s.repo.GetProductByBarcode(ctx, params.Barcode)
-	if err == nil {
-		return nil, constants.NewUniqueConstrainError("barcode")
-	} else {
-		if err != pgx.ErrNoRows {
-			return nil, err
-		}
+	if params.LotAllocation == "" {
+		params.LotAllocation = "FEFO"
+	}
+
+	if params.TaxRate == "" {
+		params.TaxRate = constants.TaxRateIVA10
+	}
+
+	unit, err := s.getActiveUnit(ctx, params.Unit, "unit")
+	if err != nil {
+		return nil, err
+	}
+	params.Unit = unit.Code
+
+	err = s.checkBarcodeAvailable(ctx, params.Barcode, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkReorderLevels(ctx, params.MinStock, params.ReorderPoint, params.PreferredSupplierID, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	product, err := s.repo.CreateProduct(ctx, &repository.CreateProductParams{
-		Barcode:          params.Barcode,
-		Name:             params.Name,
-		Unit:             params.Unit,
-		BatchControl:     params.BatchControl,
-		ConversionFactor: params.ConversionFactor,
+		Barcode:             params.Barcode,
+		Name:                params.Name,
+		Unit:                params.Unit,
+		BatchControl:        params.BatchControl,
+		LotAllocation:       params.LotAllocation,
+		AllowNegativeStock:  params.AllowNegativeStock,
+		TaxRate:             params.TaxRate,
+		MinStock:            params.MinStock,
+		ReorderPoint:        params.ReorderPoint,
+		PreferredSupplierID: params.PreferredSupplierID,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return s.toProductDTO(product), nil
+	return s.toProductDTO(product, nil, decimal.Zero), nil
 }
 
 type UpdateProductParams struct {
-	ID               *pgxuuid.UUID `validate:"required"`
-	Status           string        `validate:"required,custom_status"`
-	Barcode          string        `validate:"required,gte=3"`
-	Name             string        `validate:"required,gte=3,lte=80"`
-	Unit             string        `validate:"required,custom_unit"`
-	BatchControl     bool
-	ConversionFactor int `validate:"required,gte=1"`
+	ID                  *pgxuuid.UUID `validate:"required"`
+	Status              string        `validate:"required,custom_status"`
+	Barcode             string        `validate:"required,gte=3"`
+	Name                string        `validate:"required,gte=3,lte=80"`
+	Unit                string        `validate:"required"`
+	BatchControl        bool
+	LotAllocation       string `validate:"omitempty,custom_lot_allocation"`
+	AllowNegativeStock  bool
+	TaxRate             string           `validate:"omitempty,custom_tax_rate"`
+	MinStock            *decimal.Decimal `validate:"omitempty,gte=0"`
+	ReorderPoint        *decimal.Decimal `validate:"omitempty,gte=0"`
+	PreferredSupplierID *pgxuuid.UUID
 }
 
 func (s *ServiceManager) UpdateProduct(ctx context.Context, params *UpdateProductParams) (*ProductDTO, error) {
@@ -105,31 +174,85 @@ func (s *ServiceManager) UpdateProduct(ctx context.Context, params *UpdateProduc
 		return nil, err
 	}
 
-	if product.Barcode != params.Barcode {
-		_, err = s.repo.GetProductByBarcode(ctx, params.Barcode)
+	if params.LotAllocation == "" {
+		params.LotAllocation = product.LotAllocation
+	}
+
+	if params.TaxRate == "" {
+		params.TaxRate = product.TaxRate
+	}
+
+	if product.Unit != strings.ToUpper(params.Unit) {
+		// the quantities already recorded would change their meaning
+		inUse, err := s.repo.ProductBaseUnitInUse(ctx, params.ID)
+		if err != nil {
+			return nil, err
+		}
+		if inUse {
+			return nil, constants.NewInvalidOperationError("unit cannot change once the product has movements, packaging units or reservations")
+		}
+
+		unit, err := s.getActiveUnit(ctx, params.Unit, "unit")
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = s.repo.GetProductUnit(ctx, params.ID, unit.Code)
 		if err == nil {
-			return nil, constants.NewUniqueConstrainError("barcode")
-		} else {
-			if err != pgx.ErrNoRows {
-				return nil, err
-			}
+			return nil, constants.NewInvalidOperationError("unit is a packaging unit of the product")
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+	}
+	params.Unit = strings.ToUpper(params.Unit)
+
+	if product.Barcode != params.Barcode {
+		err = s.checkBarcodeAvailable(ctx, params.Barcode, params.ID, "")
+		if err != nil {
+			return nil, err
 		}
 	}
 
+	err = s.checkReorderLevels(ctx, params.MinStock, params.ReorderPoint, params.PreferredSupplierID, product.PreferredSupplierID)
+	if err != nil {
+		return nil, err
+	}
+
 	product, err = s.repo.UpdateProduct(ctx, &repository.UpdateProductParams{
-		ID:               params.ID,
-		Status:           params.Status,
-		Barcode:          params.Barcode,
-		Name:             params.Name,
-		Unit:             params.Unit,
-		BatchControl:     params.BatchControl,
-		ConversionFactor: params.ConversionFactor,
+		ID:                  params.ID,
+		Status:              params.Status,
+		Barcode:             params.Barcode,
+		Name:                params.Name,
+		Unit:                params.Unit,
+		BatchControl:        params.BatchControl,
+		LotAllocation:       params.LotAllocation,
+		AllowNegativeStock:  params.AllowNegativeStock,
+		TaxRate:             params.TaxRate,
+		MinStock:            params.MinStock,
+		ReorderPoint:        params.ReorderPoint,
+		PreferredSupplierID: params.PreferredSupplierID,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return s.toProductDTO(product), nil
+	return s.toProductDetailDTO(ctx, product)
+}
+
+// checkReorderLevels fails when the minimum stock is above the reorder point
+// or the preferred supplier is not an active entity. A product keeps its
+// current supplier, currentSupplierID, even if it was deactivated since.
+func (s *ServiceManager) checkReorderLevels(ctx context.Context, minStock *decimal.Decimal, reorderPoint *decimal.Decimal, supplierID *pgxuuid.UUID, currentSupplierID *pgxuuid.UUID) error {
+	if minStock != nil && reorderPoint != nil && minStock.GreaterThan(*reorderPoint) {
+		return constants.NewInvalidOperationError("minStock: minimum stock must not be above the reorder point")
+	}
+
+	if supplierID == nil || (currentSupplierID != nil && *supplierID == *currentSupplierID) {
+		return nil
+	}
+
+	_, err := s.getActiveEntity(ctx, supplierID, "preferredSupplierId")
+	return err
 }
 
 type FetchProductsParams struct {
@@ -160,9 +283,33 @@ func (s *ServiceManager) FetchProducts(ctx context.Context, params *FetchProduct
 		return nil, err
 	}
 
+	productIDs := make([]pgxuuid.UUID, len(result.Items))
+	for i, item := range result.Items {
+		productIDs[i] = *item.ID
+	}
+
+	valuations, err := s.fetchStockValuations(ctx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved, err := s.repo.FetchReservedStock(ctx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	productUnits, err := s.fetchProductUnitDTOs(ctx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
 	itemsDTP := make([]*ProductDTO, 0)
 	for _, item := range result.Items {
-		itemsDTP = append(itemsDTP, s.toProductDTO(item))
+		productDTO := s.toProductDTO(item, valuations[*item.ID], reserved[*item.ID])
+		if units, ok := productUnits[*item.ID]; ok {
+			productDTO.Units = units
+		}
+		itemsDTP = append(itemsDTP, productDTO)
 	}
 
 	return &FetchProductsDTOResult{
@@ -171,6 +318,32 @@ func (s *ServiceManager) FetchProducts(ctx context.Context, params *FetchProduct
 	}, nil
 }
 
+// toProductDetailDTO returns a single product with its stock valuation,
+// reservations and packaging units
+func (s *ServiceManager) toProductDetailDTO(ctx context.Context, product *repository.Product) (*ProductDTO, error) {
+	valuation, err := s.fetchProductValuation(ctx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved, err := s.repo.FetchReservedStock(ctx, []pgxuuid.UUID{*product.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	productUnits, err := s.fetchProductUnitDTOs(ctx, []pgxuuid.UUID{*product.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	productDTO := s.toProductDTO(product, valuation, reserved[*product.ID])
+	if units, ok := productUnits[*product.ID]; ok {
+		productDTO.Units = units
+	}
+
+	return productDTO, nil
+}
+
 func (s *ServiceManager) FetchProductById(ctx context.Context, id *pgxuuid.UUID) (*ProductDTO, error) {
 	product, err := s.repo.GetProductByID(ctx, id)
 	if err != nil {
@@ -180,11 +353,21 @@ func (s *ServiceManager) FetchProductById(ctx context.Context, id *pgxuuid.UUID)
 		return nil, err
 	}
 
-	return s.toProductDTO(product), nil
+	return s.toProductDetailDTO(ctx, product)
 }
 
+// FetchProductByBarcode looks the barcode up in the products and then in
+// their packaging units
 func (s *ServiceManager) FetchProductByBarcode(ctx context.Context, barcode string) (*ProductDTO, error) {
 	product, err := s.repo.GetProductByBarcode(ctx, barcode)
+	if err == nil {
+		return s.toProductDetailDTO(ctx, product)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	productUnit, err := s.repo.GetProductUnitByBarcode(ctx, barcode)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, constants.NewNotFoundError()
@@ -192,5 +375,16 @@ func (s *ServiceManager) FetchProductByBarcode(ctx context.Context, barcode stri
 		return nil, err
 	}
 
-	return s.toProductDTO(product), nil
+	product, err = s.repo.GetProductByID(ctx, productUnit.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	productDTO, err := s.toProductDetailDTO(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	productDTO.BarcodeUnit = productUnit.Unit
+
+	return productDTO, nil
 }
@@ -0,0 +1,577 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type ProductionOrderDTO struct {
+	ID               *uuid.UUID       `json:"id"`
+	Status           string           `json:"status"`
+	ProductID        *uuid.UUID       `json:"productId"`
+	ProductName      string           `json:"productName"`
+	BomID            *uuid.UUID       `json:"bomId"`
+	BomVersion       int              `json:"bomVersion"`
+	PlannedQuantity  decimal.Decimal  `json:"plannedQuantity"`
+	ProducedQuantity *decimal.Decimal `json:"producedQuantity"`
+	Date             time.Time        `json:"date"`
+	CompletedDate    *time.Time       `json:"completedDate"`
+	Batch            string           `json:"batch"`
+	Notes            string           `json:"notes"`
+	CreatedAt        time.Time        `json:"createdAt"`
+	UpdatedAt        time.Time        `json:"updatedAt"`
+
+	// costs are totals of the order except UnitCost, the price per unit of
+	// the finished product
+	ComponentsCost *decimal.Decimal `json:"componentsCost"`
+	LabourCost     decimal.Decimal  `json:"labourCost"`
+	OverheadCost   decimal.Decimal  `json:"overheadCost"`
+	TotalCost      *decimal.Decimal `json:"totalCost"`
+	UnitCost       *decimal.Decimal `json:"unitCost"`
+
+	ComponentsWarehouseID   *uuid.UUID `json:"componentsWarehouseId"`
+	ComponentsWarehouseName string     `json:"componentsWarehouseName"`
+	OutputWarehouseID       *uuid.UUID `json:"outputWarehouseId"`
+	OutputWarehouseName     string     `json:"outputWarehouseName"`
+	ConsumptionMovementID   *uuid.UUID `json:"consumptionMovementId"`
+	OutputMovementID        *uuid.UUID `json:"outputMovementId"`
+
+	CreatedByUserID     *uuid.UUID `json:"createdByUserId"`
+	CreatedByUserName   string     `json:"createdByUserName"`
+	CancelledByUserID   *uuid.UUID `json:"cancelledByUserId"`
+	CancelledByUserName string     `json:"cancelledByUserName"`
+}
+
+func (s *ServiceManager) toProductionOrderDTO(po *repository.ProductionOrder) *ProductionOrderDTO {
+	poId, err := s.parseUUID(po.ID)
+	if err != nil {
+		poId = nil
+	}
+
+	productId, err := s.parseUUID(po.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	bomId, err := s.parseUUID(po.BomID)
+	if err != nil {
+		bomId = nil
+	}
+
+	componentsWarehouseId, err := s.parseUUID(po.ComponentsWarehouseID)
+	if err != nil {
+		componentsWarehouseId = nil
+	}
+
+	outputWarehouseId, err := s.parseUUID(po.OutputWarehouseID)
+	if err != nil {
+		outputWarehouseId = nil
+	}
+
+	consumptionMovementId, err := s.parseUUID(po.ConsumptionMovementID)
+	if err != nil {
+		consumptionMovementId = nil
+	}
+
+	outputMovementId, err := s.parseUUID(po.OutputMovementID)
+	if err != nil {
+		outputMovementId = nil
+	}
+
+	createdByUserId, err := s.parseUUID(po.CreatedByUserID)
+	if err != nil {
+		createdByUserId = nil
+	}
+
+	cancelledByUserId, err := s.parseUUID(po.CancelledByUserID)
+	if err != nil {
+		cancelledByUserId = nil
+	}
+
+	var totalCost, unitCost *decimal.Decimal
+	if po.ComponentsCost != nil && po.ProducedQuantity != nil {
+		total := po.ComponentsCost.Add(po.LabourCost).Add(po.OverheadCost)
+		unit := constants.UnitPrice(total, *po.ProducedQuantity)
+		totalCost = &total
+		unitCost = &unit
+	}
+
+	return &ProductionOrderDTO{
+		ID:                      poId,
+		Status:                  po.Status,
+		ProductID:               productId,
+		ProductName:             po.ProductName,
+		BomID:                   bomId,
+		BomVersion:              po.BomVersion,
+		PlannedQuantity:         po.PlannedQuantity,
+		ProducedQuantity:        po.ProducedQuantity,
+		Date:                    po.Date,
+		CompletedDate:           po.CompletedDate,
+		Batch:                   po.Batch,
+		Notes:                   po.Notes,
+		CreatedAt:               po.CreatedAt,
+		UpdatedAt:               po.UpdatedAt,
+		ComponentsCost:          po.ComponentsCost,
+		LabourCost:              po.LabourCost,
+		OverheadCost:            po.OverheadCost,
+		TotalCost:               totalCost,
+		UnitCost:                unitCost,
+		ComponentsWarehouseID:   componentsWarehouseId,
+		ComponentsWarehouseName: po.ComponentsWarehouseName,
+		OutputWarehouseID:       outputWarehouseId,
+		OutputWarehouseName:     po.OutputWarehouseName,
+		ConsumptionMovementID:   consumptionMovementId,
+		OutputMovementID:        outputMovementId,
+		CreatedByUserID:         createdByUserId,
+		CreatedByUserName:       po.CreatedByUserName,
+		CancelledByUserID:       cancelledByUserId,
+		CancelledByUserName:     po.CancelledByUserName,
+	}
+}
+
+// getProductionBom returns the recipe an order of productID should follow,
+// the given version or the latest ACTIVE one when version is 0
+func (s *ServiceManager) getProductionBom(ctx context.Context, productID *pgxuuid.UUID, version int) (*repository.Bom, error) {
+	if version == 0 {
+		bom, err := s.repo.GetActiveBom(ctx, productID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, constants.NewInvalidOperationError("product has no active bom")
+			}
+			return nil, err
+		}
+		return bom, nil
+	}
+
+	bom, err := s.repo.GetBomByVersion(ctx, productID, version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("bom version %d not found", version))
+		}
+		return nil, err
+	}
+
+	if bom.Status != "ACTIVE" {
+		return nil, constants.NewInvalidOperationError(fmt.Sprintf("bom version %d is inactive", version))
+	}
+
+	return bom, nil
+}
+
+type CreateProductionOrderParams struct {
+	ProductID             *pgxuuid.UUID   `validate:"required"`
+	BomVersion            int             `validate:"gte=0"`
+	PlannedQuantity       decimal.Decimal `validate:"gt=0"`
+	Date                  time.Time       `validate:"required"`
+	ComponentsWarehouseID *pgxuuid.UUID
+	OutputWarehouseID     *pgxuuid.UUID
+	Batch                 string        `validate:"lte=30"`
+	Notes                 string        `validate:"lte=500"`
+	UserID                *pgxuuid.UUID `validate:"required"`
+}
+
+func (s *ServiceManager) CreateProductionOrder(ctx context.Context, params *CreateProductionOrderParams) (*ProductionOrderDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := s.repo.GetProductByID(ctx, params.ProductID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if product.Status != "ACTIVE" {
+		return nil, constants.NewInvalidOperationError("product is inactive")
+	}
+
+	bom, err := s.getProductionBom(ctx, params.ProductID, params.BomVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.getActiveWarehouse(ctx, params.ComponentsWarehouseID, "componentsWarehouseId")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.getActiveWarehouse(ctx, params.OutputWarehouseID, "outputWarehouseId")
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := s.repo.CreateProductionOrder(ctx, &repository.CreateProductionOrderParams{
+		ProductID:             params.ProductID,
+		BomID:                 bom.ID,
+		PlannedQuantity:       constants.RoundQuantity(params.PlannedQuantity),
+		Date:                  params.Date,
+		ComponentsWarehouseID: params.ComponentsWarehouseID,
+		OutputWarehouseID:     params.OutputWarehouseID,
+		Batch:                 params.Batch,
+		Notes:                 params.Notes,
+		CreatedBy:             params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toProductionOrderDTO(po), nil
+}
+
+type UpdateProductionOrderParams struct {
+	ID                    *pgxuuid.UUID   `validate:"required"`
+	BomVersion            int             `validate:"gte=0"`
+	PlannedQuantity       decimal.Decimal `validate:"gt=0"`
+	Date                  time.Time       `validate:"required"`
+	ComponentsWarehouseID *pgxuuid.UUID
+	OutputWarehouseID     *pgxuuid.UUID
+	Batch                 string `validate:"lte=30"`
+	Notes                 string `validate:"lte=500"`
+}
+
+func (s *ServiceManager) UpdateProductionOrder(ctx context.Context, params *UpdateProductionOrderParams) (*ProductionOrderDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := s.repo.GetProductionOrderByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if po.Status != "PLANNED" {
+		return nil, constants.NewInvalidOperationError("only planned production orders can be changed")
+	}
+
+	bomID := po.BomID
+	if params.BomVersion != 0 && params.BomVersion != po.BomVersion {
+		bom, err := s.getProductionBom(ctx, po.ProductID, params.BomVersion)
+		if err != nil {
+			return nil, err
+		}
+		bomID = bom.ID
+	}
+
+	_, err = s.getActiveWarehouse(ctx, params.ComponentsWarehouseID, "componentsWarehouseId")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.getActiveWarehouse(ctx, params.OutputWarehouseID, "outputWarehouseId")
+	if err != nil {
+		return nil, err
+	}
+
+	po, err = s.repo.UpdateProductionOrder(ctx, &repository.UpdateProductionOrderParams{
+		ID:                    params.ID,
+		BomID:                 bomID,
+		PlannedQuantity:       constants.RoundQuantity(params.PlannedQuantity),
+		Date:                  params.Date,
+		ComponentsWarehouseID: params.ComponentsWarehouseID,
+		OutputWarehouseID:     params.OutputWarehouseID,
+		Batch:                 params.Batch,
+		Notes:                 params.Notes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toProductionOrderDTO(po), nil
+}
+
+func (s *ServiceManager) StartProductionOrder(ctx context.Context, id *pgxuuid.UUID) (*ProductionOrderDTO, error) {
+	po, err := s.repo.GetProductionOrderByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if po.Status != "PLANNED" {
+		return nil, constants.NewInvalidOperationError("only planned production orders can be started")
+	}
+
+	po, err = s.repo.StartProductionOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toProductionOrderDTO(po), nil
+}
+
+type ProductionConsumptionParams struct {
+	ProductID *pgxuuid.UUID   `validate:"required"`
+	Quantity  decimal.Decimal `validate:"gt=0"`
+	Batch     string          `validate:"lte=30"`
+}
+
+type CompleteProductionOrderParams struct {
+	ID               *pgxuuid.UUID   `validate:"required"`
+	Date             time.Time       `validate:"required"`
+	ProducedQuantity decimal.Decimal `validate:"gt=0"`
+	// LabourCost and OverheadCost are totals for the whole order, added to
+	// the cost of the consumed components
+	LabourCost   decimal.Decimal `validate:"gte=0"`
+	OverheadCost decimal.Decimal `validate:"gte=0"`
+	Batch        string          `validate:"lte=30"`
+	// Consumption replaces the quantities of the recipe when the actual
+	// consumption differs, empty means consume what the recipe says
+	Consumption []*ProductionConsumptionParams `validate:"dive,required"`
+	UserID      *pgxuuid.UUID                  `validate:"required"`
+}
+
+// bomQuantity is the quantity of a recipe component needed for quantity
+// units of the finished product, scrap included
+func bomQuantity(item *repository.BomItem, quantity decimal.Decimal) decimal.Decimal {
+	scrap := decimal.NewFromInt(1).Add(item.ScrapPercentage.Div(decimal.NewFromInt(100)))
+	return constants.RoundQuantity(item.Quantity.Mul(quantity).Mul(scrap))
+}
+
+// bomConsumption scales the recipe to the produced quantity
+func bomConsumption(bom *repository.Bom, producedQuantity decimal.Decimal) []*ProductionConsumptionParams {
+	consumption := make([]*ProductionConsumptionParams, 0, len(bom.Items))
+	for _, item := range bom.Items {
+		consumption = append(consumption, &ProductionConsumptionParams{
+			ProductID: item.ComponentProductID,
+			Quantity:  bomQuantity(item, producedQuantity),
+		})
+	}
+
+	return consumption
+}
+
+// CompleteProductionOrder consumes the components from the components
+// warehouse and receives the finished product in the output warehouse. The
+// components are valued at their average cost on the completion date and the
+// finished product at the sum of that cost plus labour and overhead.
+func (s *ServiceManager) CompleteProductionOrder(ctx context.Context, params *CompleteProductionOrderParams) (*ProductionOrderDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := s.repo.GetProductionOrderByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if po.Status != "IN_PROGRESS" {
+		return nil, constants.NewInvalidOperationError("only production orders in progress can be completed")
+	}
+
+	params.ProducedQuantity = constants.RoundQuantity(params.ProducedQuantity)
+	params.LabourCost = constants.RoundAmount(params.LabourCost)
+	params.OverheadCost = constants.RoundAmount(params.OverheadCost)
+
+	if params.Date.Before(po.Date) {
+		return nil, constants.NewInvalidOperationError("completion date is before the order date")
+	}
+
+	consumption := params.Consumption
+	if len(consumption) == 0 {
+		bom, err := s.repo.GetBomByID(ctx, po.BomID)
+		if err != nil {
+			return nil, err
+		}
+		consumption = bomConsumption(bom, params.ProducedQuantity)
+	}
+
+	componentIDs := make([]pgxuuid.UUID, 0, len(consumption))
+	for i, component := range consumption {
+		if *component.ProductID == *po.ProductID {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("consumption[%d]: a product cannot consume itself", i))
+		}
+		componentIDs = append(componentIDs, *component.ProductID)
+	}
+
+	valuations, err := s.fetchStockValuationsAt(ctx, componentIDs, &params.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	consumptionItems := make([]*CreateStockItem, 0, len(consumption))
+	for _, component := range consumption {
+		consumptionItems = append(consumptionItems, &CreateStockItem{
+			ProductID: component.ProductID,
+			Quantity:  component.Quantity,
+			Price:     valuations[*component.ProductID].AverageCost,
+			Batch:     component.Batch,
+		})
+	}
+
+	consumptionParams := &CreateStockMovementParams{
+		Type:        "PRODUCTION_OUT",
+		Date:        params.Date,
+		WarehouseID: po.ComponentsWarehouseID,
+		UserID:      params.UserID,
+		Items:       consumptionItems,
+	}
+	consumptionRows, err := s.buildStockItems(ctx, consumptionParams)
+	if err != nil {
+		return nil, err
+	}
+
+	componentsCost := decimal.Zero
+	for _, row := range consumptionRows {
+		componentsCost = componentsCost.Add(constants.LineTotal(row.Quantity, row.Price))
+	}
+	totalCost := componentsCost.Add(params.LabourCost).Add(params.OverheadCost)
+	unitCost := constants.UnitPrice(totalCost, params.ProducedQuantity)
+
+	batch := params.Batch
+	if batch == "" {
+		batch = po.Batch
+	}
+
+	outputParams := &CreateStockMovementParams{
+		Type:        "PRODUCTION_IN",
+		Date:        params.Date,
+		WarehouseID: po.OutputWarehouseID,
+		UserID:      params.UserID,
+		Items: []*CreateStockItem{
+			{
+				ProductID: po.ProductID,
+				Quantity:  params.ProducedQuantity,
+				Price:     unitCost,
+				Batch:     batch,
+			},
+		},
+	}
+	outputRows, err := s.buildStockItems(ctx, outputParams)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err = s.repo.CompleteProductionOrder(ctx, &repository.CompleteProductionOrderParams{
+		ID:               params.ID,
+		ProducedQuantity: params.ProducedQuantity,
+		Date:             params.Date,
+		Batch:            batch,
+		ComponentsCost:   componentsCost,
+		LabourCost:       params.LabourCost,
+		OverheadCost:     params.OverheadCost,
+		Consumption: &repository.CreateStockMovementParams{
+			Type:      consumptionParams.Type,
+			Date:      consumptionParams.Date,
+			CreatedBy: params.UserID,
+			Items:     consumptionRows,
+		},
+		Output: &repository.CreateStockMovementParams{
+			Type:      outputParams.Type,
+			Date:      outputParams.Date,
+			CreatedBy: params.UserID,
+			Items:     outputRows,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toProductionOrderDTO(po), nil
+}
+
+type CancelProductionOrderParams struct {
+	ID     *pgxuuid.UUID `validate:"required"`
+	UserID *pgxuuid.UUID `validate:"required"`
+}
+
+// CancelProductionOrder cancels the order, a completed order also gets its
+// consumption and output movements cancelled
+func (s *ServiceManager) CancelProductionOrder(ctx context.Context, params *CancelProductionOrderParams) (*ProductionOrderDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := s.repo.GetProductionOrderByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if po.Status == "CANCELLED" {
+		return nil, constants.NewInvalidOperationError("production order is already cancelled")
+	}
+
+	po, err = s.repo.CancelProductionOrder(ctx, &repository.CancelProductionOrderParams{
+		ID:     params.ID,
+		UserID: params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toProductionOrderDTO(po), nil
+}
+
+func (s *ServiceManager) FetchProductionOrderByID(ctx context.Context, id *pgxuuid.UUID) (*ProductionOrderDTO, error) {
+	po, err := s.repo.GetProductionOrderByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return s.toProductionOrderDTO(po), nil
+}
+
+type FetchProductionOrdersParams struct {
+	StatusOptions []string `validate:"dive,custom_production_order_status"`
+	ProductID     *pgxuuid.UUID
+	Limit         int `validate:"required,gte=1,lte=100"`
+	Offset        int `validate:"gte=0"`
+}
+
+type FetchProductionOrdersResult struct {
+	TotalCount int                   `json:"totalCount"`
+	Items      []*ProductionOrderDTO `json:"items"`
+}
+
+func (s *ServiceManager) FetchProductionOrders(ctx context.Context, params *FetchProductionOrdersParams) (*FetchProductionOrdersResult, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.FetchProductionOrders(ctx, &repository.FetchProductionOrdersParams{
+		StatusOptions: params.StatusOptions,
+		ProductID:     params.ProductID,
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*ProductionOrderDTO, 0)
+	for _, po := range result.Items {
+		items = append(items, s.toProductionOrderDTO(po))
+	}
+
+	return &FetchProductionOrdersResult{
+		TotalCount: result.TotalCount,
+		Items:      items,
+	}, nil
+}
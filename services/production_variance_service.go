@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// ComponentVarianceDTO compares the expected and actual consumption of a
+// component. Variance is actual minus expected, positive means more material
+// was used than the recipe allows.
+type ComponentVarianceDTO struct {
+	ProductID          *uuid.UUID      `json:"productId"`
+	ProductName        string          `json:"productName"`
+	ExpectedQuantity   decimal.Decimal `json:"expectedQuantity"`
+	ActualQuantity     decimal.Decimal `json:"actualQuantity"`
+	Variance           decimal.Decimal `json:"variance"`
+	VariancePercentage decimal.Decimal `json:"variancePercentage"`
+	UnitCost           decimal.Decimal `json:"unitCost"`
+	VarianceValuation  decimal.Decimal `json:"varianceValuation"`
+}
+
+type ProductionOrderVarianceDTO struct {
+	ProductionOrderID *uuid.UUID              `json:"productionOrderId"`
+	ProductID         *uuid.UUID              `json:"productId"`
+	ProductName       string                  `json:"productName"`
+	BomVersion        int                     `json:"bomVersion"`
+	CompletedDate     time.Time               `json:"completedDate"`
+	ExpectedOutput    decimal.Decimal         `json:"expectedOutput"`
+	ActualOutput      decimal.Decimal         `json:"actualOutput"`
+	OutputVariance    decimal.Decimal         `json:"outputVariance"`
+	YieldPercentage   decimal.Decimal         `json:"yieldPercentage"`
+	WasteValuation    decimal.Decimal         `json:"wasteValuation"`
+	Components        []*ComponentVarianceDTO `json:"components"`
+}
+
+type ProductVarianceDTO struct {
+	ProductID       *uuid.UUID              `json:"productId"`
+	ProductName     string                  `json:"productName"`
+	Orders          int                     `json:"orders"`
+	ExpectedOutput  decimal.Decimal         `json:"expectedOutput"`
+	ActualOutput    decimal.Decimal         `json:"actualOutput"`
+	OutputVariance  decimal.Decimal         `json:"outputVariance"`
+	YieldPercentage decimal.Decimal         `json:"yieldPercentage"`
+	WasteValuation  decimal.Decimal         `json:"wasteValuation"`
+	Components      []*ComponentVarianceDTO `json:"components"`
+}
+
+type ProductionVarianceReportDTO struct {
+	From     *time.Time                    `json:"from"`
+	To       *time.Time                    `json:"to"`
+	Orders   []*ProductionOrderVarianceDTO `json:"orders"`
+	Products []*ProductVarianceDTO         `json:"products"`
+}
+
+type FetchProductionVarianceParams struct {
+	From      *time.Time
+	To        *time.Time
+	ProductID *pgxuuid.UUID
+}
+
+// addComponentVariance accumulates a component line into the lines of a
+// product, keeping the order they were first seen in
+func addComponentVariance(components []*ComponentVarianceDTO, index map[uuid.UUID]*ComponentVarianceDTO, line *ComponentVarianceDTO) []*ComponentVarianceDTO {
+	total, ok := index[*line.ProductID]
+	if !ok {
+		total = &ComponentVarianceDTO{
+			ProductID:   line.ProductID,
+			ProductName: line.ProductName,
+		}
+		index[*line.ProductID] = total
+		components = append(components, total)
+	}
+
+	total.ExpectedQuantity = total.ExpectedQuantity.Add(line.ExpectedQuantity)
+	total.ActualQuantity = total.ActualQuantity.Add(line.ActualQuantity)
+	total.Variance = total.Variance.Add(line.Variance)
+	total.VarianceValuation = total.VarianceValuation.Add(line.VarianceValuation)
+	total.VariancePercentage = constants.Percentage(total.Variance, total.ExpectedQuantity)
+	total.UnitCost = constants.UnitPrice(total.VarianceValuation, total.Variance)
+
+	return components
+}
+
+// FetchProductionVariance compares the expected output and consumption of
+// the orders completed between From and To with the movements they posted.
+// Expected values come from the recipe and the planned quantity. Components
+// are valued at the cost they were consumed at, or their current average cost
+// when they were not consumed at all. The waste valuation only adds the
+// components consumed over what the recipe expects.
+func (s *ServiceManager) FetchProductionVariance(ctx context.Context, params *FetchProductionVarianceParams) (*ProductionVarianceReportDTO, error) {
+	if params.From != nil && params.To != nil && params.To.Before(*params.From) {
+		return nil, constants.InvalidParams("to must not be before from")
+	}
+
+	result, err := s.repo.FetchProductionVariances(ctx, &repository.FetchProductionVariancesParams{
+		From:      params.From,
+		To:        params.To,
+		ProductID: params.ProductID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unconsumedIDs := make([]pgxuuid.UUID, 0)
+	for _, component := range result.Components {
+		if component.ActualQuantity.IsZero() {
+			unconsumedIDs = append(unconsumedIDs, *component.ProductID)
+		}
+	}
+
+	valuations, err := s.fetchStockValuations(ctx, unconsumedIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	componentsByOrder := make(map[pgxuuid.UUID][]*repository.ProductionVarianceComponent)
+	for _, component := range result.Components {
+		componentsByOrder[*component.ProductionOrderID] = append(componentsByOrder[*component.ProductionOrderID], component)
+	}
+
+	report := &ProductionVarianceReportDTO{
+		From:     params.From,
+		To:       params.To,
+		Orders:   make([]*ProductionOrderVarianceDTO, 0),
+		Products: make([]*ProductVarianceDTO, 0),
+	}
+
+	products := make(map[pgxuuid.UUID]*ProductVarianceDTO)
+	productComponents := make(map[pgxuuid.UUID]map[uuid.UUID]*ComponentVarianceDTO)
+	for _, order := range result.Orders {
+		orderId, err := s.parseUUID(order.ID)
+		if err != nil {
+			orderId = nil
+		}
+
+		productId, err := s.parseUUID(order.ProductID)
+		if err != nil {
+			productId = nil
+		}
+
+		orderVariance := &ProductionOrderVarianceDTO{
+			ProductionOrderID: orderId,
+			ProductID:         productId,
+			ProductName:       order.ProductName,
+			BomVersion:        order.BomVersion,
+			CompletedDate:     order.CompletedDate,
+			ExpectedOutput:    order.PlannedQuantity,
+			ActualOutput:      order.ProducedQuantity,
+			OutputVariance:    order.ProducedQuantity.Sub(order.PlannedQuantity),
+			YieldPercentage:   constants.Percentage(order.ProducedQuantity, order.PlannedQuantity),
+			Components:        make([]*ComponentVarianceDTO, 0),
+		}
+
+		for _, component := range componentsByOrder[*order.ID] {
+			componentId, err := s.parseUUID(component.ProductID)
+			if err != nil {
+				continue
+			}
+
+			unitCost := constants.UnitPrice(component.ActualValue, component.ActualQuantity)
+			if valuation, ok := valuations[*component.ProductID]; ok && component.ActualQuantity.IsZero() {
+				unitCost = valuation.AverageCost
+			}
+
+			variance := component.ActualQuantity.Sub(component.ExpectedQuantity)
+			line := &ComponentVarianceDTO{
+				ProductID:          componentId,
+				ProductName:        component.ProductName,
+				ExpectedQuantity:   component.ExpectedQuantity,
+				ActualQuantity:     component.ActualQuantity,
+				Variance:           variance,
+				VariancePercentage: constants.Percentage(variance, component.ExpectedQuantity),
+				UnitCost:           unitCost,
+				VarianceValuation:  constants.LineTotal(variance, unitCost),
+			}
+			if line.VarianceValuation.IsPositive() {
+				orderVariance.WasteValuation = orderVariance.WasteValuation.Add(line.VarianceValuation)
+			}
+			orderVariance.Components = append(orderVariance.Components, line)
+		}
+
+		report.Orders = append(report.Orders, orderVariance)
+
+		product, ok := products[*order.ProductID]
+		if !ok {
+			product = &ProductVarianceDTO{
+				ProductID:   productId,
+				ProductName: order.ProductName,
+				Components:  make([]*ComponentVarianceDTO, 0),
+			}
+			products[*order.ProductID] = product
+			productComponents[*order.ProductID] = make(map[uuid.UUID]*ComponentVarianceDTO)
+			report.Products = append(report.Products, product)
+		}
+
+		product.Orders++
+		product.ExpectedOutput = product.ExpectedOutput.Add(orderVariance.ExpectedOutput)
+		product.ActualOutput = product.ActualOutput.Add(orderVariance.ActualOutput)
+		product.OutputVariance = product.OutputVariance.Add(orderVariance.OutputVariance)
+		product.YieldPercentage = constants.Percentage(product.ActualOutput, product.ExpectedOutput)
+		product.WasteValuation = product.WasteValuation.Add(orderVariance.WasteValuation)
+		for _, line := range orderVariance.Components {
+			product.Components = addComponentVariance(product.Components, productComponents[*order.ProductID], line)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,542 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+type PurchaseOrderDTO struct {
+	ID           *uuid.UUID `json:"id"`
+	Status       string     `json:"status"`
+	EntityID     *uuid.UUID `json:"entityId"`
+	EntityName   string     `json:"entityName"`
+	Date         time.Time  `json:"date"`
+	ExpectedDate *time.Time `json:"expectedDate"`
+	Currency     string     `json:"currency"`
+	Notes        string     `json:"notes"`
+	// Total is the ordered value of the lines in Currency
+	Total     decimal.Decimal `json:"total"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+
+	CreatedByUserID   *uuid.UUID `json:"createdByUserId"`
+	CreatedByUserName string     `json:"createdByUserName"`
+	ClosedByUserID    *uuid.UUID `json:"closedByUserId"`
+	ClosedByUserName  string     `json:"closedByUserName"`
+
+	Lines []*PurchaseOrderLineDTO `json:"lines"`
+}
+
+// PurchaseOrderLineDTO holds quantities in the base unit of the product,
+// OutstandingQuantity is what is still to be received
+type PurchaseOrderLineDTO struct {
+	ID                  *uuid.UUID      `json:"id"`
+	ProductID           *uuid.UUID      `json:"productId"`
+	ProductName         string          `json:"productName"`
+	Quantity            decimal.Decimal `json:"quantity"`
+	Price               decimal.Decimal `json:"price"`
+	Total               decimal.Decimal `json:"total"`
+	ReceivedQuantity    decimal.Decimal `json:"receivedQuantity"`
+	OutstandingQuantity decimal.Decimal `json:"outstandingQuantity"`
+}
+
+func (s *ServiceManager) toPurchaseOrderLineDTO(line *repository.PurchaseOrderLine) *PurchaseOrderLineDTO {
+	lineId, err := s.parseUUID(line.ID)
+	if err != nil {
+		lineId = nil
+	}
+
+	productId, err := s.parseUUID(line.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	return &PurchaseOrderLineDTO{
+		ID:                  lineId,
+		ProductID:           productId,
+		ProductName:         line.ProductName,
+		Quantity:            line.Quantity,
+		Price:               line.Price,
+		Total:               constants.LineTotal(line.Quantity, line.Price),
+		ReceivedQuantity:    line.ReceivedQuantity,
+		OutstandingQuantity: decimal.Max(line.Quantity.Sub(line.ReceivedQuantity), decimal.Zero),
+	}
+}
+
+func (s *ServiceManager) toPurchaseOrderDTO(po *repository.PurchaseOrder) *PurchaseOrderDTO {
+	poId, err := s.parseUUID(po.ID)
+	if err != nil {
+		poId = nil
+	}
+
+	entityId, err := s.parseUUID(po.EntityID)
+	if err != nil {
+		entityId = nil
+	}
+
+	createdByUserId, err := s.parseUUID(po.CreatedByUserID)
+	if err != nil {
+		createdByUserId = nil
+	}
+
+	closedByUserId, err := s.parseUUID(po.ClosedByUserID)
+	if err != nil {
+		closedByUserId = nil
+	}
+
+	total := decimal.Zero
+	lines := make([]*PurchaseOrderLineDTO, 0)
+	for _, line := range po.Lines {
+		lineDTO := s.toPurchaseOrderLineDTO(line)
+		total = total.Add(lineDTO.Total)
+		lines = append(lines, lineDTO)
+	}
+
+	return &PurchaseOrderDTO{
+		ID:                poId,
+		Status:            po.Status,
+		EntityID:          entityId,
+		EntityName:        po.EntityName,
+		Date:              po.Date,
+		ExpectedDate:      po.ExpectedDate,
+		Currency:          po.Currency,
+		Notes:             po.Notes,
+		Total:             total,
+		CreatedAt:         po.CreatedAt,
+		UpdatedAt:         po.UpdatedAt,
+		CreatedByUserID:   createdByUserId,
+		CreatedByUserName: po.CreatedByUserName,
+		ClosedByUserID:    closedByUserId,
+		ClosedByUserName:  po.ClosedByUserName,
+		Lines:             lines,
+	}
+}
+
+func (s *ServiceManager) getPurchaseOrder(ctx context.Context, id *pgxuuid.UUID) (*repository.PurchaseOrder, error) {
+	po, err := s.repo.GetPurchaseOrderByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return po, nil
+}
+
+type PurchaseOrderLineParams struct {
+	ProductID *pgxuuid.UUID   `validate:"required"`
+	Quantity  decimal.Decimal `validate:"gt=0"`
+	Price     decimal.Decimal `validate:"gte=0"`
+}
+
+// checkPurchaseOrder validates the supplier, dates and lines of an order
+// and returns the lines to store, rounded like movement items
+func (s *ServiceManager) checkPurchaseOrder(ctx context.Context, entityID *pgxuuid.UUID, date time.Time, expectedDate *time.Time, lines []*PurchaseOrderLineParams) ([]*repository.CreatePurchaseOrderLine, error) {
+	_, err := s.getActiveEntity(ctx, entityID, "entityId")
+	if err != nil {
+		return nil, err
+	}
+
+	if expectedDate != nil && expectedDate.Before(date) {
+		return nil, constants.InvalidParams("expectedDate must not be before date")
+	}
+
+	createLines := make([]*repository.CreatePurchaseOrderLine, 0, len(lines))
+	seen := make(map[pgxuuid.UUID]bool, len(lines))
+	for i, line := range lines {
+		if seen[*line.ProductID] {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("lines[%d]: product is already on another line", i))
+		}
+		seen[*line.ProductID] = true
+
+		product, err := s.repo.GetProductByID(ctx, line.ProductID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, constants.NewInvalidOperationError(fmt.Sprintf("lines[%d]: product not found", i))
+			}
+			return nil, err
+		}
+
+		if product.Status != "ACTIVE" {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("lines[%d]: product is inactive", i))
+		}
+
+		quantity := constants.RoundQuantity(line.Quantity)
+		if !quantity.IsPositive() {
+			return nil, constants.NewRequiredFieldError(fmt.Sprintf("lines[%d].quantity", i))
+		}
+
+		createLines = append(createLines, &repository.CreatePurchaseOrderLine{
+			ProductID: line.ProductID,
+			Quantity:  quantity,
+			Price:     constants.RoundPrice(line.Price),
+		})
+	}
+
+	return createLines, nil
+}
+
+type FetchPurchaseOrdersParams struct {
+	StatusOptions []string `validate:"dive,oneof=DRAFT SENT PARTIALLY_RECEIVED RECEIVED CLOSED"`
+	EntityID      *pgxuuid.UUID
+	ProductID     *pgxuuid.UUID
+	Limit         int `validate:"required,gte=1,lte=100"`
+	Offset        int `validate:"gte=0"`
+}
+
+type FetchPurchaseOrdersResult struct {
+	TotalCount int                 `json:"totalCount"`
+	Items      []*PurchaseOrderDTO `json:"items"`
+}
+
+func (s *ServiceManager) FetchPurchaseOrders(ctx context.Context, params *FetchPurchaseOrdersParams) (*FetchPurchaseOrdersResult, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.FetchPurchaseOrders(ctx, &repository.FetchPurchaseOrdersParams{
+		StatusOptions: params.StatusOptions,
+		EntityID:      params.EntityID,
+		ProductID:     params.ProductID,
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*PurchaseOrderDTO, 0)
+	for _, po := range result.Items {
+		items = append(items, s.toPurchaseOrderDTO(po))
+	}
+
+	return &FetchPurchaseOrdersResult{
+		TotalCount: result.TotalCount,
+		Items:      items,
+	}, nil
+}
+
+func (s *ServiceManager) FetchPurchaseOrderByID(ctx context.Context, id *pgxuuid.UUID) (*PurchaseOrderDTO, error) {
+	po, err := s.getPurchaseOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toPurchaseOrderDTO(po), nil
+}
+
+// OpenPurchaseOrderLineDTO is a line still expected from a supplier
+type OpenPurchaseOrderLineDTO struct {
+	PurchaseOrderLineDTO
+	PurchaseOrderID *uuid.UUID `json:"purchaseOrderId"`
+	EntityID        *uuid.UUID `json:"entityId"`
+	EntityName      string     `json:"entityName"`
+	Date            time.Time  `json:"date"`
+	ExpectedDate    *time.Time `json:"expectedDate"`
+	Currency        string     `json:"currency"`
+}
+
+type FetchOpenPurchaseOrderLinesParams struct {
+	EntityID  *pgxuuid.UUID
+	ProductID *pgxuuid.UUID
+}
+
+// FetchOpenPurchaseOrderLines returns what is still to be received on sent
+// orders, the lines expected first on top
+func (s *ServiceManager) FetchOpenPurchaseOrderLines(ctx context.Context, params *FetchOpenPurchaseOrderLinesParams) ([]*OpenPurchaseOrderLineDTO, error) {
+	lines, err := s.repo.FetchOpenPurchaseOrderLines(ctx, &repository.FetchOpenPurchaseOrderLinesParams{
+		EntityID:  params.EntityID,
+		ProductID: params.ProductID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	linesDTO := make([]*OpenPurchaseOrderLineDTO, 0)
+	for _, line := range lines {
+		poId, err := s.parseUUID(line.PurchaseOrderID)
+		if err != nil {
+			poId = nil
+		}
+
+		entityId, err := s.parseUUID(line.EntityID)
+		if err != nil {
+			entityId = nil
+		}
+
+		linesDTO = append(linesDTO, &OpenPurchaseOrderLineDTO{
+			PurchaseOrderLineDTO: *s.toPurchaseOrderLineDTO(&line.PurchaseOrderLine),
+			PurchaseOrderID:      poId,
+			EntityID:             entityId,
+			EntityName:           line.EntityName,
+			Date:                 line.Date,
+			ExpectedDate:         line.ExpectedDate,
+			Currency:             line.Currency,
+		})
+	}
+
+	return linesDTO, nil
+}
+
+type CreatePurchaseOrderParams struct {
+	EntityID     *pgxuuid.UUID `validate:"required"`
+	Date         time.Time     `validate:"required"`
+	ExpectedDate *time.Time
+	// Currency of the line prices, the base currency when empty
+	Currency string                     `validate:"omitempty,iso4217"`
+	Notes    string                     `validate:"lte=500"`
+	UserID   *pgxuuid.UUID              `validate:"required"`
+	Lines    []*PurchaseOrderLineParams `validate:"required,min=1,dive,required"`
+}
+
+func (s *ServiceManager) CreatePurchaseOrder(ctx context.Context, params *CreatePurchaseOrderParams) (*PurchaseOrderDTO, error) {
+	params.Currency = strings.ToUpper(params.Currency)
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Currency == "" {
+		params.Currency = constants.BaseCurrency
+	}
+
+	lines, err := s.checkPurchaseOrder(ctx, params.EntityID, params.Date, params.ExpectedDate, params.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := s.repo.CreatePurchaseOrder(ctx, &repository.CreatePurchaseOrderParams{
+		EntityID:     params.EntityID,
+		Date:         params.Date,
+		ExpectedDate: params.ExpectedDate,
+		Currency:     params.Currency,
+		Notes:        params.Notes,
+		CreatedBy:    params.UserID,
+		Lines:        lines,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toPurchaseOrderDTO(po), nil
+}
+
+type UpdatePurchaseOrderParams struct {
+	ID           *pgxuuid.UUID `validate:"required"`
+	EntityID     *pgxuuid.UUID `validate:"required"`
+	Date         time.Time     `validate:"required"`
+	ExpectedDate *time.Time
+	Currency     string                     `validate:"omitempty,iso4217"`
+	Notes        string                     `validate:"lte=500"`
+	Lines        []*PurchaseOrderLineParams `validate:"required,min=1,dive,required"`
+}
+
+// UpdatePurchaseOrder changes a DRAFT order, once sent only its status moves
+func (s *ServiceManager) UpdatePurchaseOrder(ctx context.Context, params *UpdatePurchaseOrderParams) (*PurchaseOrderDTO, error) {
+	params.Currency = strings.ToUpper(params.Currency)
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := s.getPurchaseOrder(ctx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if po.Status != "DRAFT" {
+		return nil, constants.NewInvalidOperationError("only draft purchase orders can be edited")
+	}
+
+	if params.Currency == "" {
+		params.Currency = constants.BaseCurrency
+	}
+
+	lines, err := s.checkPurchaseOrder(ctx, params.EntityID, params.Date, params.ExpectedDate, params.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err = s.repo.UpdatePurchaseOrder(ctx, &repository.UpdatePurchaseOrderParams{
+		ID:           params.ID,
+		EntityID:     params.EntityID,
+		Date:         params.Date,
+		ExpectedDate: params.ExpectedDate,
+		Currency:     params.Currency,
+		Notes:        params.Notes,
+		Lines:        lines,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toPurchaseOrderDTO(po), nil
+}
+
+// SendPurchaseOrder marks a draft as sent to the supplier, from then on it
+// can be received
+func (s *ServiceManager) SendPurchaseOrder(ctx context.Context, id *pgxuuid.UUID) (*PurchaseOrderDTO, error) {
+	po, err := s.getPurchaseOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if po.Status != "DRAFT" {
+		return nil, constants.NewInvalidOperationError("only draft purchase orders can be sent")
+	}
+
+	po, err = s.repo.SendPurchaseOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toPurchaseOrderDTO(po), nil
+}
+
+type ClosePurchaseOrderParams struct {
+	ID     *pgxuuid.UUID `validate:"required"`
+	UserID *pgxuuid.UUID `validate:"required"`
+}
+
+// ClosePurchaseOrder closes an order, what is still outstanding is no longer
+// expected. Its receipts stay as they are.
+func (s *ServiceManager) ClosePurchaseOrder(ctx context.Context, params *ClosePurchaseOrderParams) (*PurchaseOrderDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := s.getPurchaseOrder(ctx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if po.Status == "CLOSED" {
+		return nil, constants.NewInvalidOperationError("purchase order is already closed")
+	}
+
+	po, err = s.repo.ClosePurchaseOrder(ctx, &repository.ClosePurchaseOrderParams{
+		ID:     params.ID,
+		UserID: params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toPurchaseOrderDTO(po), nil
+}
+
+// ReceivePurchaseOrderItem receives Quantity of a line in Unit, the base
+// unit of the product when empty. Without a Price the agreed price of the
+// line is used.
+type ReceivePurchaseOrderItem struct {
+	LineID      *pgxuuid.UUID   `validate:"required"`
+	Quantity    decimal.Decimal `validate:"gt=0"`
+	Price       *decimal.Decimal
+	Unit        string
+	Batch       string
+	WarehouseID *pgxuuid.UUID
+}
+
+type ReceivePurchaseOrderParams struct {
+	ID   *pgxuuid.UUID `validate:"required"`
+	Date time.Time     `validate:"required"`
+	// WarehouseID is used by items without their own warehouse
+	WarehouseID   *pgxuuid.UUID
+	InvoiceNumber string                      `validate:"omitempty,lte=30"`
+	ExchangeRate  decimal.Decimal             `validate:"gte=0"`
+	UserID        *pgxuuid.UUID               `validate:"required"`
+	Items         []*ReceivePurchaseOrderItem `validate:"required,min=1,dive,required"`
+}
+
+// ReceivePurchaseOrder posts a PURCHASE movement from the supplier of a sent
+// order, each item on one of its lines. Receiving more than what is
+// outstanding on a line is rejected.
+func (s *ServiceManager) ReceivePurchaseOrder(ctx context.Context, params *ReceivePurchaseOrderParams) (*StockMovementDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := s.getPurchaseOrder(ctx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if po.Status != "SENT" && po.Status != "PARTIALLY_RECEIVED" {
+		return nil, constants.NewInvalidOperationError("only sent or partially received purchase orders can be received")
+	}
+
+	if params.Date.Before(po.Date) {
+		return nil, constants.NewInvalidOperationError("receipt date is before the order date")
+	}
+
+	lines := make(map[pgxuuid.UUID]*repository.PurchaseOrderLine, len(po.Lines))
+	for _, line := range po.Lines {
+		lines[*line.ID] = line
+	}
+
+	receiving := make(map[pgxuuid.UUID]decimal.Decimal, len(params.Items))
+	items := make([]*CreateStockItem, 0, len(params.Items))
+	for i, item := range params.Items {
+		line, ok := lines[*item.LineID]
+		if !ok {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: line is not on the purchase order", i))
+		}
+
+		product, err := s.repo.GetProductByID(ctx, line.ProductID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, factor, err := s.getItemUnitFactor(ctx, i, product, item.Unit)
+		if err != nil {
+			return nil, err
+		}
+
+		receiving[*line.ID] = receiving[*line.ID].Add(constants.RoundQuantity(item.Quantity.Mul(factor)))
+		outstanding := line.Quantity.Sub(line.ReceivedQuantity)
+		if receiving[*line.ID].GreaterThan(outstanding) {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: over-receipt, %v outstanding for %v", i, outstanding, line.ProductName))
+		}
+
+		price := constants.RoundPrice(line.Price.Mul(factor))
+		if item.Price != nil {
+			price = *item.Price
+		}
+
+		items = append(items, &CreateStockItem{
+			ProductID:           line.ProductID,
+			Quantity:            item.Quantity,
+			Price:               price,
+			Unit:                item.Unit,
+			Batch:               item.Batch,
+			WarehouseID:         item.WarehouseID,
+			PurchaseOrderLineID: line.ID,
+		})
+	}
+
+	return s.CreateStockMovement(ctx, &CreateStockMovementParams{
+		Type:            "PURCHASE",
+		Date:            params.Date,
+		EntityID:        po.EntityID,
+		WarehouseID:     params.WarehouseID,
+		Currency:        po.Currency,
+		ExchangeRate:    params.ExchangeRate,
+		InvoiceNumber:   params.InvoiceNumber,
+		UserID:          params.UserID,
+		Items:           items,
+		PurchaseOrderID: po.ID,
+	})
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"log"
+	"time"
+)
+
+// ReservationDTO holds quantities in the base unit of the product,
+// HeldQuantity is the part of Quantity still kept from other documents
+type ReservationDTO struct {
+	ID               *uuid.UUID      `json:"id"`
+	Status           string          `json:"status"`
+	ProductID        *uuid.UUID      `json:"productId"`
+	ProductName      string          `json:"productName"`
+	WarehouseID      *uuid.UUID      `json:"warehouseId"`
+	WarehouseName    string          `json:"warehouseName"`
+	LotID            *uuid.UUID      `json:"lotId"`
+	Batch            string          `json:"batch"`
+	Quantity         decimal.Decimal `json:"quantity"`
+	HeldQuantity     decimal.Decimal `json:"heldQuantity"`
+	SourceType       string          `json:"sourceType"`
+	SourceID         *uuid.UUID      `json:"sourceId"`
+	SalesOrderLineID *uuid.UUID      `json:"salesOrderLineId"`
+	Reference        string          `json:"reference"`
+	ExpiresAt        *time.Time      `json:"expiresAt"`
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
+
+	CreatedByUserID    *uuid.UUID `json:"createdByUserId"`
+	CreatedByUserName  string     `json:"createdByUserName"`
+	ReleasedByUserID   *uuid.UUID `json:"releasedByUserId"`
+	ReleasedByUserName string     `json:"releasedByUserName"`
+	ReleasedAt         *time.Time `json:"releasedAt"`
+}
+
+func (s *ServiceManager) toReservationDTO(reservation *repository.Reservation) *ReservationDTO {
+	reservationId, err := s.parseUUID(reservation.ID)
+	if err != nil {
+		reservationId = nil
+	}
+
+	productId, err := s.parseUUID(reservation.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	warehouseId, err := s.parseUUID(reservation.WarehouseID)
+	if err != nil {
+		warehouseId = nil
+	}
+
+	lotId, err := s.parseUUID(reservation.LotID)
+	if err != nil {
+		lotId = nil
+	}
+
+	sourceId, err := s.parseUUID(reservation.SourceID)
+	if err != nil {
+		sourceId = nil
+	}
+
+	salesOrderLineId, err := s.parseUUID(reservation.SalesOrderLineID)
+	if err != nil {
+		salesOrderLineId = nil
+	}
+
+	createdByUserId, err := s.parseUUID(reservation.CreatedByUserID)
+	if err != nil {
+		createdByUserId = nil
+	}
+
+	releasedByUserId, err := s.parseUUID(reservation.ReleasedByUserID)
+	if err != nil {
+		releasedByUserId = nil
+	}
+
+	return &ReservationDTO{
+		ID:                 reservationId,
+		Status:             reservation.Status,
+		ProductID:          productId,
+		ProductName:        reservation.ProductName,
+		WarehouseID:        warehouseId,
+		WarehouseName:      reservation.WarehouseName,
+		LotID:              lotId,
+		Batch:              reservation.Batch,
+		Quantity:           reservation.Quantity,
+		HeldQuantity:       reservation.HeldQuantity,
+		SourceType:         reservation.SourceType,
+		SourceID:           sourceId,
+		SalesOrderLineID:   salesOrderLineId,
+		Reference:          reservation.Reference,
+		ExpiresAt:          reservation.ExpiresAt,
+		CreatedAt:          reservation.CreatedAt,
+		UpdatedAt:          reservation.UpdatedAt,
+		CreatedByUserID:    createdByUserId,
+		CreatedByUserName:  reservation.CreatedByUserName,
+		ReleasedByUserID:   releasedByUserId,
+		ReleasedByUserName: reservation.ReleasedByUserName,
+		ReleasedAt:         reservation.ReleasedAt,
+	}
+}
+
+type FetchReservationsParams struct {
+	StatusOptions []string `validate:"dive,oneof=ACTIVE RELEASED EXPIRED"`
+	ProductID     *pgxuuid.UUID
+	WarehouseID   *pgxuuid.UUID
+	SourceID      *pgxuuid.UUID
+	Limit         int `validate:"required,gte=1,lte=100"`
+	Offset        int `validate:"gte=0"`
+}
+
+type FetchReservationsResult struct {
+	TotalCount int               `json:"totalCount"`
+	Items      []*ReservationDTO `json:"items"`
+}
+
+func (s *ServiceManager) FetchReservations(ctx context.Context, params *FetchReservationsParams) (*FetchReservationsResult, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.FetchReservations(ctx, &repository.FetchReservationsParams{
+		StatusOptions: params.StatusOptions,
+		ProductID:     params.ProductID,
+		WarehouseID:   params.WarehouseID,
+		SourceID:      params.SourceID,
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*ReservationDTO, 0)
+	for _, reservation := range result.Items {
+		items = append(items, s.toReservationDTO(reservation))
+	}
+
+	return &FetchReservationsResult{
+		TotalCount: result.TotalCount,
+		Items:      items,
+	}, nil
+}
+
+func (s *ServiceManager) FetchReservationByID(ctx context.Context, id *pgxuuid.UUID) (*ReservationDTO, error) {
+	reservation, err := s.repo.GetReservationByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return s.toReservationDTO(reservation), nil
+}
+
+// CreateReservationParams reserves Quantity, in the base unit of the
+// product, until ExpiresAt. A batch can only be reserved on a warehouse.
+type CreateReservationParams struct {
+	ProductID   *pgxuuid.UUID `validate:"required"`
+	WarehouseID *pgxuuid.UUID
+	Batch       string
+	Quantity    decimal.Decimal `validate:"required"`
+	Reference   string          `validate:"lte=100"`
+	ExpiresAt   *time.Time      `validate:"required"`
+	UserID      *pgxuuid.UUID   `validate:"required"`
+}
+
+func (s *ServiceManager) CreateReservation(ctx context.Context, params *CreateReservationParams) (*ReservationDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	params.Quantity = constants.RoundQuantity(params.Quantity)
+	if !params.Quantity.IsPositive() {
+		return nil, constants.NewInvalidOperationError("quantity must be greater than zero")
+	}
+
+	if !params.ExpiresAt.After(time.Now()) {
+		return nil, constants.NewInvalidOperationError("expiresAt is in the past")
+	}
+
+	product, err := s.repo.GetProductByID(ctx, params.ProductID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if product.Status != "ACTIVE" {
+		return nil, constants.NewInvalidOperationError("product is inactive")
+	}
+
+	if params.WarehouseID != nil {
+		_, err = s.getActiveWarehouse(ctx, params.WarehouseID, "warehouseId")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var lotID *pgxuuid.UUID
+	if params.Batch != "" {
+		if !product.BatchControl {
+			return nil, constants.NewInvalidOperationError("batch: product has no batch control")
+		}
+		if params.WarehouseID == nil {
+			return nil, constants.NewRequiredFieldError("warehouseId")
+		}
+
+		lot, err := s.repo.GetLotByProductAndCode(ctx, params.ProductID, params.Batch)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, constants.NewInvalidOperationError("batch: lot not found")
+			}
+			return nil, err
+		}
+		lotID = lot.ID
+	}
+
+	reservation, err := s.repo.CreateReservation(ctx, &repository.CreateReservationParams{
+		ProductID:   params.ProductID,
+		WarehouseID: params.WarehouseID,
+		LotID:       lotID,
+		Quantity:    params.Quantity,
+		Reference:   params.Reference,
+		ExpiresAt:   params.ExpiresAt,
+		CreatedBy:   params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toReservationDTO(reservation), nil
+}
+
+type ReleaseReservationParams struct {
+	ID     *pgxuuid.UUID `validate:"required"`
+	UserID *pgxuuid.UUID `validate:"required"`
+}
+
+func (s *ServiceManager) ReleaseReservation(ctx context.Context, params *ReleaseReservationParams) (*ReservationDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.repo.GetReservationByID(ctx, params.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	reservation, err := s.repo.ReleaseReservation(ctx, &repository.ReleaseReservationParams{
+		ID:     params.ID,
+		UserID: params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toReservationDTO(reservation), nil
+}
+
+// RunReservationSweeper expires the reservations past their expiry every
+// interval until ctx is done
+func (s *ServiceManager) RunReservationSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := s.repo.ExpireReservations(ctx)
+			if err != nil {
+				log.Printf("reservation sweeper: %v\n", err)
+				continue
+			}
+			if expired > 0 {
+				log.Printf("reservation sweeper: %d reservations expired\n", expired)
+			}
+		}
+	}
+}
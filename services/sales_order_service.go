@@ -0,0 +1,608 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+type SalesOrderDTO struct {
+	ID            *uuid.UUID `json:"id"`
+	Status        string     `json:"status"`
+	EntityID      *uuid.UUID `json:"entityId"`
+	EntityName    string     `json:"entityName"`
+	WarehouseID   *uuid.UUID `json:"warehouseId"`
+	WarehouseName string     `json:"warehouseName"`
+	Date          time.Time  `json:"date"`
+	ValidUntil    *time.Time `json:"validUntil"`
+	Currency      string     `json:"currency"`
+	Notes         string     `json:"notes"`
+	// Total is the ordered value of the lines in Currency
+	Total       decimal.Decimal `json:"total"`
+	ConfirmedAt *time.Time      `json:"confirmedAt"`
+	CancelledAt *time.Time      `json:"cancelledAt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+
+	CreatedByUserID     *uuid.UUID `json:"createdByUserId"`
+	CreatedByUserName   string     `json:"createdByUserName"`
+	CancelledByUserID   *uuid.UUID `json:"cancelledByUserId"`
+	CancelledByUserName string     `json:"cancelledByUserName"`
+
+	Lines []*SalesOrderLineDTO `json:"lines"`
+}
+
+// SalesOrderLineDTO holds quantities in the base unit of the product. Of
+// the OutstandingQuantity, ReservedOpenQuantity is held on the warehouse of
+// the order and BackorderQuantity is waiting for stock.
+type SalesOrderLineDTO struct {
+	ID                   *uuid.UUID      `json:"id"`
+	ProductID            *uuid.UUID      `json:"productId"`
+	ProductName          string          `json:"productName"`
+	Quantity             decimal.Decimal `json:"quantity"`
+	Price                decimal.Decimal `json:"price"`
+	Total                decimal.Decimal `json:"total"`
+	ReservedQuantity     decimal.Decimal `json:"reservedQuantity"`
+	DeliveredQuantity    decimal.Decimal `json:"deliveredQuantity"`
+	OutstandingQuantity  decimal.Decimal `json:"outstandingQuantity"`
+	ReservedOpenQuantity decimal.Decimal `json:"reservedOpenQuantity"`
+	BackorderQuantity    decimal.Decimal `json:"backorderQuantity"`
+}
+
+func (s *ServiceManager) toSalesOrderLineDTO(status string, line *repository.SalesOrderLine) *SalesOrderLineDTO {
+	lineId, err := s.parseUUID(line.ID)
+	if err != nil {
+		lineId = nil
+	}
+
+	productId, err := s.parseUUID(line.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	outstanding := decimal.Max(line.Quantity.Sub(line.DeliveredQuantity), decimal.Zero)
+	backorder := decimal.Zero
+	if status == "CONFIRMED" || status == "PARTIALLY_DELIVERED" {
+		backorder = decimal.Max(outstanding.Sub(line.ReservedOpenQuantity), decimal.Zero)
+	}
+
+	return &SalesOrderLineDTO{
+		ID:                   lineId,
+		ProductID:            productId,
+		ProductName:          line.ProductName,
+		Quantity:             line.Quantity,
+		Price:                line.Price,
+		Total:                constants.LineTotal(line.Quantity, line.Price),
+		ReservedQuantity:     line.ReservedQuantity,
+		DeliveredQuantity:    line.DeliveredQuantity,
+		OutstandingQuantity:  outstanding,
+		ReservedOpenQuantity: line.ReservedOpenQuantity,
+		BackorderQuantity:    backorder,
+	}
+}
+
+func (s *ServiceManager) toSalesOrderDTO(so *repository.SalesOrder) *SalesOrderDTO {
+	soId, err := s.parseUUID(so.ID)
+	if err != nil {
+		soId = nil
+	}
+
+	entityId, err := s.parseUUID(so.EntityID)
+	if err != nil {
+		entityId = nil
+	}
+
+	warehouseId, err := s.parseUUID(so.WarehouseID)
+	if err != nil {
+		warehouseId = nil
+	}
+
+	createdByUserId, err := s.parseUUID(so.CreatedByUserID)
+	if err != nil {
+		createdByUserId = nil
+	}
+
+	cancelledByUserId, err := s.parseUUID(so.CancelledByUserID)
+	if err != nil {
+		cancelledByUserId = nil
+	}
+
+	total := decimal.Zero
+	lines := make([]*SalesOrderLineDTO, 0)
+	for _, line := range so.Lines {
+		lineDTO := s.toSalesOrderLineDTO(so.Status, line)
+		total = total.Add(lineDTO.Total)
+		lines = append(lines, lineDTO)
+	}
+
+	return &SalesOrderDTO{
+		ID:                  soId,
+		Status:              so.Status,
+		EntityID:            entityId,
+		EntityName:          so.EntityName,
+		WarehouseID:         warehouseId,
+		WarehouseName:       so.WarehouseName,
+		Date:                so.Date,
+		ValidUntil:          so.ValidUntil,
+		Currency:            so.Currency,
+		Notes:               so.Notes,
+		Total:               total,
+		ConfirmedAt:         so.ConfirmedAt,
+		CancelledAt:         so.CancelledAt,
+		CreatedAt:           so.CreatedAt,
+		UpdatedAt:           so.UpdatedAt,
+		CreatedByUserID:     createdByUserId,
+		CreatedByUserName:   so.CreatedByUserName,
+		CancelledByUserID:   cancelledByUserId,
+		CancelledByUserName: so.CancelledByUserName,
+		Lines:               lines,
+	}
+}
+
+func (s *ServiceManager) getSalesOrder(ctx context.Context, id *pgxuuid.UUID) (*repository.SalesOrder, error) {
+	so, err := s.repo.GetSalesOrderByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return so, nil
+}
+
+type SalesOrderLineParams struct {
+	ProductID *pgxuuid.UUID   `validate:"required"`
+	Quantity  decimal.Decimal `validate:"gt=0"`
+	Price     decimal.Decimal `validate:"gt=0"`
+}
+
+// checkSalesOrder validates the customer, warehouse, dates and lines of an
+// order and returns the lines to store, rounded like movement items
+func (s *ServiceManager) checkSalesOrder(ctx context.Context, entityID *pgxuuid.UUID, warehouseID *pgxuuid.UUID, date time.Time, validUntil *time.Time, lines []*SalesOrderLineParams) ([]*repository.CreateSalesOrderLine, error) {
+	_, err := s.getActiveEntity(ctx, entityID, "entityId")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.getActiveWarehouse(ctx, warehouseID, "warehouseId")
+	if err != nil {
+		return nil, err
+	}
+
+	if validUntil != nil && validUntil.Before(date) {
+		return nil, constants.InvalidParams("validUntil must not be before date")
+	}
+
+	createLines := make([]*repository.CreateSalesOrderLine, 0, len(lines))
+	seen := make(map[pgxuuid.UUID]bool, len(lines))
+	for i, line := range lines {
+		if seen[*line.ProductID] {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("lines[%d]: product is already on another line", i))
+		}
+		seen[*line.ProductID] = true
+
+		product, err := s.repo.GetProductByID(ctx, line.ProductID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, constants.NewInvalidOperationError(fmt.Sprintf("lines[%d]: product not found", i))
+			}
+			return nil, err
+		}
+
+		if product.Status != "ACTIVE" {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("lines[%d]: product is inactive", i))
+		}
+
+		quantity := constants.RoundQuantity(line.Quantity)
+		if !quantity.IsPositive() {
+			return nil, constants.NewRequiredFieldError(fmt.Sprintf("lines[%d].quantity", i))
+		}
+
+		createLines = append(createLines, &repository.CreateSalesOrderLine{
+			ProductID: line.ProductID,
+			Quantity:  quantity,
+			Price:     constants.RoundPrice(line.Price),
+		})
+	}
+
+	return createLines, nil
+}
+
+type FetchSalesOrdersParams struct {
+	StatusOptions []string `validate:"dive,oneof=QUOTE CONFIRMED PARTIALLY_DELIVERED DELIVERED CANCELLED"`
+	EntityID      *pgxuuid.UUID
+	ProductID     *pgxuuid.UUID
+	Limit         int `validate:"required,gte=1,lte=100"`
+	Offset        int `validate:"gte=0"`
+}
+
+type FetchSalesOrdersResult struct {
+	TotalCount int              `json:"totalCount"`
+	Items      []*SalesOrderDTO `json:"items"`
+}
+
+func (s *ServiceManager) FetchSalesOrders(ctx context.Context, params *FetchSalesOrdersParams) (*FetchSalesOrdersResult, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.FetchSalesOrders(ctx, &repository.FetchSalesOrdersParams{
+		StatusOptions: params.StatusOptions,
+		EntityID:      params.EntityID,
+		ProductID:     params.ProductID,
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*SalesOrderDTO, 0)
+	for _, so := range result.Items {
+		items = append(items, s.toSalesOrderDTO(so))
+	}
+
+	return &FetchSalesOrdersResult{
+		TotalCount: result.TotalCount,
+		Items:      items,
+	}, nil
+}
+
+func (s *ServiceManager) FetchSalesOrderByID(ctx context.Context, id *pgxuuid.UUID) (*SalesOrderDTO, error) {
+	so, err := s.getSalesOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toSalesOrderDTO(so), nil
+}
+
+// CustomerBackordersDTO lists the backordered lines of one customer
+type CustomerBackordersDTO struct {
+	EntityID   *uuid.UUID      `json:"entityId"`
+	EntityName string          `json:"entityName"`
+	Lines      []*BackorderDTO `json:"lines"`
+}
+
+type BackorderDTO struct {
+	SalesOrderLineDTO
+	SalesOrderID  *uuid.UUID `json:"salesOrderId"`
+	WarehouseID   *uuid.UUID `json:"warehouseId"`
+	WarehouseName string     `json:"warehouseName"`
+	Date          time.Time  `json:"date"`
+}
+
+type FetchBackordersParams struct {
+	EntityID  *pgxuuid.UUID
+	ProductID *pgxuuid.UUID
+}
+
+// FetchBackorders returns, per customer, the confirmed lines that are still
+// waiting for stock
+func (s *ServiceManager) FetchBackorders(ctx context.Context, params *FetchBackordersParams) ([]*CustomerBackordersDTO, error) {
+	lines, err := s.repo.FetchBackorders(ctx, &repository.FetchBackordersParams{
+		EntityID:  params.EntityID,
+		ProductID: params.ProductID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	customers := make([]*CustomerBackordersDTO, 0)
+	var customer *CustomerBackordersDTO
+	for _, line := range lines {
+		entityId, err := s.parseUUID(line.EntityID)
+		if err != nil {
+			entityId = nil
+		}
+
+		// lines come sorted by customer
+		if customer == nil || customer.EntityID == nil || entityId == nil || *customer.EntityID != *entityId {
+			customer = &CustomerBackordersDTO{
+				EntityID:   entityId,
+				EntityName: line.EntityName,
+				Lines:      make([]*BackorderDTO, 0),
+			}
+			customers = append(customers, customer)
+		}
+
+		soId, err := s.parseUUID(line.SalesOrderID)
+		if err != nil {
+			soId = nil
+		}
+
+		warehouseId, err := s.parseUUID(line.WarehouseID)
+		if err != nil {
+			warehouseId = nil
+		}
+
+		customer.Lines = append(customer.Lines, &BackorderDTO{
+			SalesOrderLineDTO: *s.toSalesOrderLineDTO("CONFIRMED", &line.SalesOrderLine),
+			SalesOrderID:      soId,
+			WarehouseID:       warehouseId,
+			WarehouseName:     line.WarehouseName,
+			Date:              line.Date,
+		})
+	}
+
+	return customers, nil
+}
+
+type CreateSalesOrderParams struct {
+	EntityID    *pgxuuid.UUID `validate:"required"`
+	WarehouseID *pgxuuid.UUID `validate:"required"`
+	Date        time.Time     `validate:"required"`
+	// ValidUntil is the last day the quote can be confirmed
+	ValidUntil *time.Time
+	// Currency of the line prices, the base currency when empty
+	Currency string                  `validate:"omitempty,iso4217"`
+	Notes    string                  `validate:"lte=500"`
+	UserID   *pgxuuid.UUID           `validate:"required"`
+	Lines    []*SalesOrderLineParams `validate:"required,min=1,dive,required"`
+}
+
+// CreateSalesOrder creates a QUOTE, nothing is reserved until it is confirmed
+func (s *ServiceManager) CreateSalesOrder(ctx context.Context, params *CreateSalesOrderParams) (*SalesOrderDTO, error) {
+	params.Currency = strings.ToUpper(params.Currency)
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Currency == "" {
+		params.Currency = constants.BaseCurrency
+	}
+
+	lines, err := s.checkSalesOrder(ctx, params.EntityID, params.WarehouseID, params.Date, params.ValidUntil, params.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	so, err := s.repo.CreateSalesOrder(ctx, &repository.CreateSalesOrderParams{
+		EntityID:    params.EntityID,
+		WarehouseID: params.WarehouseID,
+		Date:        params.Date,
+		ValidUntil:  params.ValidUntil,
+		Currency:    params.Currency,
+		Notes:       params.Notes,
+		CreatedBy:   params.UserID,
+		Lines:       lines,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toSalesOrderDTO(so), nil
+}
+
+type UpdateSalesOrderParams struct {
+	ID          *pgxuuid.UUID `validate:"required"`
+	EntityID    *pgxuuid.UUID `validate:"required"`
+	WarehouseID *pgxuuid.UUID `validate:"required"`
+	Date        time.Time     `validate:"required"`
+	ValidUntil  *time.Time
+	Currency    string                  `validate:"omitempty,iso4217"`
+	Notes       string                  `validate:"lte=500"`
+	Lines       []*SalesOrderLineParams `validate:"required,min=1,dive,required"`
+}
+
+// UpdateSalesOrder changes a QUOTE, once confirmed only its status moves
+func (s *ServiceManager) UpdateSalesOrder(ctx context.Context, params *UpdateSalesOrderParams) (*SalesOrderDTO, error) {
+	params.Currency = strings.ToUpper(params.Currency)
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	so, err := s.getSalesOrder(ctx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if so.Status != "QUOTE" {
+		return nil, constants.NewInvalidOperationError("only quotes can be edited")
+	}
+
+	if params.Currency == "" {
+		params.Currency = constants.BaseCurrency
+	}
+
+	lines, err := s.checkSalesOrder(ctx, params.EntityID, params.WarehouseID, params.Date, params.ValidUntil, params.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	so, err = s.repo.UpdateSalesOrder(ctx, &repository.UpdateSalesOrderParams{
+		ID:          params.ID,
+		EntityID:    params.EntityID,
+		WarehouseID: params.WarehouseID,
+		Date:        params.Date,
+		ValidUntil:  params.ValidUntil,
+		Currency:    params.Currency,
+		Notes:       params.Notes,
+		Lines:       lines,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toSalesOrderDTO(so), nil
+}
+
+type ConfirmSalesOrderParams struct {
+	ID     *pgxuuid.UUID `validate:"required"`
+	UserID *pgxuuid.UUID `validate:"required"`
+}
+
+// ConfirmSalesOrder turns a quote still within its validity into an order
+// and reserves its lines, what is not in stock becomes a backorder
+func (s *ServiceManager) ConfirmSalesOrder(ctx context.Context, params *ConfirmSalesOrderParams) (*SalesOrderDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	so, err := s.getSalesOrder(ctx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if so.Status != "QUOTE" {
+		return nil, constants.NewInvalidOperationError("only quotes can be confirmed")
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	if so.ValidUntil != nil && so.ValidUntil.Before(today) {
+		return nil, constants.NewInvalidOperationError("quote expired on " + so.ValidUntil.Format("2006-01-02"))
+	}
+
+	so, err = s.repo.ConfirmSalesOrder(ctx, &repository.ConfirmSalesOrderParams{
+		ID:     params.ID,
+		UserID: params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toSalesOrderDTO(so), nil
+}
+
+type CancelSalesOrderParams struct {
+	ID     *pgxuuid.UUID `validate:"required"`
+	UserID *pgxuuid.UUID `validate:"required"`
+}
+
+// CancelSalesOrder cancels a quote or an order that is not fully delivered
+// and releases what it still had reserved
+func (s *ServiceManager) CancelSalesOrder(ctx context.Context, params *CancelSalesOrderParams) (*SalesOrderDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	so, err := s.getSalesOrder(ctx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if so.Status == "DELIVERED" || so.Status == "CANCELLED" {
+		return nil, constants.NewInvalidOperationError("sales order is already delivered or cancelled")
+	}
+
+	so, err = s.repo.CancelSalesOrder(ctx, &repository.CancelSalesOrderParams{
+		ID:     params.ID,
+		UserID: params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toSalesOrderDTO(so), nil
+}
+
+// DeliverSalesOrderItem delivers Quantity of a line in Unit, the base unit
+// of the product when empty. Without a Price the price of the line is used.
+type DeliverSalesOrderItem struct {
+	LineID   *pgxuuid.UUID   `validate:"required"`
+	Quantity decimal.Decimal `validate:"gt=0"`
+	Price    *decimal.Decimal
+	Unit     string
+	Batch    string
+}
+
+type DeliverSalesOrderParams struct {
+	ID           *pgxuuid.UUID            `validate:"required"`
+	Date         time.Time                `validate:"required"`
+	ExchangeRate decimal.Decimal          `validate:"gte=0"`
+	UserID       *pgxuuid.UUID            `validate:"required"`
+	Items        []*DeliverSalesOrderItem `validate:"required,min=1,dive,required"`
+}
+
+// DeliverSalesOrder posts a SALE movement to the customer of a confirmed
+// order from its warehouse, each item on one of its lines. An order can be
+// delivered in several movements but never over the ordered quantity.
+func (s *ServiceManager) DeliverSalesOrder(ctx context.Context, params *DeliverSalesOrderParams) (*StockMovementDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	so, err := s.getSalesOrder(ctx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if so.Status != "CONFIRMED" && so.Status != "PARTIALLY_DELIVERED" {
+		return nil, constants.NewInvalidOperationError("only confirmed or partially delivered sales orders can be delivered")
+	}
+
+	if params.Date.Before(so.Date) {
+		return nil, constants.NewInvalidOperationError("delivery date is before the order date")
+	}
+
+	lines := make(map[pgxuuid.UUID]*repository.SalesOrderLine, len(so.Lines))
+	for _, line := range so.Lines {
+		lines[*line.ID] = line
+	}
+
+	delivering := make(map[pgxuuid.UUID]decimal.Decimal, len(params.Items))
+	items := make([]*CreateStockItem, 0, len(params.Items))
+	for i, item := range params.Items {
+		line, ok := lines[*item.LineID]
+		if !ok {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: line is not on the sales order", i))
+		}
+
+		product, err := s.repo.GetProductByID(ctx, line.ProductID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, factor, err := s.getItemUnitFactor(ctx, i, product, item.Unit)
+		if err != nil {
+			return nil, err
+		}
+
+		delivering[*line.ID] = delivering[*line.ID].Add(constants.RoundQuantity(item.Quantity.Mul(factor)))
+		outstanding := line.Quantity.Sub(line.DeliveredQuantity)
+		if delivering[*line.ID].GreaterThan(outstanding) {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: over-delivery, %v outstanding for %v", i, outstanding, line.ProductName))
+		}
+
+		price := constants.RoundPrice(line.Price.Mul(factor))
+		if item.Price != nil {
+			price = *item.Price
+		}
+
+		items = append(items, &CreateStockItem{
+			ProductID:        line.ProductID,
+			Quantity:         item.Quantity,
+			Price:            price,
+			Unit:             item.Unit,
+			Batch:            item.Batch,
+			SalesOrderLineID: line.ID,
+		})
+	}
+
+	return s.CreateStockMovement(ctx, &CreateStockMovementParams{
+		Type:         "SALE",
+		Date:         params.Date,
+		EntityID:     so.EntityID,
+		WarehouseID:  so.WarehouseID,
+		Currency:     so.Currency,
+		ExchangeRate: params.ExchangeRate,
+		UserID:       params.UserID,
+		Items:        items,
+		SalesOrderID: so.ID,
+	})
+}
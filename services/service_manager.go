@@ -7,16 +7,30 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/gofrs/uuid/v5"
 	"github.com/hoffax/prodrest/repository"
+	"github.com/hoffax/prodrest/storage"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"reflect"
 )
 
 type ServiceManager struct {
 	repo     *repository.PgRepository
+	storage  storage.Storage
 	validate *validator.Validate
 }
 
-func NewServiceManager(repo *repository.PgRepository) (*ServiceManager, error) {
+func NewServiceManager(repo *repository.PgRepository, fileStorage storage.Storage) (*ServiceManager, error) {
 	validate := validator.New()
+	// decimals are validated by their float value so gt, gte and the like
+	// work on quantities and prices
+	validate.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		if value, ok := field.Interface().(decimal.Decimal); ok {
+			f, _ := value.Float64()
+			return f
+		}
+		return nil
+	}, decimal.Decimal{})
+
 	err := validate.RegisterValidation("custom_status", func(fl validator.FieldLevel) bool {
 		value := fl.Field()
 
@@ -26,18 +40,46 @@ func NewServiceManager(repo *repository.PgRepository) (*ServiceManager, error) {
 		return nil, errors.New("could not load custom_status validator")
 	}
 
-	err = validate.RegisterValidation("custom_unit", func(fl validator.FieldLevel) bool {
+	err = validate.RegisterValidation("custom_lot_status", func(fl validator.FieldLevel) bool {
+		value := fl.Field()
+
+		return value.String() == "ACTIVE" || value.String() == "QUARANTINE" || value.String() == "INACTIVE"
+	})
+	if err != nil {
+		return nil, errors.New("could not load custom_lot_status validator")
+	}
+
+	err = validate.RegisterValidation("custom_lot_allocation", func(fl validator.FieldLevel) bool {
+		value := fl.Field()
+
+		return value.String() == "FEFO" || value.String() == "FIFO"
+	})
+	if err != nil {
+		return nil, errors.New("could not load custom_lot_allocation validator")
+	}
+
+	err = validate.RegisterValidation("custom_production_order_status", func(fl validator.FieldLevel) bool {
+		value := fl.Field()
+
+		return value.String() == "PLANNED" || value.String() == "IN_PROGRESS" ||
+			value.String() == "COMPLETED" || value.String() == "CANCELLED"
+	})
+	if err != nil {
+		return nil, errors.New("could not load custom_production_order_status validator")
+	}
+
+	err = validate.RegisterValidation("custom_tax_rate", func(fl validator.FieldLevel) bool {
 		value := fl.Field()
 
-		return value.String() != "unknown" && (value.String() == "KG" || value.String() == "L" ||
-			value.String() == "UN" || value.String() == "OTHER")
+		return value.String() == "IVA_10" || value.String() == "IVA_5" || value.String() == "EXENTA"
 	})
 	if err != nil {
-		return nil, errors.New("could not load custom_unit validator")
+		return nil, errors.New("could not load custom_tax_rate validator")
 	}
 
 	return &ServiceManager{
 		repo:     repo,
+		storage:  fileStorage,
 		validate: validate,
 	}, nil
 }
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// returnedTypes maps each return type to the type of movement it gives back
+var returnedTypes = map[string]string{
+	"SALE_RETURN":     "SALE",
+	"PURCHASE_RETURN": "PURCHASE",
+}
+
+// resolveReturnOf checks a SALE_RETURN or PURCHASE_RETURN against the
+// movement it gives back and returns that movement. The return takes the
+// entity, currency and exchange rate of the original, and each item the
+// batch and, when none is given, the warehouse of the item it returns.
+// Other types cannot point to a returned movement.
+func (s *ServiceManager) resolveReturnOf(ctx context.Context, params *CreateStockMovementParams) (*repository.StockMovement, error) {
+	returnedType, ok := returnedTypes[params.Type]
+	if !ok {
+		if params.ReturnOfID != nil {
+			return nil, constants.NewInvalidOperationError("returnOfId: only returns give back another movement")
+		}
+		for _, item := range params.Items {
+			item.ReturnOfItemID = nil
+		}
+		return nil, nil
+	}
+
+	if params.ReturnOfID == nil {
+		return nil, constants.NewRequiredFieldError("returnOfId")
+	}
+
+	returned, err := s.repo.FetchStockMovementByID(ctx, params.ReturnOfID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError("returnOfId: stock movement not found")
+		}
+		return nil, err
+	}
+
+	if returned.Type != returnedType {
+		return nil, constants.NewInvalidOperationError(fmt.Sprintf("returnOfId: a %v can only give back a %v", params.Type, returnedType))
+	}
+
+	if returned.Status == "INACTIVE" || returned.CancelledAt != nil {
+		return nil, constants.NewInvalidOperationError("returnOfId: stock movement is cancelled")
+	}
+
+	if returned.ReversalOfID != nil {
+		return nil, constants.NewInvalidOperationError("returnOfId: stock movement is a reversal")
+	}
+
+	if params.Date.Before(returned.Date) {
+		return nil, constants.InvalidParams("date must not be before the date of the returned movement")
+	}
+
+	params.EntityID = returned.EntityID
+	params.Currency = returned.Currency
+	params.ExchangeRate = returned.ExchangeRate
+
+	returnedItems := make(map[pgxuuid.UUID]*repository.StockMovementItem, len(returned.Items))
+	for _, item := range returned.Items {
+		returnedItems[*item.ID] = item
+	}
+
+	for i, item := range params.Items {
+		if item.ReturnOfItemID == nil {
+			return nil, constants.NewRequiredFieldError(fmt.Sprintf("items[%d].returnOfItemId", i))
+		}
+
+		returnedItem, ok := returnedItems[*item.ReturnOfItemID]
+		if !ok {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: item is not on the returned movement", i))
+		}
+
+		if *item.ProductID != *returnedItem.ProductID {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: product does not match the returned item", i))
+		}
+
+		if !item.Quantity.IsPositive() {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: quantity must be positive", i))
+		}
+
+		if item.Batch != "" && item.Batch != returnedItem.Batch {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: batch does not match the returned item", i))
+		}
+		item.Batch = returnedItem.Batch
+
+		// replaced by the exact price of the returned item once the
+		// quantity is in the base unit, see checkReturnItems
+		item.Price = returnedItem.Price
+
+		if item.WarehouseID == nil && params.WarehouseID == nil {
+			item.WarehouseID = returnedItem.WarehouseID
+		}
+	}
+
+	return returned, nil
+}
+
+// checkReturnItems prices the items of a return, already in the base unit,
+// like the items they give back and fails when one would give back more than
+// is left of its item after the previous returns. createStockMovement checks
+// it again with the returned movement locked.
+func checkReturnItems(returned *repository.StockMovement, items []*repository.CreateStockItem) error {
+	returnedItems := make(map[pgxuuid.UUID]*repository.StockMovementItem, len(returned.Items))
+	for _, item := range returned.Items {
+		returnedItems[*item.ID] = item
+	}
+
+	quantities := make(map[pgxuuid.UUID]decimal.Decimal)
+	for i, item := range items {
+		returnedItem := returnedItems[*item.ReturnOfItemID]
+
+		item.Price = returnedItem.Price
+		item.LotID = returnedItem.LotID
+		item.TaxRate = returnedItem.TaxRate
+
+		quantities[*returnedItem.ID] = quantities[*returnedItem.ID].Add(item.Quantity)
+		left := returnedItem.Quantity.Sub(returnedItem.ReturnedQuantity)
+		if quantities[*returnedItem.ID].GreaterThan(left) {
+			return constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: over-return, %v left to return of %v", i, left, returnedItem.ProductName))
+		}
+	}
+
+	return nil
+}
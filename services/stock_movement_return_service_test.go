@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func newTestID() *pgxuuid.UUID {
+	id := pgxuuid.UUID(uuid.Must(uuid.NewV4()))
+	return &id
+}
+
+func TestCheckReturnItems(t *testing.T) {
+	productID := newTestID()
+	lotID := newTestID()
+	returnedItem := &repository.StockMovementItem{
+		ID:               newTestID(),
+		ProductID:        productID,
+		ProductName:      "product",
+		Quantity:         decimal.NewFromInt(10),
+		Price:            decimal.RequireFromString("1250.5"),
+		TaxRate:          constants.TaxRateIVA10,
+		LotID:            lotID,
+		ReturnedQuantity: decimal.NewFromInt(4),
+	}
+	returned := &repository.StockMovement{
+		Items: []*repository.StockMovementItem{returnedItem},
+	}
+
+	returnItem := func(quantity int64) *repository.CreateStockItem {
+		return &repository.CreateStockItem{
+			ProductID:      productID,
+			Quantity:       decimal.NewFromInt(quantity),
+			Price:          decimal.NewFromInt(1),
+			ReturnOfItemID: returnedItem.ID,
+		}
+	}
+
+	t.Run("what is left", func(t *testing.T) {
+		items := []*repository.CreateStockItem{returnItem(2), returnItem(4)}
+
+		err := checkReturnItems(returned, items)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		for _, item := range items {
+			if !item.Price.Equal(returnedItem.Price) {
+				t.Fatalf("expected price %v, got %v", returnedItem.Price, item.Price)
+			}
+			if item.LotID != lotID || item.TaxRate != returnedItem.TaxRate {
+				t.Fatalf("expected the lot and tax rate of the returned item")
+			}
+		}
+	})
+
+	t.Run("over-return", func(t *testing.T) {
+		items := []*repository.CreateStockItem{returnItem(2), returnItem(5)}
+
+		err := checkReturnItems(returned, items)
+		var invalidOperation *constants.InvalidOperationError
+		if !errors.As(err, &invalidOperation) {
+			t.Fatalf("expected an invalid operation error, got %v", err)
+		}
+	})
+}
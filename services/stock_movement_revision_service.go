@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// StockMovementChangesDTO is the diff stored with a revision, header fields
+// are only present when they changed and Items only lists the lines whose
+// quantity or price changed
+type StockMovementChangesDTO struct {
+	Date     *DateChangeDTO                `json:"date,omitempty"`
+	EntityID *IDChangeDTO                  `json:"entityId,omitempty"`
+	Items    []*StockMovementItemChangeDTO `json:"items"`
+}
+
+type DateChangeDTO struct {
+	Before time.Time `json:"before"`
+	After  time.Time `json:"after"`
+}
+
+type IDChangeDTO struct {
+	Before *uuid.UUID `json:"before"`
+	After  *uuid.UUID `json:"after"`
+}
+
+// StockMovementItemChangeDTO compares the lines of a product, warehouse and
+// batch, Before is nil for added lines and After for removed ones
+type StockMovementItemChangeDTO struct {
+	ProductID   *uuid.UUID                  `json:"productId"`
+	ProductName string                      `json:"productName"`
+	WarehouseID *uuid.UUID                  `json:"warehouseId"`
+	Batch       string                      `json:"batch"`
+	Before      *StockMovementItemValuesDTO `json:"before"`
+	After       *StockMovementItemValuesDTO `json:"after"`
+}
+
+// StockMovementItemValuesDTO holds the quantity in the base unit and the
+// price in the currency of the movement
+type StockMovementItemValuesDTO struct {
+	Quantity decimal.Decimal `json:"quantity"`
+	Price    decimal.Decimal `json:"price"`
+}
+
+type StockMovementRevisionDTO struct {
+	ID              *uuid.UUID               `json:"id"`
+	StockMovementID *uuid.UUID               `json:"stockMovementId"`
+	Revision        int                      `json:"revision"`
+	UserID          *uuid.UUID               `json:"userId"`
+	UserName        string                   `json:"userName"`
+	Changes         *StockMovementChangesDTO `json:"changes"`
+	CreatedAt       time.Time                `json:"createdAt"`
+}
+
+// itemChangeKey groups the lines of a movement for the diff
+type itemChangeKey struct {
+	ProductID   pgxuuid.UUID
+	WarehouseID pgxuuid.UUID
+	Batch       string
+}
+
+type itemChangeTotals struct {
+	Quantity decimal.Decimal
+	Total    decimal.Decimal
+}
+
+func (t *itemChangeTotals) values() *StockMovementItemValuesDTO {
+	if t == nil {
+		return nil
+	}
+
+	return &StockMovementItemValuesDTO{
+		Quantity: t.Quantity,
+		Price:    constants.UnitPrice(t.Total, t.Quantity),
+	}
+}
+
+// stockMovementChanges compares a movement with its edited header and items,
+// nil items keep the current ones. It returns nil when nothing changes.
+func (s *ServiceManager) stockMovementChanges(ctx context.Context, current *repository.StockMovement, date time.Time, entityID *pgxuuid.UUID, items []*repository.CreateStockItem) (*StockMovementChangesDTO, error) {
+	changes := &StockMovementChangesDTO{
+		Items: make([]*StockMovementItemChangeDTO, 0),
+	}
+
+	if !current.Date.Equal(date) {
+		changes.Date = &DateChangeDTO{
+			Before: current.Date,
+			After:  date,
+		}
+	}
+
+	if (current.EntityID == nil) != (entityID == nil) || (entityID != nil && *current.EntityID != *entityID) {
+		before, err := s.parseUUID(current.EntityID)
+		if err != nil {
+			before = nil
+		}
+
+		after, err := s.parseUUID(entityID)
+		if err != nil {
+			after = nil
+		}
+
+		changes.EntityID = &IDChangeDTO{
+			Before: before,
+			After:  after,
+		}
+	}
+
+	if items != nil {
+		keys := make([]itemChangeKey, 0)
+		names := make(map[pgxuuid.UUID]string)
+		before := make(map[itemChangeKey]*itemChangeTotals)
+		after := make(map[itemChangeKey]*itemChangeTotals)
+
+		add := func(totals map[itemChangeKey]*itemChangeTotals, key itemChangeKey, quantity decimal.Decimal, price decimal.Decimal) {
+			if _, seen := before[key]; !seen {
+				if _, seen := after[key]; !seen {
+					keys = append(keys, key)
+				}
+			}
+
+			line, ok := totals[key]
+			if !ok {
+				line = &itemChangeTotals{}
+				totals[key] = line
+			}
+			line.Quantity = line.Quantity.Add(quantity)
+			line.Total = line.Total.Add(constants.LineTotal(quantity, price))
+		}
+
+		for _, item := range current.Items {
+			names[*item.ProductID] = item.ProductName
+			add(before, itemChangeKey{*item.ProductID, *item.WarehouseID, item.Batch}, item.Quantity, item.Price)
+		}
+		for _, item := range items {
+			add(after, itemChangeKey{*item.ProductID, *item.WarehouseID, item.Batch}, item.Quantity, item.Price)
+		}
+
+		for _, key := range keys {
+			beforeValues := before[key].values()
+			afterValues := after[key].values()
+			if beforeValues != nil && afterValues != nil &&
+				beforeValues.Quantity.Equal(afterValues.Quantity) && beforeValues.Price.Equal(afterValues.Price) {
+				continue
+			}
+
+			if _, ok := names[key.ProductID]; !ok {
+				product, err := s.repo.GetProductByID(ctx, &key.ProductID)
+				if err != nil {
+					return nil, err
+				}
+				names[key.ProductID] = product.Name
+			}
+
+			productId, err := s.parseUUID(&key.ProductID)
+			if err != nil {
+				productId = nil
+			}
+
+			warehouseId, err := s.parseUUID(&key.WarehouseID)
+			if err != nil {
+				warehouseId = nil
+			}
+
+			changes.Items = append(changes.Items, &StockMovementItemChangeDTO{
+				ProductID:   productId,
+				ProductName: names[key.ProductID],
+				WarehouseID: warehouseId,
+				Batch:       key.Batch,
+				Before:      beforeValues,
+				After:       afterValues,
+			})
+		}
+	}
+
+	if changes.Date == nil && changes.EntityID == nil && len(changes.Items) == 0 {
+		return nil, nil
+	}
+
+	return changes, nil
+}
+
+func (s *ServiceManager) FetchStockMovementRevisions(ctx context.Context, stockMovementID *pgxuuid.UUID) ([]*StockMovementRevisionDTO, error) {
+	_, err := s.repo.FetchStockMovementByID(ctx, stockMovementID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	revisions, err := s.repo.FetchStockMovementRevisions(ctx, stockMovementID)
+	if err != nil {
+		return nil, err
+	}
+
+	revisionsDTO := make([]*StockMovementRevisionDTO, 0)
+	for _, revision := range revisions {
+		revisionId, err := s.parseUUID(revision.ID)
+		if err != nil {
+			revisionId = nil
+		}
+
+		stockMovementId, err := s.parseUUID(revision.StockMovementID)
+		if err != nil {
+			stockMovementId = nil
+		}
+
+		userId, err := s.parseUUID(revision.UserID)
+		if err != nil {
+			userId = nil
+		}
+
+		changes := &StockMovementChangesDTO{}
+		err = json.Unmarshal(revision.Changes, changes)
+		if err != nil {
+			return nil, err
+		}
+
+		revisionsDTO = append(revisionsDTO, &StockMovementRevisionDTO{
+			ID:              revisionId,
+			StockMovementID: stockMovementId,
+			Revision:        revision.Revision,
+			UserID:          userId,
+			UserName:        revision.UserName,
+			Changes:         changes,
+			CreatedAt:       revision.CreatedAt,
+		})
+	}
+
+	return revisionsDTO, nil
+}
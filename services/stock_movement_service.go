@@ -3,10 +3,15 @@ package services
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gofrs/uuid/v5"
 	"github.com/hoffax/prodrest/constants"
 	"github.com/hoffax/prodrest/repository"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
 	"time"
 )
 
@@ -18,6 +23,9 @@ type StockMovementDTO struct {
 	CreatedAt time.Time  `json:"createdAt"`
 	UpdatedAt time.Time  `json:"updatedAt"`
 
+	DocumentNumber string `json:"documentNumber"`
+	InvoiceNumber  string `json:"invoiceNumber"`
+
 	EntityID            *uuid.UUID `json:"entityId"`
 	EntityName          string     `json:"entityName"`
 	EntityDocument      string     `json:"entityDocument"`
@@ -25,21 +33,59 @@ type StockMovementDTO struct {
 	CreatedByUserName   string     `json:"createdByUserName"`
 	CancelledByUserID   *uuid.UUID `json:"cancelledByUserId"`
 	CancelledByUserName string     `json:"cancelledByUserName"`
+	CancelReason        string     `json:"cancelReason"`
+	CancelledAt         *time.Time `json:"cancelledAt"`
+	ReversalOfID        *uuid.UUID `json:"reversalOfId"`
+	ReversedByID        *uuid.UUID `json:"reversedById"`
+	ProductionOrderID   *uuid.UUID `json:"productionOrderId"`
+	PurchaseOrderID     *uuid.UUID `json:"purchaseOrderId"`
+	SalesOrderID        *uuid.UUID `json:"salesOrderId"`
+	ReturnOfID          *uuid.UUID `json:"returnOfId"`
+	InventoryCountID    *uuid.UUID `json:"inventoryCountId"`
+
+	// item prices, DocumentTotal and Taxes are in Currency, Total is
+	// converted to the base currency with ExchangeRate
+	Currency      string                  `json:"currency"`
+	ExchangeRate  decimal.Decimal         `json:"exchangeRate"`
+	Items         []*StockMovementItemDTO `json:"items"`
+	DocumentTotal decimal.Decimal         `json:"documentTotal"`
+	Total         decimal.Decimal         `json:"total"`
+	Taxes         []*TaxBreakdownDTO      `json:"taxes"`
+}
 
-	Items []*StockMovementItemDTO `json:"items"`
-	Total int                     `json:"total"`
+// TaxBreakdownDTO splits the total of the items of one IVA rate into the
+// taxable base and the tax it includes
+type TaxBreakdownDTO struct {
+	TaxRate     string          `json:"taxRate"`
+	TaxableBase decimal.Decimal `json:"taxableBase"`
+	Tax         decimal.Decimal `json:"tax"`
+	Total       decimal.Decimal `json:"total"`
 }
 
 type StockMovementItemDTO struct {
-	Id              *uuid.UUID `json:"id"`
-	StockMovementID *uuid.UUID `json:"StockMovementId"`
-	ProductID       *uuid.UUID `json:"productId"`
-	ProductName     string     `json:"productName"`
-	Quantity        int        `json:"quantity"`
-	Price           int        `json:"price"`
-	Batch           string     `json:"batch"`
-	CreatedAt       time.Time  `json:"createdAt"`
-	UpdatedAt       time.Time  `json:"updatedAt"`
+	Id              *uuid.UUID      `json:"id"`
+	StockMovementID *uuid.UUID      `json:"StockMovementId"`
+	ProductID       *uuid.UUID      `json:"productId"`
+	ProductName     string          `json:"productName"`
+	Quantity        decimal.Decimal `json:"quantity"`
+	Price           decimal.Decimal `json:"price"`
+	Total           decimal.Decimal `json:"total"`
+	Unit            string          `json:"unit"`
+	UnitFactor      decimal.Decimal `json:"unitFactor"`
+	UnitQuantity    decimal.Decimal `json:"unitQuantity"`
+	UnitPrice       decimal.Decimal `json:"unitPrice"`
+	TaxRate         string          `json:"taxRate"`
+	Batch           string          `json:"batch"`
+	LotID           *uuid.UUID      `json:"lotId"`
+	WarehouseID     *uuid.UUID      `json:"warehouseId"`
+	WarehouseName   string          `json:"warehouseName"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt"`
+
+	PurchaseOrderLineID *uuid.UUID      `json:"purchaseOrderLineId"`
+	SalesOrderLineID    *uuid.UUID      `json:"salesOrderLineId"`
+	ReturnOfItemID      *uuid.UUID      `json:"returnOfItemId"`
+	ReturnedQuantity    decimal.Decimal `json:"returnedQuantity"`
 }
 
 func (s *ServiceManager) toStockMovementDTO(stockMovement *repository.StockMovement) *StockMovementDTO {
@@ -63,6 +109,41 @@ func (s *ServiceManager) toStockMovementDTO(stockMovement *repository.StockMovem
 		cancelledByUserId = nil
 	}
 
+	productionOrderId, err := s.parseUUID(stockMovement.ProductionOrderID)
+	if err != nil {
+		productionOrderId = nil
+	}
+
+	reversalOfId, err := s.parseUUID(stockMovement.ReversalOfID)
+	if err != nil {
+		reversalOfId = nil
+	}
+
+	reversedById, err := s.parseUUID(stockMovement.ReversedByID)
+	if err != nil {
+		reversedById = nil
+	}
+
+	purchaseOrderId, err := s.parseUUID(stockMovement.PurchaseOrderID)
+	if err != nil {
+		purchaseOrderId = nil
+	}
+
+	salesOrderId, err := s.parseUUID(stockMovement.SalesOrderID)
+	if err != nil {
+		salesOrderId = nil
+	}
+
+	returnOfId, err := s.parseUUID(stockMovement.ReturnOfID)
+	if err != nil {
+		returnOfId = nil
+	}
+
+	inventoryCountId, err := s.parseUUID(stockMovement.InventoryCountID)
+	if err != nil {
+		inventoryCountId = nil
+	}
+
 	items := make([]*StockMovementItemDTO, 0)
 	for _, item := range stockMovement.Items {
 		productId, err := s.parseUUID(item.ProductID)
@@ -75,6 +156,31 @@ func (s *ServiceManager) toStockMovementDTO(stockMovement *repository.StockMovem
 			stockMovementItemId = nil
 		}
 
+		lotId, err := s.parseUUID(item.LotID)
+		if err != nil {
+			lotId = nil
+		}
+
+		warehouseId, err := s.parseUUID(item.WarehouseID)
+		if err != nil {
+			warehouseId = nil
+		}
+
+		purchaseOrderLineId, err := s.parseUUID(item.PurchaseOrderLineID)
+		if err != nil {
+			purchaseOrderLineId = nil
+		}
+
+		salesOrderLineId, err := s.parseUUID(item.SalesOrderLineID)
+		if err != nil {
+			salesOrderLineId = nil
+		}
+
+		returnOfItemId, err := s.parseUUID(item.ReturnOfItemID)
+		if err != nil {
+			returnOfItemId = nil
+		}
+
 		items = append(items, &StockMovementItemDTO{
 			Id:              stockMovementItemId,
 			StockMovementID: stockMovementId,
@@ -82,9 +188,23 @@ func (s *ServiceManager) toStockMovementDTO(stockMovement *repository.StockMovem
 			ProductName:     item.ProductName,
 			Quantity:        item.Quantity,
 			Price:           item.Price,
+			Total:           item.Total,
+			Unit:            item.Unit,
+			UnitFactor:      item.UnitFactor,
+			UnitQuantity:    constants.RoundQuantity(item.Quantity.Div(item.UnitFactor)),
+			UnitPrice:       constants.RoundPrice(item.Price.Mul(item.UnitFactor)),
+			TaxRate:         item.TaxRate,
 			Batch:           item.Batch,
+			LotID:           lotId,
+			WarehouseID:     warehouseId,
+			WarehouseName:   item.WarehouseName,
 			CreatedAt:       item.CreatedAt,
 			UpdatedAt:       item.UpdatedAt,
+
+			PurchaseOrderLineID: purchaseOrderLineId,
+			SalesOrderLineID:    salesOrderLineId,
+			ReturnOfItemID:      returnOfItemId,
+			ReturnedQuantity:    item.ReturnedQuantity,
 		})
 	}
 
@@ -95,6 +215,8 @@ func (s *ServiceManager) toStockMovementDTO(stockMovement *repository.StockMovem
 		Date:                stockMovement.Date,
 		CreatedAt:           stockMovement.CreatedAt,
 		UpdatedAt:           stockMovement.UpdatedAt,
+		DocumentNumber:      stockMovement.DocumentNumber,
+		InvoiceNumber:       stockMovement.InvoiceNumber,
 		EntityID:            entityId,
 		EntityName:          stockMovement.EntityName,
 		EntityDocument:      stockMovement.EntityDocument,
@@ -102,24 +224,105 @@ func (s *ServiceManager) toStockMovementDTO(stockMovement *repository.StockMovem
 		CreatedByUserName:   stockMovement.CreatedByUserName,
 		CancelledByUserID:   cancelledByUserId,
 		CancelledByUserName: stockMovement.CancelledByUserName,
+		CancelReason:        stockMovement.CancelReason,
+		CancelledAt:         stockMovement.CancelledAt,
+		ReversalOfID:        reversalOfId,
+		ReversedByID:        reversedById,
+		ProductionOrderID:   productionOrderId,
+		PurchaseOrderID:     purchaseOrderId,
+		SalesOrderID:        salesOrderId,
+		ReturnOfID:          returnOfId,
+		InventoryCountID:    inventoryCountId,
+		Currency:            stockMovement.Currency,
+		ExchangeRate:        stockMovement.ExchangeRate,
 		Items:               items,
+		DocumentTotal:       stockMovement.DocumentTotal,
 		Total:               stockMovement.Total,
+		Taxes:               taxBreakdown(stockMovement.Items),
+	}
+}
+
+// taxBreakdown groups the item totals by IVA rate, the tax is taken from the
+// total of each rate so it matches the invoice rather than adding the
+// rounding of every line
+func taxBreakdown(items []*repository.StockMovementItem) []*TaxBreakdownDTO {
+	totals := make(map[string]decimal.Decimal)
+	for _, item := range items {
+		totals[item.TaxRate] = totals[item.TaxRate].Add(item.Total)
+	}
+
+	taxes := make([]*TaxBreakdownDTO, 0)
+	for _, taxRate := range constants.TaxRates {
+		total, ok := totals[taxRate]
+		if !ok {
+			continue
+		}
+
+		tax := constants.IncludedTax(total, taxRate)
+		taxes = append(taxes, &TaxBreakdownDTO{
+			TaxRate:     taxRate,
+			TaxableBase: total.Sub(tax),
+			Tax:         tax,
+			Total:       total,
+		})
 	}
+
+	return taxes
 }
 
 type CreateStockMovementParams struct {
 	Type     string    `validate:"required"`
 	Date     time.Time `validate:"required"`
 	EntityID *pgxuuid.UUID
-	UserID   *pgxuuid.UUID      `validate:"required"`
-	Items    []*CreateStockItem `validate:"required,min=1,dive,required"`
+	// WarehouseID is used by items without their own warehouse, on TRANSFER
+	// it is the source warehouse
+	WarehouseID            *pgxuuid.UUID
+	DestinationWarehouseID *pgxuuid.UUID
+	// Currency of the item prices, the base currency when empty. Without an
+	// ExchangeRate the rate effective on Date is used
+	Currency     string          `validate:"omitempty,iso4217"`
+	ExchangeRate decimal.Decimal `validate:"gte=0"`
+	// InvoiceNumber is the supplier invoice of a PURCHASE, unique per entity
+	InvoiceNumber string             `validate:"omitempty,lte=30"`
+	UserID        *pgxuuid.UUID      `validate:"required"`
+	Items         []*CreateStockItem `validate:"required,min=1,dive,required"`
+	// PurchaseOrderID is set by ReceivePurchaseOrder, whose items carry
+	// the line they receive
+	PurchaseOrderID *pgxuuid.UUID
+	// SalesOrderID is set by DeliverSalesOrder, whose items carry the line
+	// they deliver
+	SalesOrderID *pgxuuid.UUID
+	// ReturnOfID is the SALE given back by a SALE_RETURN or the PURCHASE
+	// given back by a PURCHASE_RETURN, whose items carry the item they return
+	ReturnOfID *pgxuuid.UUID
+	// EditedID is set by UpdateStockMovement, the stock the edited movement
+	// takes out is allocated again to its new items
+	EditedID *pgxuuid.UUID
 }
 
+// CreateStockItem is entered in Unit, the base unit of the product when
+// empty, and normalised to the base unit before it is stored
 type CreateStockItem struct {
-	ProductID *pgxuuid.UUID `validate:"required"`
-	Quantity  int           `validate:"required"`
-	Price     int           `validate:"required"`
-	Batch     string        `validate:""`
+	ProductID   *pgxuuid.UUID   `validate:"required"`
+	Quantity    decimal.Decimal `validate:"required"`
+	Price       decimal.Decimal `validate:"gte=0"`
+	Unit        string
+	Batch       string `validate:""`
+	WarehouseID *pgxuuid.UUID
+
+	PurchaseOrderLineID *pgxuuid.UUID
+	SalesOrderLineID    *pgxuuid.UUID
+	ReturnOfItemID      *pgxuuid.UUID
+}
+
+// isTradeMovement reports whether a movement of the given type is made with
+// a supplier or customer, only those can be in a foreign currency
+func isTradeMovement(movementType string) bool {
+	switch movementType {
+	case "PURCHASE", "SALE", "PURCHASE_RETURN", "SALE_RETURN":
+		return true
+	}
+	return false
 }
 
 func (s *ServiceManager) CreateStockMovement(ctx context.Context, params *CreateStockMovementParams) (*StockMovementDTO, error) {
@@ -128,7 +331,12 @@ func (s *ServiceManager) CreateStockMovement(ctx context.Context, params *Create
 		return nil, err
 	}
 
-	if params.Type == "PURCHASE" || params.Type == "SALE" {
+	returned, err := s.resolveReturnOf(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if isTradeMovement(params.Type) {
 		entityUUID, err := params.EntityID.UUIDValue()
 		if err != nil {
 			return nil, constants.NewRequiredFieldError("entityId")
@@ -140,22 +348,62 @@ func (s *ServiceManager) CreateStockMovement(ctx context.Context, params *Create
 		params.EntityID = nil
 	}
 
-	items := make([]*repository.CreateStockItem, 0)
-	for _, item := range params.Items {
-		items = append(items, &repository.CreateStockItem{
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     item.Price,
-			Batch:     item.Batch,
-		})
+	if params.Type == "TRANSFER" {
+		_, err = s.getActiveWarehouse(ctx, params.DestinationWarehouseID, "destinationWarehouseId")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		params.DestinationWarehouseID = nil
+	}
+
+	if params.Currency == "" {
+		params.Currency = constants.BaseCurrency
+	}
+	if params.Currency != constants.BaseCurrency && !isTradeMovement(params.Type) {
+		return nil, constants.NewInvalidOperationError("currency: only purchases and sales can be in a foreign currency")
+	}
+
+	exchangeRate, err := s.resolveExchangeRate(ctx, params.Currency, params.ExchangeRate, params.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.InvoiceNumber != "" {
+		if params.Type != "PURCHASE" {
+			return nil, constants.NewInvalidOperationError("invoiceNumber: only purchases have a supplier invoice")
+		}
+
+		err = s.checkInvoiceNumberAvailable(ctx, params.EntityID, params.InvoiceNumber)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	items, err := s.buildStockItems(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if returned != nil {
+		err = checkReturnItems(returned, items)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	stockMovement, err := s.repo.CreateStockMovement(ctx, &repository.CreateStockMovementParams{
-		Type:      params.Type,
-		Date:      params.Date,
-		EntityID:  params.EntityID,
-		CreatedBy: params.UserID,
-		Items:     items,
+		Type:            params.Type,
+		Date:            params.Date,
+		EntityID:        params.EntityID,
+		CreatedBy:       params.UserID,
+		PurchaseOrderID: params.PurchaseOrderID,
+		SalesOrderID:    params.SalesOrderID,
+		ReturnOfID:      params.ReturnOfID,
+		InvoiceNumber:   params.InvoiceNumber,
+		Currency:        params.Currency,
+		ExchangeRate:    exchangeRate,
+		Items:           items,
 	})
 	if err != nil {
 		return nil, err
@@ -164,12 +412,150 @@ func (s *ServiceManager) CreateStockMovement(ctx context.Context, params *Create
 	return s.toStockMovementDTO(stockMovement), nil
 }
 
+// checkInvoiceNumberAvailable returns a UniqueConstraintError when an active
+// movement of entityID already holds invoiceNumber
+func (s *ServiceManager) checkInvoiceNumberAvailable(ctx context.Context, entityID *pgxuuid.UUID, invoiceNumber string) error {
+	_, err := s.repo.GetStockMovementIDByInvoiceNumber(ctx, entityID, invoiceNumber)
+	if err == nil {
+		return constants.NewUniqueConstrainError("invoiceNumber")
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	return nil
+}
+
+// lotAllocationKey identifies the stock of a lot in a warehouse
+type lotAllocationKey struct {
+	LotID       pgxuuid.UUID
+	WarehouseID pgxuuid.UUID
+}
+
+// buildStockItems validates the requested items and turns them into the rows
+// to insert: lots are resolved or allocated, warehouses defaulted and TRANSFER
+// items split into an outgoing row on the source warehouse and an incoming
+// row on the destination
+func (s *ServiceManager) buildStockItems(ctx context.Context, params *CreateStockMovementParams) ([]*repository.CreateStockItem, error) {
+	items := make([]*repository.CreateStockItem, 0)
+	allocated := make(map[lotAllocationKey]decimal.Decimal)
+	for i, item := range params.Items {
+		item.Quantity = constants.RoundQuantity(item.Quantity)
+		item.Price = constants.RoundPrice(item.Price)
+		if item.Quantity.IsZero() {
+			return nil, constants.NewRequiredFieldError(fmt.Sprintf("items[%d].quantity", i))
+		}
+
+		// the type gives the direction, only an ADJUST is signed
+		if params.Type != "ADJUST" && item.Quantity.IsNegative() {
+			return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: quantity must be positive", i))
+		}
+
+		// transfers are valued at the average cost and production lines
+		// by the cost rollup of their order
+		if params.Type != "TRANSFER" && params.Type != "PRODUCTION_OUT" && params.Type != "PRODUCTION_IN" && item.Price.IsZero() {
+			return nil, constants.NewRequiredFieldError(fmt.Sprintf("items[%d].price", i))
+		}
+
+		if item.WarehouseID == nil {
+			item.WarehouseID = params.WarehouseID
+		}
+		_, err := s.getActiveWarehouse(ctx, item.WarehouseID, fmt.Sprintf("items[%d].warehouseId", i))
+		if err != nil {
+			return nil, err
+		}
+
+		if params.Type == "TRANSFER" {
+			equalIds, err := s.comparePgxUUID(item.WarehouseID, params.DestinationWarehouseID)
+			if err != nil {
+				return nil, err
+			}
+			if equalIds {
+				return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: source and destination warehouse are the same", i))
+			}
+		}
+
+		product, err := s.repo.GetProductByID(ctx, item.ProductID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: product not found", i))
+			}
+			return nil, err
+		}
+
+		unit, factor, err := s.getItemUnitFactor(ctx, i, product, item.Unit)
+		if err != nil {
+			return nil, err
+		}
+		if !factor.Equal(decimal.NewFromInt(1)) {
+			item.Quantity = constants.RoundQuantity(item.Quantity.Mul(factor))
+			item.Price = constants.UnitPrice(item.Price, factor)
+		}
+
+		lotItems := []*CreateStockItem{item}
+		if product.BatchControl && item.Batch == "" &&
+			(params.Type == "SALE" || params.Type == "PRODUCTION_OUT" || params.Type == "TRANSFER") {
+			lotItems, err = s.allocateItemLots(ctx, i, params, product, item, allocated)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, lotItem := range lotItems {
+			lot, err := s.resolveItemLot(ctx, i, params.Type, params.Date, product, lotItem)
+			if err != nil {
+				return nil, err
+			}
+
+			createItem := &repository.CreateStockItem{
+				ProductID:   lotItem.ProductID,
+				Quantity:    lotItem.Quantity,
+				Price:       lotItem.Price,
+				Unit:        unit,
+				UnitFactor:  factor,
+				TaxRate:     product.TaxRate,
+				Batch:       lotItem.Batch,
+				WarehouseID: lotItem.WarehouseID,
+
+				PurchaseOrderLineID: item.PurchaseOrderLineID,
+				SalesOrderLineID:    item.SalesOrderLineID,
+				ReturnOfItemID:      item.ReturnOfItemID,
+			}
+			if lot != nil {
+				createItem.LotID = lot.ID
+			}
+
+			if params.Type == "TRANSFER" {
+				incomingItem := *createItem
+				incomingItem.WarehouseID = params.DestinationWarehouseID
+				createItem.Quantity = createItem.Quantity.Neg()
+				items = append(items, createItem, &incomingItem)
+				continue
+			}
+
+			items = append(items, createItem)
+		}
+	}
+
+	return items, nil
+}
+
+// UpdateStockMovementParams edits the header of a movement and, when Items
+// is not nil, replaces all of its items
 type UpdateStockMovementParams struct {
 	ID       *pgxuuid.UUID `validate:"required"`
 	Date     time.Time     `validate:"required"`
 	EntityID *pgxuuid.UUID
+	// WarehouseID is used by items without their own warehouse
+	WarehouseID *pgxuuid.UUID
+	UserID      *pgxuuid.UUID      `validate:"required"`
+	Items       []*CreateStockItem `validate:"omitempty,min=1,dive,required"`
 }
 
+// UpdateStockMovement edits an ACTIVE movement and records the change as a
+// revision. New items are validated like on creation, lots of batch
+// controlled products are allocated from the stock without the items being
+// replaced.
 func (s *ServiceManager) UpdateStockMovement(ctx context.Context, params *UpdateStockMovementParams) (*StockMovementDTO, error) {
 	err := s.validate.Struct(params)
 	if err != nil {
@@ -185,7 +571,31 @@ func (s *ServiceManager) UpdateStockMovement(ctx context.Context, params *Update
 		return nil, constants.NewInvalidOperationError("stock movement is inactive")
 	}
 
-	if currentStockMovement.Type == "PURCHASE" || currentStockMovement.Type == "SALE" {
+	if currentStockMovement.ProductionOrderID != nil {
+		return nil, constants.NewInvalidOperationError("stock movement belongs to a production order and cannot be edited")
+	}
+
+	if currentStockMovement.PurchaseOrderID != nil {
+		return nil, constants.NewInvalidOperationError("stock movement is a purchase order receipt, cancel it and receive again instead")
+	}
+
+	if currentStockMovement.SalesOrderID != nil {
+		return nil, constants.NewInvalidOperationError("stock movement is a sales order delivery, cancel it and deliver again instead")
+	}
+
+	if currentStockMovement.ReturnOfID != nil {
+		return nil, constants.NewInvalidOperationError("stock movement is a return, cancel it and register the return again instead")
+	}
+
+	if currentStockMovement.InventoryCountID != nil {
+		return nil, constants.NewInvalidOperationError("stock movement is the adjustment of an inventory count and cannot be edited")
+	}
+
+	if currentStockMovement.CancelledAt != nil || currentStockMovement.ReversalOfID != nil {
+		return nil, constants.NewInvalidOperationError("stock movement is reversed or a reversal and cannot be edited")
+	}
+
+	if isTradeMovement(currentStockMovement.Type) {
 		entityUUID, err := params.EntityID.UUIDValue()
 		if err != nil {
 			return nil, constants.NewRequiredFieldError("entityId")
@@ -197,10 +607,61 @@ func (s *ServiceManager) UpdateStockMovement(ctx context.Context, params *Update
 		params.EntityID = nil
 	}
 
+	if params.Date.Year() != currentStockMovement.Date.Year() {
+		return nil, constants.NewInvalidOperationError(fmt.Sprintf("date: the document number belongs to %v, the movement cannot move to another year", currentStockMovement.Date.Year()))
+	}
+
+	if currentStockMovement.InvoiceNumber != "" && params.EntityID != nil && *params.EntityID != *currentStockMovement.EntityID {
+		err = s.checkInvoiceNumberAvailable(ctx, params.EntityID, currentStockMovement.InvoiceNumber)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var items []*repository.CreateStockItem
+	if params.Items != nil {
+		if len(params.Items) == 0 {
+			return nil, constants.NewRequiredFieldError("items")
+		}
+		if currentStockMovement.Type == "TRANSFER" {
+			return nil, constants.NewInvalidOperationError("items of a transfer cannot be edited, cancel it and register a new one")
+		}
+
+		items, err = s.buildStockItems(ctx, &CreateStockMovementParams{
+			Type:        currentStockMovement.Type,
+			Date:        params.Date,
+			EntityID:    params.EntityID,
+			WarehouseID: params.WarehouseID,
+			UserID:      params.UserID,
+			Items:       params.Items,
+			EditedID:    params.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := s.stockMovementChanges(ctx, currentStockMovement, params.Date, params.EntityID, items)
+	if err != nil {
+		return nil, err
+	}
+	if changes == nil {
+		return s.toStockMovementDTO(currentStockMovement), nil
+	}
+
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+
 	stockMovement, err := s.repo.UpdateStockMovement(ctx, &repository.UpdateStockMovementParams{
-		ID:       params.ID,
-		Date:     params.Date,
-		EntityID: params.EntityID,
+		ID:        params.ID,
+		Date:      params.Date,
+		EntityID:  params.EntityID,
+		UserID:    params.UserID,
+		UpdatedAt: currentStockMovement.UpdatedAt,
+		Items:     items,
+		Changes:   changesJSON,
 	})
 	if err != nil {
 		return nil, err
@@ -209,9 +670,15 @@ func (s *ServiceManager) UpdateStockMovement(ctx context.Context, params *Update
 	return s.toStockMovementDTO(stockMovement), nil
 }
 
+// CancelStockMovementParams cancels a movement with Mode CANCEL, the
+// default, which deactivates it, or REVERSAL, which posts a reversal on Date,
+// today when nil
 type CancelStockMovementParams struct {
 	ID     *pgxuuid.UUID `validate:"required"`
 	UserID *pgxuuid.UUID `validate:"required"`
+	Reason string        `validate:"required,gte=3,lte=500"`
+	Mode   string        `validate:"omitempty,oneof=CANCEL REVERSAL"`
+	Date   *time.Time
 }
 
 func (s *ServiceManager) CancelStockMovementByID(ctx context.Context, params *CancelStockMovementParams) (*StockMovementDTO, error) {
@@ -229,9 +696,52 @@ func (s *ServiceManager) CancelStockMovementByID(ctx context.Context, params *Ca
 		return nil, constants.NewInvalidOperationError("stock movement is inactive")
 	}
 
-	stockMovement, err := s.repo.DeleteStockMovement(ctx, &repository.DeleteStockMovementParams{
+	if currentStockMovement.CancelledAt != nil {
+		return nil, constants.NewInvalidOperationError("stock movement is already cancelled")
+	}
+
+	if currentStockMovement.ReversalOfID != nil {
+		return nil, constants.NewInvalidOperationError("stock movement is a reversal and cannot be cancelled")
+	}
+
+	if currentStockMovement.ProductionOrderID != nil {
+		return nil, constants.NewInvalidOperationError("stock movement belongs to a production order, cancel the order instead")
+	}
+
+	if currentStockMovement.InventoryCountID != nil {
+		return nil, constants.NewInvalidOperationError("stock movement is the adjustment of an inventory count and cannot be cancelled, open a new count instead")
+	}
+
+	if params.Mode != "REVERSAL" {
+		if params.Date != nil {
+			return nil, constants.InvalidParams("date is only used by a reversal")
+		}
+
+		stockMovement, err := s.repo.DeleteStockMovement(ctx, &repository.DeleteStockMovementParams{
+			ID:     params.ID,
+			UserID: params.UserID,
+			Reason: params.Reason,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return s.toStockMovementDTO(stockMovement), nil
+	}
+
+	date := time.Now().UTC().Truncate(24 * time.Hour)
+	if params.Date != nil {
+		date = *params.Date
+	}
+	if date.Before(currentStockMovement.Date) {
+		return nil, constants.InvalidParams("date must not be before the date of the movement")
+	}
+
+	stockMovement, err := s.repo.ReverseStockMovement(ctx, &repository.ReverseStockMovementParams{
 		ID:     params.ID,
 		UserID: params.UserID,
+		Reason: params.Reason,
+		Date:   date,
 	})
 	if err != nil {
 		return nil, err
@@ -257,8 +767,9 @@ type FetchStockMovementsParams struct {
 	StatusOptions []string  `validate:"dive,custom_status"`
 	TypeOptions   []string  `validate:"dive,required"`
 	StartDate     time.Time `validate:"required"`
-	Limit         int       `validate:"required,gte=10,max=100"`
-	Offset        int       `validate:"gte=0"`
+	Search        string
+	Limit         int `validate:"required,gte=10,max=100"`
+	Offset        int `validate:"gte=0"`
 }
 
 type FetchStockMovementsResult struct {
@@ -276,6 +787,7 @@ func (s *ServiceManager) FetchStockMovements(ctx context.Context, params *FetchS
 		StatusOptions: params.StatusOptions,
 		TypeOptions:   params.TypeOptions,
 		StartDate:     params.StartDate,
+		Search:        params.Search,
 		Limit:         params.Limit,
 		Offset:        params.Offset,
 	})
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// stockValuation keeps the running stock and weighted moving-average cost of
+// a single product. Value is rounded like any money amount and AverageCost
+// like any unit price.
+type stockValuation struct {
+	Stock       decimal.Decimal
+	AverageCost decimal.Decimal
+	Value       decimal.Decimal
+}
+
+// isCostMovement reports whether the price of an incoming line of the given
+// type should be used to recompute the average cost, PRODUCTION_IN lines are
+// priced with the cost rolled up from the consumed components
+func isCostMovement(movementType string) bool {
+	return movementType == "PURCHASE" || movementType == "PRODUCTION_IN"
+}
+
+// apply adds a signed ledger line to the valuation and returns the unit cost
+// the line was valued at
+func (v *stockValuation) apply(movementType string, quantity decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	incomingCost := quantity.IsPositive() && isCostMovement(movementType)
+
+	unitCost := v.AverageCost
+	if incomingCost {
+		unitCost = price
+	}
+
+	v.Value = v.Value.Add(constants.LineTotal(quantity, unitCost))
+	v.Stock = v.Stock.Add(quantity)
+
+	if !v.Stock.IsPositive() {
+		v.Value = decimal.Zero
+		return unitCost
+	}
+
+	if incomingCost {
+		v.AverageCost = constants.UnitPrice(v.Value, v.Stock)
+	}
+
+	return unitCost
+}
+
+// fetchStockValuations computes the current stock and average cost of the
+// given products from their active movement lines
+func (s *ServiceManager) fetchStockValuations(ctx context.Context, productIDs []pgxuuid.UUID) (map[pgxuuid.UUID]*stockValuation, error) {
+	return s.fetchStockValuationsAt(ctx, productIDs, nil)
+}
+
+// fetchStockValuationsAt computes the stock and average cost of the given
+// products at the end of date, a nil date means today
+func (s *ServiceManager) fetchStockValuationsAt(ctx context.Context, productIDs []pgxuuid.UUID, date *time.Time) (map[pgxuuid.UUID]*stockValuation, error) {
+	valuations := make(map[pgxuuid.UUID]*stockValuation, len(productIDs))
+	for _, id := range productIDs {
+		valuations[id] = &stockValuation{}
+	}
+
+	if len(productIDs) == 0 {
+		return valuations, nil
+	}
+
+	lines, err := s.repo.FetchStockLedger(ctx, &repository.FetchStockLedgerParams{
+		ProductIDs: productIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		if date != nil && line.Date.After(*date) {
+			break
+		}
+
+		valuation, ok := valuations[*line.ProductID]
+		if !ok {
+			continue
+		}
+		valuation.apply(line.Type, line.Quantity, line.Price)
+	}
+
+	return valuations, nil
+}
+
+func (s *ServiceManager) fetchProductValuation(ctx context.Context, productID *pgxuuid.UUID) (*stockValuation, error) {
+	valuations, err := s.fetchStockValuations(ctx, []pgxuuid.UUID{*productID})
+	if err != nil {
+		return nil, err
+	}
+
+	return valuations[*productID], nil
+}
+
+type KardexDTO struct {
+	ProductID        *uuid.UUID       `json:"productId"`
+	ProductName      string           `json:"productName"`
+	From             *time.Time       `json:"from"`
+	To               *time.Time       `json:"to"`
+	OpeningBalance   decimal.Decimal  `json:"openingBalance"`
+	OpeningValuation decimal.Decimal  `json:"openingValuation"`
+	ClosingBalance   decimal.Decimal  `json:"closingBalance"`
+	ClosingValuation decimal.Decimal  `json:"closingValuation"`
+	Items            []*KardexLineDTO `json:"items"`
+}
+
+type KardexLineDTO struct {
+	ID              *uuid.UUID      `json:"id"`
+	StockMovementID *uuid.UUID      `json:"stockMovementId"`
+	Type            string          `json:"type"`
+	Date            time.Time       `json:"date"`
+	EntityID        *uuid.UUID      `json:"entityId"`
+	EntityName      string          `json:"entityName"`
+	EntityDocument  string          `json:"entityDocument"`
+	WarehouseID     *uuid.UUID      `json:"warehouseId"`
+	WarehouseName   string          `json:"warehouseName"`
+	Batch           string          `json:"batch"`
+	QuantityIn      decimal.Decimal `json:"quantityIn"`
+	QuantityOut     decimal.Decimal `json:"quantityOut"`
+	Balance         decimal.Decimal `json:"balance"`
+	UnitCost        decimal.Decimal `json:"unitCost"`
+	Valuation       decimal.Decimal `json:"valuation"`
+	CreatedAt       time.Time       `json:"createdAt"`
+}
+
+type FetchProductKardexParams struct {
+	ProductID *pgxuuid.UUID `validate:"required"`
+	From      *time.Time
+	To        *time.Time
+}
+
+// FetchProductKardex lists the active movement lines of a product between
+// From and To (both inclusive and optional) with the running balance and
+// valuation. Lines before From are folded into the opening balance.
+func (s *ServiceManager) FetchProductKardex(ctx context.Context, params *FetchProductKardexParams) (*KardexDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.From != nil && params.To != nil && params.To.Before(*params.From) {
+		return nil, constants.InvalidParams("to must not be before from")
+	}
+
+	product, err := s.repo.GetProductByID(ctx, params.ProductID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	lines, err := s.repo.FetchStockLedger(ctx, &repository.FetchStockLedgerParams{
+		ProductIDs: []pgxuuid.UUID{*params.ProductID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	productId, err := s.parseUUID(product.ID)
+	if err != nil {
+		productId = nil
+	}
+
+	kardex := &KardexDTO{
+		ProductID:   productId,
+		ProductName: product.Name,
+		From:        params.From,
+		To:          params.To,
+		Items:       make([]*KardexLineDTO, 0),
+	}
+
+	valuation := &stockValuation{}
+	for _, line := range lines {
+		if params.To != nil && line.Date.After(*params.To) {
+			break
+		}
+
+		unitCost := valuation.apply(line.Type, line.Quantity, line.Price)
+
+		if params.From != nil && line.Date.Before(*params.From) {
+			kardex.OpeningBalance = valuation.Stock
+			kardex.OpeningValuation = valuation.Value
+			continue
+		}
+
+		kardex.Items = append(kardex.Items, s.toKardexLineDTO(line, valuation, unitCost))
+	}
+
+	kardex.ClosingBalance = valuation.Stock
+	kardex.ClosingValuation = valuation.Value
+
+	return kardex, nil
+}
+
+func (s *ServiceManager) toKardexLineDTO(line *repository.StockLedgerLine, valuation *stockValuation, unitCost decimal.Decimal) *KardexLineDTO {
+	lineId, err := s.parseUUID(line.ID)
+	if err != nil {
+		lineId = nil
+	}
+
+	stockMovementId, err := s.parseUUID(line.StockMovementID)
+	if err != nil {
+		stockMovementId = nil
+	}
+
+	entityId, err := s.parseUUID(line.EntityID)
+	if err != nil {
+		entityId = nil
+	}
+
+	warehouseId, err := s.parseUUID(line.WarehouseID)
+	if err != nil {
+		warehouseId = nil
+	}
+
+	item := &KardexLineDTO{
+		ID:              lineId,
+		StockMovementID: stockMovementId,
+		Type:            line.Type,
+		Date:            line.Date,
+		EntityID:        entityId,
+		EntityName:      line.EntityName,
+		EntityDocument:  line.EntityDocument,
+		WarehouseID:     warehouseId,
+		WarehouseName:   line.WarehouseName,
+		Batch:           line.Batch,
+		Balance:         valuation.Stock,
+		UnitCost:        unitCost,
+		Valuation:       valuation.Value,
+		CreatedAt:       line.CreatedAt,
+	}
+
+	if !line.Quantity.IsNegative() {
+		item.QuantityIn = line.Quantity
+	} else {
+		item.QuantityOut = line.Quantity.Neg()
+	}
+
+	return item
+}
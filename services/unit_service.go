@@ -0,0 +1,387 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+type UnitDTO struct {
+	Code      string    `json:"code"`
+	Status    string    `json:"status"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func toUnitDTO(unit *repository.Unit) *UnitDTO {
+	return &UnitDTO{
+		Code:      unit.Code,
+		Status:    unit.Status,
+		Name:      unit.Name,
+		CreatedAt: unit.CreatedAt,
+		UpdatedAt: unit.UpdatedAt,
+	}
+}
+
+type FetchUnitsParams struct {
+	StatusOptions []string `validate:"dive,custom_status"`
+}
+
+func (s *ServiceManager) FetchUnits(ctx context.Context, params *FetchUnitsParams) ([]*UnitDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	units, err := s.repo.FetchUnits(ctx, params.StatusOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	unitsDTO := make([]*UnitDTO, 0)
+	for _, unit := range units {
+		unitsDTO = append(unitsDTO, toUnitDTO(unit))
+	}
+
+	return unitsDTO, nil
+}
+
+func (s *ServiceManager) FetchUnitByCode(ctx context.Context, code string) (*UnitDTO, error) {
+	unit, err := s.repo.GetUnitByCode(ctx, strings.ToUpper(code))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return toUnitDTO(unit), nil
+}
+
+type CreateUnitParams struct {
+	Code string `validate:"required,alphanum,lte=10"`
+	Name string `validate:"required,gte=2,lte=80"`
+}
+
+func (s *ServiceManager) CreateUnit(ctx context.Context, params *CreateUnitParams) (*UnitDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	params.Code = strings.ToUpper(params.Code)
+
+	_, err = s.repo.GetUnitByCode(ctx, params.Code)
+	if err == nil {
+		return nil, constants.NewUniqueConstrainError("code")
+	} else {
+		if err != pgx.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	unit, err := s.repo.CreateUnit(ctx, &repository.CreateUnitParams{
+		Code: params.Code,
+		Name: params.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toUnitDTO(unit), nil
+}
+
+type UpdateUnitParams struct {
+	Code   string `validate:"required"`
+	Status string `validate:"required,custom_status"`
+	Name   string `validate:"required,gte=2,lte=80"`
+}
+
+func (s *ServiceManager) UpdateUnit(ctx context.Context, params *UpdateUnitParams) (*UnitDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	unit, err := s.repo.GetUnitByCode(ctx, strings.ToUpper(params.Code))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	if unit.Status == "ACTIVE" && params.Status == "INACTIVE" {
+		products, err := s.repo.CountUnitProducts(ctx, unit.Code)
+		if err != nil {
+			return nil, err
+		}
+		if products > 0 {
+			return nil, constants.NewInvalidOperationError("unit is used by active products")
+		}
+	}
+
+	unit, err = s.repo.UpdateUnit(ctx, &repository.UpdateUnitParams{
+		Code:   unit.Code,
+		Status: params.Status,
+		Name:   params.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toUnitDTO(unit), nil
+}
+
+// getActiveUnit replaces the old hard-coded unit validator, the unit must
+// exist and be ACTIVE
+func (s *ServiceManager) getActiveUnit(ctx context.Context, code string, field string) (*repository.Unit, error) {
+	if code == "" {
+		return nil, constants.NewRequiredFieldError(field)
+	}
+
+	unit, err := s.repo.GetUnitByCode(ctx, strings.ToUpper(code))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError(field + ": unit not found")
+		}
+		return nil, err
+	}
+
+	if unit.Status != "ACTIVE" {
+		return nil, constants.NewInvalidOperationError(field + ": unit is inactive")
+	}
+
+	return unit, nil
+}
+
+// checkBarcodeAvailable fails when barcode is already used by another
+// product or packaging unit than the one identified by productID and unit
+func (s *ServiceManager) checkBarcodeAvailable(ctx context.Context, barcode string, productID *pgxuuid.UUID, unit string) error {
+	if barcode == "" {
+		return nil
+	}
+
+	product, err := s.repo.GetProductByBarcode(ctx, barcode)
+	if err == nil {
+		if unit != "" || productID == nil || *product.ID != *productID {
+			return constants.NewUniqueConstrainError("barcode")
+		}
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	productUnit, err := s.repo.GetProductUnitByBarcode(ctx, barcode)
+	if err == nil {
+		if productID == nil || *productUnit.ProductID != *productID || productUnit.Unit != unit {
+			return constants.NewUniqueConstrainError("barcode")
+		}
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	return nil
+}
+
+type ProductUnitDTO struct {
+	ID        *uuid.UUID      `json:"id"`
+	Status    string          `json:"status"`
+	ProductID *uuid.UUID      `json:"productId"`
+	Unit      string          `json:"unit"`
+	UnitName  string          `json:"unitName"`
+	Factor    decimal.Decimal `json:"factor"`
+	Barcode   string          `json:"barcode"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+func (s *ServiceManager) toProductUnitDTO(productUnit *repository.ProductUnit) *ProductUnitDTO {
+	productUnitId, err := s.parseUUID(productUnit.ID)
+	if err != nil {
+		productUnitId = nil
+	}
+
+	productId, err := s.parseUUID(productUnit.ProductID)
+	if err != nil {
+		productId = nil
+	}
+
+	return &ProductUnitDTO{
+		ID:        productUnitId,
+		Status:    productUnit.Status,
+		ProductID: productId,
+		Unit:      productUnit.Unit,
+		UnitName:  productUnit.UnitName,
+		Factor:    productUnit.Factor,
+		Barcode:   productUnit.Barcode,
+		CreatedAt: productUnit.CreatedAt,
+		UpdatedAt: productUnit.UpdatedAt,
+	}
+}
+
+// fetchProductUnitDTOs returns the packaging units of the given products
+// keyed by product
+func (s *ServiceManager) fetchProductUnitDTOs(ctx context.Context, productIDs []pgxuuid.UUID) (map[pgxuuid.UUID][]*ProductUnitDTO, error) {
+	productUnits, err := s.repo.FetchProductUnits(ctx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[pgxuuid.UUID][]*ProductUnitDTO, len(productIDs))
+	for _, productUnit := range productUnits {
+		result[*productUnit.ProductID] = append(result[*productUnit.ProductID], s.toProductUnitDTO(productUnit))
+	}
+
+	return result, nil
+}
+
+func (s *ServiceManager) FetchProductUnits(ctx context.Context, productID *pgxuuid.UUID) ([]*ProductUnitDTO, error) {
+	_, err := s.repo.GetProductByID(ctx, productID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	productUnits, err := s.fetchProductUnitDTOs(ctx, []pgxuuid.UUID{*productID})
+	if err != nil {
+		return nil, err
+	}
+
+	if productUnits[*productID] == nil {
+		return make([]*ProductUnitDTO, 0), nil
+	}
+
+	return productUnits[*productID], nil
+}
+
+type CreateProductUnitParams struct {
+	ProductID *pgxuuid.UUID   `validate:"required"`
+	Unit      string          `validate:"required"`
+	Factor    decimal.Decimal `validate:"gt=0"`
+	Barcode   string          `validate:"omitempty,gte=3"`
+}
+
+func (s *ServiceManager) CreateProductUnit(ctx context.Context, params *CreateProductUnitParams) (*ProductUnitDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := s.repo.GetProductByID(ctx, params.ProductID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	unit, err := s.getActiveUnit(ctx, params.Unit, "unit")
+	if err != nil {
+		return nil, err
+	}
+
+	if unit.Code == product.Unit {
+		return nil, constants.NewInvalidOperationError("unit is the base unit of the product")
+	}
+
+	_, err = s.repo.GetProductUnit(ctx, params.ProductID, unit.Code)
+	if err == nil {
+		return nil, constants.NewUniqueConstrainError("unit")
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	err = s.checkBarcodeAvailable(ctx, params.Barcode, params.ProductID, unit.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	productUnit, err := s.repo.CreateProductUnit(ctx, &repository.CreateProductUnitParams{
+		ProductID: params.ProductID,
+		Unit:      unit.Code,
+		Factor:    params.Factor.Round(constants.BomQuantityScale),
+		Barcode:   params.Barcode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toProductUnitDTO(productUnit), nil
+}
+
+type UpdateProductUnitParams struct {
+	ProductID *pgxuuid.UUID   `validate:"required"`
+	Unit      string          `validate:"required"`
+	Status    string          `validate:"required,custom_status"`
+	Factor    decimal.Decimal `validate:"gt=0"`
+	Barcode   string          `validate:"omitempty,gte=3"`
+}
+
+// UpdateProductUnit changes a packaging level of a product, movements
+// already posted keep the factor they were entered with
+func (s *ServiceManager) UpdateProductUnit(ctx context.Context, params *UpdateProductUnitParams) (*ProductUnitDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	productUnit, err := s.repo.GetProductUnit(ctx, params.ProductID, strings.ToUpper(params.Unit))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	err = s.checkBarcodeAvailable(ctx, params.Barcode, params.ProductID, productUnit.Unit)
+	if err != nil {
+		return nil, err
+	}
+
+	productUnit, err = s.repo.UpdateProductUnit(ctx, &repository.UpdateProductUnitParams{
+		ProductID: params.ProductID,
+		Unit:      productUnit.Unit,
+		Status:    params.Status,
+		Factor:    params.Factor.Round(constants.BomQuantityScale),
+		Barcode:   params.Barcode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toProductUnitDTO(productUnit), nil
+}
+
+// getItemUnitFactor resolves the unit a movement item was entered in and how
+// many base units of product one of it holds
+func (s *ServiceManager) getItemUnitFactor(ctx context.Context, index int, product *repository.Product, unit string) (string, decimal.Decimal, error) {
+	unit = strings.ToUpper(unit)
+	if unit == "" || unit == product.Unit {
+		return product.Unit, decimal.NewFromInt(1), nil
+	}
+
+	productUnit, err := s.repo.GetProductUnit(ctx, product.ID, unit)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", decimal.Zero, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: product has no unit %s", index, unit))
+		}
+		return "", decimal.Zero, err
+	}
+
+	if productUnit.Status != "ACTIVE" {
+		return "", decimal.Zero, constants.NewInvalidOperationError(fmt.Sprintf("items[%d]: unit %s is inactive for the product", index, unit))
+	}
+
+	return productUnit.Unit, productUnit.Factor, nil
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/hoffax/prodrest/constants"
+	"github.com/hoffax/prodrest/repository"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type WarehouseDTO struct {
+	ID        *uuid.UUID `json:"id"`
+	Status    string     `json:"status"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+func (s *ServiceManager) toWarehouseDTO(warehouse *repository.Warehouse) *WarehouseDTO {
+	warehouseId, err := s.parseUUID(warehouse.ID)
+	if err != nil {
+		warehouseId = nil
+	}
+
+	return &WarehouseDTO{
+		ID:        warehouseId,
+		Status:    warehouse.Status,
+		Name:      warehouse.Name,
+		CreatedAt: warehouse.CreatedAt,
+		UpdatedAt: warehouse.UpdatedAt,
+	}
+}
+
+type CreateWarehouseParams struct {
+	Name string `validate:"required,gte=2,lte=80"`
+}
+
+func (s *ServiceManager) CreateWarehouse(ctx context.Context, params *CreateWarehouseParams) (*WarehouseDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.repo.GetWarehouseByName(ctx, params.Name)
+	if err == nil {
+		return nil, constants.NewUniqueConstrainError("name")
+	} else {
+		if err != pgx.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	warehouse, err := s.repo.CreateWarehouse(ctx, &repository.CreateWarehouseParams{
+		Name: params.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toWarehouseDTO(warehouse), nil
+}
+
+type UpdateWarehouseParams struct {
+	ID     *pgxuuid.UUID `validate:"required"`
+	Status string        `validate:"required,custom_status"`
+	Name   string        `validate:"required,gte=2,lte=80"`
+}
+
+func (s *ServiceManager) UpdateWarehouse(ctx context.Context, params *UpdateWarehouseParams) (*WarehouseDTO, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.repo.GetWarehouseByID(ctx, params.ID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	warehouse, err := s.repo.GetWarehouseByName(ctx, params.Name)
+	if err == nil {
+		equalIds, err := s.comparePgxUUID(warehouse.ID, params.ID)
+		if err != nil {
+			return nil, constants.InvalidParams("could not convert id")
+		}
+		if !equalIds {
+			return nil, constants.NewUniqueConstrainError("name")
+		}
+	} else {
+		if err != pgx.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	warehouse, err = s.repo.UpdateWarehouse(ctx, &repository.UpdateWarehouseParams{
+		ID:     params.ID,
+		Status: params.Status,
+		Name:   params.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toWarehouseDTO(warehouse), nil
+}
+
+func (s *ServiceManager) GetWarehouseByID(ctx context.Context, id *pgxuuid.UUID) (*WarehouseDTO, error) {
+	warehouse, err := s.repo.GetWarehouseByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewNotFoundError()
+		}
+		return nil, err
+	}
+
+	return s.toWarehouseDTO(warehouse), nil
+}
+
+type FetchWarehousesParams struct {
+	StatusOptions []string `validate:"dive,custom_status"`
+	Search        string
+	Limit         int `validate:"required,gte=1,lte=100"`
+	Offset        int `validate:"gte=0"`
+}
+
+type FetchWarehousesResponse struct {
+	TotalCount int             `json:"totalCount"`
+	Items      []*WarehouseDTO `json:"items"`
+}
+
+func (s *ServiceManager) FetchWarehouses(ctx context.Context, params *FetchWarehousesParams) (*FetchWarehousesResponse, error) {
+	err := s.validate.Struct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.FetchWarehouses(ctx, &repository.FetchWarehousesParams{
+		StatusOptions: params.StatusOptions,
+		Search:        params.Search,
+		Limit:         params.Limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	warehousesDTO := make([]*WarehouseDTO, 0)
+	for _, warehouse := range result.Items {
+		warehousesDTO = append(warehousesDTO, s.toWarehouseDTO(warehouse))
+	}
+
+	return &FetchWarehousesResponse{
+		TotalCount: result.TotalCount,
+		Items:      warehousesDTO,
+	}, nil
+}
+
+// getActiveWarehouse returns the warehouse only if it exists and is ACTIVE,
+// field names the request field for the error message
+func (s *ServiceManager) getActiveWarehouse(ctx context.Context, id *pgxuuid.UUID, field string) (*repository.Warehouse, error) {
+	if id == nil {
+		return nil, constants.NewRequiredFieldError(field)
+	}
+
+	warehouse, err := s.repo.GetWarehouseByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, constants.NewInvalidOperationError(field + ": warehouse not found")
+		}
+		return nil, err
+	}
+
+	if warehouse.Status != "ACTIVE" {
+		return nil, constants.NewInvalidOperationError(field + ": warehouse is inactive")
+	}
+
+	return warehouse, nil
+}
+
+type WarehouseStockDTO struct {
+	WarehouseID   *uuid.UUID      `json:"warehouseId"`
+	WarehouseName string          `json:"warehouseName"`
+	ProductID     *uuid.UUID      `json:"productId"`
+	ProductName   string          `json:"productName"`
+	LotID         *uuid.UUID      `json:"lotId"`
+	Batch         string          `json:"batch"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	Reserved      decimal.Decimal `json:"reserved"`
+	Available     decimal.Decimal `json:"available"`
+}
+
+type FetchWarehouseStockParams struct {
+	WarehouseID *pgxuuid.UUID
+	ProductID   *pgxuuid.UUID
+}
+
+// FetchWarehouseStock breaks the stock on hand down per warehouse, product
+// and lot, Available is what is left once the reservations are taken out
+func (s *ServiceManager) FetchWarehouseStock(ctx context.Context, params *FetchWarehouseStockParams) ([]*WarehouseStockDTO, error) {
+	items, err := s.repo.FetchWarehouseStock(ctx, &repository.FetchWarehouseStockParams{
+		WarehouseID: params.WarehouseID,
+		ProductID:   params.ProductID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stockDTO := make([]*WarehouseStockDTO, 0)
+	for _, item := range items {
+		warehouseId, err := s.parseUUID(item.WarehouseID)
+		if err != nil {
+			warehouseId = nil
+		}
+
+		productId, err := s.parseUUID(item.ProductID)
+		if err != nil {
+			productId = nil
+		}
+
+		lotId, err := s.parseUUID(item.LotID)
+		if err != nil {
+			lotId = nil
+		}
+
+		stockDTO = append(stockDTO, &WarehouseStockDTO{
+			WarehouseID:   warehouseId,
+			WarehouseName: item.WarehouseName,
+			ProductID:     productId,
+			ProductName:   item.ProductName,
+			LotID:         lotId,
+			Batch:         item.Batch,
+			Quantity:      item.Quantity,
+			Reserved:      item.Reserved,
+			Available:     item.Quantity.Sub(item.Reserved),
+		})
+	}
+
+	return stockDTO, nil
+}
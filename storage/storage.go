@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when no file is stored under a key
+var ErrNotFound = errors.New("storage: file not found")
+
+// Storage keeps the content of uploaded files, keys are slash separated
+// paths chosen by the caller
+type Storage interface {
+	Save(ctx context.Context, key string, content io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}